@@ -51,8 +51,9 @@ func (p *Program) String() string {
 }
 
 type LetStatement struct {
-	Token token.Token // {LET, "let"}
-	Name  *Identifier // even though Identifiers produce values, we don't use that function inside of LetStatements
+	Token token.Token     // {LET, "let"}
+	Name  *Identifier     // even though Identifiers produce values, we don't use that function inside of LetStatements
+	Type  *TypeAnnotation // optional, as in let n: int = 5
 	Value Expression
 }
 
@@ -66,6 +67,9 @@ func (ls *LetStatement) String() string {
 	// let <IDENT> = <EXPRESSION>;
 	out.WriteString(ls.TokenLiteral() + " ")
 	out.WriteString(ls.Name.String())
+	if ls.Type != nil {
+		out.WriteString(": " + ls.Type.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -80,6 +84,7 @@ func (ls *LetStatement) String() string {
 type Identifier struct {
 	Token token.Token // {IDENT,<id>}
 	Value string
+	Type  *TypeAnnotation // only set on annotated function parameters
 }
 
 func (i *Identifier) expressionNode()      {}
@@ -88,6 +93,34 @@ func (i *Identifier) String() string {
 	return i.Value
 }
 
+// StructStatement declares a struct type and binds its constructor to Name
+type StructStatement struct {
+	Token  token.Token // {STRUCT, "struct"} or {DEFSTRUCT, "defstruct"}
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+func (ss *StructStatement) statementNode()       {}
+func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *StructStatement) String() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for _, f := range ss.Fields {
+		fields = append(fields, f.String())
+	}
+
+	// construct form:
+	// struct <IDENT> { <IDENT>, <IDENT> }
+	out.WriteString("struct ")
+	out.WriteString(ss.Name.String())
+	out.WriteString(" { ")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString(" }")
+
+	return out.String()
+}
+
 type ReturnStatement struct {
 	Token       token.Token // token.RETURN
 	ReturnValue Expression
@@ -189,6 +222,7 @@ func (b *Boolean) String() string       { return b.Token.Literal }
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+	End        token.Token // the token that closed the block
 }
 
 func (bs *BlockStatement) statementNode()       {}
@@ -232,9 +266,11 @@ func (ie *IfExpression) String() string {
 }
 
 type FunctionLiteral struct {
-	Token      token.Token
-	Parameters []*Identifier
-	Body       *BlockStatement
+	Token       token.Token
+	Parameters  []*Identifier
+	ReturnType  *TypeAnnotation // optional, as in fn(x: int) -> int
+	Body        *BlockStatement
+	IsGenerator bool // set by the parser when the body yields
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
@@ -245,13 +281,20 @@ func (fl *FunctionLiteral) String() string {
 	params := []string{}
 
 	for _, p := range fl.Parameters {
-		params = append(params, p.String())
+		if p.Type != nil {
+			params = append(params, p.String()+": "+p.Type.String())
+		} else {
+			params = append(params, p.String())
+		}
 	}
 
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString(" (")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
+	if fl.ReturnType != nil {
+		out.WriteString("-> " + fl.ReturnType.String() + " ")
+	}
 	out.WriteString(fl.Body.String())
 
 	return out.String()
@@ -290,6 +333,7 @@ type CallExpression struct {
 	Token     token.Token //{token.LPAREN, "("}
 	Function  Expression
 	Arguments []Expression
+	Piped     bool // written as a pipeline step, its first argument piped in
 }
 
 func (ce *CallExpression) expressionNode()      {}
@@ -343,7 +387,37 @@ func (al *ArrayLiteral) String() string {
 	return out.String()
 }
 
-type ExpressionPairs map[Expression]Expression
+type SetLiteral struct {
+	Token    token.Token
+	Elements []Expression
+}
+
+func (sl *SetLiteral) expressionNode()      {}
+func (sl *SetLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *SetLiteral) String() string {
+	var out bytes.Buffer
+
+	elems := []string{}
+
+	for _, el := range sl.Elements {
+		elems = append(elems, el.String())
+	}
+
+	out.WriteString("#{")
+	out.WriteString(strings.Join(elems, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// a single key:value entry of a HashLiteral
+type ExpressionPair struct {
+	Key   Expression
+	Value Expression
+}
+
+// the entries of a HashLiteral in source order
+type ExpressionPairs []ExpressionPair
 
 type HashLiteral struct {
 	Token token.Token
@@ -357,8 +431,8 @@ func (hl *HashLiteral) String() string {
 
 	pairs := []string{}
 
-	for k, v := range hl.Pairs {
-		pairs = append(pairs, k.String()+":"+v.String())
+	for _, p := range hl.Pairs {
+		pairs = append(pairs, p.Key.String()+":"+p.Value.String())
 	}
 
 	out.WriteString("{")
@@ -388,5 +462,277 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// FieldAccessExpression reads a field of a struct value, as in p.name
+type FieldAccessExpression struct {
+	Token  token.Token // {DOT, "."}
+	Object Expression
+	Field  *Identifier
+}
+
+func (fa *FieldAccessExpression) expressionNode()      {}
+func (fa *FieldAccessExpression) TokenLiteral() string { return fa.Token.Literal }
+func (fa *FieldAccessExpression) String() string {
+	return "(" + fa.Object.String() + "." + fa.Field.String() + ")"
+}
+
+// FieldAssignExpression updates a field of a struct value, as in p.name = "x".
+// it produces the assigned value
+type FieldAssignExpression struct {
+	Token  token.Token // {DOT, "."}
+	Object Expression
+	Field  *Identifier
+	Value  Expression
+}
+
+func (fa *FieldAssignExpression) expressionNode()      {}
+func (fa *FieldAssignExpression) TokenLiteral() string { return fa.Token.Literal }
+func (fa *FieldAssignExpression) String() string {
+	return "(" + fa.Object.String() + "." + fa.Field.String() + " = " + fa.Value.String() + ")"
+}
+
+// MethodCallExpression calls a method on a value, as in arr.push(1)
+type MethodCallExpression struct {
+	Token     token.Token // {DOT, "."}
+	Object    Expression
+	Method    *Identifier
+	Arguments []Expression
+	Piped     bool // written as a pipeline step, its first argument piped in
+}
+
+func (mc *MethodCallExpression) expressionNode()      {}
+func (mc *MethodCallExpression) TokenLiteral() string { return mc.Token.Literal }
+func (mc *MethodCallExpression) String() string {
+	args := []string{}
+	for _, a := range mc.Arguments {
+		args = append(args, a.String())
+	}
+
+	return mc.Object.String() + "." + mc.Method.String() + "(" + strings.Join(args, ", ") + ")"
+}
+
+// PipeInto inserts value as the first argument of the call made by step,
+// which is how both front ends build a pipeline: xs |> f(a) in Nala and
+// (-> xs (f a)) in Ellisp are f(xs, a). a step that is not a call is
+// called with value alone, tok being the pipeline's operator
+func PipeInto(tok token.Token, value Expression, step Expression) Expression {
+	switch step := step.(type) {
+	case *CallExpression:
+		args := append([]Expression{value}, step.Arguments...)
+		return &CallExpression{Token: step.Token, Function: step.Function, Arguments: args, Piped: true}
+	case *MethodCallExpression:
+		args := append([]Expression{value}, step.Arguments...)
+		return &MethodCallExpression{Token: step.Token, Object: step.Object, Method: step.Method, Arguments: args, Piped: true}
+	default:
+		return &CallExpression{Token: tok, Function: step, Arguments: []Expression{value}, Piped: true}
+	}
+}
+
+// YieldExpression hands a value out of a generator and suspends it
+// until it is resumed, as in yield x. yield from xs hands out every
+// element of an iterable, including the values of another generator,
+// and evaluates to what that generator returned
+type YieldExpression struct {
+	Token    token.Token // {YIELD, "yield"}
+	Value    Expression
+	Delegate bool
+}
+
+func (ye *YieldExpression) expressionNode()      {}
+func (ye *YieldExpression) TokenLiteral() string { return ye.Token.Literal }
+func (ye *YieldExpression) String() string {
+	if ye.Delegate {
+		return ye.TokenLiteral() + " from " + ye.Value.String()
+	}
+	return ye.TokenLiteral() + " " + ye.Value.String()
+}
+
+// TypeAnnotation is a type written in the source, as in xs: [int].
+// Name is a basic type or a struct name, or one of array, hash, set, func
+// and union for the composite types, whose parts are kept in Params
+type TypeAnnotation struct {
+	Token    token.Token // the first token of the type
+	Name     string
+	Params   []*TypeAnnotation
+	Return   *TypeAnnotation // the result of a fn type
+	Optional bool            // a fn parameter that can be left out, as in int?
+	Variadic bool            // a fn type whose last parameter repeats, as in fn(any...)
+}
+
+func (ta *TypeAnnotation) String() string {
+	parts := []string{}
+	for _, p := range ta.Params {
+		parts = append(parts, p.String())
+	}
+
+	switch ta.Name {
+	case "array":
+		return "[" + parts[0] + "]"
+	case "hash":
+		return "{" + parts[0] + ": " + parts[1] + "}"
+	case "set":
+		return "#{" + parts[0] + "}"
+	case "union":
+		return strings.Join(parts, " | ")
+	case "func":
+		if ta.Variadic {
+			parts[len(parts)-1] += "..."
+		}
+		out := "fn(" + strings.Join(parts, ", ") + ")"
+		if ta.Return != nil {
+			out += " -> " + ta.Return.String()
+		}
+		return out
+	}
+
+	if ta.Optional {
+		return ta.Name + "?"
+	}
+	return ta.Name
+}
+
+// StartToken returns the first token of node's source. most nodes keep
+// it as their Token, the ones that keep an operator look to their left
+func StartToken(node Node) token.Token {
+	switch node := node.(type) {
+	case *LetStatement:
+		return node.Token
+	case *ReturnStatement:
+		return node.Token
+	case *StructStatement:
+		return node.Token
+	case *BlockStatement:
+		return node.Token
+	case *ExpressionStatement:
+		if node.Expression != nil {
+			return StartToken(node.Expression)
+		}
+		return node.Token
+	case *Identifier:
+		return node.Token
+	case *IntegerLiteral:
+		return node.Token
+	case *Boolean:
+		return node.Token
+	case *StringLiteral:
+		return node.Token
+	case *PrefixExpression:
+		return node.Token
+	case *InfixExpression:
+		return StartToken(node.Left)
+	case *IfExpression:
+		return node.Token
+	case *FunctionLiteral:
+		return node.Token
+	case *MacroLiteral:
+		return node.Token
+	case *CallExpression:
+		if node.Piped {
+			return StartToken(node.Arguments[0])
+		}
+		return StartToken(node.Function)
+	case *ArrayLiteral:
+		return node.Token
+	case *SetLiteral:
+		return node.Token
+	case *HashLiteral:
+		return node.Token
+	case *IndexExpression:
+		return StartToken(node.Left)
+	case *FieldAccessExpression:
+		return StartToken(node.Object)
+	case *FieldAssignExpression:
+		return StartToken(node.Object)
+	case *MethodCallExpression:
+		if node.Piped {
+			return StartToken(node.Arguments[0])
+		}
+		return StartToken(node.Object)
+	case *YieldExpression:
+		return node.Token
+	}
+	return token.Token{}
+}
+
 // func () TokenLiteral() string { return }
 // func () String() string       {}
+
+// LastToken returns the last token of node's source that the AST keeps.
+// closing brackets are not kept, so it can come a little before the end
+func LastToken(node Node) token.Token {
+	last := StartToken(node)
+	see := func(n Node) {
+		if t := LastToken(n); tokenBefore(last, t) {
+			last = t
+		}
+	}
+	seeAll := func(exps []Expression) {
+		for _, e := range exps {
+			see(e)
+		}
+	}
+
+	switch n := node.(type) {
+	case *LetStatement:
+		see(n.Value)
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			see(n.ReturnValue)
+		}
+	case *ExpressionStatement:
+		if n.Expression != nil {
+			see(n.Expression)
+		}
+	case *StructStatement:
+		for _, f := range n.Fields {
+			see(f)
+		}
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			see(s)
+		}
+		if tokenBefore(last, n.End) {
+			last = n.End
+		}
+	case *PrefixExpression:
+		see(n.Right)
+	case *InfixExpression:
+		see(n.Right)
+	case *IfExpression:
+		see(n.Consequence)
+		if n.Alternative != nil {
+			see(n.Alternative)
+		}
+	case *FunctionLiteral:
+		see(n.Body)
+	case *MacroLiteral:
+		see(n.Body)
+	case *CallExpression:
+		see(n.Function)
+		seeAll(n.Arguments)
+	case *ArrayLiteral:
+		seeAll(n.Elements)
+	case *SetLiteral:
+		seeAll(n.Elements)
+	case *HashLiteral:
+		for _, pair := range n.Pairs {
+			see(pair.Key)
+			see(pair.Value)
+		}
+	case *IndexExpression:
+		see(n.Index)
+	case *FieldAccessExpression:
+		see(n.Field)
+	case *FieldAssignExpression:
+		see(n.Value)
+	case *MethodCallExpression:
+		see(n.Method)
+		seeAll(n.Arguments)
+	case *YieldExpression:
+		see(n.Value)
+	}
+	return last
+}
+
+func tokenBefore(a, b token.Token) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
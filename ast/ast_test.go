@@ -26,3 +26,36 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestPipeInto(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+	pipe := token.Token{Type: token.PIPELINE, Literal: "|>"}
+
+	tests := []struct {
+		step     Expression
+		expected string
+	}{
+		{ident("f"), "f(xs)"},
+		{&CallExpression{Function: ident("f"), Arguments: []Expression{ident("a")}}, "f(xs, a)"},
+		{&MethodCallExpression{Object: ident("o"), Method: ident("m"), Arguments: []Expression{}}, "o.m(xs)"},
+	}
+
+	for _, tt := range tests {
+		exp := PipeInto(pipe, ident("xs"), tt.step)
+		if exp.String() != tt.expected {
+			t.Errorf("wrong pipeline. want=%q, got=%q", tt.expected, exp.String())
+		}
+		switch exp := exp.(type) {
+		case *CallExpression:
+			if !exp.Piped {
+				t.Errorf("%s is not marked as piped", tt.expected)
+			}
+		case *MethodCallExpression:
+			if !exp.Piped {
+				t.Errorf("%s is not marked as piped", tt.expected)
+			}
+		}
+	}
+}
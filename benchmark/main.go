@@ -9,21 +9,74 @@ import (
 	"nala/object"
 	"nala/parser"
 	"nala/vm"
+	"runtime"
+	"sort"
 	"time"
 )
 
-var engine = flag.String("engine", "vm", "use 'vm' or 'eval'")
+var engine = flag.String("engine", "vm", "use 'vm', 'regvm' or 'eval'")
+var optimize = flag.Bool("O", false, "optimize the compiled code")
+
+var workload = flag.String("workload", "fib", "run 'fib', 'mapreduce' or 'closures'")
+var compare = flag.Bool("compare", false, "run every workload on both VMs and compare them")
 
 // replace with scan or file input
-var input = `
+var workloads = map[string]string{
+	"fib": `
 let fibo = fn(x) {
 	if (x < 2) {
 		return x
 	}
 	fibo(x-1) + fibo(x-2);
 };
-fibo(35);
-`
+fibo(30);
+`,
+	"mapreduce": `
+let mapper = fn(arr, f, acc) {
+	if (len(arr) == 0) {
+		return acc
+	}
+	mapper(rest(arr), f, push(acc, f(first(arr))))
+};
+let reducer = fn(arr, f, acc) {
+	if (len(arr) == 0) {
+		return acc
+	}
+	reducer(rest(arr), f, f(acc, first(arr)))
+};
+let double = fn(x) { x * 2 };
+let plus = fn(a, b) { a + b };
+let loop = fn(n, total) {
+	if (n == 0) {
+		return total
+	}
+	loop(n - 1, total + reducer(mapper(array(range(100)), double, []), plus, 0))
+};
+loop(100, 0);
+`,
+	"closures": `
+let adder = fn(n) { fn(x) { x + n } };
+let sum = fn(n, total) {
+	if (n == 0) {
+		return total
+	}
+	sum(n - 1, total + adder(n)(n))
+};
+let loop = fn(n, total) {
+	if (n == 0) {
+		return total
+	}
+	loop(n - 1, total + sum(100, 0))
+};
+let repeat = fn(n, total) {
+	if (n == 0) {
+		return total
+	}
+	repeat(n - 1, total + loop(100, 0))
+};
+repeat(20, 0);
+`,
+}
 
 // var input = `
 // fn(a, b, c) {
@@ -34,40 +87,138 @@ fibo(35);
 func main() {
 	flag.Parse()
 
-	var duration time.Duration
-	var res object.Object
+	if *compare {
+		compareVMs()
+		return
+	}
+
+	input, ok := workloads[*workload]
+	if !ok {
+		fmt.Printf("unknown workload %s\n", *workload)
+		return
+	}
 
+	res, duration, allocs, err := run(*engine, input)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("engine=%s, workload=%s, optimized=%t, result=%s, duration=%s, allocations=%d\n",
+		*engine, *workload, *optimize, res.Inspect(), duration, allocs)
+}
+
+// runs input on engine, timing it and counting its allocations
+func run(engine, input string) (object.Object, time.Duration, uint64, error) {
 	l := lexer.New(input)
 	p := parser.New(l)
 	prog := p.ParseProgram()
 
-	if *engine == "vm" {
+	var machine *vm.VM
+	switch engine {
+	case "vm":
 		comp := compiler.New()
+		comp.SetOptimize(*optimize)
+		err := comp.Compile(prog)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("compiler error: %s", err)
+		}
+		machine = vm.New(comp.ByteCode())
+	case "regvm":
+		comp := compiler.NewRegister()
+		comp.SetOptimize(*optimize)
 		err := comp.Compile(prog)
 		if err != nil {
-			fmt.Printf("compiler error: %s\n", err)
-			return
+			return nil, 0, 0, fmt.Errorf("compiler error: %s", err)
 		}
+		machine = vm.NewRegister(comp.ByteCode())
+	default:
+		env := object.NewEnvironment()
+		start, startAllocs := time.Now(), allocations()
+		res := evaluator.Eval(prog, env)
+		return res, time.Since(start), allocations() - startAllocs, nil
+	}
 
-		machine := vm.New(comp.ByteCode())
-		// capture time
-		start := time.Now()
+	// capture time
+	start, startAllocs := time.Now(), allocations()
+	err := machine.Run()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("vm error: %s", err)
+	}
+	return machine.LastPoppedElement(), time.Since(start), allocations() - startAllocs, nil
+}
 
-		err = machine.Run()
-		if err != nil {
-			fmt.Printf("vm error: %s\n", err)
-			return
+// compareVMs runs every workload on the stack VM and on the register VM,
+// taking the best of a few runs of each, and prints how the register VM's
+// time compares
+func compareVMs() {
+	names := []string{}
+	for name := range workloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%-10s %-6s %12s %12s %8s\n", "workload", "engine", "duration", "allocations", "vs vm")
+	for _, name := range names {
+		var stackTime time.Duration
+		for _, engine := range []string{"vm", "regvm"} {
+			duration, allocs, err := bestRun(engine, workloads[name])
+			if err != nil {
+				fmt.Printf("%-10s %-6s %s\n", name, engine, err)
+				continue
+			}
+			if engine == "vm" {
+				stackTime = duration
+			}
+			ratio := "-"
+			if stackTime > 0 {
+				ratio = fmt.Sprintf("%.2fx", float64(duration)/float64(stackTime))
+			}
+			fmt.Printf("%-10s %-6s %12s %12d %8s\n", name, engine, duration.Round(time.Microsecond), allocs, ratio)
+		}
+	}
+	fmt.Printf("deepest recursion: vm=%d, regvm=%d\n", deepest("vm"), deepest("regvm"))
+}
+
+// deepest is how deep a recursion that isn't a tail call can go on engine,
+// in steps of 50. a frame of the register VM holds the registers of all
+// its function's temporaries, so it uses up the stack sooner
+func deepest(engine string) int {
+	depth := 0
+	for n := 50; n <= 4000; n += 50 {
+		if !recurses(engine, n) {
+			break
 		}
+		depth = n
+	}
+	return depth
+}
 
-		duration = time.Since(start)
-		res = machine.LastPoppedElement()
-	} else {
-		env := object.NewEnvironment()
-		start := time.Now()
-		res = evaluator.Eval(prog, env)
-		duration = time.Since(start)
+func recurses(engine string, n int) bool {
+	input := fmt.Sprintf("let d = fn(n) { if (n == 0) { 0 } else { d(n - 1) + 1 } }; d(%d)", n)
+	_, _, _, err := run(engine, input)
+	return err == nil
+}
+
+// the fastest of a few runs of input on engine
+func bestRun(engine, input string) (time.Duration, uint64, error) {
+	var best time.Duration
+	var allocs uint64
+	for i := 0; i < 3; i++ {
+		_, duration, n, err := run(engine, input)
+		if err != nil {
+			return 0, 0, err
+		}
+		if best == 0 || duration < best {
+			best, allocs = duration, n
+		}
 	}
+	return best, allocs, nil
+}
 
-	fmt.Printf("engine=%s, result=%s, duration=%s\n",
-		*engine, res.Inspect(), duration)
+// the number of heap allocations made so far
+func allocations() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Mallocs
 }
@@ -0,0 +1,511 @@
+package checker
+
+import (
+	"fmt"
+	"nala/ast"
+	"nala/object"
+	"nala/parser"
+	"nala/token"
+	"strings"
+)
+
+// Error is a type mismatch, found at Line and Column of the source
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg) }
+
+// the types of the builtins, parsed from their signatures
+var builtins = map[string]Type{}
+
+func init() {
+	c := New()
+	for _, def := range object.Builtins {
+		ta, errs := parser.ParseType(def.Signature)
+		if len(errs) > 0 {
+			panic(fmt.Sprintf("bad signature for builtin %s: %s", def.Name, strings.Join(errs, ", ")))
+		}
+		builtins[def.Name] = c.resolve(ta)
+	}
+}
+
+type scope struct {
+	names map[string]Type
+	outer *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{names: make(map[string]Type), outer: outer}
+}
+
+func (s *scope) lookup(name string) (Type, bool) {
+	for ; s != nil; s = s.outer {
+		if t, ok := s.names[name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// the function whose body is being checked
+type function struct {
+	returns Type // the annotated return type, nil if there is none
+	results Type // everything the body returns, joined together
+}
+
+// Checker infers the types of a program and reports the places where
+// they clash with its annotations. like a compiler's symbol table, it
+// keeps what it learned about the globals from one program to the next
+type Checker struct {
+	scope     *scope
+	structs   map[string]bool
+	resolved  map[*ast.TypeAnnotation]Type
+	fn        *function
+	annotated bool
+	errors    []*Error
+}
+
+func New() *Checker {
+	return &Checker{
+		scope:    newScope(nil),
+		structs:  make(map[string]bool),
+		resolved: make(map[*ast.TypeAnnotation]Type),
+	}
+}
+
+// Check checks a single program on its own
+func Check(prog *ast.Program) []*Error {
+	return New().Check(prog)
+}
+
+// Check infers the types of prog and returns the mismatches it found.
+// only programs with at least one annotation are held to them, any other
+// program passes as it always has, though its globals are still recorded
+func (c *Checker) Check(prog *ast.Program) []*Error {
+	c.errors, c.annotated = nil, false
+
+	// structs can be named in annotations before they are declared
+	for _, stmt := range prog.Statements {
+		if ss, ok := stmt.(*ast.StructStatement); ok {
+			c.structs[ss.Name.Value] = true
+		}
+	}
+	for _, stmt := range prog.Statements {
+		c.statement(stmt)
+	}
+
+	if !c.annotated {
+		return nil
+	}
+	return c.errors
+}
+
+func (c *Checker) errorf(tok token.Token, format string, a ...interface{}) {
+	c.errors = append(c.errors, &Error{Line: tok.Line, Column: tok.Column, Msg: fmt.Sprintf(format, a...)})
+}
+
+// returns the type a statement leaves behind as the value of its block.
+// a return leaves the block, so it does not constrain that value at all
+func (c *Checker) statement(stmt ast.Statement) Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		c.let(stmt)
+	case *ast.ReturnStatement:
+		c.ret(stmt)
+		return Any
+	case *ast.ExpressionStatement:
+		if stmt.Expression != nil {
+			return c.expr(stmt.Expression)
+		}
+	case *ast.BlockStatement:
+		return c.block(stmt)
+	case *ast.StructStatement:
+		c.structs[stmt.Name.Value] = true
+		params := make([]Type, len(stmt.Fields))
+		for i := range params {
+			params[i] = Any
+		}
+		c.scope.names[stmt.Name.Value] = &Func{Params: params, Required: len(params), Return: &Struct{Name: stmt.Name.Value}}
+	}
+	return Nil
+}
+
+func (c *Checker) block(bs *ast.BlockStatement) Type {
+	var last Type = Nil
+	for _, stmt := range bs.Statements {
+		last = c.statement(stmt)
+	}
+	return last
+}
+
+func (c *Checker) let(ls *ast.LetStatement) {
+	name := ls.Name.Value
+
+	var declared Type
+	if ls.Type != nil {
+		declared = c.resolve(ls.Type)
+	}
+
+	// a function is declared before its body is checked, so it can call itself
+	if fl, ok := ls.Value.(*ast.FunctionLiteral); ok {
+		if declared != nil {
+			c.scope.names[name] = declared
+		} else {
+			c.scope.names[name] = c.signature(fl)
+		}
+	}
+
+	val := c.expr(ls.Value)
+	if declared == nil {
+		c.scope.names[name] = val
+		return
+	}
+	if !Assignable(val, declared) {
+		c.errorf(ast.StartToken(ls.Value), "cannot use %s as %s in let %s", val, declared, name)
+	}
+	c.scope.names[name] = declared
+}
+
+func (c *Checker) ret(rs *ast.ReturnStatement) {
+	var val Type = Nil
+	if rs.ReturnValue != nil {
+		val = c.expr(rs.ReturnValue)
+	}
+	c.result(val, rs.Token)
+}
+
+// records a value returned by the current function
+func (c *Checker) result(val Type, tok token.Token) {
+	if c.fn == nil {
+		return
+	}
+	if c.fn.returns != nil && !Assignable(val, c.fn.returns) {
+		c.errorf(tok, "cannot return %s from a function returning %s", val, c.fn.returns)
+	}
+	c.fn.results = join(c.fn.results, val)
+}
+
+func (c *Checker) expr(exp ast.Expression) Type {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.Boolean:
+		return Bool
+	case *ast.StringLiteral:
+		return String
+	case *ast.Identifier:
+		if t, ok := c.scope.lookup(exp.Value); ok {
+			return t
+		}
+		if t, ok := builtins[exp.Value]; ok {
+			return t
+		}
+		// left for the compiler to report
+		return Any
+	case *ast.PrefixExpression:
+		return c.prefix(exp)
+	case *ast.InfixExpression:
+		return c.infix(exp)
+	case *ast.IfExpression:
+		c.expr(exp.Condition)
+		cons := c.block(exp.Consequence)
+		if exp.Alternative == nil {
+			return join(cons, Nil)
+		}
+		return join(cons, c.block(exp.Alternative))
+	case *ast.FunctionLiteral:
+		return c.function(exp)
+	case *ast.CallExpression:
+		return c.call(exp)
+	case *ast.ArrayLiteral:
+		return &Array{Elem: c.elements(exp.Elements)}
+	case *ast.SetLiteral:
+		return &Set{Elem: c.elements(exp.Elements)}
+	case *ast.HashLiteral:
+		var key, val Type
+		for _, pair := range exp.Pairs {
+			key = join(key, c.expr(pair.Key))
+			val = join(val, c.expr(pair.Value))
+		}
+		if key == nil {
+			return &Hash{Key: Any, Value: Any}
+		}
+		return &Hash{Key: key, Value: val}
+	case *ast.IndexExpression:
+		return c.index(exp)
+	case *ast.FieldAccessExpression:
+		c.expr(exp.Object)
+	case *ast.FieldAssignExpression:
+		c.expr(exp.Object)
+		return c.expr(exp.Value)
+	case *ast.MethodCallExpression:
+		c.expr(exp.Object)
+		c.elements(exp.Arguments)
+	case *ast.YieldExpression:
+		c.expr(exp.Value)
+		// resuming a generator never passes a value back in
+		return Nil
+	}
+	return Any
+}
+
+// the joined type of a list of expressions
+func (c *Checker) elements(exps []ast.Expression) Type {
+	var t Type
+	for _, el := range exps {
+		t = join(t, c.expr(el))
+	}
+	if t == nil {
+		return Any
+	}
+	return t
+}
+
+// concrete types are the ones the checker can hold an operand to
+func concrete(t Type) bool {
+	if t == Any {
+		return false
+	}
+	_, isUnion := t.(*Union)
+	return !isUnion
+}
+
+func (c *Checker) prefix(pe *ast.PrefixExpression) Type {
+	right := c.expr(pe.Right)
+	if pe.Operator == "!" {
+		return Bool
+	}
+	if concrete(right) && right != Int {
+		c.errorf(pe.Token, "unknown operator: %s%s", pe.Operator, right)
+	}
+	return Int
+}
+
+func (c *Checker) infix(ie *ast.InfixExpression) Type {
+	left, right := c.expr(ie.Left), c.expr(ie.Right)
+
+	switch ie.Operator {
+	case "==", "!=":
+		return Bool
+	case "<", ">":
+		c.ordered(ie, left, right)
+		return Bool
+	case "+":
+		return c.operands(ie, left, right, Int, String)
+	default:
+		return c.operands(ie, left, right, Int)
+	}
+}
+
+// checks the operands of an operator that takes two values of one of
+// the allowed types, and returns the type it was used with
+func (c *Checker) operands(ie *ast.InfixExpression, left, right Type, allowed ...*Basic) Type {
+	if concrete(left) && concrete(right) && left.String() != right.String() {
+		c.errorf(ie.Token, "type mismatch: %s %s %s", left, ie.Operator, right)
+		return Any
+	}
+
+	for _, operand := range []Type{left, right} {
+		if !concrete(operand) {
+			continue
+		}
+		for _, t := range allowed {
+			if operand == t {
+				return t
+			}
+		}
+		c.errorf(ie.Token, "unknown operator: %s %s %s", left, ie.Operator, right)
+		return Any
+	}
+	return Any
+}
+
+// checks the operands of < and >, which object.Compare orders when both
+// are ints, both are strings or both are arrays of values it can order
+func (c *Checker) ordered(ie *ast.InfixExpression, left, right Type) {
+	if concrete(left) && concrete(right) && !Assignable(left, right) && !Assignable(right, left) {
+		c.errorf(ie.Token, "type mismatch: %s %s %s", left, ie.Operator, right)
+		return
+	}
+	if !orderable(left) || !orderable(right) {
+		c.errorf(ie.Token, "unknown operator: %s %s %s", left, ie.Operator, right)
+	}
+}
+
+// orderable reports whether values of type t might be ordered. like the
+// rest of the checker, it only rules out what it knows can't be
+func orderable(t Type) bool {
+	switch t := t.(type) {
+	case *Array:
+		return orderable(t.Elem)
+	case *Union:
+		return true
+	}
+	return t == Any || t == Int || t == String
+}
+
+func (c *Checker) function(fl *ast.FunctionLiteral) Type {
+	sig := c.signature(fl)
+
+	outerScope, outerFn := c.scope, c.fn
+	c.scope = newScope(outerScope)
+	c.fn = &function{}
+	defer func() { c.scope, c.fn = outerScope, outerFn }()
+
+	if fl.ReturnType != nil && !fl.IsGenerator {
+		c.fn.returns = sig.Return
+	}
+	for i, p := range fl.Parameters {
+		c.scope.names[p.Value] = sig.Params[i]
+	}
+
+	last := c.block(fl.Body)
+
+	if fl.IsGenerator {
+		// calling it makes a generator, whatever the body returns
+		if !Assignable(Generator, sig.Return) {
+			c.errorf(fl.ReturnType.Token, "generator function can not return %s", sig.Return)
+		}
+		sig.Return = Generator
+		return sig
+	}
+
+	// the value of the last statement is returned too, unless it is a return
+	if n := len(fl.Body.Statements); n == 0 {
+		c.result(last, fl.Body.Token)
+	} else if _, ok := fl.Body.Statements[n-1].(*ast.ReturnStatement); !ok {
+		c.result(last, ast.StartToken(fl.Body.Statements[n-1]))
+	}
+
+	if fl.ReturnType == nil {
+		sig.Return = c.fn.results
+	}
+	return sig
+}
+
+// the type of a function literal from its annotations alone
+func (c *Checker) signature(fl *ast.FunctionLiteral) *Func {
+	sig := &Func{Required: len(fl.Parameters), Return: Any}
+	for _, p := range fl.Parameters {
+		var t Type = Any
+		if p.Type != nil {
+			t = c.resolve(p.Type)
+		}
+		sig.Params = append(sig.Params, t)
+	}
+	if fl.ReturnType != nil {
+		sig.Return = c.resolve(fl.ReturnType)
+	}
+	return sig
+}
+
+func (c *Checker) call(ce *ast.CallExpression) Type {
+	callee := c.expr(ce.Function)
+
+	args := make([]Type, len(ce.Arguments))
+	for i, arg := range ce.Arguments {
+		args[i] = c.expr(arg)
+	}
+
+	fn, ok := callee.(*Func)
+	if !ok {
+		if concrete(callee) && callee != Function {
+			c.errorf(ast.StartToken(ce.Function), "cannot call %s of type %s", ce.Function, callee)
+		}
+		return Any
+	}
+
+	if len(args) < fn.Required || (!fn.Variadic && len(args) > len(fn.Params)) {
+		c.errorf(ast.StartToken(ce.Function), "wrong number of arguments to %s: want=%s, got=%d", ce.Function, arity(fn), len(args))
+		return fn.Return
+	}
+	for i, arg := range args {
+		if param := fn.paramAt(i); !Assignable(arg, param) {
+			c.errorf(ast.StartToken(ce.Arguments[i]), "cannot use %s as %s in argument %d to %s", arg, param, i+1, ce.Function)
+		}
+	}
+	return fn.Return
+}
+
+// how many arguments fn takes, for error messages
+func arity(fn *Func) string {
+	switch {
+	case fn.Variadic:
+		return fmt.Sprintf("%d or more", fn.Required)
+	case fn.Required < len(fn.Params):
+		return fmt.Sprintf("%d to %d", fn.Required, len(fn.Params))
+	}
+	return fmt.Sprintf("%d", len(fn.Params))
+}
+
+func (c *Checker) index(ie *ast.IndexExpression) Type {
+	left, idx := c.expr(ie.Left), c.expr(ie.Index)
+
+	switch left := left.(type) {
+	case *Array:
+		if !Assignable(idx, Int) {
+			c.errorf(ast.StartToken(ie.Index), "cannot index %s with %s", left, idx)
+		}
+		return left.Elem
+	case *Hash:
+		if !Assignable(idx, left.Key) {
+			c.errorf(ast.StartToken(ie.Index), "cannot index %s with %s", left, idx)
+		}
+		return left.Value
+	}
+	if left == Range {
+		return Int
+	}
+	return Any
+}
+
+// resolve turns an annotation into the Type it stands for
+func (c *Checker) resolve(ta *ast.TypeAnnotation) Type {
+	c.annotated = true
+	if t, ok := c.resolved[ta]; ok {
+		return t
+	}
+
+	var t Type
+	switch ta.Name {
+	case "array":
+		t = &Array{Elem: c.resolve(ta.Params[0])}
+	case "set":
+		t = &Set{Elem: c.resolve(ta.Params[0])}
+	case "hash":
+		t = &Hash{Key: c.resolve(ta.Params[0]), Value: c.resolve(ta.Params[1])}
+	case "union":
+		var u Type
+		for _, p := range ta.Params {
+			u = join(u, c.resolve(p))
+		}
+		t = u
+	case "func":
+		fn := &Func{Required: len(ta.Params), Variadic: ta.Variadic, Return: Any}
+		for i, p := range ta.Params {
+			if (p.Optional || (ta.Variadic && i == len(ta.Params)-1)) && i < fn.Required {
+				fn.Required = i
+			}
+			fn.Params = append(fn.Params, c.resolve(p))
+		}
+		if ta.Return != nil {
+			fn.Return = c.resolve(ta.Return)
+		}
+		t = fn
+	default:
+		if b, ok := basics[ta.Name]; ok {
+			t = b
+		} else if c.structs[ta.Name] {
+			t = &Struct{Name: ta.Name}
+		} else {
+			c.errorf(ta.Token, "unknown type %s", ta.Name)
+			t = Any
+		}
+	}
+
+	c.resolved[ta] = t
+	return t
+}
@@ -0,0 +1,157 @@
+package checker
+
+import (
+	"nala/lexer"
+	"nala/object"
+	"nala/parser"
+	"testing"
+)
+
+func parse(t *testing.T, input string) []*Error {
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return Check(prog)
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let n: int = \"five\";", []string{"1:14: cannot use string as int in let n"}},
+		{"let n: int = 5; let s: string = n;", []string{"1:33: cannot use int as string in let s"}},
+		{
+			"let add = fn(x: int, y: int) -> int { x + y };\nadd(1, \"2\")",
+			[]string{"2:8: cannot use string as int in argument 2 to add"},
+		},
+		{
+			"let add = fn(x: int, y: int) -> int { x + y };\nadd(1)",
+			[]string{"2:1: wrong number of arguments to add: want=2, got=1"},
+		},
+		{
+			"let f = fn(x: int) -> string { return x; }",
+			[]string{"1:32: cannot return int from a function returning string"},
+		},
+		{"let f = fn(x: int) -> string { x }", []string{"1:32: cannot return int from a function returning string"}},
+		{"let f = fn() -> int { }", []string{"1:21: cannot return nil from a function returning int"}},
+		{"let f = fn(x: int) -> int { if (x > 0) { x } }", []string{"1:29: cannot return int | nil from a function returning int"}},
+		{"let f = fn(s: string) { s - 1 }", []string{"1:27: type mismatch: string - int"}},
+		{"let f = fn(s: string) { s * s }", []string{"1:27: unknown operator: string * string"}},
+		{"let f = fn(b: bool) { -b }", []string{"1:23: unknown operator: -bool"}},
+		{"let xs: [int] = [1, \"a\"];", []string{"1:17: cannot use [int | string] as [int] in let xs"}},
+		{"let h: {string: int} = {}; h[1]", []string{"1:30: cannot index {string: int} with int"}},
+		{"let p: Point = 1;", []string{"1:8: unknown type Point"}},
+		{"struct Point { x, y }\nlet p: Point = Point(1);", []string{"2:16: wrong number of arguments to Point: want=2, got=1"}},
+		{"let n: int = 5; n(1)", []string{"1:17: cannot call n of type int"}},
+		{"let g = fn() -> int { yield 1 }", []string{"1:17: generator function can not return int"}},
+		{
+			"let apply = fn(f: fn(int) -> int, x: int) -> int { f(x) };\napply(fn(s: string) { s }, 1)",
+			[]string{"2:7: cannot use fn(string) -> string as fn(int) -> int in argument 1 to apply"},
+		},
+		{
+			"let n: int = len(5);\nlet s: string = range(1);",
+			[]string{
+				"1:18: cannot use int as string | [any] | {any: any} | #{any} | range in argument 1 to len",
+				"2:17: cannot use range as string in let s",
+			},
+		},
+		{"let n: int = 1; puts(n, n, n); push([], 1, 2)", []string{"1:32: wrong number of arguments to push: want=2, got=3"}},
+		{"let n: int = 5; [true] < [false]", []string{"1:24: unknown operator: [bool] < [bool]"}},
+		{"let n: int = 5; [n] > [\"a\"]", []string{"1:21: type mismatch: [int] > [string]"}},
+		{"let n: int = 5; [n] > n", []string{"1:21: type mismatch: [int] > int"}},
+	}
+
+	for _, tt := range tests {
+		errs := parse(t, tt.input)
+
+		if len(errs) != len(tt.expected) {
+			t.Errorf("wrong number of errors for %q. want=%d, got=%v", tt.input, len(tt.expected), errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Error() != tt.expected[i] {
+				t.Errorf("wrong error for %q. want=%q, got=%q", tt.input, tt.expected[i], err.Error())
+			}
+		}
+	}
+}
+
+func TestCheckAccepts(t *testing.T) {
+	tests := []string{
+		"let n: int = 5; let m: int = n * 2 + len([1, 2]);",
+		"let f = fn(xs: [int]) -> int { if (len(xs) == 0) { 0 } else { first(xs) + f(rest(xs)) } }",
+		"let f = fn(x: int) -> int { if (x > 0) { return x } else { return 0 } }",
+		"let id = fn(x) { x }; let s: string = id(1);",
+		"let greet = fn(name: string?) -> string { \"hi \" + name }",
+		"let xs: [int | string] = [1, \"a\"]; let ys: [any] = xs;",
+		"let h: {string: int} = {\"a\": 1}; let n: int = h[\"a\"];",
+		"let r: range = range(1, 10, 2); let n: int = r[0];",
+		"struct Point { x, y }\nlet origin = fn() -> Point { Point(0, 0) }; let p: Point = origin();",
+		"let g = fn(n: int) -> generator { yield n; yield from range(n) }; let v = next(g(2));",
+		"let ch: chan = chan(); let t: task = spawn(fn(c: chan) { send(c, 1) }, ch);",
+		"let f: fn = len; let n: int = 1; puts(n, \"a\", [n]);",
+		"let apply = fn(f: fn(int) -> int, x: int) -> int { f(x) }; apply(fn(x) { x + 1 }, 1)",
+		"let n: int = 5; let b: bool = [1, 2] < [1, 3]; let c: bool = [[\"a\"]] > [[\"b\"], []]; let d: bool = [] < [n];",
+		"let xs: [int | string] = [1, \"a\"]; let b: bool = xs < [\"b\"];",
+	}
+
+	for _, input := range tests {
+		if errs := parse(t, input); len(errs) != 0 {
+			t.Errorf("unexpected errors for %q: %v", input, errs)
+		}
+	}
+}
+
+func TestUnannotatedProgramsAreNotChecked(t *testing.T) {
+	tests := []string{
+		"let s = \"a\" - 1;",
+		"len(5)",
+		"let add = fn(x, y) { x + y }; add(1)",
+	}
+
+	for _, input := range tests {
+		if errs := parse(t, input); len(errs) != 0 {
+			t.Errorf("unexpected errors for %q: %v", input, errs)
+		}
+	}
+}
+
+func TestCheckerKeepsGlobals(t *testing.T) {
+	c := New()
+
+	first := parser.New(lexer.New("let add = fn(x, y) { x + y }; let n = 5;"))
+	if errs := c.Check(first.ParseProgram()); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	second := parser.New(lexer.New("let s: string = n; add(1)"))
+	errs := c.Check(second.ParseProgram())
+	expected := []string{
+		"1:17: cannot use int as string in let s",
+		"1:20: wrong number of arguments to add: want=2, got=1",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%d, got=%v", len(expected), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("wrong error. want=%q, got=%q", expected[i], err.Error())
+		}
+	}
+}
+
+func TestBuiltinSignatures(t *testing.T) {
+	for _, def := range object.Builtins {
+		fn, ok := builtins[def.Name].(*Func)
+		if !ok {
+			t.Errorf("builtin %s is not a function. got=%s", def.Name, builtins[def.Name])
+			continue
+		}
+		if fn.String() != def.Signature {
+			t.Errorf("signature of %s does not round trip. want=%q, got=%q", def.Name, def.Signature, fn.String())
+		}
+	}
+}
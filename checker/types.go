@@ -0,0 +1,209 @@
+package checker
+
+import (
+	"strings"
+)
+
+// Type is what the checker knows about the values an expression can
+// produce. Any stands for values it knows nothing about, which is where
+// unannotated code ends up whenever inference runs out
+type Type interface {
+	String() string
+}
+
+// Basic is a type with no parts, as in int or chan
+type Basic struct {
+	Name string
+}
+
+func (b *Basic) String() string { return b.Name }
+
+var (
+	Any       = &Basic{Name: "any"}
+	Int       = &Basic{Name: "int"}
+	Bool      = &Basic{Name: "bool"}
+	String    = &Basic{Name: "string"}
+	Nil       = &Basic{Name: "nil"}
+	Range     = &Basic{Name: "range"}
+	Generator = &Basic{Name: "generator"}
+	Task      = &Basic{Name: "task"}
+	Chan      = &Basic{Name: "chan"}
+	Function  = &Basic{Name: "fn"} // any function at all
+)
+
+var basics = map[string]*Basic{
+	"any":       Any,
+	"int":       Int,
+	"bool":      Bool,
+	"string":    String,
+	"nil":       Nil,
+	"range":     Range,
+	"generator": Generator,
+	"task":      Task,
+	"chan":      Chan,
+	"fn":        Function,
+}
+
+type Array struct {
+	Elem Type
+}
+
+func (a *Array) String() string { return "[" + a.Elem.String() + "]" }
+
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string { return "{" + h.Key.String() + ": " + h.Value.String() + "}" }
+
+type Set struct {
+	Elem Type
+}
+
+func (s *Set) String() string { return "#{" + s.Elem.String() + "}" }
+
+// Func is a function or a builtin. parameters from Required on can be
+// left out, and a Variadic function takes any number of its last one
+type Func struct {
+	Params   []Type
+	Required int
+	Variadic bool
+	Return   Type
+}
+
+func (f *Func) String() string {
+	params := []string{}
+	for i, p := range f.Params {
+		switch {
+		case f.Variadic && i == len(f.Params)-1:
+			params = append(params, p.String()+"...")
+		case i >= f.Required:
+			params = append(params, p.String()+"?")
+		default:
+			params = append(params, p.String())
+		}
+	}
+	return "fn(" + strings.Join(params, ", ") + ") -> " + f.Return.String()
+}
+
+// paramAt is the type of the i'th argument of a call, or nil if there
+// is no parameter for it
+func (f *Func) paramAt(i int) Type {
+	if i < len(f.Params) {
+		return f.Params[i]
+	}
+	if f.Variadic && len(f.Params) > 0 {
+		return f.Params[len(f.Params)-1]
+	}
+	return nil
+}
+
+// Struct is a value made by the constructor of a struct declaration
+type Struct struct {
+	Name string
+}
+
+func (s *Struct) String() string { return s.Name }
+
+// Union is a value that can be any one of its Options
+type Union struct {
+	Options []Type
+}
+
+func (u *Union) String() string {
+	opts := []string{}
+	for _, o := range u.Options {
+		opts = append(opts, o.String())
+	}
+	return strings.Join(opts, " | ")
+}
+
+// Assignable reports whether a value of type from can be used where to
+// is expected. Any goes both ways, so unannotated code is never rejected
+// for what the checker could not work out
+func Assignable(from, to Type) bool {
+	if from == Any || to == Any {
+		return true
+	}
+
+	if u, ok := from.(*Union); ok {
+		for _, o := range u.Options {
+			if !Assignable(o, to) {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch to := to.(type) {
+	case *Union:
+		for _, o := range to.Options {
+			if Assignable(from, o) {
+				return true
+			}
+		}
+		return false
+	case *Basic:
+		if _, isFunc := from.(*Func); isFunc && to == Function {
+			return true
+		}
+		f, ok := from.(*Basic)
+		return ok && f.Name == to.Name
+	case *Array:
+		f, ok := from.(*Array)
+		return ok && Assignable(f.Elem, to.Elem)
+	case *Hash:
+		f, ok := from.(*Hash)
+		return ok && Assignable(f.Key, to.Key) && Assignable(f.Value, to.Value)
+	case *Set:
+		f, ok := from.(*Set)
+		return ok && Assignable(f.Elem, to.Elem)
+	case *Struct:
+		f, ok := from.(*Struct)
+		return ok && f.Name == to.Name
+	case *Func:
+		f, ok := from.(*Func)
+		if !ok || !Assignable(f.Return, to.Return) {
+			return false
+		}
+		// every call that fits to has to fit f as well
+		if to.Required < f.Required || (to.Variadic && !f.Variadic) ||
+			(!f.Variadic && len(to.Params) > len(f.Params)) {
+			return false
+		}
+		for i, p := range to.Params {
+			if !Assignable(p, f.paramAt(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// join is the type of a value that is either a or b
+func join(a, b Type) Type {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case a == Any || b == Any:
+		return Any
+	case Assignable(a, b):
+		return b
+	case Assignable(b, a):
+		return a
+	}
+
+	u := &Union{}
+	for _, t := range []Type{a, b} {
+		if tu, ok := t.(*Union); ok {
+			u.Options = append(u.Options, tu.Options...)
+		} else {
+			u.Options = append(u.Options, t)
+		}
+	}
+	return u
+}
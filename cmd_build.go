@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"nala/compiler"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// nala build [-prelude file] [-O] [-o file.nlc] file
+// compiles file, along with the prelude, to bytecode that nala run can
+// run without parsing or compiling anything
+func runBuild(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	preludeFlag := newPreludeFlag(flags, "File to compile into the program, ahead of it")
+	output := flags.String("o", "", "Where to write the bytecode. defaults to the file with a .nlc extension")
+	optimize := flags.Bool("O", false, "Optimize the compiled code")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return fail("build: expected a single file")
+	}
+	path := flags.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".nlc"
+	}
+
+	preludePath, err := preludeFlag.file()
+	if err != nil {
+		return fail("%s", err)
+	}
+	bc, symbols, err := compileProgram(preludePath, path, *optimize)
+	if err != nil {
+		return fail("%s", err)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fail("%s", err)
+	}
+	w := bufio.NewWriter(f)
+	err = compiler.WriteByteCode(w, bc, symbols)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*output)
+		return fail("%s: %s", *output, err)
+	}
+	return 0
+}
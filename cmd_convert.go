@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"nala/format"
+	"nala/source"
+	"path/filepath"
+	"strings"
+)
+
+// nala convert --to el|nl [-w] file...
+// prints each file in the other language, or with -w writes it next to
+// the original under the new extension
+func runConvert(args []string) int {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	to := flags.String("to", "", "The language to convert to: el or nl")
+	write := flags.Bool("w", false, "Write each result to a file with the new extension")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *to != "el" && *to != "nl" {
+		return fail("convert: --to must be el or nl, got %q", *to)
+	}
+	if flags.NArg() == 0 {
+		return fail("convert: no files given")
+	}
+
+	for _, path := range flags.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fail("%s", err)
+		}
+		out, err := format.Convert(string(src), source.IsEllisp(path), *to == "el")
+		if err != nil {
+			return fail("%s: %s", path, err)
+		}
+
+		if !*write {
+			fmt.Print(out)
+			continue
+		}
+		target := strings.TrimSuffix(path, filepath.Ext(path)) + "." + *to
+		if target == path {
+			return fail("convert: %s is already %s", path, *to)
+		}
+		if err := ioutil.WriteFile(target, []byte(out), 0644); err != nil {
+			return fail("%s", err)
+		}
+	}
+	return 0
+}
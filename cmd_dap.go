@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"nala/dap"
+	"os"
+)
+
+// nala dap [-prelude file]
+// serves the Debug Adapter Protocol over stdin and stdout
+func runDap(args []string) int {
+	flags := flag.NewFlagSet("dap", flag.ContinueOnError)
+	preludeFlag := newPreludeFlag(flags, "File to run before each program")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	prelude, err := preludeFlag.file()
+	if err != nil {
+		return fail("%s", err)
+	}
+
+	if err := dap.NewServer(os.Stdin, os.Stdout, prelude).Run(); err != nil {
+		return fail("dap: %s", err)
+	}
+	return 0
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"nala/object"
+	"nala/vm"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const debugHelp = `commands:
+  break <line|name>   stop at a line of the file, or on entry to a function
+  delete <id>         remove a breakpoint
+  breakpoints         list the breakpoints
+  continue, c         run to the next breakpoint
+  step, s             run to the next statement, stepping into calls
+  next, n             run to the next statement, stepping over calls
+  out, o              run until the current function returns
+  backtrace, bt       show the call stack
+  frame <n>           select frame n of the backtrace for locals, free and print
+  locals              show the locals of the selected frame
+  free                show the free variables of the selected frame
+  globals             show the globals
+  stack               show the operand stack
+  print, p <expr>     evaluate a Nala expression in the selected frame
+  list, l             show the source around the current line
+  quit, q             stop the program
+`
+
+// nala debug [-prelude file] file
+// runs file on the VM under an interactive debugger, stopped before its
+// first statement. the prelude is run first, without stopping
+func runDebug(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	preludeFlag := newPreludeFlag(flags, "File to run before the debugged one")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return fail("debug: expected a single file")
+	}
+	path := flags.Arg(0)
+
+	preludePath, err := preludeFlag.file()
+	if err != nil {
+		return fail("%s", err)
+	}
+	prog, err := compileForVM(preludePath, path, false)
+	if err != nil {
+		return fail("%s", err)
+	}
+
+	machine := vm.NewWithGlobalsStore(prog.bytecode, prog.globals)
+	console := &debugConsole{
+		in:    bufio.NewScanner(os.Stdin),
+		out:   os.Stdout,
+		file:  path,
+		lines: strings.Split(prog.src, "\n"),
+	}
+	d := vm.NewDebugger(machine, prog.symbols, true)
+	d.OnStop = console.stop
+
+	err = machine.Run()
+	if err == vm.ErrQuit {
+		return 0
+	}
+	if err != nil {
+		return fail("%s: %s", path, err)
+	}
+	fmt.Fprintln(console.out, "program finished")
+	return 0
+}
+
+// debugConsole reads debugger commands each time the program stops
+type debugConsole struct {
+	in    *bufio.Scanner
+	out   io.Writer
+	file  string
+	lines []string // of file
+
+	frame int // selected for locals, free and print
+}
+
+func (c *debugConsole) stop(d *vm.Debugger, s vm.Stop) vm.StepMode {
+	c.frame = 0
+	if s.Breakpoint != nil {
+		fmt.Fprintf(c.out, "breakpoint %d, ", s.Breakpoint.ID)
+	}
+	fmt.Fprintf(c.out, "%s at %s:%d\n", s.Frame.Func, s.Frame.File, s.Frame.Line)
+	c.list(s.Frame, 0)
+
+	for {
+		fmt.Fprint(c.out, "(nala) ")
+		if !c.in.Scan() {
+			return vm.Quit
+		}
+		cmd, arg := splitCommand(c.in.Text())
+
+		switch cmd {
+		case "":
+		case "continue", "c":
+			return vm.Continue
+		case "step", "s":
+			return vm.StepIn
+		case "next", "n":
+			return vm.StepOver
+		case "out", "o":
+			return vm.StepOut
+		case "quit", "q":
+			return vm.Quit
+		case "break", "b":
+			c.addBreakpoint(d, arg)
+		case "delete":
+			id, err := strconv.Atoi(arg)
+			if err != nil || !d.Delete(id) {
+				fmt.Fprintf(c.out, "no breakpoint %s\n", arg)
+			}
+		case "breakpoints":
+			for _, bp := range d.Breakpoints() {
+				fmt.Fprintf(c.out, "%d: %s\n", bp.ID, describeBreakpoint(bp))
+			}
+		case "backtrace", "bt":
+			for i, f := range d.Frames() {
+				fmt.Fprintf(c.out, "#%d %s at %s:%d\n", i, f.Func, f.File, f.Line)
+			}
+		case "frame":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n < 0 || n >= len(d.Frames()) {
+				fmt.Fprintf(c.out, "no frame %s\n", arg)
+				continue
+			}
+			c.frame = n
+			f := d.Frames()[n]
+			fmt.Fprintf(c.out, "#%d %s at %s:%d\n", n, f.Func, f.File, f.Line)
+		case "locals":
+			vars, _ := d.Locals(c.frame)
+			c.showVariables(vars)
+		case "free":
+			vars, _ := d.Free(c.frame)
+			c.showVariables(vars)
+		case "globals":
+			c.showVariables(d.Globals())
+		case "stack":
+			stack := d.Stack()
+			for i := len(stack) - 1; i >= 0; i-- {
+				fmt.Fprintf(c.out, "%4d  %s\n", i, inspect(stack[i]))
+			}
+		case "print", "p":
+			val, err := d.Eval(arg, c.frame)
+			if err != nil {
+				fmt.Fprintf(c.out, "error: %s\n", err)
+				continue
+			}
+			fmt.Fprintln(c.out, inspect(val))
+		case "list", "l":
+			c.list(d.Frames()[c.frame], 5)
+		case "help", "h":
+			fmt.Fprint(c.out, debugHelp)
+		default:
+			fmt.Fprintf(c.out, "unknown command %s, try help\n", cmd)
+		}
+	}
+}
+
+func splitCommand(line string) (string, string) {
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(fields) == 1 {
+		return fields[0], ""
+	}
+	return fields[0], strings.TrimSpace(fields[1])
+}
+
+// break 12 stops at line 12 of the file, break name on entry to name
+func (c *debugConsole) addBreakpoint(d *vm.Debugger, arg string) {
+	if arg == "" {
+		fmt.Fprintln(c.out, "break needs a line or a function name")
+		return
+	}
+
+	var bp *vm.Breakpoint
+	if line, err := strconv.Atoi(arg); err == nil {
+		bp = d.BreakLine(c.file, line)
+	} else {
+		bp = d.BreakFunc(arg)
+	}
+	fmt.Fprintf(c.out, "breakpoint %d: %s", bp.ID, describeBreakpoint(bp))
+	if !bp.Verified {
+		fmt.Fprint(c.out, " (nothing there yet)")
+	}
+	fmt.Fprintln(c.out)
+}
+
+func describeBreakpoint(bp *vm.Breakpoint) string {
+	if bp.Func != "" {
+		return "fn " + bp.Func
+	}
+	return fmt.Sprintf("%s:%d", bp.File, bp.Line)
+}
+
+func (c *debugConsole) showVariables(vars []vm.Variable) {
+	if len(vars) == 0 {
+		fmt.Fprintln(c.out, "none")
+	}
+	for _, v := range vars {
+		fmt.Fprintf(c.out, "%s = %s\n", v.Name, inspect(v.Value))
+	}
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "(unset)"
+	}
+	return obj.Inspect()
+}
+
+// shows the lines of f's file within context of its line, marking it
+func (c *debugConsole) list(f vm.FrameInfo, context int) {
+	if f.File != c.file {
+		return
+	}
+	for n := f.Line - context; n <= f.Line+context; n++ {
+		if n < 1 || n > len(c.lines) {
+			continue
+		}
+		mark := "  "
+		if n == f.Line {
+			mark = "=>"
+		}
+		fmt.Fprintf(c.out, "%s %4d  %s\n", mark, n, c.lines[n-1])
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"nala/format"
+	"nala/source"
+)
+
+// nala fmt [-w] [-check] [-diff] file...
+// prints the formatted files, unless told to write them back or to report
+// the ones that are not formatted. -check and -diff exit with 1 when any
+// file would change, so they can guard a commit
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "Write the result back to the files")
+	check := flags.Bool("check", false, "List the files whose formatting differs")
+	diff := flags.Bool("diff", false, "Print the changes formatting would make as a diff")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		return fail("fmt: no files given")
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fail("%s", err)
+		}
+		out, err := format.Source(string(src), source.IsEllisp(path))
+		if err != nil {
+			return fail("%s: %s", path, err)
+		}
+		changed := out != string(src)
+
+		switch {
+		case *check || *diff:
+			if !changed {
+				continue
+			}
+			status = 1
+			if *diff {
+				fmt.Print(format.Diff(path, string(src), out))
+			} else {
+				fmt.Println(path)
+			}
+		case *write:
+			if !changed {
+				continue
+			}
+			if err := ioutil.WriteFile(path, []byte(out), 0644); err != nil {
+				return fail("%s", err)
+			}
+		default:
+			fmt.Print(out)
+		}
+	}
+	return status
+}
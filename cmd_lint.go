@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"nala/lint"
+	"nala/source"
+	"os"
+)
+
+// a Diagnostic along with the file it was found in
+type fileDiagnostic struct {
+	File string `json:"file"`
+	lint.Diagnostic
+}
+
+// nala lint [-json] file...
+// exits with 1 when anything was reported
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Report diagnostics as a JSON array")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		return fail("lint: no files given")
+	}
+
+	diags := []fileDiagnostic{}
+	for _, path := range flags.Args() {
+		prog, err := source.ParseFile(path)
+		if err != nil {
+			return fail("%s", err)
+		}
+		for _, d := range lint.Lint(prog) {
+			diags = append(diags, fileDiagnostic{File: path, Diagnostic: d})
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(diags)
+	} else {
+		for _, d := range diags {
+			fmt.Printf("%s:%s\n", d.File, d.Diagnostic)
+		}
+	}
+
+	if len(diags) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"nala/ast"
+	"nala/lsp"
+	"nala/source"
+	"os"
+)
+
+// nala lsp [-prelude file]
+// serves the Language Server Protocol over stdin and stdout
+func runLsp(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	preludeFlag := newPreludeFlag(flags, "File whose names every document can use")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	preludePath, err := preludeFlag.file()
+	if err != nil {
+		return fail("%s", err)
+	}
+	var prelude *ast.Program
+	if preludePath != "" {
+		prelude, err = source.ParseFile(preludePath)
+		if err != nil {
+			return fail("%s", err)
+		}
+	}
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout, prelude).Run(); err != nil {
+		return fail("lsp: %s", err)
+	}
+	return 0
+}
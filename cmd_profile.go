@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"nala/vm"
+	"os"
+)
+
+// nala profile [-prelude file] [-O] [-sample interval] [-o file] [-top n] [-lines] file
+// runs file on the VM with the profiler on, then reports the functions, or
+// lines, it spent the most time in. -o also writes a profile for go tool pprof.
+// the profiler times every instruction unless -sample makes it take a
+// sample every interval instead
+func runProfile(args []string) int {
+	flags := flag.NewFlagSet("profile", flag.ContinueOnError)
+	preludeFlag := newPreludeFlag(flags, "File to run, unprofiled, before the profiled one")
+	output := flags.String("o", "", "Write a pprof profile to this file")
+	top := flags.Int("top", 10, "How many entries to report, 0 for all of them")
+	byLine := flags.Bool("lines", false, "Report source lines rather than functions")
+	optimize := flags.Bool("O", false, "Optimize the compiled code")
+	sample := flags.Duration("sample", 0, "Take a sample of the time every interval, such as 1ms, rather than timing every instruction")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return fail("profile: expected a single file")
+	}
+	if *sample < 0 {
+		return fail("profile: -sample must not be negative")
+	}
+	path := flags.Arg(0)
+
+	preludePath, err := preludeFlag.file()
+	if err != nil {
+		return fail("%s", err)
+	}
+	prog, err := compileForVM(preludePath, path, *optimize)
+	if err != nil {
+		return fail("%s", err)
+	}
+
+	machine := vm.NewWithGlobalsStore(prog.bytecode, prog.globals)
+	profiler := vm.NewProfiler(machine)
+	if *sample > 0 {
+		profiler = vm.NewSamplingProfiler(machine, *sample)
+	}
+	if err := machine.Run(); err != nil {
+		return fail("%s: %s", path, err)
+	}
+
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fail("%s", err)
+		}
+		err = profiler.WriteProfile(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fail("%s: %s", *output, err)
+		}
+	}
+	profiler.WriteTop(os.Stdout, *top, *byLine)
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"nala/compiler"
+	"nala/vm"
+	"os"
+	"path/filepath"
+)
+
+// nala run [-prelude file] [-O] file
+// runs a program on the VM. a .nlc file from nala build is loaded as it
+// is, already holding its prelude, while source files are compiled first
+func runRun(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	preludeFlag := newPreludeFlag(flags, "File to run before a source file")
+	optimize := flags.Bool("O", false, "Optimize the compiled code")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		return fail("run: expected a single file")
+	}
+	path := flags.Arg(0)
+
+	var machine *vm.VM
+	if filepath.Ext(path) == ".nlc" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fail("%s", err)
+		}
+		bc, _, err := compiler.ReadByteCode(f)
+		f.Close()
+		if err == nil {
+			err = compiler.Verify(bc)
+		}
+		if err != nil {
+			return fail("%s: %s", path, err)
+		}
+		machine = vm.New(bc)
+	} else {
+		preludePath, err := preludeFlag.file()
+		if err != nil {
+			return fail("%s", err)
+		}
+		prog, err := compileForVM(preludePath, path, *optimize)
+		if err != nil {
+			return fail("%s", err)
+		}
+		machine = vm.NewWithGlobalsStore(prog.bytecode, prog.globals)
+	}
+
+	if err := machine.Run(); err != nil {
+		return fail("%s: %s", path, err)
+	}
+	return 0
+}
@@ -3,11 +3,11 @@ let map = fn(arr, f) {
         if (len(arr) == 0) {
             accum
         } else {
-            iter(rest(arr), push(accum, f(first(arr))))
+            iter(rest(arr), push(accum, f(first(arr))), iter)
         }
 
     };
-    iter(arr, [], iter);
+    iter(array(arr), [], iter);
 };
 
 let reduce = fn(arr, init, f) {
@@ -18,7 +18,22 @@ let reduce = fn(arr, init, f) {
             reduce(rest(arr), f(res, first(arr)), f)
         }
     }
-    iter(arr, init)
+    iter(array(arr), init)
+};
+
+let filter = fn(arr, f) {
+    let iter = fn(arr, accum, iter) {
+        if (len(arr) == 0) {
+            accum
+        } else {
+            if (f(first(arr))) {
+                iter(rest(arr), push(accum, first(arr)), iter)
+            } else {
+                iter(rest(arr), accum, iter)
+            }
+        }
+    };
+    iter(array(arr), [], iter);
 };
 
 let incr = fn(x, in) { return x + in  }
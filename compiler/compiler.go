@@ -5,7 +5,7 @@ import (
 	"nala/ast"
 	"nala/object"
 	"nala/opcode"
-	"sort"
+	"nala/token"
 )
 
 type EmittedInstruction struct {
@@ -17,19 +17,54 @@ type CompilationScope struct {
 	instructions        opcode.Instructions // instruction to be returned in *object.CompiledFunction
 	recentInstruction   EmittedInstruction  // recent instruction for this compilation scope
 	previousInstruction EmittedInstruction  // instruction before recent for this compilation scope
+	lines               []object.LineEntry  // where each statement compiled in this scope starts
 }
 
 type Compiler struct {
 	constants   []object.Object // handles language constants (integers and other objects)
 	symbolTable *SymbolTable    // handles identifier bindings
 
+	// the constants so far, found by hash key or, when they have none, kept in a list
+	hashedConstants   map[object.HashKey][]int
+	unhashedConstants []int
+	indexedConstants  int // how many of the constants are in those
+
 	scopes     []CompilationScope // slice allowing separate compilation of individual scoped objects (e.g Functions)
 	scopeIndex int                // index of current scope of compilation
+
+	// what the compiler knows about struct bindings, used to reject unknown fields early
+	structDefs   map[boundName]*object.StructType // names bound to a struct declaration
+	structValues map[boundName]*object.StructType // names bound to a value built by a known constructor
+
+	file     string // recorded in the debug info of everything compiled
+	fnName   string // the name the function literal about to be compiled is bound to
+	optimize bool   // fold constants and apply the peephole rules, see SetOptimize
+	overflow string // an instruction emitted with operands too big to encode, reported after its statement
+}
+
+// a name as defined in a particular symbol table
+type boundName struct {
+	table *SymbolTable
+	name  string
+}
+
+// Error is a compile error, found at Token
+type Error struct {
+	Token token.Token
+	Msg   string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+func errorAt(tok token.Token, format string, a ...interface{}) error {
+	return &Error{Token: tok, Msg: fmt.Sprintf(format, a...)}
 }
 
 type ByteCode struct {
 	Instructions opcode.Instructions
 	Constants    []object.Object
+	Debug        *object.DebugInfo // line table of the main instructions
+	Unverified   bool              // loaded from outside the compiler, and not yet passed by Verify
 }
 
 func New() *Compiler {
@@ -46,10 +81,13 @@ func New() *Compiler {
 	}
 
 	return &Compiler{
-		constants:   []object.Object{},
-		symbolTable: symbolTable,
-		scopes:      []CompilationScope{mainScope},
-		scopeIndex:  0,
+		constants:       []object.Object{},
+		symbolTable:     symbolTable,
+		hashedConstants: make(map[object.HashKey][]int),
+		scopes:          []CompilationScope{mainScope},
+		scopeIndex:      0,
+		structDefs:      make(map[boundName]*object.StructType),
+		structValues:    make(map[boundName]*object.StructType),
 	}
 }
 
@@ -64,25 +102,42 @@ func (c *Compiler) ByteCode() *ByteCode {
 	return &ByteCode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Debug:        &object.DebugInfo{File: c.file, Lines: c.currentScope().lines},
 	}
 }
 
+// SetFile names the file being compiled in the debug info of the
+// functions compiled from now on
+func (c *Compiler) SetFile(name string) {
+	c.file = name
+}
+
 func (c *Compiler) Compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.Program:
+		if c.optimize {
+			fold(node)
+		}
 		for _, s := range node.Statements {
-			err := c.Compile(s)
+			err := c.compileStatement(s)
 			if err != nil {
 				return err
 			}
 		}
+		if c.optimize {
+			c.optimizeScope()
+			c.specializeScope(false)
+		}
 	case *ast.ExpressionStatement:
+		c.markStatement(node)
 		err := c.Compile(node.Expression)
 		if err != nil {
 			return err
 		}
 		c.emit(opcode.OpPop)
 	case *ast.FunctionLiteral:
+		name := c.fnName
+		c.fnName = ""
 		c.enterScope()
 
 		for _, p := range node.Parameters {
@@ -104,8 +159,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if !c.recentInstructionIs(opcode.OpReturnValue) {
 			c.emit(opcode.OpReturn)
 		}
+		if c.optimize {
+			c.optimizeScope()
+			// a generator has to stay in its own frame to be resumed
+			c.specializeScope(!node.IsGenerator)
+		}
 		freeSyms := c.symbolTable.FreeSymbols     // free symbols used in this function
 		numLocals := c.symbolTable.numDefinitions // number of locals defined in this scope
+		debug := c.debugInfo(name)
 		instructions := c.leaveScope()
 
 		// write instructions to properly load FreeSymbols to be used by this function later
@@ -119,19 +180,36 @@ func (c *Compiler) Compile(node ast.Node) error {
 			Instructions:    instructions,
 			NumOfLocals:     numLocals,
 			NumOfParameters: len(node.Parameters),
+			IsGenerator:     node.IsGenerator,
+			Debug:           debug,
 		}
 		c.emit(opcode.OpClosure, c.addConstant(compiledFn), len(freeSyms))
 	case *ast.ReturnStatement:
+		c.markStatement(node)
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
 		c.emit(opcode.OpReturnValue)
-	case *ast.CallExpression:
-		err := c.Compile(node.Function)
+	case *ast.YieldExpression:
+		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
+		if node.Delegate {
+			c.emit(opcode.OpYieldFrom)
+		} else {
+			c.emit(opcode.OpYield)
+		}
+	case *ast.CallExpression:
+		// a global function is called without pushing it first
+		callee, global := c.globalCallee(node)
+		if !global {
+			err := c.Compile(node.Function)
+			if err != nil {
+				return err
+			}
+		}
 
 		for _, a := range node.Arguments {
 			err := c.Compile(a)
@@ -139,7 +217,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
-		c.emit(opcode.OpCall, len(node.Arguments))
+		if global {
+			c.emit(opcode.OpCallGlobal, callee.Index, len(node.Arguments))
+		} else {
+			c.emit(opcode.OpCall, len(node.Arguments))
+		}
 	case *ast.InfixExpression:
 		err := c.Compile(node.Left)
 		if err != nil {
@@ -173,7 +255,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			// OpEqual and then OpNegateBool
 			c.emit(opcode.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return errorAt(node.Token, "unknown operator %s", node.Operator)
 		}
 	case *ast.PrefixExpression:
 		err := c.Compile(node.Right)
@@ -187,7 +269,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case "!":
 			c.emit(opcode.OpNegateBool)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return errorAt(node.Token, "unknown operator %s", node.Operator)
 		}
 	case *ast.LetStatement:
 		// by defining a location where the function can be found,
@@ -195,7 +277,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// this however wont bind frees correctly after 2 levels of nesting
 		// as it doesn't know a "Global" location to find a value referencing
 		// itself during definition
+		c.markStatement(node)
 		symbol := c.symbolTable.Define(node.Name.Value)
+		if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+			c.fnName = node.Name.Value
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
@@ -206,16 +292,75 @@ func (c *Compiler) Compile(node ast.Node) error {
 		} else if symbol.Scope == LocalScope {
 			c.emit(opcode.OpSetLocal, symbol.Index)
 		}
+
+		c.noteLet(node)
+	case *ast.StructStatement:
+		c.markStatement(node)
+		def, err := structType(node)
+		if err != nil {
+			return err
+		}
+
+		symbol := c.symbolTable.Define(node.Name.Value)
+		c.emit(opcode.OpConstant, c.addConstant(def))
+		if symbol.Scope == GlobalScope {
+			c.emit(opcode.OpSetGlobal, symbol.Index)
+		} else {
+			c.emit(opcode.OpSetLocal, symbol.Index)
+		}
+
+		c.noteStruct(node.Name.Value, def)
+	case *ast.FieldAccessExpression:
+		err := c.checkField(node.Object, node.Field)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Object)
+		if err != nil {
+			return err
+		}
+		c.emit(opcode.OpGetField, c.addConstant(&object.String{Value: node.Field.Value}))
+	case *ast.MethodCallExpression:
+		err := c.Compile(node.Object)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range node.Arguments {
+			err := c.Compile(a)
+			if err != nil {
+				return err
+			}
+		}
+		name := c.addConstant(&object.String{Value: node.Method.Value})
+		c.emit(opcode.OpCallMethod, name, len(node.Arguments))
+	case *ast.FieldAssignExpression:
+		err := c.checkField(node.Object, node.Field)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Object)
+		if err != nil {
+			return err
+		}
+
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(opcode.OpSetField, c.addConstant(&object.String{Value: node.Field.Value}))
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return errorAt(node.Token, "undefined variable %s", node.Value)
 		}
 		// fmt.Println(symbol.Name, " ", symbol.Scope, " ", symbol.Index)
 		c.loadSymbol(symbol)
 	case *ast.BlockStatement:
 		for _, s := range node.Statements {
-			err := c.Compile(s)
+			err := c.compileStatement(s)
 			if err != nil {
 				return err
 			}
@@ -234,17 +379,16 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		// remove errant Pop statement (if it exists), so we can arbitrarily return
 		// values like if (true) { 5 } should return a 5.
-		if c.recentInstructionIs(opcode.OpPop) {
-			c.removeRecentPop()
-		}
+		c.leaveBranchValue()
 		// insert jump to finish consequence section
 		// return program to normal flow
 		jmpPos := c.emit(opcode.OpJump, 9999)
 
 		// fix the jmpNotTruthy address
 		afterConsequencePos := len(c.currentInstructions())
-		// update jump location with correct address
-		c.changeOperand(jmpNTPos, afterConsequencePos)
+		// update jump location with correct address. if the jump had to be
+		// made wide, the OpJump after it moved along
+		jmpPos = c.changeOperand(jmpNTPos, afterConsequencePos)(jmpPos)
 
 		if node.Alternative == nil {
 			// correct jump location
@@ -256,10 +400,7 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
-
-			if c.recentInstructionIs(opcode.OpPop) {
-				c.removeRecentPop()
-			}
+			c.leaveBranchValue()
 		}
 
 		afterAlternative := len(c.currentInstructions())
@@ -272,23 +413,24 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 		}
 		c.emit(opcode.OpArray, len(node.Elements))
-	case *ast.HashLiteral:
-		// collect and sort keys in ascending order
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
+	case *ast.SetLiteral:
+		for _, el := range node.Elements {
+			err := c.Compile(el)
+			if err != nil {
+				return err
+			}
 		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
-			err := c.Compile(k)
+		c.emit(opcode.OpSet, len(node.Elements))
+	case *ast.HashLiteral:
+		// pairs are compiled in source order, so OpHashMap sees
+		// them in the order they should be inserted
+		for _, p := range node.Pairs {
+			err := c.Compile(p.Key)
 			if err != nil {
 				return err
 			}
 
-			err = c.Compile(node.Pairs[k])
+			err = c.Compile(p.Value)
 			if err != nil {
 				return err
 			}
@@ -321,6 +463,74 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// structType is the type a struct statement declares
+func structType(node *ast.StructStatement) (*object.StructType, error) {
+	def := &object.StructType{Name: node.Name.Value}
+	for _, f := range node.Fields {
+		if _, exists := def.FieldIndex(f.Value); exists {
+			return nil, errorAt(f.Token, "duplicate field %s in struct %s", f.Value, def.Name)
+		}
+		def.Fields = append(def.Fields, f.Value)
+	}
+	return def, nil
+}
+
+// noteLet records what the let just compiled binds its name to
+func (c *Compiler) noteLet(node *ast.LetStatement) {
+	bound := boundName{table: c.symbolTable, name: node.Name.Value}
+	delete(c.structDefs, bound)
+	if def := c.staticStructType(node.Value); def != nil {
+		c.structValues[bound] = def
+	} else {
+		delete(c.structValues, bound)
+	}
+}
+
+// noteStruct records that name is bound to the struct declaration def
+func (c *Compiler) noteStruct(name string, def *object.StructType) {
+	bound := boundName{table: c.symbolTable, name: name}
+	c.structDefs[bound] = def
+	delete(c.structValues, bound)
+}
+
+// staticStructType returns the struct type expr is known to produce, if any.
+// only direct constructor calls and names bound to them are known
+func (c *Compiler) staticStructType(expr ast.Expression) *object.StructType {
+	switch expr := expr.(type) {
+	case *ast.Identifier:
+		table, ok := c.symbolTable.lookup(expr.Value)
+		if !ok {
+			return nil
+		}
+		return c.structValues[boundName{table: table, name: expr.Value}]
+	case *ast.CallExpression:
+		ident, ok := expr.Function.(*ast.Identifier)
+		if !ok {
+			return nil
+		}
+		table, ok := c.symbolTable.lookup(ident.Value)
+		if !ok {
+			return nil
+		}
+		return c.structDefs[boundName{table: table, name: ident.Value}]
+	default:
+		return nil
+	}
+}
+
+// rejects fields that a statically known struct type does not have.
+// everything else is left for the VM to check
+func (c *Compiler) checkField(obj ast.Expression, field *ast.Identifier) error {
+	def := c.staticStructType(obj)
+	if def == nil {
+		return nil
+	}
+	if _, ok := def.FieldIndex(field.Value); !ok {
+		return errorAt(field.Token, "unknown field %s for struct %s", field.Value, def.Name)
+	}
+	return nil
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -334,11 +544,39 @@ func (c *Compiler) loadSymbol(s Symbol) {
 	}
 }
 
-func (c *Compiler) changeOperand(opPos int, operand int) {
+// changeOperand points the jump at opPos to operand. when that changes
+// its size, like when the jump is too far for a narrow operand, the scope
+// is laid out again, and the function returned gives the new position of
+// an instruction emitted before
+// compileStatement compiles s, failing if it needed an operand bigger
+// than any instruction can hold
+func (c *Compiler) compileStatement(s ast.Statement) error {
+	if err := c.Compile(s); err != nil {
+		return err
+	}
+	if c.overflow != "" {
+		msg := c.overflow
+		c.overflow = ""
+		return errorAt(ast.StartToken(s), "%s", msg)
+	}
+	return nil
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) func(pos int) int {
 	op := opcode.OpCode(c.currentInstructions()[opPos])
 	newInstruction := opcode.Make(op, operand)
 
-	c.replaceInstruction(opPos, newInstruction)
+	old, _ := opcode.Decode(c.currentInstructions()[opPos:])
+	if old.Size == len(newInstruction) {
+		c.replaceInstruction(opPos, newInstruction)
+		return func(pos int) int { return pos }
+	}
+
+	code, index := c.decodeScope()
+	in := &code[index[opPos]]
+	in.operands[0], in.placeholder = index[operand], false
+	offsets := c.encodeScope(code, index)
+	return func(pos int) int { return offsets[index[pos]] }
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -361,6 +599,18 @@ func (c *Compiler) recentInstructionIs(op opcode.OpCode) bool {
 	return c.currentScope().recentInstruction.OpCode == op
 }
 
+// leaves the value of the branch of an if just compiled on the stack: that
+// of its last expression, or nil when it ends with a let, a struct or
+// nothing at all, so both branches leave the stack as deep
+func (c *Compiler) leaveBranchValue() {
+	switch {
+	case c.recentInstructionIs(opcode.OpPop):
+		c.removeRecentPop()
+	case !c.recentInstructionIs(opcode.OpReturnValue):
+		c.emit(opcode.OpNil)
+	}
+}
+
 func (c *Compiler) removeRecentPop() {
 	recent := c.currentScope().recentInstruction
 	prev := c.currentScope().previousInstruction
@@ -373,6 +623,10 @@ func (c *Compiler) removeRecentPop() {
 }
 
 func (c *Compiler) emit(op opcode.OpCode, operands ...int) int {
+	if !opcode.Fits(op, operands...) && c.overflow == "" {
+		def, _ := opcode.Lookup(byte(op))
+		c.overflow = fmt.Sprintf("program too large: %s operands %v are out of range", def.Name, operands)
+	}
 	ins := opcode.Make(op, operands...)
 	pos := c.addInstruction(ins)
 
@@ -406,22 +660,53 @@ func (c *Compiler) addConstant(obj object.Object) int {
 }
 
 func (c *Compiler) isExistingConstant(obj object.Object) (int, bool) {
-	hashAble, ok := obj.(object.Hashable)
-	for i, con := range c.constants {
-		cHash, cOk := con.(object.Hashable)
-		if ok && cOk {
-			if hashAble.HashKey() == cHash.HashKey() {
-				return i, true
-			}
+	// constants may have been added by another compiler sharing them
+	for ; c.indexedConstants < len(c.constants); c.indexedConstants++ {
+		i := c.indexedConstants
+		if hashAble, ok := c.constants[i].(object.Hashable); ok {
+			c.hashedConstants[hashAble.HashKey()] = append(c.hashedConstants[hashAble.HashKey()], i)
 		} else {
-			if obj.Inspect() == con.Inspect() {
+			c.unhashedConstants = append(c.unhashedConstants, i)
+		}
+	}
+
+	if hashAble, ok := obj.(object.Hashable); ok {
+		for _, i := range c.hashedConstants[hashAble.HashKey()] {
+			// matching hashes can still collide, so confirm with Equal
+			if con := c.constants[i]; sameSource(obj, con) && object.Equal(obj, con) {
 				return i, true
 			}
 		}
+		return -1, false
+	}
+	for _, i := range c.unhashedConstants {
+		if con := c.constants[i]; sameSource(obj, con) && obj.Inspect() == con.Inspect() {
+			return i, true
+		}
 	}
 	return -1, false
 }
 
+// functions compiled to the same instructions from different places in
+// the source are kept apart, so each keeps its own line table
+func sameSource(a, b object.Object) bool {
+	aFn, ok := a.(*object.CompiledFunction)
+	bFn, bOk := b.(*object.CompiledFunction)
+	if !ok || !bOk || aFn.Debug == nil || bFn.Debug == nil {
+		return true
+	}
+	if aFn.Debug.Name != bFn.Debug.Name || aFn.Debug.File != bFn.Debug.File ||
+		len(aFn.Debug.Lines) != len(bFn.Debug.Lines) {
+		return false
+	}
+	for i, l := range aFn.Debug.Lines {
+		if l != bFn.Debug.Lines[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Compiler) currentInstructions() opcode.Instructions {
 	return c.scopes[c.scopeIndex].instructions
 }
@@ -430,6 +715,66 @@ func (c *Compiler) currentScope() CompilationScope {
 	return c.scopes[c.scopeIndex]
 }
 
+// CompileAll compiles the statements of prog one at a time, carrying on
+// past the ones that fail, and returns every error it ran into. the
+// bytecode of a program with errors is not fit to run; this is for tools
+// that report all the problems in a file at once
+func (c *Compiler) CompileAll(prog *ast.Program) []error {
+	errs := []error{}
+	for _, s := range prog.Statements {
+		symbolTable := c.symbolTable
+		err := c.Compile(s)
+		if err == nil {
+			continue
+		}
+
+		errs = append(errs, err)
+		// an error inside a function leaves its scope open
+		for c.scopeIndex > 0 {
+			c.leaveScope()
+		}
+		c.symbolTable = symbolTable
+	}
+	return errs
+}
+
+// records that the next instruction emitted starts stmt
+func (c *Compiler) markStatement(stmt ast.Statement) {
+	line := ast.StartToken(stmt).Line
+	if line == 0 {
+		return
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	offset := len(scope.instructions)
+	if n := len(scope.lines); n > 0 && scope.lines[n-1].Offset == offset {
+		// the earlier statement compiled to nothing
+		scope.lines[n-1].Line = line
+		return
+	}
+	scope.lines = append(scope.lines, object.LineEntry{Offset: offset, Line: line})
+}
+
+// describes the function whose scope is being left, naming its
+// locals and free variables from the symbol table
+func (c *Compiler) debugInfo(name string) *object.DebugInfo {
+	debug := &object.DebugInfo{
+		Name:   name,
+		File:   c.file,
+		Lines:  c.currentScope().lines,
+		Locals: make([]string, c.symbolTable.numDefinitions),
+	}
+	for _, sym := range c.symbolTable.Symbols() {
+		if sym.Scope == LocalScope {
+			debug.Locals[sym.Index] = sym.Name
+		}
+	}
+	for _, sym := range c.symbolTable.FreeSymbols {
+		debug.Free = append(debug.Free, sym.Name)
+	}
+	return debug
+}
+
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
 		instructions:        opcode.Instructions{},
@@ -454,16 +799,19 @@ func (c *Compiler) leaveScope() opcode.Instructions {
 func (c *Compiler) Decompile(ins opcode.Instructions, constants []object.Object, globals []object.Object, offset string, depth int) {
 	i := 0
 	for i < len(ins) {
-		def, err := opcode.Lookup(ins[i]) // get operand definition
+		in, err := opcode.Decode(ins[i:]) // get operand definition and operands
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
-			continue
+			break
+		}
+		prefix := ""
+		if in.Wide {
+			prefix = "OpWide "
 		}
-		operands, read := opcode.ReadOperands(def, ins[i+1:])
 
-		fmt.Printf(offset+"%04d....%s....[%d bytes]\n", i, c.fmtInstruction(def, operands), i+read+1)
-		c.showOperand(def, operands, constants, globals, offset+"            ", depth+1)
-		i += read + 1
+		fmt.Printf(offset+"%04d....%s%s....[%d bytes]\n", i, prefix, c.fmtInstruction(in.Def, in.Operands), i+in.Size)
+		c.showOperand(in.Def, in.Operands, constants, globals, offset+"            ", depth+1)
+		i += in.Size
 	}
 }
 
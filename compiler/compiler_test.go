@@ -1,12 +1,16 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
+	"hash/crc32"
 	"nala/ast"
 	"nala/lexer"
 	"nala/object"
 	"nala/opcode"
 	"nala/parser"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -14,6 +18,7 @@ type CompilerTest struct {
 	input                string
 	expectedConstants    []interface{}
 	expectedInstructions []opcode.Instructions
+	optimize             bool
 }
 
 func TestIntegerArithmetic(t *testing.T) {
@@ -119,6 +124,7 @@ func runCompilerTests(t *testing.T, tests []CompilerTest) {
 		prog := parse(tt.input)
 
 		compiler := New()
+		compiler.SetOptimize(tt.optimize)
 		err := compiler.Compile(prog)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -166,6 +172,10 @@ func testConstants(expCons []interface{}, actual []object.Object) error {
 			if err != nil {
 				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
 			}
+		case string:
+			if actual[i].Inspect() != cons {
+				return fmt.Errorf("constant %d - wrong value. got=%q, want=%q", i, actual[i].Inspect(), cons)
+			}
 		}
 	}
 	return nil
@@ -388,6 +398,235 @@ func TestGlobalLetStatements(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestStructs(t *testing.T) {
+	tests := []CompilerTest{
+		{
+			input:             `struct Person { name, age } let p = Person("Nala", 3); p.name`,
+			expectedConstants: []interface{}{"struct Person { name, age }", "Nala", 3, "name"},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpSetGlobal, 0),
+				opcode.Make(opcode.OpGetGlobal, 0),
+				opcode.Make(opcode.OpConstant, 1),
+				opcode.Make(opcode.OpConstant, 2),
+				opcode.Make(opcode.OpCall, 2),
+				opcode.Make(opcode.OpSetGlobal, 1),
+				opcode.Make(opcode.OpGetGlobal, 1),
+				opcode.Make(opcode.OpGetField, 3),
+				opcode.Make(opcode.OpPop),
+			},
+		},
+		{
+			input: `fn(p) { p.age = 4 }`,
+			expectedConstants: []interface{}{4, "age", []opcode.Instructions{
+				opcode.Make(opcode.OpGetLocal, 0),
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpSetField, 1),
+				opcode.Make(opcode.OpReturnValue),
+			}},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpClosure, 2, 0),
+				opcode.Make(opcode.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []CompilerTest{
+		{
+			input:             `[1].push(2)`,
+			expectedConstants: []interface{}{1, 2, "push"},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpArray, 1),
+				opcode.Make(opcode.OpConstant, 1),
+				opcode.Make(opcode.OpCallMethod, 2, 1),
+				opcode.Make(opcode.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []CompilerTest{
+		{
+			input: `fn(xs) { yield 1; yield from xs }`,
+			expectedConstants: []interface{}{
+				1,
+				[]opcode.Instructions{
+					opcode.Make(opcode.OpConstant, 0),
+					opcode.Make(opcode.OpYield),
+					opcode.Make(opcode.OpPop),
+					opcode.Make(opcode.OpGetLocal, 0),
+					opcode.Make(opcode.OpYieldFrom),
+					opcode.Make(opcode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpClosure, 1, 0),
+				opcode.Make(opcode.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+
+	for input, want := range map[string]bool{
+		"fn() { yield 1 }":          true,
+		"fn() { 1 }":                false,
+		"fn() { fn() { yield 1 } }": false,
+	} {
+		comp := New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		consts := comp.ByteCode().Constants
+		fn := consts[len(consts)-1].(*object.CompiledFunction)
+		if fn.IsGenerator != want {
+			t.Errorf("wrong IsGenerator for %q. want=%t, got=%t", input, want, fn.IsGenerator)
+		}
+	}
+}
+
+func TestUnknownStructFields(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct P { x } let p = P(1); p.y`, "unknown field y for struct P"},
+		{`struct P { x } P(1).y = 2`, "unknown field y for struct P"},
+		{`struct P { x } let p = P(1); fn() { p.y }`, "unknown field y for struct P"},
+		{`struct P { x, x }`, "duplicate field x in struct P"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q but resulted in none", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+
+	// once p is rebound or only known at runtime, the VM has to check the field
+	for _, input := range []string{
+		`struct P { x } let p = P(1); let p = 5; p.y`,
+		`struct P { x } fn(p) { p.y }`,
+	} {
+		if err := New().Compile(parse(input)); err != nil {
+			t.Errorf("unexpected compiler error for %q: %s", input, err)
+		}
+	}
+}
+
+func TestCompilerErrorPositions(t *testing.T) {
+	tests := []struct {
+		input  string
+		line   int
+		column int
+	}{
+		{"let a = 1;\nlet b = a + c;", 2, 13},
+		{"struct P { x }\nlet p = P(1);\n  p.y", 3, 5},
+		{"struct P { x, y, x }", 1, 18},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		compileErr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("expected a positioned compiler error for %q. got=%T (%v)", tt.input, err, err)
+		}
+		if compileErr.Token.Line != tt.line || compileErr.Token.Column != tt.column {
+			t.Errorf("wrong position for %q. want=%d:%d, got=%d:%d",
+				tt.input, tt.line, tt.column, compileErr.Token.Line, compileErr.Token.Column)
+		}
+	}
+}
+
+func TestCompileAll(t *testing.T) {
+	input := `
+	struct P { x }
+	let f = fn(a) { a + b };
+	let p = P(1);
+	p.y;
+	f(p.x)
+	`
+
+	errs := New().CompileAll(parse(input).(*ast.Program))
+	expected := []string{"undefined variable b", "unknown field y for struct P"}
+	if len(errs) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%q, got=%v", expected, errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("wrong error at %d. want=%q, got=%q", i, expected[i], err)
+		}
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	input := `let one = 1;
+let outer = fn(a) {
+  let b = a + one;
+  fn(c) { a + b + c }
+};
+outer(2)(3)`
+
+	comp := New()
+	comp.SetFile("test.nl")
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bc := comp.ByteCode()
+
+	if fmt.Sprint(bc.Debug.Lines) != "[{0 1} {6 2} {13 6}]" || bc.Debug.File != "test.nl" {
+		t.Errorf("wrong main debug info. got=%+v", bc.Debug)
+	}
+
+	fns := []*object.DebugInfo{}
+	for _, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fns = append(fns, fn.Debug)
+		}
+	}
+	if len(fns) != 2 {
+		t.Fatalf("expected 2 functions, got=%d", len(fns))
+	}
+
+	inner, outer := fns[0], fns[1]
+	if inner.Name != "" || fmt.Sprint(inner.Lines) != "[{0 4}]" ||
+		fmt.Sprint(inner.Locals) != "[c]" || fmt.Sprint(inner.Free) != "[a b]" {
+		t.Errorf("wrong inner debug info. got=%+v", inner)
+	}
+	if outer.Name != "outer" || fmt.Sprint(outer.Lines) != "[{0 3} {8 4}]" ||
+		fmt.Sprint(outer.Locals) != "[a b]" || len(outer.Free) != 0 {
+		t.Errorf("wrong outer debug info. got=%+v", outer)
+	}
+	if outer.Line(15) != 4 || outer.Line(5) != 3 {
+		t.Errorf("wrong lines for offsets. got=%d, %d", outer.Line(15), outer.Line(5))
+	}
+}
+
+func TestFunctionsKeepTheirOwnLines(t *testing.T) {
+	input := `[fn() { 1 }, fn() { 1 },
+	fn() { 1 }]`
+
+	comp := New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	// the same instructions each time, but the last function is on a line of its own
+	if n := len(comp.ByteCode().Constants); n != 3 {
+		t.Errorf("wrong number of constants. want=3, got=%d", n)
+	}
+}
+
 func TestArrayLiterals(t *testing.T) {
 	tests := []CompilerTest{
 		{
@@ -431,6 +670,33 @@ func TestArrayLiterals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+func TestSetLiterals(t *testing.T) {
+	tests := []CompilerTest{
+		{
+			input:             "#{}",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpSet, 0),
+				opcode.Make(opcode.OpPop),
+			},
+		},
+		{
+			input:             "#{1, 2 + 3}",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpConstant, 1),
+				opcode.Make(opcode.OpConstant, 2),
+				opcode.Make(opcode.OpAdd),
+				opcode.Make(opcode.OpSet, 2),
+				opcode.Make(opcode.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 func TestHashMapLiteral(t *testing.T) {
 	tests := []CompilerTest{
 		{
@@ -471,6 +737,19 @@ func TestHashMapLiteral(t *testing.T) {
 				opcode.Make(opcode.OpPop),
 			},
 		},
+		{
+			// pairs are compiled in source order, not sorted
+			input:             "{3: 4, 1: 2}",
+			expectedConstants: []interface{}{3, 4, 1, 2},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpConstant, 1),
+				opcode.Make(opcode.OpConstant, 2),
+				opcode.Make(opcode.OpConstant, 3),
+				opcode.Make(opcode.OpHashMap, 4),
+				opcode.Make(opcode.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -941,3 +1220,582 @@ func TestClosures(t *testing.T) {
 
 	runCompilerTests(t, tests)
 }
+
+func writeTestByteCode(t *testing.T, input string) ([]byte, *ByteCode) {
+	t.Helper()
+
+	comp := New()
+	comp.SetFile("test.nl")
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var out bytes.Buffer
+	if err := WriteByteCode(&out, comp.ByteCode(), comp.symbolTable); err != nil {
+		t.Fatalf("WriteByteCode: %s", err)
+	}
+	return out.Bytes(), comp.ByteCode()
+}
+
+func TestByteCodeRoundTrip(t *testing.T) {
+	input := `let big = -9000000000;
+struct Point { x, y }
+let gen = fn(n) { yield n; };
+let add2 = fn(a) { fn(b) { a + b + big } };
+add2(1)(2) + len("nala")`
+
+	data, bc := writeTestByteCode(t, input)
+	loaded, symbols, err := ReadByteCode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadByteCode: %s", err)
+	}
+
+	if loaded.Instructions.String() != bc.Instructions.String() {
+		t.Errorf("wrong instructions.\nwant=%s\ngot=%s", bc.Instructions, loaded.Instructions)
+	}
+	if !reflect.DeepEqual(loaded.Debug, bc.Debug) {
+		t.Errorf("wrong debug info. want=%+v, got=%+v", bc.Debug, loaded.Debug)
+	}
+	if len(loaded.Constants) != len(bc.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(bc.Constants), len(loaded.Constants))
+	}
+	for i, c := range bc.Constants {
+		got := loaded.Constants[i]
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			gotFn, ok := got.(*object.CompiledFunction)
+			if !ok || gotFn.Instructions.String() != fn.Instructions.String() ||
+				gotFn.NumOfLocals != fn.NumOfLocals || gotFn.NumOfParameters != fn.NumOfParameters ||
+				gotFn.IsGenerator != fn.IsGenerator || !reflect.DeepEqual(gotFn.Debug, fn.Debug) {
+				t.Errorf("constant %d: wrong function. want=%+v, got=%+v", i, fn, got)
+			}
+			continue
+		}
+		if c.Type() != got.Type() || c.Inspect() != got.Inspect() {
+			t.Errorf("constant %d: want=%s, got=%s", i, c.Inspect(), got.Inspect())
+		}
+	}
+
+	for _, name := range []string{"big", "Point", "gen", "add2"} {
+		sym, ok := symbols.Resolve(name)
+		if !ok || sym.Scope != GlobalScope {
+			t.Errorf("global %s was not loaded. got=%+v", name, sym)
+		}
+	}
+	if sym, ok := symbols.Resolve("len"); !ok || sym.Scope != BuiltInScope {
+		t.Errorf("builtin len was not loaded. got=%+v", sym)
+	}
+}
+
+func TestReadByteCodeErrors(t *testing.T) {
+	data, _ := writeTestByteCode(t, `let a = fn(x) { x * 2 }; a(21)`)
+	withChecksum := func(b []byte) []byte {
+		body := b[:len(b)-4]
+		return append(body, byte(crc32.ChecksumIEEE(body)>>24), byte(crc32.ChecksumIEEE(body)>>16),
+			byte(crc32.ChecksumIEEE(body)>>8), byte(crc32.ChecksumIEEE(body)))
+	}
+	modified := func(f func(b []byte)) []byte {
+		b := append([]byte{}, data...)
+		f(b)
+		return b
+	}
+
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte("let a = 1;"), "not a compiled nala program"},
+		{data[:8], "not a compiled nala program"},
+		{modified(func(b []byte) { b[5] = FormatVersion + 1 }), fmt.Sprintf("compiled for version %d of the format, expected version %d", FormatVersion+1, FormatVersion)},
+		{modified(func(b []byte) { b[len(b)-6] ^= 0xff }), "checksum mismatch, the file is corrupt"},
+		// with the last byte of the payload cut off, or an extra one
+		{withChecksum(append(append([]byte{}, data[:len(data)-5]...), 0, 0, 0, 0)), "malformed program: unexpected end of data"},
+		{withChecksum(append(append([]byte{}, data[:len(data)-4]...), 0, 0, 0, 0, 0)), "malformed program: 1 bytes left over"},
+	}
+
+	for i, tt := range tests {
+		_, _, err := ReadByteCode(bytes.NewReader(tt.data))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("test %d: wrong error. want=%q, got=%v", i, tt.expected, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	fn := func(locals int, ins ...opcode.Instructions) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concatInstructions(ins), NumOfLocals: locals}
+	}
+	name := &object.String{Value: "x"}
+
+	tests := []struct {
+		main      []opcode.Instructions
+		constants []object.Object
+		expected  string
+	}{
+		{[]opcode.Instructions{{255}}, nil, "main: 0000: opcode 255 undefined"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpConstant, 0)[:2]}, nil, "main: 0000: OpConstant is cut off"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpConstant, 1), opcode.Make(opcode.OpPop)},
+			[]object.Object{name}, "main: 0000: OpConstant refers to constant 1 of 1"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpConstant, 0), opcode.Make(opcode.OpGetField, 0)},
+			[]object.Object{&object.Integer{Value: 1}}, "main: 0003: OpGetField needs a STRING constant, constant 0 is a INTEGER"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpGetBuiltin, 200)}, nil, fmt.Sprintf("main: 0000: OpGetBuiltin refers to builtin 200 of %d", len(object.Builtins))},
+		{[]opcode.Instructions{opcode.Make(opcode.OpJump, 2), opcode.Make(opcode.OpNil)},
+			nil, "main: 0000: OpJump to 0002, which is not the start of an instruction"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpGetLocal, 0)}, nil, "main: 0000: OpGetLocal refers to local 0 of 0"},
+		// let x = [1]; len(x), with the OpSetGlobal changed to set another global
+		{[]opcode.Instructions{
+			opcode.Make(opcode.OpConstant, 0),
+			opcode.Make(opcode.OpArray, 1),
+			opcode.Make(opcode.OpSetGlobal, 1),
+			opcode.Make(opcode.OpGetBuiltin, 0),
+			opcode.Make(opcode.OpGetGlobal, 0),
+			opcode.Make(opcode.OpCall, 1),
+			opcode.Make(opcode.OpPop),
+		}, []object.Object{&object.Integer{Value: 1}}, "main: 0011: OpGetGlobal reads global 0, which is never set"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpClosure, 0, 0), opcode.Make(opcode.OpPop)},
+			[]object.Object{fn(0, opcode.Make(opcode.OpCallGlobal, 3, 0), opcode.Make(opcode.OpReturnValue))},
+			"function 0: 0000: OpCallGlobal reads global 3, which is never set"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpPop)}, nil, "main: 0000: OpPop pops 1 values, the stack only has 0"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpNil), opcode.Make(opcode.OpNil), opcode.Make(opcode.OpAdd), opcode.Make(opcode.OpAdd)},
+			nil, "main: 0003: OpAdd pops 2 values, the stack only has 1"},
+		// the false branch skips the constant, leaving one value fewer
+		{[]opcode.Instructions{
+			opcode.Make(opcode.OpTrue),
+			opcode.Make(opcode.OpJumpNotTruthy, 7),
+			opcode.Make(opcode.OpConstant, 0),
+			opcode.Make(opcode.OpPop),
+		}, []object.Object{name}, "main: 0007: reached with 1 values on the stack and with 0"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpClosure, 0, 0)},
+			[]object.Object{fn(1, opcode.Make(opcode.OpGetLocal, 1), opcode.Make(opcode.OpReturnValue))},
+			"function 0: 0000: OpGetLocal refers to local 1 of 1"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpNil), opcode.Make(opcode.OpClosure, 0, 1)},
+			[]object.Object{fn(0, opcode.Make(opcode.OpGetFree, 1), opcode.Make(opcode.OpReturnValue))},
+			"function 0: 0000: OpGetFree refers to free variable 1 of 1"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpClosure, 0, 0)},
+			[]object.Object{fn(0, opcode.Make(opcode.OpNil), opcode.Make(opcode.OpPop))},
+			"function 0: 0001: runs off the end of the function"},
+		{[]opcode.Instructions{opcode.Make(opcode.OpClosure, 0, 0)},
+			[]object.Object{&object.CompiledFunction{Instructions: opcode.Make(opcode.OpReturn), NumOfParameters: 1}},
+			"function 0: 1 parameters but only 0 locals"},
+	}
+
+	for i, tt := range tests {
+		bc := &ByteCode{Instructions: concatInstructions(tt.main), Constants: tt.constants, Unverified: true}
+		err := Verify(bc)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("test %d: wrong error. want=%q, got=%v", i, tt.expected, err)
+		}
+		if !bc.Unverified {
+			t.Errorf("test %d: failed bytecode was marked verified", i)
+		}
+	}
+}
+
+func TestVerifyCompiledCode(t *testing.T) {
+	input := `let counter = fn(start) {
+  let n = start;
+  fn() { yield n; yield from [n + 1, n + 2]; }
+};
+struct Point { x, y }
+let p = Point(1, {"a": 2}["a"]);
+p.x = if (p.y > 1) { let q = 3 } else { 4 };
+if (len(array(counter(1)())) == 3) { p.distance(p) }`
+
+	comp := New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bc := comp.ByteCode()
+	bc.Unverified = true
+	if err := Verify(bc); err != nil {
+		t.Fatalf("compiled code does not verify: %s", err)
+	}
+	if bc.Unverified {
+		t.Errorf("verified bytecode is still marked unverified")
+	}
+}
+
+func TestOptimizations(t *testing.T) {
+	tests := []CompilerTest{
+		{
+			input:             `1 + 2 * 3; -(5); !true; "na" + "la"; 2 < 3 == true`,
+			expectedConstants: []interface{}{7, -5, "nala"},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpPop),
+				opcode.Make(opcode.OpConstant, 1),
+				opcode.Make(opcode.OpPop),
+				opcode.Make(opcode.OpFalse),
+				opcode.Make(opcode.OpPop),
+				opcode.Make(opcode.OpConstant, 2),
+				opcode.Make(opcode.OpPop),
+				opcode.Make(opcode.OpTrue),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// left for the VM to report
+			input:             "1 / 0",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpConstant, 1),
+				opcode.Make(opcode.OpDivide),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// the untaken branch stays in the constants, but not in the code
+			input:             "if (1 < 2) { 10 } else { 20 }; 3333",
+			expectedConstants: []interface{}{10, 20, 3333},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpPop),
+				opcode.Make(opcode.OpConstant, 2),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			input:             "if (0) { 10 }",
+			expectedConstants: []interface{}{0, 10},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpNil),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// the jump out of the inner if goes straight past the outer one
+			input:             "let x = 1; if (x) { if (x) { 1 } else { 2 } } else { 3 }",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpSetGlobal, 0),
+				opcode.Make(opcode.OpGetGlobal, 0),
+				opcode.Make(opcode.OpJumpNotTruthy, 30),
+				opcode.Make(opcode.OpGetGlobal, 0),
+				opcode.Make(opcode.OpJumpNotTruthy, 24),
+				opcode.Make(opcode.OpConstant, 0),
+				opcode.Make(opcode.OpJump, 33),
+				opcode.Make(opcode.OpConstant, 1),
+				opcode.Make(opcode.OpJump, 33),
+				opcode.Make(opcode.OpConstant, 2),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// nothing after a return is kept
+			input: "fn(x) { if (x) { return 1 } else { return 2 }; x }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]opcode.Instructions{
+					opcode.Make(opcode.OpGetLocal0),
+					opcode.Make(opcode.OpJumpNotTruthy, 8),
+					opcode.Make(opcode.OpConstant, 0),
+					opcode.Make(opcode.OpReturnValue),
+					opcode.Make(opcode.OpConstant, 1),
+					opcode.Make(opcode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpClosure, 2, 0),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// a jump to a return returns right away
+			input: "fn(x) { if (x) { 1 } else { 2 } }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				[]opcode.Instructions{
+					opcode.Make(opcode.OpGetLocal0),
+					opcode.Make(opcode.OpJumpNotTruthy, 8),
+					opcode.Make(opcode.OpConstant, 0),
+					opcode.Make(opcode.OpReturnValue),
+					opcode.Make(opcode.OpConstant, 1),
+					opcode.Make(opcode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpClosure, 2, 0),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestOptimizedLineTables(t *testing.T) {
+	input := `let f = fn(x) {
+  if (x) {
+    return 1
+  } else {
+    return 2
+  }
+  puts(x)
+};
+let y = 1 + 2;
+f(y)`
+
+	comp := New()
+	comp.SetOptimize(true)
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bc := comp.ByteCode()
+	if fmt.Sprint(bc.Debug.Lines) != "[{0 1} {7 9} {13 10}]" {
+		t.Errorf("wrong main lines. got=%v\n%s", bc.Debug.Lines, bc.Instructions)
+	}
+	fn := bc.Constants[2].(*object.CompiledFunction)
+	// the unreachable puts(x) loses its line
+	if fmt.Sprint(fn.Debug.Lines) != "[{0 2} {4 3} {8 5}]" {
+		t.Errorf("wrong function lines. got=%v\n%s", fn.Debug.Lines, fn.Instructions)
+	}
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []CompilerTest{
+		{
+			input: "let fib = fn(x) { if (x < 2) { return x }; fib(x - 1) + fib(x - 2) }",
+			expectedConstants: []interface{}{
+				2,
+				1,
+				[]opcode.Instructions{
+					opcode.Make(opcode.OpGetLocal0),
+					opcode.Make(opcode.OpLThanConstant, 0),
+					opcode.Make(opcode.OpJumpNotTruthy, 9),
+					opcode.Make(opcode.OpGetLocal0),
+					opcode.Make(opcode.OpReturnValue),
+					opcode.Make(opcode.OpNil),
+					opcode.Make(opcode.OpPop),
+					opcode.Make(opcode.OpGetLocal0),
+					opcode.Make(opcode.OpSubtractConstant, 1),
+					opcode.Make(opcode.OpCallGlobal, 0, 1),
+					opcode.Make(opcode.OpGetLocal0),
+					opcode.Make(opcode.OpSubtractConstant, 0),
+					opcode.Make(opcode.OpCallGlobal, 0, 1),
+					opcode.Make(opcode.OpAdd),
+					opcode.Make(opcode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpClosure, 2, 0),
+				opcode.Make(opcode.OpSetGlobal, 0),
+			},
+			optimize: true,
+		},
+		{
+			// locals past the fourth, and calls in tail position
+			input: "fn(a, b, c, d, e, f) { f(e) }",
+			expectedConstants: []interface{}{
+				[]opcode.Instructions{
+					opcode.Make(opcode.OpGetLocal, 5),
+					opcode.Make(opcode.OpGetLocal, 4),
+					opcode.Make(opcode.OpTailCall, 1),
+					opcode.Make(opcode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpClosure, 0, 0),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+		{
+			// a generator keeps its frame, so it can be resumed
+			input: "fn(f) { yield 1; f() }",
+			expectedConstants: []interface{}{
+				1,
+				[]opcode.Instructions{
+					opcode.Make(opcode.OpConstant, 0),
+					opcode.Make(opcode.OpYield),
+					opcode.Make(opcode.OpPop),
+					opcode.Make(opcode.OpGetLocal0),
+					opcode.Make(opcode.OpCall, 0),
+					opcode.Make(opcode.OpReturnValue),
+				},
+			},
+			expectedInstructions: []opcode.Instructions{
+				opcode.Make(opcode.OpClosure, 1, 0),
+				opcode.Make(opcode.OpPop),
+			},
+			optimize: true,
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// statements pushing the integers from 0 to n-1, each its own constant
+func manyConstants(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%d;\n", i)
+	}
+	return b.String()
+}
+
+func TestWideOperands(t *testing.T) {
+	comp := New()
+	if err := comp.Compile(parse(manyConstants(70000))); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	ins := comp.ByteCode().Instructions
+	last := concatInstructions([]opcode.Instructions{opcode.Make(opcode.OpConstant, 69999), opcode.Make(opcode.OpPop)})
+	if got := ins[len(ins)-len(last):]; !bytes.Equal(got, last) {
+		t.Errorf("wrong last instructions.\nwant=%q\ngot=%q", opcode.Instructions(last), opcode.Instructions(got))
+	}
+	if !strings.HasSuffix(ins.String(), "OpWide OpConstant 69999\n"+fmt.Sprintf("%04d OpPop\n", len(ins)-1)) {
+		t.Errorf("wide instruction printed wrongly:\n%s", ins[len(ins)-len(last):])
+	}
+
+	// the consequence is too long for a narrow jump over it, so the
+	// OpJumpNotTruthy is made wide, moving the OpJump after it along
+	comp = New()
+	if err := comp.Compile(parse("if (true) { " + manyConstants(20000) + " }")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bc := comp.ByteCode()
+	jnt, _ := opcode.Decode(bc.Instructions[1:])
+	if jnt.Op != opcode.OpJumpNotTruthy || !jnt.Wide {
+		t.Fatalf("expected a wide OpJumpNotTruthy. got=%+v", jnt)
+	}
+	jump, _ := opcode.Decode(bc.Instructions[jnt.Operands[0]-6:]) // wide too, as it jumps further still
+	end := len(bc.Instructions) - 1                               // after the OpNil of the alternative
+	if jump.Op != opcode.OpJump || jump.Operands[0] != end {
+		t.Errorf("wrong jump over the alternative. got=%+v, want a jump to %d", jump, end)
+	}
+	bc.Unverified = true
+	if err := Verify(bc); err != nil {
+		t.Errorf("wide code does not verify: %s", err)
+	}
+
+	// the VM only has room for 65536 globals
+	var globals strings.Builder
+	for i := 0; i <= 65536; i++ {
+		fmt.Fprintf(&globals, "let g%d = 0;\n", i)
+	}
+	err := New().Compile(parse(globals.String()))
+	compileErr, ok := err.(*Error)
+	if !ok || compileErr.Token.Line != 65537 {
+		t.Fatalf("expected an error on the last global. got=%v", err)
+	}
+}
+
+func TestRegisterCompiler(t *testing.T) {
+	tests := []struct {
+		input     string
+		optimize  bool
+		main      string
+		fn        string // the code of the function among the constants, if any
+		registers int    // how many registers that function needs
+	}{
+		{
+			input: "1 + 2",
+			main: `0000 RegLoadConstant 1 0
+0001 RegAddConstant 0 1 1
+0002 RegResult 0
+`,
+		},
+		{
+			// parameters, then locals, then temporaries
+			input: "let f = fn(a, b) { let c = a + b; c }",
+			main: `0000 RegClosure 0 0 0
+0001 RegSetGlobal 0 0
+0002 RegResult 0
+`,
+			fn: `0000 RegAdd 2 0 1
+0001 RegMove 3 2
+0002 RegReturn 3
+`,
+			registers: 4,
+		},
+		{
+			// a call in tail position, its arguments right after the callee
+			input:    "fn(f, x) { f(x) }",
+			optimize: true,
+			main: `0000 RegClosure 0 0 0
+0001 RegResult 0
+`,
+			fn: `0000 RegMove 2 0
+0001 RegMove 3 1
+0002 RegTailCall 2 1
+0003 RegReturn 2
+`,
+			registers: 4,
+		},
+		{
+			// a generator keeps its frame, so it can be resumed
+			input:    "fn(f) { yield 1; f() }",
+			optimize: true,
+			main: `0000 RegClosure 0 1 0
+0001 RegResult 0
+`,
+			fn: `0000 RegLoadConstant 2 0
+0001 RegYield 2
+0002 RegMove 1 0
+0003 RegCall 1 0
+0004 RegReturn 1
+`,
+			registers: 3,
+		},
+		{
+			// free variables are gathered in the registers the closure is made in
+			input: "fn(a) { fn() { a } }",
+			main: `0000 RegClosure 0 1 0
+0001 RegResult 0
+`,
+			fn: `0000 RegMove 1 0
+0001 RegClosure 1 0 1
+0002 RegReturn 1
+`,
+			registers: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		rc := NewRegister()
+		rc.SetOptimize(tt.optimize)
+		if err := rc.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bc := rc.ByteCode()
+
+		if got := bc.Main.Registers.String(); got != tt.main {
+			t.Errorf("%s: wrong main code.\nwant=%q\ngot=%q", tt.input, tt.main, got)
+		}
+		if tt.fn == "" {
+			continue
+		}
+		// the outermost function is the last one compiled
+		var fn *object.CompiledFunction
+		for _, c := range bc.Constants {
+			if f, ok := c.(*object.CompiledFunction); ok {
+				fn = f
+			}
+		}
+		if fn == nil {
+			t.Fatalf("%s: no function among the constants", tt.input)
+		}
+		if got := fn.Registers.String(); got != tt.fn {
+			t.Errorf("%s: wrong function code.\nwant=%q\ngot=%q", tt.input, tt.fn, got)
+		}
+		if fn.NumOfRegisters != tt.registers {
+			t.Errorf("%s: wrong number of registers. want=%d, got=%d", tt.input, tt.registers, fn.NumOfRegisters)
+		}
+	}
+
+	// the register compiler rejects what the stack one does
+	rc := NewRegister()
+	err := rc.Compile(parse("struct P { x } P(1).y"))
+	if err == nil || err.Error() != "unknown field y for struct P" {
+		t.Errorf("expected unknown field error, got=%v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package compiler
+
+import (
+	"nala/object"
+	"nala/opcode"
+)
+
+// codeIns is an instruction taken out of a scope to be rewritten. jumps
+// hold the index of the instruction they go to rather than its offset,
+// len(code) standing for the end
+type codeIns struct {
+	op       opcode.OpCode
+	operands []int
+
+	placeholder bool // a jump not pointed anywhere yet, whose operand is kept as it is
+	removed     bool // does nothing, so control flows on to the next one
+	unreachable bool
+}
+
+func (in codeIns) dropped() bool {
+	return in.removed || in.unreachable
+}
+
+func isJump(op opcode.OpCode) bool {
+	return op == opcode.OpJump || op == opcode.OpJumpNotTruthy
+}
+
+// decodeScope takes the instructions of the current scope apart. index
+// maps the offset of each instruction, and of the end, to its index
+func (c *Compiler) decodeScope() ([]codeIns, map[int]int) {
+	ins := c.currentInstructions()
+	code := []codeIns{}
+	index := make(map[int]int)
+	for i := 0; i < len(ins); {
+		in, _ := opcode.Decode(ins[i:]) // what the compiler emits always decodes
+		index[i] = len(code)
+		code = append(code, codeIns{op: in.Op, operands: in.Operands})
+		i += in.Size
+	}
+	index[len(ins)] = len(code)
+
+	for i := range code {
+		if !isJump(code[i].op) {
+			continue
+		}
+		if target, ok := index[code[i].operands[0]]; ok {
+			code[i].operands[0] = target
+		} else {
+			code[i].placeholder = true
+		}
+	}
+	return code, index
+}
+
+// encodeScope lays code out again as the instructions of the current
+// scope, and returns the new offset of each index. a dropped instruction
+// takes up no room, so it gets the offset of the one after it. jumps
+// are made wide when their targets need it, which can push other targets
+// further along, so the layout is repeated until it settles. the line
+// table moves along with the instructions, losing the lines of
+// unreachable ones
+func (c *Compiler) encodeScope(code []codeIns, index map[int]int) []int {
+	end := len(code)
+	offsets := make([]int, end+1)
+	encode := func(in codeIns) []byte {
+		if isJump(in.op) && !in.placeholder {
+			return opcode.Make(in.op, offsets[in.operands[0]])
+		}
+		return opcode.Make(in.op, in.operands...)
+	}
+
+	for settled := false; !settled; {
+		settled = true
+		size := 0
+		for i := range code {
+			if offsets[i] != size {
+				offsets[i] = size
+				settled = false
+			}
+			if !code[i].dropped() {
+				size += len(encode(code[i]))
+			}
+		}
+		if offsets[end] != size {
+			offsets[end] = size
+			settled = false
+		}
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	out := opcode.Instructions{}
+	scope.recentInstruction = EmittedInstruction{}
+	scope.previousInstruction = EmittedInstruction{}
+	for i, in := range code {
+		if in.dropped() {
+			continue
+		}
+		out = append(out, encode(in)...)
+		scope.previousInstruction = scope.recentInstruction
+		scope.recentInstruction = EmittedInstruction{OpCode: in.op, Position: offsets[i]}
+	}
+	scope.instructions = out
+
+	lines := []object.LineEntry{}
+	for _, l := range scope.lines {
+		i, ok := index[l.Offset]
+		if !ok || i < end && code[i].unreachable {
+			continue
+		}
+		entry := object.LineEntry{Offset: offsets[i], Line: l.Line}
+		if n := len(lines); n > 0 && lines[n-1].Offset == entry.Offset {
+			lines[n-1] = entry
+			continue
+		}
+		lines = append(lines, entry)
+	}
+	scope.lines = lines
+	return offsets
+}
@@ -0,0 +1,330 @@
+package compiler
+
+import (
+	"nala/ast"
+	"nala/object"
+	"nala/opcode"
+	"nala/token"
+	"strconv"
+)
+
+// SetOptimize turns the optimizations on or off for what is compiled from
+// now on. constant expressions are folded in the AST, which is rewritten
+// in place, and the instructions of each function and of the main program
+// are then improved with the peephole rules in optimizeScope and the
+// superinstructions in specializeScope
+func (c *Compiler) SetOptimize(on bool) {
+	c.optimize = on
+}
+
+// fold replaces the constant expressions under node with their values
+func fold(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			fold(s)
+		}
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			fold(s)
+		}
+	case *ast.ExpressionStatement:
+		node.Expression = foldExpression(node.Expression)
+	case *ast.LetStatement:
+		node.Value = foldExpression(node.Value)
+	case *ast.ReturnStatement:
+		node.ReturnValue = foldExpression(node.ReturnValue)
+	}
+}
+
+func foldExpression(expr ast.Expression) ast.Expression {
+	switch node := expr.(type) {
+	case *ast.PrefixExpression:
+		node.Right = foldExpression(node.Right)
+		if folded := foldPrefix(node); folded != nil {
+			return folded
+		}
+	case *ast.InfixExpression:
+		node.Left = foldExpression(node.Left)
+		node.Right = foldExpression(node.Right)
+		if folded := foldInfix(node); folded != nil {
+			return folded
+		}
+	case *ast.IfExpression:
+		node.Condition = foldExpression(node.Condition)
+		fold(node.Consequence)
+		if node.Alternative != nil {
+			fold(node.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		fold(node.Body)
+	case *ast.CallExpression:
+		node.Function = foldExpression(node.Function)
+		foldExpressions(node.Arguments)
+	case *ast.ArrayLiteral:
+		foldExpressions(node.Elements)
+	case *ast.SetLiteral:
+		foldExpressions(node.Elements)
+	case *ast.HashLiteral:
+		for i := range node.Pairs {
+			node.Pairs[i].Key = foldExpression(node.Pairs[i].Key)
+			node.Pairs[i].Value = foldExpression(node.Pairs[i].Value)
+		}
+	case *ast.IndexExpression:
+		node.Left = foldExpression(node.Left)
+		node.Index = foldExpression(node.Index)
+	case *ast.FieldAccessExpression:
+		node.Object = foldExpression(node.Object)
+	case *ast.FieldAssignExpression:
+		node.Object = foldExpression(node.Object)
+		node.Value = foldExpression(node.Value)
+	case *ast.MethodCallExpression:
+		node.Object = foldExpression(node.Object)
+		foldExpressions(node.Arguments)
+	case *ast.YieldExpression:
+		node.Value = foldExpression(node.Value)
+	}
+	return expr
+}
+
+func foldExpressions(exprs []ast.Expression) {
+	for i, e := range exprs {
+		exprs[i] = foldExpression(e)
+	}
+}
+
+// folds what the VM would work out the same way every time. anything
+// that is an error at run time, like dividing by 0, is left for the VM
+func foldPrefix(node *ast.PrefixExpression) ast.Expression {
+	switch right := node.Right.(type) {
+	case *ast.IntegerLiteral:
+		if node.Operator == "-" {
+			return integerLiteral(node, -right.Value)
+		}
+	case *ast.Boolean:
+		if node.Operator == "!" {
+			return booleanLiteral(node, !right.Value)
+		}
+	}
+	return nil
+}
+
+func foldInfix(node *ast.InfixExpression) ast.Expression {
+	switch left := node.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := node.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		l, r := left.Value, right.Value
+		switch node.Operator {
+		case "+":
+			return integerLiteral(node, l+r)
+		case "-":
+			return integerLiteral(node, l-r)
+		case "*":
+			return integerLiteral(node, l*r)
+		case "/":
+			if r != 0 {
+				return integerLiteral(node, l/r)
+			}
+		case "%":
+			if r != 0 {
+				return integerLiteral(node, l%r)
+			}
+		case "<":
+			return booleanLiteral(node, l < r)
+		case ">":
+			return booleanLiteral(node, l > r)
+		case "==":
+			return booleanLiteral(node, l == r)
+		case "!=":
+			return booleanLiteral(node, l != r)
+		}
+	case *ast.StringLiteral:
+		right, ok := node.Right.(*ast.StringLiteral)
+		if !ok {
+			return nil
+		}
+		l, r := left.Value, right.Value
+		switch node.Operator {
+		case "+":
+			return &ast.StringLiteral{Token: foldedToken(node, token.STRING, l+r), Value: l + r}
+		case "<":
+			return booleanLiteral(node, l < r)
+		case ">":
+			return booleanLiteral(node, l > r)
+		case "==":
+			return booleanLiteral(node, l == r)
+		case "!=":
+			return booleanLiteral(node, l != r)
+		}
+	case *ast.Boolean:
+		right, ok := node.Right.(*ast.Boolean)
+		if !ok {
+			return nil
+		}
+		switch node.Operator {
+		case "==":
+			return booleanLiteral(node, left.Value == right.Value)
+		case "!=":
+			return booleanLiteral(node, left.Value != right.Value)
+		}
+	}
+	return nil
+}
+
+// a literal standing where the folded expression started, so line tables
+// still point at it
+func foldedToken(expr ast.Expression, typ token.TokenType, literal string) token.Token {
+	start := ast.StartToken(expr)
+	return token.Token{Type: typ, Literal: literal, Line: start.Line, Column: start.Column}
+}
+
+func integerLiteral(expr ast.Expression, value int64) *ast.IntegerLiteral {
+	return &ast.IntegerLiteral{Token: foldedToken(expr, token.INT, strconv.FormatInt(value, 10)), Value: value}
+}
+
+func booleanLiteral(expr ast.Expression, value bool) *ast.Boolean {
+	typ := token.TokenType(token.FALSE)
+	if value {
+		typ = token.TRUE
+	}
+	return &ast.Boolean{Token: foldedToken(expr, typ, strconv.FormatBool(value)), Value: value}
+}
+
+// optimizeScope rewrites the instructions of the current scope until none
+// of these rules applies:
+//   - jumps to jumps go straight to where the last one goes
+//   - a jump to a return becomes the return
+//   - a jump to the next instruction is removed
+//   - a constant tested by OpJumpNotTruthy becomes a jump, or nothing
+//   - code that can't be reached, like that after OpReturnValue, is removed
+//
+// the line table is moved along with the instructions
+func (c *Compiler) optimizeScope() {
+	code, index := c.decodeScope()
+	end := len(code)
+
+	live := func(i int) int {
+		for i < end && code[i].dropped() {
+			i++
+		}
+		return i
+	}
+	// where a jump to i really ends up
+	resolve := func(i int) int {
+		i = live(i)
+		for steps := 0; i < end && code[i].op == opcode.OpJump && steps < end; steps++ {
+			i = live(code[i].operands[0])
+		}
+		return i
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		targeted := make(map[int]bool)
+		for i := range code {
+			if in := code[i]; !in.dropped() && isJump(in.op) {
+				targeted[live(in.operands[0])] = true
+			}
+		}
+
+		for i := range code {
+			in := &code[i]
+			if in.dropped() {
+				continue
+			}
+			next := live(i + 1)
+
+			if isJump(in.op) {
+				if t := resolve(in.operands[0]); t != in.operands[0] {
+					in.operands[0] = t
+					changed = true
+				}
+				t := in.operands[0]
+				switch {
+				case t == next:
+					in.removed = true
+					if in.op == opcode.OpJumpNotTruthy {
+						// the condition still has to come off the stack
+						in.removed = false
+						in.op, in.operands = opcode.OpPop, nil
+					}
+					changed = true
+				case in.op == opcode.OpJump && t < end &&
+					(code[t].op == opcode.OpReturn || code[t].op == opcode.OpReturnValue):
+					in.op, in.operands = code[t].op, nil
+					changed = true
+				}
+				continue
+			}
+
+			if next == end || code[next].op != opcode.OpJumpNotTruthy || targeted[next] {
+				continue
+			}
+			truthy, known := c.constantTruthiness(*in)
+			if !known {
+				continue
+			}
+			in.removed = true
+			if truthy {
+				code[next].removed = true
+			} else {
+				code[next].op = opcode.OpJump
+			}
+			changed = true
+		}
+
+		// anything not reached from the first instruction is unreachable
+		reached := make([]bool, end+1)
+		work := []int{live(0)}
+		for len(work) > 0 {
+			i := work[len(work)-1]
+			work = work[:len(work)-1]
+			if reached[i] {
+				continue
+			}
+			reached[i] = true
+			if i == end {
+				continue
+			}
+			switch code[i].op {
+			case opcode.OpReturn, opcode.OpReturnValue:
+			case opcode.OpJump:
+				work = append(work, resolve(code[i].operands[0]))
+			case opcode.OpJumpNotTruthy:
+				work = append(work, resolve(code[i].operands[0]), live(i+1))
+			default:
+				work = append(work, live(i+1))
+			}
+		}
+		for i := range code {
+			if !reached[i] && !code[i].dropped() {
+				code[i].unreachable = true
+				changed = true
+			}
+		}
+	}
+
+	c.encodeScope(code, index)
+}
+
+// whether the value in pushes is known at compile time to be truthy
+func (c *Compiler) constantTruthiness(in codeIns) (bool, bool) {
+	switch in.op {
+	case opcode.OpTrue:
+		return true, true
+	case opcode.OpFalse, opcode.OpNil:
+		return false, true
+	case opcode.OpConstant:
+		switch con := c.constants[in.operands[0]].(type) {
+		case *object.Integer:
+			return con.Value != 0, true
+		case *object.String:
+			return true, true
+		}
+	}
+	return false, false
+}
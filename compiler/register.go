@@ -0,0 +1,583 @@
+package compiler
+
+import (
+	"fmt"
+	"nala/ast"
+	"nala/object"
+	"nala/opcode"
+)
+
+// RegisterCompiler compiles the AST to the bytecode of the register VM.
+// symbols, constants and what is known about structs are kept by a
+// Compiler, the same way they are for the stack VM, so both reject the
+// same programs. each function's parameters and locals have registers of
+// their own, and the temporaries of an expression are taken above them as
+// it is compiled and given back once it is done
+type RegisterCompiler struct {
+	state  *Compiler
+	scopes []*registerScope
+}
+
+// the function being compiled
+type registerScope struct {
+	code     opcode.RegInstructions
+	reserved int // registers held by the parameters and locals
+	next     int // the first register not in use
+	size     int // how many registers the function needs
+}
+
+// RegisterByteCode is a program compiled for the register VM
+type RegisterByteCode struct {
+	Main      *object.CompiledFunction
+	Constants []object.Object
+}
+
+func NewRegister() *RegisterCompiler {
+	return &RegisterCompiler{state: New(), scopes: []*registerScope{{}}}
+}
+
+func NewRegisterWithState(s *SymbolTable, constants []object.Object) *RegisterCompiler {
+	return &RegisterCompiler{state: NewWithState(s, constants), scopes: []*registerScope{{}}}
+}
+
+// SetOptimize is Compiler.SetOptimize. constant expressions are folded,
+// and calls in tail position take over the frame of their caller
+func (rc *RegisterCompiler) SetOptimize(on bool) {
+	rc.state.SetOptimize(on)
+}
+
+// SetFile names the file being compiled in the debug info of the
+// functions compiled from now on
+func (rc *RegisterCompiler) SetFile(name string) {
+	rc.state.SetFile(name)
+}
+
+func (rc *RegisterCompiler) ByteCode() *RegisterByteCode {
+	main := rc.scopes[0]
+	return &RegisterByteCode{
+		Main: &object.CompiledFunction{
+			Registers:      main.code,
+			NumOfRegisters: main.size,
+			Debug:          &object.DebugInfo{File: rc.state.file},
+		},
+		Constants: rc.state.constants,
+	}
+}
+
+// Compile compiles a program, adding to what was compiled before
+func (rc *RegisterCompiler) Compile(node ast.Node) error {
+	prog, ok := node.(*ast.Program)
+	if !ok {
+		return fmt.Errorf("the register compiler takes a whole program, got %T", node)
+	}
+	if rc.state.optimize {
+		fold(prog)
+	}
+	for _, s := range prog.Statements {
+		if _, err := rc.statement(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statement compiles s, reporting whether it returns. the value of an
+// expression statement, a let or a struct in the main program becomes
+// the program's value, like the stack VM's last popped element
+func (rc *RegisterCompiler) statement(s ast.Statement) (bool, error) {
+	mark := rc.scope().next
+	defer rc.free(mark)
+
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		r := rc.alloc()
+		if err := rc.expression(s.Expression, r); err != nil {
+			return false, err
+		}
+		rc.result(r)
+	case *ast.ReturnStatement:
+		r, err := rc.operand(s.ReturnValue)
+		if err != nil {
+			return false, err
+		}
+		rc.emit(opcode.RegReturn, r)
+		return true, nil
+	case *ast.LetStatement:
+		symbol := rc.state.symbolTable.Define(s.Name.Value)
+		if _, ok := s.Value.(*ast.FunctionLiteral); ok {
+			rc.state.fnName = s.Name.Value
+		}
+
+		r := symbol.Index
+		if symbol.Scope != LocalScope {
+			r = rc.alloc()
+		}
+		if err := rc.expression(s.Value, r); err != nil {
+			return false, err
+		}
+		if symbol.Scope == GlobalScope {
+			rc.emit(opcode.RegSetGlobal, symbol.Index, r)
+		}
+		rc.result(r)
+		rc.state.noteLet(s)
+	case *ast.StructStatement:
+		def, err := structType(s)
+		if err != nil {
+			return false, err
+		}
+
+		symbol := rc.state.symbolTable.Define(s.Name.Value)
+		r := symbol.Index
+		if symbol.Scope != LocalScope {
+			r = rc.alloc()
+		}
+		rc.emit(opcode.RegLoadConstant, r, rc.state.addConstant(def))
+		if symbol.Scope == GlobalScope {
+			rc.emit(opcode.RegSetGlobal, symbol.Index, r)
+		}
+		rc.result(r)
+		rc.state.noteStruct(s.Name.Value, def)
+	}
+	return false, nil
+}
+
+// block compiles the statements of b, leaving the value of the last one in
+// dst. a block ending with anything other than an expression is nil
+func (rc *RegisterCompiler) block(b *ast.BlockStatement, dst int) (bool, error) {
+	for i, s := range b.Statements {
+		if es, ok := s.(*ast.ExpressionStatement); ok && i == len(b.Statements)-1 {
+			return false, rc.expression(es.Expression, dst)
+		}
+		returned, err := rc.statement(s)
+		if err != nil {
+			return false, err
+		}
+		if returned && i == len(b.Statements)-1 {
+			return true, nil
+		}
+	}
+	rc.emit(opcode.RegLoadNil, dst)
+	return false, nil
+}
+
+// expression compiles expr to leave its value in dst. the temporaries it
+// takes are given back before it returns
+func (rc *RegisterCompiler) expression(expr ast.Expression, dst int) error {
+	mark := rc.scope().next
+	defer rc.free(mark)
+
+	switch node := expr.(type) {
+	case *ast.IntegerLiteral:
+		rc.emit(opcode.RegLoadConstant, dst, rc.state.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.StringLiteral:
+		rc.emit(opcode.RegLoadConstant, dst, rc.state.addConstant(&object.String{Value: node.Value}))
+	case *ast.Boolean:
+		if node.Value {
+			rc.emit(opcode.RegLoadTrue, dst)
+		} else {
+			rc.emit(opcode.RegLoadFalse, dst)
+		}
+	case *ast.Identifier:
+		symbol, ok := rc.state.symbolTable.Resolve(node.Value)
+		if !ok {
+			return errorAt(node.Token, "undefined variable %s", node.Value)
+		}
+		rc.loadSymbol(symbol, dst)
+	case *ast.PrefixExpression:
+		right, err := rc.operand(node.Right)
+		if err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "-":
+			rc.emit(opcode.RegNegateInt, dst, right)
+		case "!":
+			rc.emit(opcode.RegNegateBool, dst, right)
+		default:
+			return errorAt(node.Token, "unknown operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		op, ok := registerOperators[node.Operator]
+		if !ok {
+			return errorAt(node.Token, "unknown operator %s", node.Operator)
+		}
+		left, err := rc.operandBefore(node.Left, node.Right)
+		if err != nil {
+			return err
+		}
+		if k, ok := rc.constant(node.Right); ok {
+			if constOp, ok := opcode.RegWithConstant[op]; ok {
+				rc.emit(constOp, dst, left, k)
+				return nil
+			}
+		}
+		right, err := rc.operand(node.Right)
+		if err != nil {
+			return err
+		}
+		rc.emit(op, dst, left, right)
+	case *ast.IfExpression:
+		cond, err := rc.operand(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpNotTruthy := rc.emit(opcode.RegJumpNotTruthy, cond, 0)
+		rc.free(mark)
+
+		if _, err := rc.block(node.Consequence, dst); err != nil {
+			return err
+		}
+		jump := rc.emit(opcode.RegJump, 0)
+
+		rc.scope().code[jumpNotTruthy].B = len(rc.scope().code)
+		if node.Alternative == nil {
+			rc.emit(opcode.RegLoadNil, dst)
+		} else if _, err := rc.block(node.Alternative, dst); err != nil {
+			return err
+		}
+		rc.scope().code[jump].A = len(rc.scope().code)
+	case *ast.FunctionLiteral:
+		return rc.function(node, dst)
+	case *ast.CallExpression:
+		base := rc.window(dst)
+		if err := rc.expression(node.Function, base); err != nil {
+			return err
+		}
+		if err := rc.arguments(node.Arguments); err != nil {
+			return err
+		}
+		rc.emit(opcode.RegCall, base, len(node.Arguments))
+		rc.move(dst, base)
+	case *ast.MethodCallExpression:
+		base := rc.window(dst)
+		if err := rc.expression(node.Object, base); err != nil {
+			return err
+		}
+		if err := rc.arguments(node.Arguments); err != nil {
+			return err
+		}
+		name := rc.state.addConstant(&object.String{Value: node.Method.Value})
+		rc.emit(opcode.RegCallMethod, base, name, len(node.Arguments))
+		rc.move(dst, base)
+	case *ast.FieldAccessExpression:
+		if err := rc.state.checkField(node.Object, node.Field); err != nil {
+			return err
+		}
+		obj, err := rc.operand(node.Object)
+		if err != nil {
+			return err
+		}
+		rc.emit(opcode.RegGetField, dst, obj, rc.state.addConstant(&object.String{Value: node.Field.Value}))
+	case *ast.FieldAssignExpression:
+		if err := rc.state.checkField(node.Object, node.Field); err != nil {
+			return err
+		}
+		obj, err := rc.operandBefore(node.Object, node.Value)
+		if err != nil {
+			return err
+		}
+		value, err := rc.operand(node.Value)
+		if err != nil {
+			return err
+		}
+		rc.emit(opcode.RegSetField, obj, rc.state.addConstant(&object.String{Value: node.Field.Value}), value)
+		rc.move(dst, value)
+	case *ast.IndexExpression:
+		left, err := rc.operandBefore(node.Left, node.Index)
+		if err != nil {
+			return err
+		}
+		index, err := rc.operand(node.Index)
+		if err != nil {
+			return err
+		}
+		rc.emit(opcode.RegIndex, dst, left, index)
+	case *ast.ArrayLiteral:
+		base := rc.scope().next
+		if err := rc.arguments(node.Elements); err != nil {
+			return err
+		}
+		rc.emit(opcode.RegArray, dst, base, len(node.Elements))
+	case *ast.SetLiteral:
+		base := rc.scope().next
+		if err := rc.arguments(node.Elements); err != nil {
+			return err
+		}
+		rc.emit(opcode.RegSet, dst, base, len(node.Elements))
+	case *ast.HashLiteral:
+		base := rc.scope().next
+		for _, p := range node.Pairs {
+			if err := rc.arguments([]ast.Expression{p.Key, p.Value}); err != nil {
+				return err
+			}
+		}
+		rc.emit(opcode.RegHashMap, dst, base, len(node.Pairs)*2)
+	case *ast.YieldExpression:
+		if err := rc.expression(node.Value, dst); err != nil {
+			return err
+		}
+		if node.Delegate {
+			rc.emit(opcode.RegYieldFrom, dst)
+		} else {
+			rc.emit(opcode.RegYield, dst)
+		}
+	}
+	return nil
+}
+
+var registerOperators = map[string]opcode.RegOp{
+	"+":  opcode.RegAdd,
+	"-":  opcode.RegSubtract,
+	"*":  opcode.RegMultiply,
+	"/":  opcode.RegDivide,
+	"%":  opcode.RegModulo,
+	">":  opcode.RegGThan,
+	"<":  opcode.RegLThan,
+	"==": opcode.RegEqual,
+	"!=": opcode.RegNotEqual,
+}
+
+func (rc *RegisterCompiler) function(node *ast.FunctionLiteral, dst int) error {
+	name := rc.state.fnName
+	rc.state.fnName = ""
+
+	rc.state.symbolTable = NewEnclosedSymbolTable(rc.state.symbolTable)
+	for _, p := range node.Parameters {
+		rc.state.symbolTable.Define(p.Value)
+	}
+	reserved := len(node.Parameters) + countDefinitions(node.Body)
+	rc.scopes = append(rc.scopes, &registerScope{reserved: reserved, next: reserved, size: reserved})
+
+	r := rc.alloc()
+	returned, err := rc.block(node.Body, r)
+	if err != nil {
+		return err
+	}
+	if !returned {
+		rc.emit(opcode.RegReturn, r)
+	}
+	if rc.state.optimize && !node.IsGenerator {
+		// a generator has to stay in its own frame to be resumed
+		rc.markTailCalls()
+	}
+
+	symbols := rc.state.symbolTable
+	scope := rc.scope()
+	fn := &object.CompiledFunction{
+		Registers:       scope.code,
+		NumOfRegisters:  scope.size,
+		NumOfLocals:     symbols.numDefinitions,
+		NumOfParameters: len(node.Parameters),
+		IsGenerator:     node.IsGenerator,
+		Debug:           &object.DebugInfo{Name: name, File: rc.state.file},
+	}
+	for _, sym := range symbols.FreeSymbols {
+		fn.Debug.Free = append(fn.Debug.Free, sym.Name)
+	}
+	rc.scopes = rc.scopes[:len(rc.scopes)-1]
+	rc.state.symbolTable = symbols.Outer
+
+	// the free variables are loaded into the registers the closure is made in
+	base := rc.window(dst)
+	for i, sym := range symbols.FreeSymbols {
+		r := base
+		if i > 0 {
+			r = rc.alloc()
+		}
+		rc.loadSymbol(sym, r)
+	}
+	rc.emit(opcode.RegClosure, base, rc.state.addConstant(fn), len(symbols.FreeSymbols))
+	rc.move(dst, base)
+	return nil
+}
+
+// arguments compiles exprs into registers taken one after the other
+func (rc *RegisterCompiler) arguments(exprs []ast.Expression) error {
+	for _, e := range exprs {
+		if err := rc.expression(e, rc.alloc()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markTailCalls turns each call whose value the function returns straight
+// away into a RegTailCall
+func (rc *RegisterCompiler) markTailCalls() {
+	code := rc.scope().code
+	for i := 0; i+1 < len(code); i++ {
+		next := code[i+1]
+		if code[i].Op == opcode.RegCall && next.Op == opcode.RegReturn && next.A == code[i].A {
+			code[i].Op = opcode.RegTailCall
+		}
+	}
+}
+
+func (rc *RegisterCompiler) loadSymbol(s Symbol, dst int) {
+	switch s.Scope {
+	case GlobalScope:
+		rc.emit(opcode.RegGetGlobal, dst, s.Index)
+	case LocalScope:
+		rc.move(dst, s.Index)
+	case BuiltInScope:
+		rc.emit(opcode.RegGetBuiltin, dst, s.Index)
+	case FreeScope:
+		rc.emit(opcode.RegGetFree, dst, s.Index)
+	}
+}
+
+// operand compiles expr to a register holding its value: a local's own
+// register, or a temporary it is compiled into
+func (rc *RegisterCompiler) operand(expr ast.Expression) (int, error) {
+	if ident, ok := expr.(*ast.Identifier); ok {
+		symbol, ok := rc.state.symbolTable.Resolve(ident.Value)
+		if ok && symbol.Scope == LocalScope {
+			return symbol.Index, nil
+		}
+	}
+	r := rc.alloc()
+	return r, rc.expression(expr, r)
+}
+
+// operandBefore is operand for expr, evaluated ahead of later. a local is
+// only read from its register when later can't bind it to something else
+// before the value is used
+func (rc *RegisterCompiler) operandBefore(expr, later ast.Expression) (int, error) {
+	if countDefinitions(later) == 0 {
+		return rc.operand(expr)
+	}
+	r := rc.alloc()
+	return r, rc.expression(expr, r)
+}
+
+// constant is the constant index of expr, when it is a literal one
+func (rc *RegisterCompiler) constant(expr ast.Expression) (int, bool) {
+	switch expr := expr.(type) {
+	case *ast.IntegerLiteral:
+		return rc.state.addConstant(&object.Integer{Value: expr.Value}), true
+	case *ast.StringLiteral:
+		return rc.state.addConstant(&object.String{Value: expr.Value}), true
+	}
+	return 0, false
+}
+
+// window is the first of the registers a call or closure made for dst
+// is built in. everything above it has to be free, as the frame of a
+// called function starts right after it. that is dst itself when it is
+// the last temporary taken
+func (rc *RegisterCompiler) window(dst int) int {
+	scope := rc.scope()
+	if dst >= scope.reserved && dst == scope.next-1 {
+		return dst
+	}
+	return rc.alloc()
+}
+
+// result records r as the main program's value, when compiling it
+func (rc *RegisterCompiler) result(r int) {
+	if len(rc.scopes) == 1 {
+		rc.emit(opcode.RegResult, r)
+	}
+}
+
+func (rc *RegisterCompiler) move(dst, src int) {
+	if dst != src {
+		rc.emit(opcode.RegMove, dst, src)
+	}
+}
+
+func (rc *RegisterCompiler) alloc() int {
+	scope := rc.scope()
+	r := scope.next
+	scope.next++
+	if scope.next > scope.size {
+		scope.size = scope.next
+	}
+	return r
+}
+
+// free gives back the temporaries taken since next was mark
+func (rc *RegisterCompiler) free(mark int) {
+	rc.scope().next = mark
+}
+
+func (rc *RegisterCompiler) scope() *registerScope {
+	return rc.scopes[len(rc.scopes)-1]
+}
+
+func (rc *RegisterCompiler) emit(op opcode.RegOp, operands ...int) int {
+	in := opcode.RegInstruction{Op: op}
+	for i, o := range operands {
+		switch i {
+		case 0:
+			in.A = o
+		case 1:
+			in.B = o
+		case 2:
+			in.C = o
+		}
+	}
+	scope := rc.scope()
+	scope.code = append(scope.code, in)
+	return len(scope.code) - 1
+}
+
+// countDefinitions is how many lets and structs there are under node,
+// leaving out those inside the functions in it. a function's locals are
+// given the registers after its parameters, so its temporaries start
+// after as many registers as this
+func countDefinitions(node ast.Node) int {
+	n := 0
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			n += countDefinitions(s)
+		}
+	case *ast.LetStatement:
+		n = 1 + countDefinitions(node.Value)
+	case *ast.StructStatement:
+		n = 1
+	case *ast.ExpressionStatement:
+		n = countDefinitions(node.Expression)
+	case *ast.ReturnStatement:
+		n = countDefinitions(node.ReturnValue)
+	case *ast.IfExpression:
+		n = countDefinitions(node.Condition) + countDefinitions(node.Consequence)
+		if node.Alternative != nil {
+			n += countDefinitions(node.Alternative)
+		}
+	case *ast.PrefixExpression:
+		n = countDefinitions(node.Right)
+	case *ast.InfixExpression:
+		n = countDefinitions(node.Left) + countDefinitions(node.Right)
+	case *ast.CallExpression:
+		n = countDefinitions(node.Function) + countAll(node.Arguments)
+	case *ast.MethodCallExpression:
+		n = countDefinitions(node.Object) + countAll(node.Arguments)
+	case *ast.FieldAccessExpression:
+		n = countDefinitions(node.Object)
+	case *ast.FieldAssignExpression:
+		n = countDefinitions(node.Object) + countDefinitions(node.Value)
+	case *ast.IndexExpression:
+		n = countDefinitions(node.Left) + countDefinitions(node.Index)
+	case *ast.ArrayLiteral:
+		n = countAll(node.Elements)
+	case *ast.SetLiteral:
+		n = countAll(node.Elements)
+	case *ast.HashLiteral:
+		for _, p := range node.Pairs {
+			n += countDefinitions(p.Key) + countDefinitions(p.Value)
+		}
+	case *ast.YieldExpression:
+		n = countDefinitions(node.Value)
+	}
+	return n
+}
+
+func countAll(exprs []ast.Expression) int {
+	n := 0
+	for _, e := range exprs {
+		n += countDefinitions(e)
+	}
+	return n
+}
@@ -0,0 +1,322 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"nala/object"
+)
+
+// FormatVersion is the version of the .nlc format written by
+// WriteByteCode. ReadByteCode refuses files of any other version, since
+// the opcodes and builtins they refer to may have changed
+const FormatVersion = 3
+
+// a .nlc file is the magic, the version as 2 big endian bytes, the
+// payload and a big endian CRC-32 of everything before it
+var magic = []byte("NALC")
+
+const headerSize = 6
+
+// constant tags
+const (
+	integerConstant  byte = 'i'
+	stringConstant   byte = 's'
+	functionConstant byte = 'f'
+	structConstant   byte = 't'
+)
+
+// WriteByteCode writes bc to w in the .nlc format, with the names of the
+// globals and builtins in symbols, so it can be run without being compiled again
+func WriteByteCode(w io.Writer, bc *ByteCode, symbols *SymbolTable) error {
+	var e encoder
+
+	builtins := []string{}
+	globals := []string{}
+	for _, sym := range symbols.Symbols() {
+		switch sym.Scope {
+		case BuiltInScope:
+			builtins = append(builtins, sym.Name)
+		case GlobalScope:
+			globals = append(globals, sym.Name)
+		}
+	}
+	e.strings(builtins)
+	e.strings(globals)
+
+	e.bytes(bc.Instructions)
+	e.debugInfo(bc.Debug)
+
+	e.uint(len(bc.Constants))
+	for i, c := range bc.Constants {
+		switch c := c.(type) {
+		case *object.Integer:
+			e.WriteByte(integerConstant)
+			e.int(c.Value)
+		case *object.String:
+			e.WriteByte(stringConstant)
+			e.string(c.Value)
+		case *object.CompiledFunction:
+			e.WriteByte(functionConstant)
+			e.bytes(c.Instructions)
+			e.uint(c.NumOfLocals)
+			e.uint(c.NumOfParameters)
+			e.bool(c.IsGenerator)
+			e.debugInfo(c.Debug)
+		case *object.StructType:
+			e.WriteByte(structConstant)
+			e.string(c.Name)
+			e.strings(c.Fields)
+		default:
+			return fmt.Errorf("constant %d: cannot write a %s", i, c.Type())
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(magic)
+	binary.Write(&out, binary.BigEndian, uint16(FormatVersion))
+	out.Write(e.Bytes())
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(out.Bytes()))
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// ReadByteCode reads a program written by WriteByteCode. it returns the
+// bytecode along with a symbol table holding its globals, which can be
+// used to compile more code against it
+func ReadByteCode(r io.Reader) (*ByteCode, *SymbolTable, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < headerSize+4 || !bytes.Equal(data[:len(magic)], magic) {
+		return nil, nil, fmt.Errorf("not a compiled nala program")
+	}
+	if version := binary.BigEndian.Uint16(data[len(magic):]); version != FormatVersion {
+		return nil, nil, fmt.Errorf("compiled for version %d of the format, expected version %d", version, FormatVersion)
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(body):]) {
+		return nil, nil, fmt.Errorf("checksum mismatch, the file is corrupt")
+	}
+
+	d := &decoder{data: body[headerSize:]}
+	builtins := d.strings()
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	if len(builtins) != len(object.Builtins) {
+		return nil, nil, fmt.Errorf("compiled against %d builtins, there are %d", len(builtins), len(object.Builtins))
+	}
+	symbols := NewSymbolTable()
+	for i, v := range object.Builtins {
+		if builtins[i] != v.Name {
+			return nil, nil, fmt.Errorf("compiled against builtin %s at %d, found %s", builtins[i], i, v.Name)
+		}
+		symbols.DefineBuiltin(i, v.Name)
+	}
+	for i, name := range d.strings() {
+		if sym := symbols.Define(name); sym.Scope != GlobalScope || sym.Index != i {
+			return nil, nil, fmt.Errorf("global %s is defined twice", name)
+		}
+	}
+
+	bc := &ByteCode{Instructions: d.bytes(), Debug: d.debugInfo(), Unverified: true}
+	n := d.uint()
+	for i := 0; i < n && d.err == nil; i++ {
+		switch tag := d.byte(); tag {
+		case integerConstant:
+			bc.Constants = append(bc.Constants, &object.Integer{Value: d.int()})
+		case stringConstant:
+			bc.Constants = append(bc.Constants, &object.String{Value: d.string()})
+		case functionConstant:
+			fn := &object.CompiledFunction{Instructions: d.bytes()}
+			fn.NumOfLocals = d.uint()
+			fn.NumOfParameters = d.uint()
+			fn.IsGenerator = d.bool()
+			fn.Debug = d.debugInfo()
+			bc.Constants = append(bc.Constants, fn)
+		case structConstant:
+			bc.Constants = append(bc.Constants, &object.StructType{Name: d.string(), Fields: d.strings()})
+		default:
+			d.fail("unknown constant tag %q", tag)
+		}
+	}
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("%d bytes left over", len(d.data))
+	}
+	if d.err != nil {
+		return nil, nil, d.err
+	}
+	if bc.Constants == nil {
+		bc.Constants = []object.Object{}
+	}
+	return bc, symbols, nil
+}
+
+// encoder writes the parts of the payload. integers are varints and
+// strings and byte slices are prefixed with their length
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) uint(x int) {
+	var buf [binary.MaxVarintLen64]byte
+	e.Write(buf[:binary.PutUvarint(buf[:], uint64(x))])
+}
+
+func (e *encoder) int(x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.Write(buf[:binary.PutVarint(buf[:], x)])
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.WriteByte(1)
+	} else {
+		e.WriteByte(0)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uint(len(b))
+	e.Write(b)
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.WriteString(s)
+}
+
+func (e *encoder) strings(ss []string) {
+	e.uint(len(ss))
+	for _, s := range ss {
+		e.string(s)
+	}
+}
+
+func (e *encoder) debugInfo(d *object.DebugInfo) {
+	e.bool(d != nil)
+	if d == nil {
+		return
+	}
+	e.string(d.Name)
+	e.string(d.File)
+	e.uint(len(d.Lines))
+	for _, l := range d.Lines {
+		e.uint(l.Offset)
+		e.uint(l.Line)
+	}
+	e.strings(d.Locals)
+	e.strings(d.Free)
+}
+
+// decoder reads what encoder wrote. the first problem it finds is kept
+// in err, after which it only returns zero values
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("malformed program: "+format, a...)
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uint() int {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(d.data)
+	if n == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	if n < 0 || x > math.MaxInt32 {
+		d.fail("bad number")
+		return 0
+	}
+	d.data = d.data[n:]
+	return int(x)
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(d.data)
+	if n == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	if n < 0 {
+		d.fail("bad integer")
+		return 0
+	}
+	d.data = d.data[n:]
+	return x
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() == 1
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint()
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.data) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, d.data)
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	n := d.uint()
+	var ss []string
+	for i := 0; i < n && d.err == nil; i++ {
+		ss = append(ss, d.string())
+	}
+	return ss
+}
+
+func (d *decoder) debugInfo() *object.DebugInfo {
+	if !d.bool() {
+		return nil
+	}
+	info := &object.DebugInfo{Name: d.string(), File: d.string()}
+	n := d.uint()
+	for i := 0; i < n && d.err == nil; i++ {
+		info.Lines = append(info.Lines, object.LineEntry{Offset: d.uint(), Line: d.uint()})
+	}
+	info.Locals = d.strings()
+	info.Free = d.strings()
+	return info
+}
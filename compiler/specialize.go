@@ -0,0 +1,67 @@
+package compiler
+
+import (
+	"nala/ast"
+	"nala/opcode"
+)
+
+// specializeScope replaces common sequences in the current scope with the
+// superinstructions doing the same in one step:
+//   - OpGetLocal of one of the first four locals becomes OpGetLocal0..3
+//   - OpConstant before an operator becomes the operator's constant form
+//   - OpCall before OpReturnValue becomes OpTailCall, when tailCalls is set
+//
+// OpCallGlobal is picked as the call is compiled instead, see globalCallee
+func (c *Compiler) specializeScope(tailCalls bool) {
+	code, index := c.decodeScope()
+	end := len(code)
+
+	// an operator jumped to can't be merged into the constant before it
+	targeted := make(map[int]bool)
+	for _, in := range code {
+		if isJump(in.op) {
+			targeted[in.operands[0]] = true
+		}
+	}
+
+	for i := range code {
+		in := &code[i]
+		if in.dropped() {
+			continue
+		}
+		switch in.op {
+		case opcode.OpGetLocal:
+			if in.operands[0] < 4 {
+				in.op, in.operands = opcode.OpGetLocal0+opcode.OpCode(in.operands[0]), nil
+			}
+		case opcode.OpConstant:
+			if i+1 == end || targeted[i+1] {
+				continue
+			}
+			if op, ok := opcode.WithConstant[code[i+1].op]; ok {
+				in.op = op
+				code[i+1].removed = true
+			}
+		case opcode.OpCall:
+			if tailCalls && i+1 < end && code[i+1].op == opcode.OpReturnValue {
+				in.op = opcode.OpTailCall
+			}
+		}
+	}
+
+	c.encodeScope(code, index)
+}
+
+// globalCallee is the global a call made with the optimizations on can
+// use OpCallGlobal for
+func (c *Compiler) globalCallee(node *ast.CallExpression) (Symbol, bool) {
+	ident, ok := node.Function.(*ast.Identifier)
+	if !c.optimize || !ok {
+		return Symbol{}, false
+	}
+	sym, ok := c.symbolTable.Resolve(ident.Value)
+	if !ok || sym.Scope != GlobalScope {
+		return Symbol{}, false
+	}
+	return sym, opcode.Fits(opcode.OpCallGlobal, sym.Index, len(node.Arguments))
+}
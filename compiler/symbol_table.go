@@ -1,5 +1,7 @@
 package compiler
 
+import "sort"
+
 type SymbolScope string
 
 const (
@@ -25,8 +27,10 @@ type SymbolTable struct {
 func (st *SymbolTable) Define(id string) Symbol {
 	// can check if symbol actually already exists and reuse it's index.
 	// but not sure of the implications of that just yet
+	// a builtin is shadowed by a definition of the same name, the way the
+	// evaluator looks in the environment before the builtins
 	existing, ok := st.store[id]
-	if ok {
+	if ok && existing.Scope != BuiltInScope {
 		return existing
 	} else {
 		sym := Symbol{
@@ -64,6 +68,17 @@ func (st *SymbolTable) Resolve(id string) (Symbol, bool) {
 	return existing, ok
 }
 
+// lookup finds the table that defines id, without capturing it as a
+// free symbol the way Resolve does
+func (st *SymbolTable) lookup(id string) (*SymbolTable, bool) {
+	for table := st; table != nil; table = table.Outer {
+		if sym, ok := table.store[id]; ok && sym.Scope != FreeScope {
+			return table, true
+		}
+	}
+	return nil, false
+}
+
 func (st *SymbolTable) DefineBuiltin(index int, id string) Symbol {
 	sym := Symbol{
 		Name:  id,
@@ -100,3 +115,19 @@ func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	s.Outer = outer
 	return s
 }
+
+// Symbols returns the symbols defined in st itself, ordered by scope and
+// then by index
+func (st *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(st.store))
+	for _, sym := range st.store {
+		symbols = append(symbols, sym)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if symbols[i].Scope != symbols[j].Scope {
+			return symbols[i].Scope < symbols[j].Scope
+		}
+		return symbols[i].Index < symbols[j].Index
+	})
+	return symbols
+}
@@ -251,6 +251,19 @@ func TestDefineResolveBuiltins(t *testing.T) {
 	}
 }
 
+func TestDefineShadowsBuiltin(t *testing.T) {
+	glob := NewSymbolTable()
+	glob.DefineBuiltin(0, "add")
+
+	expected := Symbol{Name: "add", Scope: GlobalScope, Index: 0}
+	if sym := glob.Define("add"); sym != expected {
+		t.Errorf("expected add to be defined as %+v, got=%+v", expected, sym)
+	}
+	if sym, ok := glob.Resolve("add"); !ok || sym != expected {
+		t.Errorf("expected add to resolve to %+v, got=%+v", expected, sym)
+	}
+}
+
 func TestResolveFree(t *testing.T) {
 	glob := NewSymbolTable()
 	glob.Define("a")
@@ -430,3 +443,37 @@ func TestResolveUnresolvableFree(t *testing.T) {
 		}
 	}
 }
+
+func TestSymbols(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len")
+	global.Define("b")
+	global.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Resolve("a")
+
+	expected := map[*SymbolTable][]Symbol{
+		global: {
+			{Name: "len", Scope: BuiltInScope, Index: 0},
+			{Name: "b", Scope: GlobalScope, Index: 0},
+			{Name: "a", Scope: GlobalScope, Index: 1},
+		},
+		local: {
+			{Name: "c", Scope: LocalScope, Index: 0},
+		},
+	}
+
+	for table, want := range expected {
+		got := table.Symbols()
+		if len(got) != len(want) {
+			t.Fatalf("wrong number of symbols. want=%+v, got=%+v", want, got)
+		}
+		for i, sym := range want {
+			if got[i] != sym {
+				t.Errorf("wrong symbol at %d. want=%+v, got=%+v", i, sym, got[i])
+			}
+		}
+	}
+}
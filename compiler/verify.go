@@ -0,0 +1,290 @@
+package compiler
+
+import (
+	"fmt"
+	"nala/object"
+	"nala/opcode"
+)
+
+// Verify checks that the VM can run bc safely: every instruction decodes,
+// every jump lands on an instruction, every constant, local, free
+// variable and builtin referred to exists, every global read is set
+// somewhere, and every path through the code agrees on how deep the stack
+// is, never popping more than it pushed.
+// bytecode read with ReadByteCode must pass Verify before the VM runs it
+func Verify(bc *ByteCode) error {
+	v := &verifier{constants: bc.Constants, free: make(map[int]int)}
+
+	// decode everything first, so the number of free variables each
+	// function is closed over with is known before it is checked
+	main, err := v.decode(bc.Instructions)
+	if err != nil {
+		return fmt.Errorf("main: %s", err)
+	}
+	fns := make(map[int][]instruction)
+	for i, c := range bc.Constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumOfParameters > fn.NumOfLocals {
+			return fmt.Errorf("function %d: %d parameters but only %d locals", i, fn.NumOfParameters, fn.NumOfLocals)
+		}
+		if fns[i], err = v.decode(fn.Instructions); err != nil {
+			return fmt.Errorf("function %d: %s", i, err)
+		}
+	}
+
+	if err := checkGlobals(main, fns, len(bc.Constants)); err != nil {
+		return err
+	}
+	if err := v.check(main, len(bc.Instructions), 0, 0, true); err != nil {
+		return fmt.Errorf("main: %s", err)
+	}
+	for i, c := range bc.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			if err := v.check(fns[i], len(fn.Instructions), fn.NumOfLocals, v.free[i], false); err != nil {
+				return fmt.Errorf("function %d: %s", i, err)
+			}
+		}
+	}
+
+	bc.Unverified = false
+	return nil
+}
+
+type verifier struct {
+	constants []object.Object
+	free      map[int]int // the fewest free variables each function is closed over with
+}
+
+// instruction is a decoded instruction, at offset in its stream
+type instruction struct {
+	offset   int
+	op       opcode.OpCode
+	name     string
+	operands []int
+}
+
+// decode splits ins into instructions, checking their operands fit and
+// refer to constants of the right kind
+func (v *verifier) decode(ins opcode.Instructions) ([]instruction, error) {
+	decoded := []instruction{}
+	for i := 0; i < len(ins); {
+		d, err := opcode.Decode(ins[i:])
+		if err != nil {
+			return nil, fmt.Errorf("%04d: %s", i, err)
+		}
+		in := instruction{offset: i, op: d.Op, name: d.Def.Name, operands: d.Operands}
+		if err := v.checkOperands(in); err != nil {
+			return nil, fmt.Errorf("%04d: %s", i, err)
+		}
+		decoded = append(decoded, in)
+		i += d.Size
+	}
+	return decoded, nil
+}
+
+func (v *verifier) checkOperands(in instruction) error {
+	constant := func(want object.ObjectType) error {
+		i := in.operands[0]
+		if i >= len(v.constants) {
+			return fmt.Errorf("%s refers to constant %d of %d", in.name, i, len(v.constants))
+		}
+		if want != "" && v.constants[i].Type() != want {
+			return fmt.Errorf("%s needs a %s constant, constant %d is a %s", in.name, want, i, v.constants[i].Type())
+		}
+		return nil
+	}
+
+	switch in.op {
+	case opcode.OpConstant, opcode.OpAddConstant, opcode.OpSubtractConstant, opcode.OpLThanConstant,
+		opcode.OpGThanConstant, opcode.OpEqualConstant, opcode.OpNotEqualConstant:
+		return constant("")
+	case opcode.OpGetField, opcode.OpSetField, opcode.OpCallMethod:
+		return constant(object.STRING_OBJ)
+	case opcode.OpClosure:
+		if err := constant(object.COMPILED_FUNCTION_OBJ); err != nil {
+			return err
+		}
+		if n, ok := v.free[in.operands[0]]; !ok || in.operands[1] < n {
+			v.free[in.operands[0]] = in.operands[1]
+		}
+	case opcode.OpGetBuiltin:
+		if in.operands[0] >= len(object.Builtins) {
+			return fmt.Errorf("OpGetBuiltin refers to builtin %d of %d", in.operands[0], len(object.Builtins))
+		}
+	case opcode.OpHashMap:
+		if in.operands[0]%2 != 0 {
+			return fmt.Errorf("OpHashMap needs pairs, got %d values", in.operands[0])
+		}
+	}
+	return nil
+}
+
+// checkGlobals checks every global read, in main or a function, is set by
+// some instruction. whether it is set before it is read depends on the
+// order functions are called in, so the VM reads a global never set as nil
+func checkGlobals(main []instruction, fns map[int][]instruction, constants int) error {
+	set := make(map[int]bool)
+	sets := func(ins []instruction) {
+		for _, in := range ins {
+			if in.op == opcode.OpSetGlobal {
+				set[in.operands[0]] = true
+			}
+		}
+	}
+	sets(main)
+	for _, ins := range fns {
+		sets(ins)
+	}
+
+	read := func(where string, ins []instruction) error {
+		for _, in := range ins {
+			if (in.op == opcode.OpGetGlobal || in.op == opcode.OpCallGlobal) && !set[in.operands[0]] {
+				return fmt.Errorf("%s: %04d: %s reads global %d, which is never set", where, in.offset, in.name, in.operands[0])
+			}
+		}
+		return nil
+	}
+	if err := read("main", main); err != nil {
+		return err
+	}
+	for i := 0; i < constants; i++ {
+		if ins, ok := fns[i]; ok {
+			if err := read(fmt.Sprintf("function %d", i), ins); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check follows every path through a stream, tracking the depth of the
+// stack above the function's locals. main may run off its end, functions
+// must return
+func (v *verifier) check(ins []instruction, end int, locals int, free int, isMain bool) error {
+	at := make(map[int]int, len(ins)) // offset to index in ins
+	for i, in := range ins {
+		at[in.offset] = i
+	}
+	target := func(in instruction) (int, error) {
+		t := in.operands[0]
+		if t == end && isMain {
+			return -1, nil
+		}
+		i, ok := at[t]
+		if !ok {
+			return 0, fmt.Errorf("%04d: %s to %04d, which is not the start of an instruction", in.offset, in.name, t)
+		}
+		return i, nil
+	}
+
+	for _, in := range ins {
+		switch in.op {
+		case opcode.OpGetLocal, opcode.OpSetLocal:
+			if in.operands[0] >= locals {
+				return fmt.Errorf("%04d: %s refers to local %d of %d", in.offset, in.name, in.operands[0], locals)
+			}
+		case opcode.OpGetLocal0, opcode.OpGetLocal1, opcode.OpGetLocal2, opcode.OpGetLocal3:
+			if i := int(in.op - opcode.OpGetLocal0); i >= locals {
+				return fmt.Errorf("%04d: %s refers to local %d of %d", in.offset, in.name, i, locals)
+			}
+		case opcode.OpGetFree:
+			if in.operands[0] >= free {
+				return fmt.Errorf("%04d: OpGetFree refers to free variable %d of %d", in.offset, in.operands[0], free)
+			}
+		case opcode.OpJump, opcode.OpJumpNotTruthy:
+			if _, err := target(in); err != nil {
+				return err
+			}
+		}
+	}
+	if len(ins) == 0 {
+		if isMain {
+			return nil
+		}
+		return fmt.Errorf("function has no instructions")
+	}
+
+	depths := make([]int, len(ins))
+	for i := range depths {
+		depths[i] = -1
+	}
+	type path struct{ index, depth int }
+	work := []path{{0, 0}}
+	for len(work) > 0 {
+		p := work[len(work)-1]
+		work = work[:len(work)-1]
+		if p.index == -1 {
+			continue // main ran off its end
+		}
+		if depths[p.index] != -1 {
+			if depths[p.index] != p.depth {
+				return fmt.Errorf("%04d: reached with %d values on the stack and with %d",
+					ins[p.index].offset, depths[p.index], p.depth)
+			}
+			continue
+		}
+		depths[p.index] = p.depth
+
+		in := ins[p.index]
+		pops, pushes := stackEffect(in)
+		if p.depth < pops {
+			return fmt.Errorf("%04d: %s pops %d values, the stack only has %d", in.offset, in.name, pops, p.depth)
+		}
+		depth := p.depth - pops + pushes
+
+		next := p.index + 1
+		if next == len(ins) {
+			next = -1
+		}
+		switch in.op {
+		case opcode.OpReturn, opcode.OpReturnValue:
+			continue
+		case opcode.OpJump:
+			t, _ := target(in)
+			work = append(work, path{t, depth})
+			continue
+		case opcode.OpJumpNotTruthy:
+			t, _ := target(in)
+			work = append(work, path{t, depth})
+		}
+		if next == -1 && !isMain {
+			return fmt.Errorf("%04d: runs off the end of the function", in.offset)
+		}
+		work = append(work, path{next, depth})
+	}
+	return nil
+}
+
+// stackEffect is how many values in takes off the stack, and how many it
+// leaves in their place
+func stackEffect(in instruction) (int, int) {
+	switch in.op {
+	case opcode.OpConstant, opcode.OpTrue, opcode.OpFalse, opcode.OpNil,
+		opcode.OpGetGlobal, opcode.OpGetLocal, opcode.OpGetBuiltin, opcode.OpGetFree,
+		opcode.OpGetLocal0, opcode.OpGetLocal1, opcode.OpGetLocal2, opcode.OpGetLocal3:
+		return 0, 1
+	case opcode.OpPop, opcode.OpSetGlobal, opcode.OpSetLocal, opcode.OpJumpNotTruthy, opcode.OpReturnValue:
+		return 1, 0
+	case opcode.OpAdd, opcode.OpSubtract, opcode.OpMultiply, opcode.OpDivide, opcode.OpModulo,
+		opcode.OpGThan, opcode.OpLThan, opcode.OpEqual, opcode.OpNotEqual, opcode.OpIndex, opcode.OpSetField:
+		return 2, 1
+	case opcode.OpNegateInt, opcode.OpNegateBool, opcode.OpGetField, opcode.OpYield, opcode.OpYieldFrom,
+		opcode.OpAddConstant, opcode.OpSubtractConstant, opcode.OpLThanConstant,
+		opcode.OpGThanConstant, opcode.OpEqualConstant, opcode.OpNotEqualConstant:
+		return 1, 1
+	case opcode.OpArray, opcode.OpHashMap, opcode.OpSet:
+		return in.operands[0], 1
+	case opcode.OpCall, opcode.OpTailCall:
+		return in.operands[0] + 1, 1
+	case opcode.OpCallGlobal:
+		return in.operands[1], 1
+	case opcode.OpCallMethod:
+		return in.operands[1] + 1, 1
+	case opcode.OpClosure:
+		return in.operands[1], 1
+	}
+	return 0, 0
+}
@@ -0,0 +1,412 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// client drives a Server the way an editor would, over a pair of pipes
+type client struct {
+	t        *testing.T
+	w        *io.PipeWriter
+	messages chan []byte // read off the server as soon as it writes them
+	seq      int
+	done     chan error
+
+	events []message // received while waiting for something else
+}
+
+const program = `let plus = fn(a, b) {
+  let total = a + b;
+  total
+};
+let point = {"x": [1, 2], "y": 3};
+let twice = fn(x) {
+  let y = plus(x, x);
+  puts(y);
+  y * 2
+};
+twice(4)`
+
+const prelude = `let double = fn(n) { n * 2 };`
+
+// writes the program and the prelude to files and starts a server on them
+func newClient(t *testing.T) (*client, string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.nl")
+	preludePath := filepath.Join(dir, "prelude.nl")
+	ioutil.WriteFile(path, []byte(program), 0644)
+	ioutil.WriteFile(preludePath, []byte(prelude), 0644)
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, messages: make(chan []byte, 100), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(inR, outW, preludePath).Run()
+		outW.Close()
+		c.done <- err
+	}()
+	// an editor keeps reading while it writes, or the two would block
+	// each other on the unbuffered pipes
+	go func() {
+		r := bufio.NewReader(outR)
+		for {
+			body, err := readMessage(r)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			c.messages <- body
+		}
+	}()
+	return c, path
+}
+
+func (c *client) read() (message, json.RawMessage) {
+	c.t.Helper()
+	body, ok := <-c.messages
+	if !ok {
+		c.t.Fatalf("the server closed its stream")
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("server sent bad JSON %s: %s", body, err)
+	}
+	return msg, body
+}
+
+// request sends a request and decodes the body of its response into
+// body. it returns the message of a failed response, or "" on success
+func (c *client) request(command string, args interface{}, body interface{}) string {
+	c.t.Helper()
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args}
+	if err := writeMessage(c.w, req); err != nil {
+		c.t.Fatalf("could not send %s: %s", command, err)
+	}
+
+	for {
+		msg, raw := c.read()
+		if msg.Type == "event" {
+			c.events = append(c.events, eventWithBody(c.t, raw))
+			continue
+		}
+		var resp struct {
+			RequestSeq int             `json:"request_seq"`
+			Success    bool            `json:"success"`
+			Message    string          `json:"message"`
+			Body       json.RawMessage `json:"body"`
+		}
+		json.Unmarshal(raw, &resp)
+		if resp.RequestSeq != c.seq {
+			c.t.Fatalf("response to %s is for request %d, want %d", command, resp.RequestSeq, c.seq)
+		}
+		if !resp.Success {
+			return resp.Message
+		}
+		if body != nil {
+			if err := json.Unmarshal(resp.Body, body); err != nil {
+				c.t.Fatalf("bad body for %s: %s (%s)", command, err, resp.Body)
+			}
+		}
+		return ""
+	}
+}
+
+// keeps an event's body in Arguments, which events do not otherwise use
+func eventWithBody(t *testing.T, raw json.RawMessage) message {
+	var ev struct {
+		message
+		Body json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		t.Fatalf("bad event %s: %s", raw, err)
+	}
+	ev.message.Arguments = ev.Body
+	return ev.message
+}
+
+// waitFor returns the next event called name, decoding its body into
+// body. the events it passes over are kept for later waits
+func (c *client) waitFor(name string, body interface{}) {
+	c.t.Helper()
+	for i := 0; ; i++ {
+		if i == len(c.events) {
+			_, raw := c.read()
+			c.events = append(c.events, eventWithBody(c.t, raw))
+		}
+		ev := c.events[i]
+		if ev.Type != "event" || ev.Event != name {
+			continue
+		}
+		c.events = append(c.events[:i], c.events[i+1:]...)
+		if body != nil {
+			json.Unmarshal(ev.Arguments, body)
+		}
+		return
+	}
+}
+
+func (c *client) stopped() StoppedEvent {
+	c.t.Helper()
+	var ev StoppedEvent
+	c.waitFor("stopped", &ev)
+	return ev
+}
+
+// the innermost frame's function and line
+func (c *client) where() string {
+	c.t.Helper()
+	var body struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	if msg := c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, &body); msg != "" {
+		c.t.Fatalf("stackTrace failed: %s", msg)
+	}
+	f := body.StackFrames[0]
+	return fmt.Sprintf("%s:%d", f.Name, f.Line)
+}
+
+func (c *client) variables(ref int) map[string]Variable {
+	c.t.Helper()
+	var body struct {
+		Variables []Variable `json:"variables"`
+	}
+	if msg := c.request("variables", VariablesArguments{VariablesReference: ref}, &body); msg != "" {
+		c.t.Fatalf("variables failed: %s", msg)
+	}
+	vars := make(map[string]Variable)
+	for _, v := range body.Variables {
+		vars[v.Name] = v
+	}
+	return vars
+}
+
+func (c *client) close() {
+	c.t.Helper()
+	c.request("disconnect", map[string]interface{}{}, nil)
+	c.w.Close()
+	if err := <-c.done; err != nil {
+		c.t.Errorf("server failed: %s", err)
+	}
+}
+
+func TestBreakpointsAndStepping(t *testing.T) {
+	c, path := newClient(t)
+
+	var caps Capabilities
+	c.request("initialize", map[string]interface{}{"adapterID": "nala"}, &caps)
+	if !caps.SupportsConfigurationDoneRequest {
+		t.Errorf("expected configurationDone to be supported")
+	}
+	c.waitFor("initialized", nil)
+
+	// set before the launch, these are only placed once the program is compiled
+	var early struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", SetBreakpointsArguments{
+		Source: Source{Path: path}, Breakpoints: []SourceBreakpoint{{Line: 2}},
+	}, &early)
+	if len(early.Breakpoints) != 1 || early.Breakpoints[0].Verified {
+		t.Errorf("expected an unverified breakpoint before launch, got=%+v", early.Breakpoints)
+	}
+
+	if msg := c.request("launch", LaunchArguments{Program: path}, nil); msg != "" {
+		t.Fatalf("launch failed: %s", msg)
+	}
+	var placed struct {
+		Breakpoint Breakpoint `json:"breakpoint"`
+	}
+	c.waitFor("breakpoint", &placed)
+	if !placed.Breakpoint.Verified || placed.Breakpoint.Line != 2 {
+		t.Errorf("wrong breakpoint placed at launch. got=%+v", placed.Breakpoint)
+	}
+
+	var set struct {
+		Breakpoints []Breakpoint `json:"breakpoints"`
+	}
+	c.request("setBreakpoints", SetBreakpointsArguments{
+		Source: Source{Path: path}, Breakpoints: []SourceBreakpoint{{Line: 7}, {Line: 4}},
+	}, &set)
+	if len(set.Breakpoints) != 2 || !set.Breakpoints[0].Verified || set.Breakpoints[1].Verified {
+		t.Errorf("wrong breakpoints. got=%+v", set.Breakpoints)
+	}
+	c.request("configurationDone", nil, nil)
+
+	// line 2 was replaced, so the first stop is on line 7
+	ev := c.stopped()
+	if ev.Reason != "breakpoint" || len(ev.HitBreakpointIDs) != 1 || ev.HitBreakpointIDs[0] != set.Breakpoints[0].ID {
+		t.Errorf("wrong stop. got=%+v", ev)
+	}
+	if where := c.where(); where != "twice:7" {
+		t.Errorf("wrong place. want=twice:7, got=%s", where)
+	}
+
+	steps := []struct {
+		command string
+		where   string
+	}{
+		{"stepIn", "plus:2"},
+		{"next", "plus:3"},
+		{"stepOut", "twice:8"},
+		{"next", "twice:9"},
+	}
+	for _, step := range steps {
+		if msg := c.request(step.command, map[string]int{"threadId": threadID}, nil); msg != "" {
+			t.Fatalf("%s failed: %s", step.command, msg)
+		}
+		if ev := c.stopped(); ev.Reason != "step" {
+			t.Errorf("%s: wrong reason. got=%s", step.command, ev.Reason)
+		}
+		if where := c.where(); where != step.where {
+			t.Errorf("%s: wrong place. want=%s, got=%s", step.command, step.where, where)
+		}
+	}
+
+	// puts went to the client rather than to stdout
+	printed := ""
+	for !strings.HasSuffix(printed, "\n") {
+		var out OutputEvent
+		c.waitFor("output", &out)
+		if out.Category != "stdout" {
+			t.Errorf("wrong output category. got=%s", out.Category)
+		}
+		printed += out.Output
+	}
+	if printed != "8\n" {
+		t.Errorf("wrong output. got=%q", printed)
+	}
+
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	var exited ExitedEvent
+	c.waitFor("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("wrong exit code. got=%d", exited.ExitCode)
+	}
+	c.waitFor("terminated", nil)
+
+	if msg := c.request("next", map[string]int{"threadId": threadID}, nil); msg == "" {
+		t.Errorf("expected next to fail once the program has ended")
+	}
+	c.close()
+}
+
+func TestScopesAndVariables(t *testing.T) {
+	c, path := newClient(t)
+	c.request("initialize", map[string]interface{}{}, nil)
+	c.request("launch", LaunchArguments{Program: path, StopOnEntry: true}, nil)
+	c.request("setFunctionBreakpoints", SetFunctionBreakpointsArguments{
+		Breakpoints: []FunctionBreakpoint{{Name: "plus"}},
+	}, nil)
+	c.request("configurationDone", nil, nil)
+
+	if ev := c.stopped(); ev.Reason != "entry" {
+		t.Errorf("expected to stop on entry, got=%s", ev.Reason)
+	}
+	c.request("continue", map[string]int{"threadId": threadID}, nil)
+	if ev := c.stopped(); ev.Reason != "breakpoint" {
+		t.Errorf("expected the function breakpoint, got=%s", ev.Reason)
+	}
+
+	var trace struct {
+		StackFrames []StackFrame `json:"stackFrames"`
+	}
+	c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, &trace)
+	names := []string{}
+	for _, f := range trace.StackFrames {
+		names = append(names, fmt.Sprintf("%s:%d", f.Name, f.Line))
+	}
+	if fmt.Sprint(names) != "[plus:2 twice:7 main:11]" {
+		t.Errorf("wrong stack trace. got=%v", names)
+	}
+	if src := trace.StackFrames[0].Source; src == nil || src.Path != path {
+		t.Errorf("wrong source. got=%+v", src)
+	}
+
+	var scopes struct {
+		Scopes []Scope `json:"scopes"`
+	}
+	c.request("scopes", ScopesArguments{FrameID: trace.StackFrames[0].ID}, &scopes)
+	if len(scopes.Scopes) != 2 || scopes.Scopes[0].Name != "Locals" || scopes.Scopes[1].Name != "Globals" {
+		t.Fatalf("wrong scopes. got=%+v", scopes.Scopes)
+	}
+
+	locals := c.variables(scopes.Scopes[0].VariablesReference)
+	if locals["a"].Value != "4" || locals["a"].Type != "INTEGER" || locals["b"].Value != "4" ||
+		locals["total"].Value != "(unset)" {
+		t.Errorf("wrong locals. got=%+v", locals)
+	}
+
+	globals := c.variables(scopes.Scopes[1].VariablesReference)
+	if _, ok := globals["double"]; !ok {
+		t.Errorf("expected the prelude's globals, got=%+v", globals)
+	}
+	point := globals["point"]
+	if point.VariablesReference == 0 {
+		t.Fatalf("expected point to have parts, got=%+v", point)
+	}
+	pairs := c.variables(point.VariablesReference)
+	if pairs["y"].Value != "3" || pairs["x"].VariablesReference == 0 {
+		t.Fatalf("wrong pairs. got=%+v", pairs)
+	}
+	elements := c.variables(pairs["x"].VariablesReference)
+	if elements["[0]"].Value != "1" || elements["[1]"].Value != "2" {
+		t.Errorf("wrong elements. got=%+v", elements)
+	}
+
+	// the caller's frame sees its own locals
+	c.request("scopes", ScopesArguments{FrameID: trace.StackFrames[1].ID}, &scopes)
+	if locals := c.variables(scopes.Scopes[0].VariablesReference); locals["x"].Value != "4" {
+		t.Errorf("wrong caller locals. got=%+v", locals)
+	}
+
+	var result struct {
+		Result string `json:"result"`
+	}
+	c.request("evaluate", EvaluateArguments{Expression: "a * 10 + double(b)", FrameID: 1}, &result)
+	if result.Result != "48" {
+		t.Errorf("wrong evaluation. got=%s", result.Result)
+	}
+	if msg := c.request("evaluate", EvaluateArguments{Expression: "missing", FrameID: 1}, nil); msg != "undefined variable missing" {
+		t.Errorf("wrong evaluation error. got=%q", msg)
+	}
+
+	// references do not outlive the stop
+	ref := point.VariablesReference
+	c.request("next", map[string]int{"threadId": threadID}, nil)
+	c.stopped()
+	if msg := c.request("variables", VariablesArguments{VariablesReference: ref}, nil); msg == "" {
+		t.Errorf("expected an old reference to fail")
+	}
+
+	// disconnecting ends the stopped program
+	c.close()
+}
+
+func TestLaunchErrors(t *testing.T) {
+	c, path := newClient(t)
+	c.request("initialize", map[string]interface{}{}, nil)
+
+	if msg := c.request("launch", LaunchArguments{Program: path + ".missing"}, nil); msg == "" {
+		t.Errorf("expected launching a missing file to fail")
+	}
+	bad := filepath.Join(filepath.Dir(path), "bad.nl")
+	ioutil.WriteFile(bad, []byte("let n: int = \"five\";"), 0644)
+	if msg := c.request("launch", LaunchArguments{Program: bad}, nil); !strings.Contains(msg, "type errors") {
+		t.Errorf("expected launching an ill-typed file to fail. got=%q", msg)
+	}
+	if msg := c.request("stackTrace", StackTraceArguments{ThreadID: threadID}, nil); msg == "" {
+		t.Errorf("expected stackTrace to fail with nothing running")
+	}
+	if msg := c.request("attach", map[string]interface{}{}, nil); msg != "attach is not supported" {
+		t.Errorf("wrong error for attach. got=%q", msg)
+	}
+	c.close()
+}
@@ -0,0 +1,437 @@
+// Package dap is a Debug Adapter Protocol server that runs Nala and
+// Ellisp programs on the VM under its debugger
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"nala/checker"
+	"nala/compiler"
+	"nala/object"
+	"nala/source"
+	"nala/vm"
+	"path/filepath"
+	"sync"
+)
+
+// the VM runs programs on a single goroutine, which the client sees as
+// the only thread
+const threadID = 1
+
+// Server debugs one program for one client. requests are read on the
+// goroutine calling Run while the program runs on one of its own, which
+// blocks in the debugger whenever the program is stopped
+type Server struct {
+	in      *bufio.Reader
+	out     io.Writer
+	prelude string
+
+	wmu sync.Mutex // guards out and seq
+	seq int
+
+	mu         sync.Mutex // guards everything below
+	machine    *vm.VM
+	debugger   *vm.Debugger
+	configured bool // the client sent configurationDone
+	running    bool
+	stopped    bool
+	quitting   bool        // the client has gone, so the program is not to stop again
+	refs       []reference // variablesReference n is refs[n-1], until the program resumes
+
+	lines    map[string][]int // line breakpoints by source path, as the client last set them
+	lineBps  map[string][]int // ids of the debugger's breakpoints for them
+	funcs    []string
+	funcBps  []int
+	resume   chan vm.StepMode
+	finished chan struct{} // closed once the program has ended
+}
+
+// NewServer returns a server reading requests from in and writing to out.
+// the file at prelude, when there is one, is run before each program
+// without being debugged, the way the REPL loads code/functions.nl
+func NewServer(in io.Reader, out io.Writer, prelude string) *Server {
+	return &Server{
+		in:       bufio.NewReader(in),
+		out:      out,
+		prelude:  prelude,
+		lines:    make(map[string][]int),
+		lineBps:  make(map[string][]int),
+		resume:   make(chan vm.StepMode),
+		finished: make(chan struct{}),
+	}
+}
+
+// Run serves requests until the client disconnects or closes its stream
+func (s *Server) Run() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			s.quit()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req message
+		if err := json.Unmarshal(body, &req); err != nil {
+			return fmt.Errorf("bad message: %s", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		result, err := s.handle(req.Command, req.Arguments)
+		s.respond(req, result, err)
+		if req.Command == "initialize" && err == nil {
+			s.event("initialized", nil)
+		}
+		if req.Command == "disconnect" {
+			s.quit()
+			return nil
+		}
+		s.afterResponse(req.Command)
+	}
+}
+
+func (s *Server) respond(req message, body interface{}, err error) {
+	resp := response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	resp.Seq = s.seq
+	writeMessage(s.out, resp)
+}
+
+func (s *Server) event(name string, body interface{}) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.seq++
+	writeMessage(s.out, event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+func (s *Server) handle(command string, args json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch command {
+	case "initialize":
+		return Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsEvaluateForHovers:        true,
+		}, nil
+	case "launch":
+		var a LaunchArguments
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		return nil, s.launch(a)
+	case "configurationDone":
+		s.configured = true
+		return nil, nil
+	case "disconnect":
+		return nil, nil
+	case "threads":
+		return map[string][]Thread{"threads": {{ID: threadID, Name: "main"}}}, nil
+
+	case "setBreakpoints":
+		var a SetBreakpointsArguments
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		lines := []int{}
+		for _, bp := range a.Breakpoints {
+			lines = append(lines, bp.Line)
+		}
+		s.lines[a.Source.Path] = lines
+		return map[string][]Breakpoint{"breakpoints": s.setLineBreakpoints(a.Source.Path)}, nil
+	case "setFunctionBreakpoints":
+		var a SetFunctionBreakpointsArguments
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		s.funcs = []string{}
+		for _, bp := range a.Breakpoints {
+			s.funcs = append(s.funcs, bp.Name)
+		}
+		return map[string][]Breakpoint{"breakpoints": s.setFunctionBreakpoints()}, nil
+
+	case "continue", "next", "stepIn", "stepOut":
+		if !s.stopped {
+			return nil, fmt.Errorf("the program is not stopped")
+		}
+		if command == "continue" {
+			return map[string]bool{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	case "stackTrace":
+		if !s.stopped {
+			return nil, fmt.Errorf("the program is not stopped")
+		}
+		frames := s.stackTrace()
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+	case "scopes":
+		var a ScopesArguments
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		scopes, err := s.scopes(a.FrameID - 1)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]Scope{"scopes": scopes}, nil
+	case "variables":
+		var a VariablesArguments
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		vars, err := s.variables(a.VariablesReference)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]Variable{"variables": vars}, nil
+	case "evaluate":
+		var a EvaluateArguments
+		if err := json.Unmarshal(args, &a); err != nil {
+			return nil, err
+		}
+		if !s.stopped {
+			return nil, fmt.Errorf("the program is not stopped")
+		}
+		frame := 0
+		if a.FrameID > 0 {
+			frame = a.FrameID - 1
+		}
+		val, err := s.debugger.Eval(a.Expression, frame)
+		if err != nil {
+			return nil, err
+		}
+		v := s.variable("", val)
+		return map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": v.VariablesReference}, nil
+	}
+
+	return nil, fmt.Errorf("%s is not supported", command)
+}
+
+// what a request starts only once it has been answered, so that the
+// client hears of it before any event it leads to
+func (s *Server) afterResponse(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch command {
+	case "launch", "configurationDone":
+		if s.machine != nil && s.configured && !s.running {
+			s.running = true
+			go s.run()
+		}
+	case "continue", "next", "stepIn", "stepOut":
+		if s.stopped {
+			s.stopped = false
+			s.refs = nil
+			s.resume <- map[string]vm.StepMode{
+				"continue": vm.Continue, "next": vm.StepOver, "stepIn": vm.StepIn, "stepOut": vm.StepOut,
+			}[command]
+		}
+	}
+}
+
+// launch compiles the program and attaches the debugger, leaving it to
+// configurationDone to start it
+func (s *Server) launch(a LaunchArguments) error {
+	if s.machine != nil {
+		return fmt.Errorf("a program is already launched")
+	}
+
+	// stdout carries the protocol, so whatever the programs print goes to the client
+	object.Output = outputWriter{s}
+
+	tc := checker.New()
+	symbols := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbols.DefineBuiltin(i, v.Name)
+	}
+	constants := []object.Object{}
+	globals := make([]vm.Value, vm.GlobalsSize)
+
+	if s.prelude != "" {
+		comp, err := compileFile(tc, s.prelude, symbols, constants)
+		if err != nil {
+			return err
+		}
+		constants = comp.ByteCode().Constants
+		if err := vm.NewWithGlobalsStore(comp.ByteCode(), globals).Run(); err != nil {
+			return fmt.Errorf("%s: %s", s.prelude, err)
+		}
+	}
+
+	comp, err := compileFile(tc, a.Program, symbols, constants)
+	if err != nil {
+		return err
+	}
+
+	s.machine = vm.NewWithGlobalsStore(comp.ByteCode(), globals)
+	s.debugger = vm.NewDebugger(s.machine, symbols, a.StopOnEntry)
+	s.debugger.OnStop = s.onStop
+
+	// breakpoints set before the launch can only be placed now
+	for path := range s.lines {
+		for _, bp := range s.setLineBreakpoints(path) {
+			s.event("breakpoint", map[string]interface{}{"reason": "new", "breakpoint": bp})
+		}
+	}
+	for _, bp := range s.setFunctionBreakpoints() {
+		s.event("breakpoint", map[string]interface{}{"reason": "new", "breakpoint": bp})
+	}
+	return nil
+}
+
+// compileFile type checks the file at path with tc, which has seen the
+// prelude, and compiles it on top of what was compiled before
+func compileFile(tc *checker.Checker, path string, symbols *compiler.SymbolTable, constants []object.Object) (*compiler.Compiler, error) {
+	prog, err := source.CheckFile(tc, path)
+	if err != nil {
+		return nil, err
+	}
+
+	comp := compiler.NewWithState(symbols, constants)
+	comp.SetFile(path)
+	if err := comp.Compile(prog); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return comp, nil
+}
+
+// run runs the program on its own goroutine and reports its end
+func (s *Server) run() {
+	exitCode := 0
+	err := s.machine.Run()
+	if err != nil && err != vm.ErrQuit {
+		s.event("output", OutputEvent{Category: "stderr", Output: err.Error() + "\n"})
+		exitCode = 1
+	}
+	s.event("exited", ExitedEvent{ExitCode: exitCode})
+	s.event("terminated", nil)
+	close(s.finished)
+}
+
+// called by the debugger on the program's goroutine. it blocks until a
+// request resumes the program
+func (s *Server) onStop(d *vm.Debugger, stop vm.Stop) vm.StepMode {
+	s.mu.Lock()
+	if s.quitting {
+		s.mu.Unlock()
+		return vm.Quit
+	}
+	s.stopped = true
+	s.mu.Unlock()
+
+	ev := StoppedEvent{Reason: stop.Reason, ThreadID: threadID, AllThreadsStopped: true}
+	if stop.Breakpoint != nil {
+		ev.HitBreakpointIDs = []int{stop.Breakpoint.ID}
+	}
+	s.event("stopped", ev)
+	return <-s.resume
+}
+
+// quit ends the program at the next place it would stop, and waits for
+// it to finish
+func (s *Server) quit() {
+	s.mu.Lock()
+	s.quitting = true
+	if s.stopped {
+		s.stopped = false
+		s.resume <- vm.Quit
+	}
+	running := s.running
+	s.mu.Unlock()
+
+	if running {
+		<-s.finished
+	}
+}
+
+// replaces the debugger's breakpoints in path with the lines the client asked for
+func (s *Server) setLineBreakpoints(path string) []Breakpoint {
+	bps := []Breakpoint{}
+	if s.debugger == nil {
+		for _, line := range s.lines[path] {
+			bps = append(bps, Breakpoint{Line: line, Source: &Source{Path: path}, Message: "the program is not launched yet"})
+		}
+		return bps
+	}
+
+	for _, id := range s.lineBps[path] {
+		s.debugger.Delete(id)
+	}
+	s.lineBps[path] = nil
+	for _, line := range s.lines[path] {
+		bp := s.debugger.BreakLine(path, line)
+		s.lineBps[path] = append(s.lineBps[path], bp.ID)
+		bps = append(bps, breakpoint(bp))
+	}
+	return bps
+}
+
+func (s *Server) setFunctionBreakpoints() []Breakpoint {
+	bps := []Breakpoint{}
+	if s.debugger == nil {
+		for range s.funcs {
+			bps = append(bps, Breakpoint{Message: "the program is not launched yet"})
+		}
+		return bps
+	}
+
+	for _, id := range s.funcBps {
+		s.debugger.Delete(id)
+	}
+	s.funcBps = nil
+	for _, name := range s.funcs {
+		bp := s.debugger.BreakFunc(name)
+		s.funcBps = append(s.funcBps, bp.ID)
+		bps = append(bps, breakpoint(bp))
+	}
+	return bps
+}
+
+func breakpoint(bp *vm.Breakpoint) Breakpoint {
+	out := Breakpoint{ID: bp.ID, Verified: bp.Verified}
+	if bp.Func == "" {
+		out.Source = &Source{Name: filepath.Base(bp.File), Path: bp.File}
+		out.Line = bp.Line
+	}
+	if !bp.Verified {
+		out.Message = "no statement is there"
+	}
+	return out
+}
+
+// frame ids count out from the innermost frame, starting at 1
+func (s *Server) stackTrace() []StackFrame {
+	frames := []StackFrame{}
+	for i, f := range s.debugger.Frames() {
+		frame := StackFrame{ID: i + 1, Name: f.Func, Line: f.Line, Column: 1}
+		if f.File != "" {
+			frame.Source = &Source{Name: filepath.Base(f.File), Path: f.File}
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// outputWriter sends what the program prints to the client
+type outputWriter struct {
+	s *Server
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.event("output", OutputEvent{Category: "stdout", Output: string(p)})
+	return len(p), nil
+}
@@ -0,0 +1,111 @@
+package dap
+
+import (
+	"fmt"
+	"nala/object"
+	"nala/vm"
+)
+
+// reference is what a variablesReference stands for: one of the scopes
+// of a frame, or a value whose parts can be shown
+type reference struct {
+	scope string // "locals", "free" or "globals", when it is a scope
+	frame int
+	value object.Object
+}
+
+// adds ref and returns the variablesReference the client can use for it
+func (s *Server) reference(ref reference) int {
+	s.refs = append(s.refs, ref)
+	return len(s.refs)
+}
+
+// scopes maps frame n, counted out from the innermost frame, to the
+// locals of its function, the free variables of its closure and the globals
+func (s *Server) scopes(n int) ([]Scope, error) {
+	if !s.stopped {
+		return nil, fmt.Errorf("the program is not stopped")
+	}
+	if n < 0 || n >= len(s.debugger.Frames()) {
+		return nil, fmt.Errorf("no frame %d", n+1)
+	}
+
+	scopes := []Scope{}
+	if locals, _ := s.debugger.Locals(n); len(locals) > 0 {
+		scopes = append(scopes, Scope{Name: "Locals", PresentationHint: "locals",
+			VariablesReference: s.reference(reference{scope: "locals", frame: n})})
+	}
+	if free, _ := s.debugger.Free(n); len(free) > 0 {
+		scopes = append(scopes, Scope{Name: "Free variables",
+			VariablesReference: s.reference(reference{scope: "free", frame: n})})
+	}
+	scopes = append(scopes, Scope{Name: "Globals",
+		VariablesReference: s.reference(reference{scope: "globals", frame: n})})
+	return scopes, nil
+}
+
+func (s *Server) variables(ref int) ([]Variable, error) {
+	if !s.stopped {
+		return nil, fmt.Errorf("the program is not stopped")
+	}
+	if ref < 1 || ref > len(s.refs) {
+		return nil, fmt.Errorf("no variables for reference %d", ref)
+	}
+	r := s.refs[ref-1]
+
+	var vars []vm.Variable
+	switch r.scope {
+	case "locals":
+		vars, _ = s.debugger.Locals(r.frame)
+	case "free":
+		vars, _ = s.debugger.Free(r.frame)
+	case "globals":
+		vars = s.debugger.Globals()
+	default:
+		vars = parts(r.value)
+	}
+
+	out := []Variable{}
+	for _, v := range vars {
+		out = append(out, s.variable(v.Name, v.Value))
+	}
+	return out, nil
+}
+
+// variable shows val, giving it a reference when it has parts of its own
+func (s *Server) variable(name string, val object.Object) Variable {
+	if val == nil {
+		return Variable{Name: name, Value: "(unset)"}
+	}
+
+	v := Variable{Name: name, Value: val.Inspect(), Type: string(val.Type())}
+	if len(parts(val)) > 0 {
+		v.VariablesReference = s.reference(reference{value: val})
+	}
+	return v
+}
+
+// the elements of arrays and sets, the pairs of hash maps and the fields
+// of structs
+func parts(val object.Object) []vm.Variable {
+	vars := []vm.Variable{}
+	switch val := val.(type) {
+	case *object.Array:
+		for i, el := range val.Elements {
+			vars = append(vars, vm.Variable{Name: fmt.Sprintf("[%d]", i), Value: el})
+		}
+	case *object.Set:
+		for i, el := range val.Elements() {
+			vars = append(vars, vm.Variable{Name: fmt.Sprintf("[%d]", i), Value: el})
+		}
+	case *object.HashMap:
+		for _, pair := range val.Pairs() {
+			vars = append(vars, vm.Variable{Name: pair.Key.Inspect(), Value: pair.Value})
+		}
+	case *object.Struct:
+		for i, field := range val.Def.Fields {
+			vars = append(vars, vm.Variable{Name: field, Value: val.Values[i]})
+		}
+	}
+	return vars
+}
@@ -11,22 +11,39 @@ type MapofIDtoBuiltin map[string]*object.BuiltIn
 
 // export builtins to REPL
 var builtins = MapofIDtoBuiltin{
-	"len":    object.GetBuiltinByName("len"),
-	"type":   object.GetBuiltinByName("type"),
-	"first":  object.GetBuiltinByName("first"),
-	"last":   object.GetBuiltinByName("last"),
-	"rest":   object.GetBuiltinByName("rest"),
-	"push":   object.GetBuiltinByName("push"),
-	"puts":   object.GetBuiltinByName("puts"),
-	"putl":   object.GetBuiltinByName("putl"),
-	"reads":  object.GetBuiltinByName("reads"),
-	"keys":   object.GetBuiltinByName("keys"),
-	"values": object.GetBuiltinByName("values"),
-	"items":  object.GetBuiltinByName("items"),
-	"ins":    object.GetBuiltinByName("ins"),
-	"del":    object.GetBuiltinByName("del"),
-	"copy":   object.GetBuiltinByName("copy"),
-	"sb":     &object.BuiltIn{Fn: nil},
-	"desc":   object.GetBuiltinByName("desc"),
+	"len":        object.GetBuiltinByName("len"),
+	"type":       object.GetBuiltinByName("type"),
+	"first":      object.GetBuiltinByName("first"),
+	"last":       object.GetBuiltinByName("last"),
+	"rest":       object.GetBuiltinByName("rest"),
+	"push":       object.GetBuiltinByName("push"),
+	"puts":       object.GetBuiltinByName("puts"),
+	"putl":       object.GetBuiltinByName("putl"),
+	"reads":      object.GetBuiltinByName("reads"),
+	"keys":       object.GetBuiltinByName("keys"),
+	"values":     object.GetBuiltinByName("values"),
+	"items":      object.GetBuiltinByName("items"),
+	"ins":        object.GetBuiltinByName("ins"),
+	"del":        object.GetBuiltinByName("del"),
+	"copy":       object.GetBuiltinByName("copy"),
+	"sb":         &object.BuiltIn{Fn: nil},
+	"desc":       object.GetBuiltinByName("desc"),
+	"range":      object.GetBuiltinByName("range"),
+	"has":        object.GetBuiltinByName("has"),
+	"array":      object.GetBuiltinByName("array"),
+	"add":        object.GetBuiltinByName("add"),
+	"remove":     object.GetBuiltinByName("remove"),
+	"union":      object.GetBuiltinByName("union"),
+	"intersect":  object.GetBuiltinByName("intersect"),
+	"difference": object.GetBuiltinByName("difference"),
+	"upper":      object.GetBuiltinByName("upper"),
+	"lower":      object.GetBuiltinByName("lower"),
+	"next":       object.GetBuiltinByName("next"),
+	"wait":       object.GetBuiltinByName("wait"),
+	"chan":       object.GetBuiltinByName("chan"),
+	"send":       object.GetBuiltinByName("send"),
+	"recv":       object.GetBuiltinByName("recv"),
+	"close":      object.GetBuiltinByName("close"),
+	"select":     object.GetBuiltinByName("select"),
 	// "loadf":  &object.BuiltIn{Fn: nala_loadf},
 }
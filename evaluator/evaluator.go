@@ -7,9 +7,9 @@ import (
 )
 
 var (
-	NIL   = &object.Nil{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NIL   = object.NIL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
@@ -34,6 +34,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return val
 		}
 		env.Set(node.Name.Value, val)
+	case *ast.StructStatement:
+		return evalStructStatement(node, env)
 
 	// Expressions
 	case *ast.IntegerLiteral:
@@ -48,6 +50,34 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return elems[0]
 		}
 		return &object.Array{Elements: elems}
+	case *ast.SetLiteral:
+		return evalSetLiteral(node, env)
+	case *ast.FieldAccessExpression:
+		obj := Eval(node.Object, env)
+		if isErrorObj(obj) {
+			return obj
+		}
+		return evalFieldAccess(obj, node.Field.Value)
+	case *ast.MethodCallExpression:
+		recv := Eval(node.Object, env)
+		if isErrorObj(recv) {
+			return recv
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isErrorObj(args[0]) {
+			return args[0]
+		}
+		return evalMethodCall(recv, node.Method.Value, args)
+	case *ast.FieldAssignExpression:
+		obj := Eval(node.Object, env)
+		if isErrorObj(obj) {
+			return obj
+		}
+		val := Eval(node.Value, env)
+		if isErrorObj(val) {
+			return val
+		}
+		return evalFieldAssign(obj, node.Field.Value, val)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isErrorObj(left) {
@@ -83,7 +113,22 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Env: env, Body: body, IsGenerator: node.IsGenerator}
+	case *ast.YieldExpression:
+		val := Eval(node.Value, env)
+		if isErrorObj(val) {
+			return val
+		}
+
+		yield := env.Yield()
+		if yield == nil {
+			return newError("yield outside of a generator")
+		}
+		if node.Delegate {
+			return evalYieldFrom(val, yield)
+		}
+		yield(val)
+		return NIL
 	case *ast.CallExpression:
 		fn := Eval(node.Function, env)
 		if isErrorObj(fn) {
@@ -123,6 +168,10 @@ func applyFunction(function object.Object, args []object.Object) object.Object {
 		// this creates a static environment binding, as fn.env is the lexical env
 		// from when it was defined vs whatever the current env is at the point of this call.
 		// passing that env instead would be dynamic environment binding
+		if fn.IsGenerator {
+			// the body only starts running on the first call to next
+			return newGenerator(fn, args)
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
 		evald := Eval(fn.Body, extendedEnv)
 
@@ -133,6 +182,8 @@ func applyFunction(function object.Object, args []object.Object) object.Object {
 	case *object.BuiltIn:
 		// call the builtin
 		return fn.Fn(args...)
+	case *object.StructType:
+		return fn.Construct(args...)
 	// case *object.Macro:
 	// 	// do parameter counting to make sure right number of arguments were passed
 	// 	if len(args) != len(fn.Parameters) {
@@ -169,6 +220,9 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 	case typeChecks(left.Type(), object.ARRAY_OBJ) &&
 		typeChecks(index.Type(), object.INTEGER_OBJ):
 		return evalArrayIndexExpression(left, index)
+	case typeChecks(left.Type(), object.RANGE_OBJ) &&
+		typeChecks(index.Type(), object.INTEGER_OBJ):
+		return evalRangeIndexExpression(left, index)
 	case typeChecks(left.Type(), object.HASHMAP_OBJ):
 		return evalHashMapIndexExpression(left, index)
 	default:
@@ -187,6 +241,17 @@ func evalArrayIndexExpression(array object.Object, index object.Object) object.O
 	return arr.Elements[idx]
 }
 
+func evalRangeIndexExpression(rangeObj object.Object, index object.Object) object.Object {
+	rng := rangeObj.(*object.Range)
+	idx := index.(*object.Integer).Value
+
+	val, ok := rng.At(idx)
+	if !ok {
+		return NIL
+	}
+	return &object.Integer{Value: val}
+}
+
 func evalHashMapIndexExpression(hashObj object.Object, index object.Object) object.Object {
 	hmap := hashObj.(*object.HashMap)
 	key, ok := index.(object.Hashable)
@@ -194,7 +259,7 @@ func evalHashMapIndexExpression(hashObj object.Object, index object.Object) obje
 		return newError("unusable as hash key: %s", index.Type())
 	}
 
-	pair, ok := hmap.Pairs[key.HashKey()]
+	pair, ok := hmap.Get(key)
 	if !ok {
 		return NIL
 	}
@@ -203,10 +268,10 @@ func evalHashMapIndexExpression(hashObj object.Object, index object.Object) obje
 }
 
 func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
+	hmap := object.NewHashMap()
 
-	for keyNode, valNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	for _, p := range node.Pairs {
+		key := Eval(p.Key, env)
 		if isErrorObj(key) {
 			return key
 		}
@@ -216,15 +281,92 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valNode, env)
+		value := Eval(p.Value, env)
 		if isErrorObj(value) {
 			return value
 		}
-		hsh := hashedKey.HashKey()
-		pairs[hsh] = object.HashPair{Key: key, Value: value}
+		hmap.Set(hashedKey, value)
+	}
+
+	return hmap
+}
+
+func evalSetLiteral(node *ast.SetLiteral, env *object.Environment) object.Object {
+	set := object.NewSet()
+
+	for _, el := range node.Elements {
+		member := Eval(el, env)
+		if isErrorObj(member) {
+			return member
+		}
+
+		hashedMember, ok := member.(object.Hashable)
+		if !ok {
+			return newError("unusable as set member: %s", member.Type())
+		}
+		set.Add(hashedMember)
 	}
 
-	return &object.HashMap{Pairs: pairs}
+	return set
+}
+
+func evalStructStatement(node *ast.StructStatement, env *object.Environment) object.Object {
+	def := &object.StructType{Name: node.Name.Value}
+	for _, f := range node.Fields {
+		if _, exists := def.FieldIndex(f.Value); exists {
+			return newError("duplicate field %s in struct %s", f.Value, def.Name)
+		}
+		def.Fields = append(def.Fields, f.Value)
+	}
+
+	env.Set(node.Name.Value, def)
+	return nil
+}
+
+func evalMethodCall(recv object.Object, name string, args []object.Object) object.Object {
+	method, ok := object.ResolveMethod(recv, name)
+	if !ok {
+		return newError("%s", object.MethodError(recv, name))
+	}
+	return applyFunction(method, append([]object.Object{recv}, args...))
+}
+
+func evalFieldAccess(obj object.Object, field string) object.Object {
+	if def, ok := obj.(*object.StructType); ok {
+		method, ok := def.Methods[field]
+		if !ok {
+			return newError("undefined method %s for struct %s", field, def.Name)
+		}
+		return method
+	}
+
+	st, ok := obj.(*object.Struct)
+	if !ok {
+		return newError("cannot access field %s on %s", field, obj.Type())
+	}
+
+	val, ok := st.Get(field)
+	if !ok {
+		return newError("unknown field %s for struct %s", field, st.Def.Name)
+	}
+	return val
+}
+
+func evalFieldAssign(obj object.Object, field string, val object.Object) object.Object {
+	if def, ok := obj.(*object.StructType); ok {
+		def.SetMethod(field, val)
+		return val
+	}
+
+	st, ok := obj.(*object.Struct)
+	if !ok {
+		return newError("cannot access field %s on %s", field, obj.Type())
+	}
+
+	if !st.Set(field, val) {
+		return newError("unknown field %s for struct %s", field, st.Def.Name)
+	}
+	return val
 }
 
 // handles top level evaluation of program
@@ -373,14 +515,17 @@ func evalInfixExpression(left object.Object, operator string, right object.Objec
 	switch {
 	case operandTypeChecks(left.Type(), right.Type(), object.INTEGER_OBJ):
 		return evalIntegerInfixExpression(left, operator, right)
-	case operandTypeChecks(left.Type(), right.Type(), object.BOOLEAN_OBJ):
-		return evalBooleanInfixExpression(left, operator, right)
+	case operator == "==":
+		return getBooleanObject(object.Equal(left, right))
+	case operator == "!=":
+		return getBooleanObject(!object.Equal(left, right))
+	case operator == "<" || operator == ">":
+		return evalComparisonInfixExpression(left, operator, right)
+	case left.Type() != right.Type():
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case operandTypeChecks(left.Type(), right.Type(), object.STRING_OBJ):
 		return evalStringInfixExpression(left, operator, right)
 	default:
-		if left.Type() != right.Type() {
-			return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
-		}
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
@@ -472,38 +617,11 @@ func evalIntInequalityInfixExpression(left object.Object, right object.Object) o
 	return getBooleanObject(lval != rval)
 }
 
-func evalBooleanInfixExpression(left object.Object, operator string, right object.Object) object.Object {
-	switch operator {
-	case "==":
-		return evalBoolEqualityInfixExpression(left, right)
-	case "!=":
-		return evalBoolInequalityInfixExpression(left, right)
-	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-}
-
-func evalBoolEqualityInfixExpression(left object.Object, right object.Object) object.Object {
-	lval := left.(*object.Boolean).Value
-	rval := right.(*object.Boolean).Value
-	return getBooleanObject(lval == rval)
-}
-
-func evalBoolInequalityInfixExpression(left object.Object, right object.Object) object.Object {
-	lval := left.(*object.Boolean).Value
-	rval := right.(*object.Boolean).Value
-	return getBooleanObject(lval != rval)
-}
-
 func evalStringInfixExpression(left object.Object, operator string,
 	right object.Object) object.Object {
 	switch operator {
 	case "+":
 		return evalStringConcatenationInfixExpression(left, operator, right)
-	case "==":
-		return evalStringEqualityInfixExpression(left, right)
-	case "!=":
-		return evalStringInequalityInfixExpression(left, right)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -516,16 +634,18 @@ func evalStringConcatenationInfixExpression(left object.Object, operator string,
 	return &object.String{Value: lVal + rVal}
 }
 
-func evalStringEqualityInfixExpression(left object.Object, right object.Object) object.Object {
-	lval := left.(*object.String).Value
-	rval := right.(*object.String).Value
-	return getBooleanObject(lval == rval)
-}
+// orders Strings and Arrays the same way object.Compare does, failing
+// with its error as the VM does
+func evalComparisonInfixExpression(left object.Object, operator string, right object.Object) object.Object {
+	res, err := object.Compare(left, right)
+	if err != nil {
+		return newError("%s", err)
+	}
 
-func evalStringInequalityInfixExpression(left object.Object, right object.Object) object.Object {
-	lval := left.(*object.String).Value
-	rval := right.(*object.String).Value
-	return getBooleanObject(lval != rval)
+	if operator == "<" {
+		return getBooleanObject(res < 0)
+	}
+	return getBooleanObject(res > 0)
 }
 
 func isTruthy(value object.Object) bool {
@@ -29,7 +29,11 @@ type FunctionTest struct {
 	expectedBody string
 }
 
-type HashMapTests map[object.HashKey]int64
+// expected key:value pairs of a HashMap, in insertion order
+type HashMapTests []struct {
+	key   object.Hashable
+	value int64
+}
 
 func TestEvalIntegerExpression(t *testing.T) {
 	tests := []IntegerTest{
@@ -103,6 +107,23 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{`"pepple" != "iwarilama"`, true},
 		{`"joshua" != "joshua"`, false},
 		{`"pepple" == "iwarilama"`, false},
+		{`1 == "1"`, false},
+		{`1 != "1"`, true},
+		{"true == 1", false},
+		{"[1] == {1: 1}", false},
+		{"len == len", true},
+		{"len == first", false},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+		{"[1, len] == [1, len]", true},
+		{`"abc" < "abd"`, true},
+		{`"abc" > "ab"`, true},
+		{`"b" < "abc"`, false},
+		{"[1, 2] < [1, 3]", true},
+		{"[1, 2] < [1, 2, 0]", true},
+		{"[2] > [1, 9, 9]", true},
+		{"[1, 2] > [1, 2]", false},
+		{`[[1, "b"]] > [[1, "a"]]`, true},
 	}
 
 	for _, tt := range tests {
@@ -255,10 +276,30 @@ func TestEvalErrorHandling(t *testing.T) {
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
 		},
+		{
+			"true < false",
+			"cannot compare BOOLEAN values",
+		},
+		{
+			`1 > "2"`,
+			"cannot compare INTEGER with STRING",
+		},
+		{
+			`[1] < "a"`,
+			"cannot compare ARRAY with STRING",
+		},
 		{
 			`{"name" : "Monkey"}[fn(x) { x }];`,
 			"unusable as hash key: FUNCTION",
 		},
+		{
+			`struct P { x } {P(1): 5}`,
+			"unusable as hash key: STRUCT",
+		},
+		{
+			`struct P { x } has(#{1}, P(1))`,
+			"unusable as set member: STRUCT",
+		},
 	}
 
 	for _, tt := range tests {
@@ -509,26 +550,295 @@ func TestHashLiterals(t *testing.T) {
 	}
 
 	expected := HashMapTests{
-		(&object.String{Value: "one"}).HashKey():   1,
-		(&object.String{Value: "two"}).HashKey():   2,
-		(&object.String{Value: "three"}).HashKey(): 3,
-		(&object.Integer{Value: 4}).HashKey():      4,
-		TRUE.HashKey():                             5,
-		FALSE.HashKey():                            6,
+		{&object.String{Value: "one"}, 1},
+		{&object.String{Value: "two"}, 2},
+		{&object.String{Value: "three"}, 3},
+		{&object.Integer{Value: 4}, 4},
+		{TRUE, 5},
+		{FALSE, 6},
 	}
 
-	if len(res.Pairs) != len(expected) {
-		t.Fatalf("Hash has wrong number of pairs. got=%d", len(res.Pairs))
+	if res.Len() != len(expected) {
+		t.Fatalf("Hash has wrong number of pairs. got=%d", res.Len())
 	}
 
-	for eKey, eVal := range expected {
-		p, ok := res.Pairs[eKey]
+	pairs := res.Pairs()
+	for i, exp := range expected {
+		p, ok := res.Get(exp.key)
 
 		if !ok {
 			t.Errorf("no pair for given key in Pairs")
 		}
 
-		testIntegerObject(t, p.Value, eVal)
+		testIntegerObject(t, p.Value, exp.value)
+
+		if pairs[i].Key.Inspect() != exp.key.Inspect() {
+			t.Errorf("pair %d out of insertion order. want=%s, got=%s",
+				i, exp.key.Inspect(), pairs[i].Key.Inspect())
+		}
+	}
+}
+
+func TestHashMapOrdering(t *testing.T) {
+	tests := []GenericTest{
+		{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+		{`keys({3: 1, 1: 2, 2: 3})`, "[3, 1, 2]"},
+		{`values({"z": 1, "y": 2})`, "[1, 2]"},
+		{`items({"z": 1, "y": 2})`, "[[z, 1], [y, 2]]"},
+		{`let h = {"a": 1, "b": 2}; ins(h, "a", 5); ins(h, "c", 6); h`, "{a: 5, b: 2, c: 6}"},
+		{`let h = {"a": 1, "b": 2, "c": 3}; del(h, "a"); ins(h, "a", 1); h`, "{b: 2, c: 3, a: 1}"},
+		{`copy({"q": 1, "p": 2})`, "{q: 1, p: 2}"},
+	}
+
+	for _, tt := range tests {
+		evald := testEval(tt.input)
+		if evald.Inspect() != tt.expected {
+			t.Errorf("wrong output for %s. want=%q, got=%q", tt.input, tt.expected, evald.Inspect())
+		}
+	}
+}
+
+func TestStructuralEquality(t *testing.T) {
+	tests := []GenericTest{
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] != [1, 2]", false},
+		{"[1, 2] == [2, 1]", false},
+		{"[[1], [2, 3]] == [[1], [2, 3]]", true},
+		{`{"a": 1, "b": 2} == {"b": 2, "a": 1}`, true},
+		{`{"a": 1} != {"a": 2}`, true},
+		{"{[1, 2]: 5}[[1, 2]]", 5},
+		{"let k = [1, 2]; {k: 5}[[1, 2]]", 5},
+		{"{[1, 2]: 5}[[2, 1]]", nil},
+		{`{{"a": 1}: 7}[{"a": 1}]`, 7},
+	}
+
+	for _, tt := range tests {
+		testEvalLiteral(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestSets(t *testing.T) {
+	tests := []GenericTest{
+		{"#{}", "#{}"},
+		{"#{1, 2, 3}", "#{1, 2, 3}"},
+		{"#{3, 1, 3, 2, 1}", "#{3, 1, 2}"},
+		{`#{[1, 2], "a", [1, 2]}`, "#{[1, 2], a}"},
+		{"len(#{1, 2, 2})", 2},
+		{"type(#{})", "SET"},
+		{"has(#{1, 2}, 2)", true},
+		{"has(#{[1, 2]}, [1, 2])", true},
+		{"has(#{1, 2}, 3)", false},
+		{"let s = #{1}; add(s, 2); add(s, 1); s", "#{1, 2}"},
+		{"let s = #{1, 2, 3}; remove(s, 2); s", "#{1, 3}"},
+		{"union(#{1, 2}, #{2, 3})", "#{1, 2, 3}"},
+		{"intersect(#{1, 2, 3}, #{3, 2, 4})", "#{2, 3}"},
+		{"difference(#{1, 2, 3}, #{2})", "#{1, 3}"},
+		{"#{1, 2} == #{2, 1}", true},
+		{"#{1, 2} == #{1}", false},
+		{"{#{1, 2}: 5}[#{2, 1}]", 5},
+		{"array(#{3, 1})", "[3, 1]"},
+		{"let s = #{1}; let c = copy(s); add(c, 2); s", "#{1}"},
+		{"remove(#{1}, 2)", "Error: member does not exist in Set"},
+		{"#{fn(x) { x }}", "Error: unusable as set member: FUNCTION"},
+		{"let add = fn(a, b) { a + b }; add(1, 2)", 3},
+		{"let union = 5; union", 5},
+		{"let add = 0; let s = #{1}; s.add(2); s", "#{1, 2}"},
+	}
+
+	for _, tt := range tests {
+		evald := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			if evald.Inspect() != expected {
+				t.Errorf("wrong output for %s. want=%q, got=%q", tt.input, expected, evald.Inspect())
+			}
+		default:
+			testEvalLiteral(t, evald, expected)
+		}
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []GenericTest{
+		{`struct Person { name, age } let p = Person("Nala", 3); p.name`, "Nala"},
+		{`struct Person { name, age } Person("Nala", 3).age`, 3},
+		{`struct Person { name, age } let p = Person("Nala", 3); p.age = p.age + 1; p.age`, 4},
+		{`struct Person { name, age } let p = Person("Nala", 3); p.age = 10`, 10},
+		{`struct Person { name, age } Person("Nala", 3)`, "Person{name: Nala, age: 3}"},
+		{`struct Person { name, age } type(Person("Nala", 3))`, "Person"},
+		{`struct Person { name, age } type(Person)`, "STRUCT_TYPE"},
+		{`struct P { x } let get = fn(p) { p.x }; get(P(7))`, 7},
+		{`struct P { x } P(1) == P(1)`, true},
+		{`struct P { x } struct Q { x } P(1) == Q(1)`, false},
+		{`struct P { x } let p = P(1); let k = [p]; let h = {k: "one"}; p.x = 2; [h[k], h[[P(2)]], h[[P(1)]]]`, "[one, one, nil]"},
+		{`struct Pair { l, r } let p = Pair(Pair(1, 2), 3); p.l.r`, 2},
+		{`struct P { x } P()`, "Error: wrong number of arguments to P. got=0, want=1"},
+		{`struct P { x } let p = P(1); p.y`, "Error: unknown field y for struct P"},
+		{`struct P { x } let p = P(1); p.y = 2`, "Error: unknown field y for struct P"},
+		{`let p = 5; p.y`, "Error: cannot access field y on INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evald := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			if evald.Inspect() != expected {
+				t.Errorf("wrong output for %s. want=%q, got=%q", tt.input, expected, evald.Inspect())
+			}
+		default:
+			testEvalLiteral(t, evald, expected)
+		}
+	}
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []GenericTest{
+		{"[1, 2].push(3)", "[1, 2, 3]"},
+		{"[].push(1).push(2).len()", 2},
+		{"let arr = [3, 4]; arr.first() + arr.last()", 7},
+		{`"Nala".upper()`, "NALA"},
+		{`"Nala".lower().has("n")`, true},
+		{`{"a": 1}.keys()`, "[a]"},
+		{"#{1, 2}.union(#{3})", "#{1, 2, 3}"},
+		{"range(5).len()", 5},
+		{"5.type()", "INTEGER"},
+		{`struct P { name } P.greet = fn(self, greeting) { greeting + ", " + self.name }; P("Nala").greet("hi")`, "hi, Nala"},
+		{`struct P { n } P.double = fn(self) { self.n * 2 }; let p = P(4); p.double() + p.n`, 12},
+		{`struct P { n } P.inc = fn(self) { self.n = self.n + 1; self }; P(1).inc().inc().n`, 3},
+		{`struct P { n } P.len = fn(self) { 99 }; P(1).len()`, 99},
+		{`struct P { n } P.get = fn(self) { self.n }; let f = P.get; f(P(5))`, 5},
+		{"[1].upper()", "Error: undefined method upper for ARRAY"},
+		{`struct P { n } P(1).greet()`, "Error: undefined method greet for struct P"},
+	}
+
+	for _, tt := range tests {
+		evald := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			if evald.Inspect() != expected {
+				t.Errorf("wrong output for %s. want=%q, got=%q", tt.input, expected, evald.Inspect())
+			}
+		default:
+			testEvalLiteral(t, evald, expected)
+		}
+	}
+}
+
+func TestPipelines(t *testing.T) {
+	tests := []GenericTest{
+		{"[1, 2, 3] |> len", 3},
+		{"[1, 2] |> push(3) |> push(4)", "[1, 2, 3, 4]"},
+		{"let double = fn(x) { x * 2 }; 5 |> double |> double", 20},
+		{`let isInt = fn(x) { type(x) == "INTEGER" };
+		  let filter = fn(arr, f) {
+		      let iter = fn(arr, acc, iter) {
+		          if (len(arr) == 0) { acc }
+		          else { if (f(first(arr))) { iter(rest(arr), push(acc, first(arr)), iter) } else { iter(rest(arr), acc, iter) } }
+		      };
+		      iter(arr, [], iter)
+		  };
+		  let sum = fn(arr) { if (len(arr) == 0) { 0 } else { first(arr) + sum(rest(arr)) } };
+		  [1, "a", 2, true, 3] |> filter(isInt) |> sum`, 6},
+		{`"nala" |> upper`, "NALA"},
+	}
+
+	for _, tt := range tests {
+		evald := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			if evald.Inspect() != expected {
+				t.Errorf("wrong output for %s. want=%q, got=%q", tt.input, expected, evald.Inspect())
+			}
+		default:
+			testEvalLiteral(t, evald, expected)
+		}
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []GenericTest{
+		{"let gen = fn() { yield 1; yield 2 }; let g = gen(); next(g) + next(g)", 3},
+		{"let gen = fn() { yield 1 }; let g = gen(); next(g); next(g)", nil},
+		{"let gen = fn(x) { yield x; yield x * 2 }; array(gen(4))", "[4, 8]"},
+		{"let gen = fn() { yield 1 }; type(gen())", "GENERATOR"},
+		{"let gen = fn() { let x = yield 1; yield x }; array(gen())", "[1, nil]"},
+		{"let gen = fn() { yield 1; return 5; yield 2 }; array(gen())", "[1]"},
+		{"let gen = fn(a, b) { let c = a + b; yield c; yield c + 1 }; let g = gen(1, 2); g.next(); g.next()", 4},
+		{"let gen = fn() { yield 5; yield 6 }; has(gen(), 6)", true},
+		{"let count = fn(i, n) { if (i < n) { yield i; yield from count(i + 1, n) } }; array(count(0, 4))", "[0, 1, 2, 3]"},
+		{"let nat = fn(i) { yield i; yield from nat(i + 1) }; let n = nat(0); next(n); next(n); next(n)", 2},
+		{`let gen = fn() { yield from [1, 2]; yield from range(3, 5); yield from "ab" }; array(gen())`, "[1, 2, 3, 4, a, b]"},
+		{"let gen = fn() { yield 1 }; let a = gen(); let b = gen(); next(a); next(b)", 1},
+		{"let gen = fn() { yield from 5 }; next(gen())", "Error: cannot yield from INTEGER"},
+		{"let gen = fn() { yield 1 / 0 }; array(gen())", "Error: division by Zero: 1 / 0"},
+		{"let g = 0; let gen = fn() { yield next(g) }; let g = gen(); next(g)", "Error: generator is already running"},
+		{"next(5)", "Error: argument to `next` must be GENERATOR, got INTEGER"},
+		{"let inner = fn() { yield 1; return 5 }; let outer = fn() { let r = yield from inner(); yield r }; array(outer())", "[1, 5]"},
+		{"let inner = fn() { yield 1; 6 }; let outer = fn() { let r = yield from inner(); yield r }; array(outer())", "[1, 6]"},
+		{"let outer = fn() { let r = yield from [1]; yield r }; array(outer())", "[1, nil]"},
+	}
+
+	for _, tt := range tests {
+		evald := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			if evald.Inspect() != expected {
+				t.Errorf("wrong output for %s. want=%q, got=%q", tt.input, expected, evald.Inspect())
+			}
+		default:
+			testEvalLiteral(t, evald, expected)
+		}
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := []GenericTest{
+		{"let sq = fn(n) { n * n }; wait(spawn(sq, 7))", 49},
+		{"let t = spawn(fn(a, b) { a + b }, 2, 3); t.wait()", 5},
+		{"wait(spawn(len, [1, 2, 3]))", 3},
+		{"struct P { x } wait(spawn(P, 1)).x", 1},
+		{"struct P { x } let p = P(1); let t = spawn(fn(q) { q.x = 2; q.x }, p); [wait(t), p.x]", "[2, 1]"},
+		{"struct P { x } let p = P(1); wait(spawn(fn(q) { q }, p)) == p", true},
+		{"let ch = chan(2); send(ch, 1); send(ch, 2); recv(ch) + recv(ch)", 3},
+		{"let ch = chan(); spawn(fn(c) { send(c, 5) }, ch); recv(ch)", 5},
+		{"let ch = chan(1); ch.send([1, 2]); ch.recv()", "[1, 2]"},
+		{"let ch = chan(1); close(ch); recv(ch)", nil},
+		{`let produce = fn(out, n) { if (n == 0) { close(out) } else { send(out, n); produce(out, n - 1) } };
+		  let sum = fn(in, acc, sum) { let v = recv(in); if (type(v) == "NIL") { acc } else { sum(in, acc + v, sum) } };
+		  let ch = chan();
+		  spawn(produce, ch, 10);
+		  sum(ch, 0, sum)`, 55},
+		{`let a = chan(); let b = chan(1); send(b, "b"); select(a, b)`, "[1, b]"},
+		{`let a = chan(1); select([a, 4]); recv(a)`, 4},
+		{"let ch = chan(1); close(ch); send(ch, 1)", "Error: send on closed channel"},
+		{"let ch = chan(); close(ch); close(ch)", "Error: close of closed channel"},
+		{"let gen = fn() { yield 1 }; spawn(fn(g) { g }, gen())", "Error: cannot share GENERATOR between tasks"},
+		{"spawn(fn(a) { a })", "Error: wrong number of arguments. got=0, want=1"},
+		{"spawn(1)", "Error: cannot spawn INTEGER"},
+		{"wait(spawn(fn() { 1 / 0 }))", "Error: division by Zero: 1 / 0"},
+		{"chan(-1)", "Error: argument to `chan` must be a non-negative INTEGER, got -1"},
+		{`let task = fn(n) { struct Q { x } Q.get = fn(q) { q.x + n }; Q(1).get() };
+		  let ts = [spawn(task, 1), spawn(task, 2), spawn(task, 3)];
+		  let q = task(10);
+		  [wait(ts[0]), wait(ts[1]), wait(ts[2]), q]`, "[2, 3, 4, 11]"},
+		{`struct Q { x } Q.get = fn(q) { q.x };
+		  let t = spawn(fn() { Q.get = fn(q) { 0 }; Q(5).get() });
+		  [wait(t), Q(5).get()]`, "[0, 5]"},
+		// a binding that can't be copied only fails the task that reads it
+		{"let g = fn() { yield 1 }; let xs = [1, g()]; wait(spawn(fn() { xs }))", "Error: cannot share GENERATOR between tasks"},
+		{"let g = fn() { yield 1 }; let xs = [1, g()]; let n = 4; wait(spawn(fn() { n + 1 }))", 5},
+	}
+
+	for _, tt := range tests {
+		evald := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case string:
+			if evald.Inspect() != expected {
+				t.Errorf("wrong output for %s. want=%q, got=%q", tt.input, expected, evald.Inspect())
+			}
+		default:
+			testEvalLiteral(t, evald, expected)
+		}
 	}
 }
 
@@ -537,3 +847,30 @@ func TestHashLiterals(t *testing.T) {
 // 	p := parser.New(l)
 // 	return p.ParseProgram()
 // }
+
+func TestRangeExpressions(t *testing.T) {
+	tests := []GenericTest{
+		{"len(range(10))", 10},
+		{"len(range(10, 0, -3))", 4},
+		{"range(2, 10, 3)[2]", 8},
+		{"range(2, 10, 3)[3]", nil},
+		{"first(rest(range(4, 8)))", 5},
+		{"last(range(4, 8))", 7},
+		{"has(range(0, 10, 2), 4)", true},
+		{"has(range(0, 10, 2), 5)", false},
+		{"len(array(range(3)))", 3},
+		{"len(range(-4611686018427387904, 4611686018427387903))", 9223372036854775807},
+	}
+
+	for _, tt := range tests {
+		evald := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evald, int64(expected))
+		case bool:
+			testBooleanObject(t, evald, expected)
+		default:
+			testNullObject(t, evald)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package evaluator
+
+import (
+	"nala/object"
+)
+
+// Generator runs the body of a generator function on its own goroutine.
+// the goroutine and its caller hand control back and forth over unbuffered
+// channels, so only one of them is ever running. a generator that is
+// dropped before it finishes leaves its goroutine parked on resume
+type Generator struct {
+	fn   *object.Function
+	args []object.Object
+
+	resume chan struct{}
+	yields chan object.Object
+
+	started  bool
+	running  bool
+	done     bool
+	returned object.Object
+}
+
+func newGenerator(fn *object.Function, args []object.Object) *Generator {
+	return &Generator{
+		fn:     fn,
+		args:   args,
+		resume: make(chan struct{}),
+		yields: make(chan object.Object),
+	}
+}
+
+func (g *Generator) Type() object.ObjectType   { return object.GENERATOR_OBJ }
+func (g *Generator) Inspect() string           { return "Generator[" + g.fn.Inspect() + "]" }
+func (g *Generator) Iterator() object.Iterator { return g }
+
+// Returned is what the body returned, or nil while it hasn't finished
+func (g *Generator) Returned() object.Object {
+	if g.returned == nil {
+		return NIL
+	}
+	return g.returned
+}
+
+// Next resumes the body until it yields or finishes. an error in the
+// body is handed back as the final value
+func (g *Generator) Next() (object.Object, bool) {
+	if g.done {
+		return nil, false
+	}
+	if g.running {
+		return newError("generator is already running"), true
+	}
+
+	g.running = true
+	if !g.started {
+		g.started = true
+		go g.run()
+	} else {
+		g.resume <- struct{}{}
+	}
+	val, ok := <-g.yields
+	g.running = false
+
+	if !ok {
+		g.done = true
+		return nil, false
+	}
+	if isErrorObj(val) {
+		g.done = true
+	}
+	return val, true
+}
+
+func (g *Generator) run() {
+	defer close(g.yields)
+
+	env := extendFunctionEnv(g.fn, g.args)
+	env.SetYield(func(val object.Object) {
+		g.yields <- val
+		<-g.resume
+	})
+
+	// what the body returns is kept for Returned, only yielded values come out
+	res := Eval(g.fn.Body, env)
+	if isErrorObj(res) {
+		g.yields <- res
+		return
+	}
+	if retVal, ok := res.(*object.ReturnValue); ok {
+		res = retVal.Value
+	}
+	g.returned = res
+}
+
+// hands out every element of an iterable through yield. yielding from a
+// generator evaluates to what it returned
+func evalYieldFrom(val object.Object, yield func(object.Object)) object.Object {
+	iterable, ok := val.(object.Iterable)
+	if !ok {
+		return newError("cannot yield from %s", val.Type())
+	}
+
+	iter := iterable.Iterator()
+	for el, ok := iter.Next(); ok; el, ok = iter.Next() {
+		if isErrorObj(el) {
+			return el
+		}
+		yield(el)
+	}
+	if gen, ok := val.(object.Generator); ok {
+		return gen.Returned()
+	}
+	return NIL
+}
@@ -0,0 +1,45 @@
+package evaluator
+
+import (
+	"nala/object"
+)
+
+// spawn calls back into Eval, so it is put in place once the
+// builtins map exists instead of being listed in it
+func init() {
+	builtins["spawn"] = &object.BuiltIn{Fn: spawn, Desc: object.GetBuiltinByName("spawn").Desc}
+}
+
+// spawn applies a copy of a function to copies of its arguments on a
+// goroutine of its own. a copied Function carries a copy of the
+// environment it was defined in, so the task never touches the caller's.
+// see the concurrency model in object/concurrency.go
+func spawn(args ...object.Object) object.Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want=1 or more")
+	}
+
+	copier := object.NewCopier()
+	copied := make([]object.Object, len(args))
+	for i, arg := range args {
+		dup, err := copier.Copy(arg)
+		if err != nil {
+			return newError("%s", err)
+		}
+		copied[i] = dup
+	}
+
+	// a wrong argument count is reported to the caller, not to whoever waits
+	if fn, ok := copied[0].(*object.Function); ok && len(copied)-1 != len(fn.Parameters) {
+		return newError("wrong number of arguments. got=%d, want=%d", len(copied)-1, len(fn.Parameters))
+	}
+
+	switch fn := copied[0].(type) {
+	case *object.Function, *object.BuiltIn, *object.StructType:
+		task := object.NewTask()
+		go func() { task.Finish(applyFunction(fn, copied[1:])) }()
+		return task
+	default:
+		return newError("cannot spawn %s", fn.Type())
+	}
+}
@@ -0,0 +1,120 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Diff compares two versions of the file name line by line and returns the
+// changes as a unified diff, or "" when they are the same
+func Diff(name string, a, b string) string {
+	if a == b {
+		return ""
+	}
+	x, y := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the longest common run of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// every line of both versions, marked with ' ', '-' or '+'
+	type edit struct {
+		op       byte
+		text     string
+		aLn, bLn int
+	}
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i], i, j})
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", name, name)
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+
+		// a hunk runs until the changes are more than twice the context apart
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for gap := 0; end < len(edits) && gap <= 2*context; end++ {
+			if edits[end].op == ' ' {
+				gap++
+			} else {
+				gap = 0
+			}
+		}
+		for end > k && edits[end-1].op == ' ' {
+			end--
+		}
+		end += context
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		aCount, bCount := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(edits[start].aLn, aCount), hunkRange(edits[start].bLn, bCount))
+		for _, e := range edits[start:end] {
+			fmt.Fprintf(&buf, "%c%s\n", e.op, e.text)
+		}
+		k = end
+	}
+	return buf.String()
+}
+
+func hunkRange(first, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", first)
+	}
+	if count == 1 {
+		return fmt.Sprint(first + 1)
+	}
+	return fmt.Sprintf("%d,%d", first+1, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
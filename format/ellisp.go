@@ -0,0 +1,284 @@
+package format
+
+import (
+	"fmt"
+	"math"
+	"nala/ast"
+	"nala/lexer"
+	"nala/token"
+	"strings"
+)
+
+type ellispPrinter struct {
+	printer
+	err error
+}
+
+// Ellisp prints prog as Ellisp source, with the comments and blank lines
+// the lexer kept aside while it was read. prog can come from either front end, though type
+// annotations and macros have no Ellisp form at all
+func Ellisp(prog *ast.Program, trivia *lexer.Trivia) (string, error) {
+	p := &ellispPrinter{printer: newPrinter(trivia)}
+
+	out := []line{}
+	p.block(&out, prog.Statements, 0, endOfSource, func(i int) []line {
+		return cat(text(indentOf(0)), p.statement(prog.Statements[i], 0))
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+	return render(out), nil
+}
+
+func (p *ellispPrinter) statement(stmt ast.Statement, indent int) []line {
+	col := len(indentOf(indent))
+
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.annotation(stmt.Type)
+		head := "(let " + stmt.Name.Value + " "
+		return suffix(cat(text(head), p.expr(stmt.Value, indent, col+len(head))), ")", indent)
+	case *ast.ReturnStatement:
+		return suffix(cat(text("(return "), p.expr(stmt.ReturnValue, indent, col+8)), ")", indent)
+	case *ast.StructStatement:
+		fields := []string{}
+		for _, f := range stmt.Fields {
+			fields = append(fields, f.Value)
+		}
+		return text("(defstruct " + strings.Join(append([]string{stmt.Name.Value}, fields...), " ") + ")")
+	case *ast.ExpressionStatement:
+		return p.expr(stmt.Expression, indent, col)
+	case *ast.BlockStatement:
+		out := []line{}
+		for _, s := range stmt.Statements {
+			out = append(out, cat(text(indentOf(indent)), p.statement(s, indent))...)
+		}
+		return out
+	}
+	return nil
+}
+
+// form lays out (head args...), the arguments separated by sep
+func (p *ellispPrinter) form(head string, args []ast.Expression, sep string, indent int, col int) []line {
+	out := text("(" + head)
+	for i, arg := range args {
+		if i > 0 {
+			out = cat(out, text(sep))
+		} else if head != "" {
+			out = cat(out, text(" "))
+		}
+		out = cat(out, p.expr(arg, indent, col+len(out[len(out)-1].text)))
+	}
+	return cat(out, text(")"))
+}
+
+func (p *ellispPrinter) expr(exp ast.Expression, indent int, col int) []line {
+	return p.layout(exp, col, func() []line {
+		switch exp := exp.(type) {
+		case *ast.Identifier:
+			return text(exp.Value)
+		case *ast.IntegerLiteral:
+			return text(fmt.Sprint(exp.Value))
+		case *ast.StringLiteral:
+			return text(`"` + exp.Value + `"`)
+		case *ast.Boolean:
+			return text(fmt.Sprint(exp.Value))
+		case *ast.PrefixExpression:
+			if exp.Operator == "!" {
+				return cat(text("(!"), p.expr(exp.Right, indent, col+2), text(")"))
+			}
+			return p.form(exp.Operator, []ast.Expression{exp.Right}, " ", indent, col)
+		case *ast.InfixExpression:
+			return p.form(exp.Operator, []ast.Expression{exp.Left, exp.Right}, " ", indent, col)
+		case *ast.IfExpression:
+			return p.ifExpression(exp, indent, col)
+		case *ast.FunctionLiteral:
+			return p.function(exp, indent)
+		case *ast.MacroLiteral:
+			p.err = fmt.Errorf("%d:%d: macros have no Ellisp form", exp.Token.Line, exp.Token.Column)
+			return text(exp.String())
+		case *ast.CallExpression:
+			if exp.Piped {
+				return p.threading(exp, indent, col)
+			}
+			return p.call(exp, indent, col)
+		case *ast.MethodCallExpression:
+			if exp.Piped {
+				return p.threading(exp, indent, col)
+			}
+			head := text("(. ")
+			obj := cat(head, p.expr(exp.Object, indent, col+3), text(" "))
+			call := p.form(exp.Method.Value, exp.Arguments, ", ", indent, col)
+			return cat(obj, call, text(")"))
+		case *ast.IndexExpression:
+			left := cat(text("|"), p.expr(exp.Left, indent, col+1), text(" "))
+			return cat(left, p.expr(exp.Index, indent, col), text("|"))
+		case *ast.FieldAccessExpression:
+			obj := cat(text("(. "), p.expr(exp.Object, indent, col+3))
+			return cat(obj, text(" "+exp.Field.Value+")"))
+		case *ast.FieldAssignExpression:
+			obj := cat(text("(. "), p.expr(exp.Object, indent, col+3), text(" "+exp.Field.Value+" "))
+			return cat(obj, p.expr(exp.Value, indent, col), text(")"))
+		case *ast.ArrayLiteral:
+			return p.elements("[", "]", exp.Elements, indent)
+		case *ast.SetLiteral:
+			return p.elements("#{", "}", exp.Elements, indent)
+		case *ast.HashLiteral:
+			return p.list("{", "}", len(exp.Pairs), indent,
+				func(i int) token.Token { return ast.StartToken(exp.Pairs[i].Key) },
+				func(i int, col int) []line {
+					key := cat(p.expr(exp.Pairs[i].Key, indent+1, col), text(": "))
+					return cat(key, p.expr(exp.Pairs[i].Value, indent+1, col))
+				})
+		case *ast.YieldExpression:
+			head := "yield"
+			if exp.Delegate {
+				head = "yield from"
+			}
+			return p.form(head, []ast.Expression{exp.Value}, " ", indent, col)
+		}
+		return text(exp.String())
+	})
+}
+
+func (p *ellispPrinter) elements(open, close string, exps []ast.Expression, indent int) []line {
+	return p.list(open, close, len(exps), indent,
+		func(i int) token.Token { return ast.StartToken(exps[i]) },
+		func(i int, col int) []line { return p.expr(exps[i], indent+1, col) })
+}
+
+// (f a, b). a callee that is not a name has to be a form of its own, as
+// in ((fn (x): x) 1). calls that do not fit on a line are broken the way
+// Nala's are, except that the first argument stays with the callee
+func (p *ellispPrinter) call(ce *ast.CallExpression, indent int, col int) []line {
+	var callee []line
+	switch fn := ce.Function.(type) {
+	case *ast.Identifier:
+		callee = text("(" + fn.Value)
+	default:
+		callee = cat(text("("), p.expr(fn, indent, col+1))
+	}
+
+	args := ce.Arguments
+	hug := func() []line {
+		out := callee
+		for i, arg := range args {
+			if i > 0 {
+				out = cat(out, text(", "))
+			} else {
+				out = cat(out, text(" "))
+			}
+			at := col + len(out[len(out)-1].text)
+			el := func() []line { return p.expr(arg, indent, at) }
+			if i < len(args)-1 {
+				out = cat(out, p.flatten(el))
+			} else {
+				out = cat(out, el())
+			}
+		}
+		return cat(out, text(")"))
+	}
+	if p.flat || len(args) < 2 {
+		return hug()
+	}
+
+	if p.hugs(args) {
+		saved := p.printer
+		if out := hug(); col+len(out[0].text) <= width {
+			return out
+		}
+		p.printer = saved
+	}
+
+	out := cat(callee, text(" "), p.expr(args[0], indent+1, col))
+	for _, arg := range args[1:] {
+		out = suffix(out, ",", indent+1)
+		p.flush(&out, ast.StartToken(arg), indent+1, math.MaxInt32)
+		ind := indentOf(indent + 1)
+		out = append(out, cat(text(ind), p.expr(arg, indent+1, len(ind)))...)
+	}
+	return suffix(out, ")", indent)
+}
+
+// (-> xs (filter isInt) sum). the steps of a chain of piped calls go in
+// one form, each written without the value threaded through it
+func (p *ellispPrinter) threading(exp ast.Expression, indent int, col int) []line {
+	steps := []ast.Expression{}
+	for {
+		var step ast.Expression
+		switch call := exp.(type) {
+		case *ast.CallExpression:
+			if !call.Piped {
+				break
+			}
+			if len(call.Arguments) == 1 && !isCall(call.Function) {
+				step = call.Function
+			} else {
+				step = &ast.CallExpression{Token: call.Token, Function: call.Function, Arguments: call.Arguments[1:]}
+			}
+			exp = call.Arguments[0]
+		case *ast.MethodCallExpression:
+			if !call.Piped {
+				break
+			}
+			step = &ast.MethodCallExpression{Token: call.Token, Object: call.Object, Method: call.Method, Arguments: call.Arguments[1:]}
+			exp = call.Arguments[0]
+		}
+		if step == nil {
+			break
+		}
+		steps = append([]ast.Expression{step}, steps...)
+	}
+
+	return p.form("->", append([]ast.Expression{exp}, steps...), " ", indent, col)
+}
+
+// (if cond: consequence, alternative)
+func (p *ellispPrinter) ifExpression(ie *ast.IfExpression, indent int, col int) []line {
+	out := cat(text("(if "), p.expr(ie.Condition, indent, col+4), text(":"))
+	out = p.body(out, ie.Consequence, indent)
+	if ie.Alternative != nil {
+		out = suffix(out, ",", indent+1)
+		out = p.body(out, ie.Alternative, indent)
+	}
+	return suffix(out, ")", indent)
+}
+
+// (fn (x, y): body)
+func (p *ellispPrinter) function(fl *ast.FunctionLiteral, indent int) []line {
+	names := []string{}
+	for _, param := range fl.Parameters {
+		p.annotation(param.Type)
+		names = append(names, param.Value)
+	}
+	p.annotation(fl.ReturnType)
+
+	out := text("(fn (" + strings.Join(names, ", ") + "):")
+	out = p.body(out, fl.Body, indent)
+	return suffix(out, ")", indent)
+}
+
+// an annotated program can't be converted without losing its types
+func (p *ellispPrinter) annotation(ta *ast.TypeAnnotation) {
+	if ta != nil && p.err == nil {
+		p.err = fmt.Errorf("%d:%d: type annotations have no Ellisp form", ta.Token.Line, ta.Token.Column)
+	}
+}
+
+// adds the statements of a block after head. in flat mode a single one
+// follows it on the same line, otherwise they go a line each below it
+func (p *ellispPrinter) body(head []line, bs *ast.BlockStatement, indent int) []line {
+	if p.flat && len(bs.Statements) <= 1 {
+		out := head
+		for _, stmt := range bs.Statements {
+			out = cat(out, text(" "), p.statement(stmt, indent))
+		}
+		return out
+	}
+
+	out := head
+	p.block(&out, bs.Statements, indent+1, bs.End, func(i int) []line {
+		return cat(text(indentOf(indent+1)), p.statement(bs.Statements[i], indent+1))
+	})
+	return out
+}
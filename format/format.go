@@ -0,0 +1,46 @@
+// Package format prints Nala and Ellisp programs back out as source in a
+// single layout, keeping the comments the lexer found in them
+package format
+
+import (
+	"fmt"
+	"nala/ast"
+	"nala/lexer"
+	lispparser "nala/lisp_parser"
+	"nala/parser"
+	"strings"
+)
+
+// Source formats src, which is Ellisp when ellisp is set and Nala otherwise.
+// formatting what it returns gives back the same text
+func Source(src string, ellisp bool) (string, error) {
+	return Convert(src, ellisp, ellisp)
+}
+
+// Convert parses src with the front end fromEllisp picks and prints it in
+// the language toEllisp picks. either way the output parses to the same
+// program. programs with type annotations or macros, which Ellisp has no
+// syntax for, can't be converted to it
+func Convert(src string, fromEllisp bool, toEllisp bool) (string, error) {
+	l := lexer.New(src)
+
+	var prog *ast.Program
+	var errs []string
+	if fromEllisp {
+		p := lispparser.New(l)
+		prog = p.ParseProgram()
+		errs = p.Errors()
+	} else {
+		p := parser.New(l)
+		prog = p.ParseProgram()
+		errs = p.Errors()
+	}
+	if len(errs) > 0 {
+		return "", fmt.Errorf("parser errors:\n\t%s", strings.Join(errs, "\n\t"))
+	}
+
+	if toEllisp {
+		return Ellisp(prog, l.Trivia())
+	}
+	return Nala(prog, l.Trivia())
+}
@@ -0,0 +1,400 @@
+package format
+
+import (
+	"io/ioutil"
+	"nala/lexer"
+	lispparser "nala/lisp_parser"
+	"nala/parser"
+	"path/filepath"
+	"testing"
+)
+
+type formatTest struct {
+	input    string
+	expected string
+}
+
+func runFormatTests(t *testing.T, tests []formatTest, ellisp bool) {
+	t.Helper()
+
+	for _, tt := range tests {
+		out, err := Source(tt.input, ellisp)
+		if err != nil {
+			t.Errorf("error formatting %q: %s", tt.input, err)
+			continue
+		}
+		if out != tt.expected {
+			t.Errorf("wrong output for %q.\n%s", tt.input, Diff("expected", tt.expected, out))
+			continue
+		}
+
+		again, err := Source(out, ellisp)
+		if err != nil || again != out {
+			t.Errorf("formatting %q is not idempotent (err=%v).\n%s", tt.input, err, Diff("first", out, again))
+		}
+	}
+}
+
+func TestNala(t *testing.T) {
+	tests := []formatTest{
+		{"let   x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"let x = (1 + 2) * 3; x", "let x = (1 + 2) * 3;\nx;\n"},
+		{"let x = 1 - (2 - 3);", "let x = 1 - (2 - 3);\n"},
+		{"let x = -(-a);let y = !(a == b);", "let x = -(-a);\nlet y = !(a == b);\n"},
+		{"let f = fn(a, b) { a + b }", "let f = fn(a, b) { a + b };\n"},
+		{
+			"let f = fn(a) { let b = a; return b; }",
+			"let f = fn(a) {\n    let b = a;\n    return b;\n};\n",
+		},
+		{"let f = fn() {}", "let f = fn() {};\n"},
+		{"let n: int = 1; let f = fn(x: int, y) -> int? { x }", "let n: int = 1;\nlet f = fn(x: int, y) -> int? { x };\n"},
+		{"if (a) { 1 } else { 2 }", "if (a) { 1 } else { 2 }\n"},
+		{"if (a) { 1 }; (b)", "if (a) { 1 }\nb;\n"},
+		{"if (a) { 1 }; -1; if (b) { 2 }; [1]", "if (a) { 1 };\n-1;\nif (b) { 2 };\n[1];\n"},
+		{"if (a) { 1 }; (fn() { 2 })()", "if (a) { 1 };\n(fn() { 2 })();\n"},
+		{"if (a) { 1 } b", "if (a) { 1 }\nb;\n"},
+		{"struct P {x,y} P(1, 2).x", "struct P { x, y }\nP(1, 2).x;\n"},
+		{"p.x = 3; p.move(1, 2); h[\"k\"]", "p.x = 3;\np.move(1, 2);\nh[\"k\"];\n"},
+		{"let s = #{1,2}; let h = {1: 2}; let a = [];", "let s = #{1, 2};\nlet h = {1: 2};\nlet a = [];\n"},
+		{"let g = fn() { yield 1; yield from xs }", "let g = fn() {\n    yield 1;\n    yield from xs\n};\n"},
+		{"let m = macro(a) { quote(unquote(a)) }", "let m = macro(a) { quote(unquote(a)) };\n"},
+		{"fn(x) { x }(1)", "(fn(x) { x })(1);\n"},
+		{"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;", "let a = 1;\n\nlet b = 2;\nlet c = 3;\n"},
+	}
+
+	runFormatTests(t, tests, false)
+}
+
+func TestEllisp(t *testing.T) {
+	tests := []formatTest{
+		{"(let   x (+ 1 (* 2 3)))", "(let x (+ 1 (* 2 3)))\n"},
+		{"(let f (fn (a,b): (+ a b)))", "(let f (fn (a, b): (+ a b)))\n"},
+		{
+			"(let f (fn (a): (let b a) (return b)))",
+			"(let f (fn (a):\n    (let b a)\n    (return b)))\n",
+		},
+		{"(if (< a b): 1, 2)", "(if (< a b): 1, 2)\n"},
+		{"(puts 1, (- x), (!y))", "(puts 1, (- x), (!y))\n"},
+		{"(defstruct P x y) (. p x) (. p x 3) (. p (move 1, 2))", "(defstruct P x y)\n(. p x)\n(. p x 3)\n(. p (move 1, 2))\n"},
+		{"(let a |xs 0|) ((fn (x): x) 1)", "(let a |xs 0|)\n((fn (x): x) 1)\n"},
+		{"(let s #{1, 2}) (let h {1: 2})", "(let s #{1, 2})\n(let h {1: 2})\n"},
+		{"(let g (fn (): (yield 1) (yield from xs)))", "(let g (fn ():\n    (yield 1)\n    (yield from xs)))\n"},
+	}
+
+	runFormatTests(t, tests, true)
+}
+
+// pipelines are printed the way they were written, rather than as the
+// calls they stand for
+func TestPipelines(t *testing.T) {
+	nala := []formatTest{
+		{"[1, 2] |> len", "[1, 2] |> len;\n"},
+		{"[1, 2]|>len()", "[1, 2] |> len;\n"},
+		{"xs |> map(double) |> filter(isInt)", "xs |> map(double) |> filter(isInt);\n"},
+		{"let n = xs |> s.count(1) |> f(1)()", "let n = xs |> s.count(1) |> f(1)();\n"},
+		{"xs |> (fn(x) { x })", "xs |> (fn(x) { x });\n"},
+		{"(a |> f) + 1; a |> f + 1; a |> (f |> g)", "(a |> f) + 1;\na |> f + 1;\na |> g(f);\n"},
+		{"xs |> (ys |> zip)", "xs |> zip(ys);\n"},
+	}
+	runFormatTests(t, nala, false)
+
+	ellisp := []formatTest{
+		{"(-> [1, 2] len)", "(-> [1, 2] len)\n"},
+		{"(->  xs (map double), (filter isInt) sum)", "(-> xs (map double) (filter isInt) sum)\n"},
+		{"(-> (-> xs f) g)", "(-> xs f g)\n"},
+		{"(-> xs (. s (count 1)) ((f 1)))", "(-> xs (. s (count 1)) ((f 1)))\n"},
+		{"(+ (-> a f) 1)", "(+ (-> a f) 1)\n"},
+	}
+	runFormatTests(t, ellisp, true)
+
+	for _, src := range []string{"xs |> map(double) |> sum;\n", "let n = xs |> s.count(1);\n"} {
+		checkRoundTrip(t, src, src, false)
+	}
+	for _, src := range []string{"(-> xs (map double) sum)\n", "(let n (-> xs (. s (count 1))))\n"} {
+		checkRoundTrip(t, src, src, true)
+	}
+}
+
+func TestComments(t *testing.T) {
+	nala := []formatTest{
+		{
+			"// header\n\nlet x = 1;   // one\n// about f\nlet f = fn(a) {\n  // inside\n  let b = a; // two\n\n\n  b\n  // before close\n};\n// end\n",
+			"// header\n\nlet x = 1; // one\n// about f\nlet f = fn(a) {\n    // inside\n    let b = a; // two\n\n    b\n    // before close\n};\n// end\n",
+		},
+		{"let f = fn() {\n// only\n};", "let f = fn() {\n    // only\n};\n"},
+		{"let h = {1: 2, // first\n3: 4};", "let h = {\n    1: 2, // first\n    3: 4\n};\n"},
+		{"let y = f(1, // why\n2);", "let y = f(\n    1, // why\n    2\n);\n"},
+	}
+	runFormatTests(t, nala, false)
+
+	ellisp := []formatTest{
+		{
+			"// top\n(let x 1) // one\n(let f (fn (a):\n  // in\n  (puts a) // two\n  a))\n",
+			"// top\n(let x 1) // one\n(let f (fn (a):\n    // in\n    (puts a) // two\n    a))\n",
+		},
+		{"(if x: 1, // c\n 2)", "(if x:\n    1, // c\n    2)\n"},
+		{"(let f (fn (a):\n  a\n  // last\n))", "(let f (fn (a):\n    a\n    // last\n))\n"},
+	}
+	runFormatTests(t, ellisp, true)
+}
+
+func TestLineBreaking(t *testing.T) {
+	nala := []formatTest{
+		{
+			`let me = {"name": "iwarilama", "age": 22, "likes": ["stuff", "wine", "code"], "human": false};`,
+			"let me = {\n    \"name\": \"iwarilama\",\n    \"age\": 22,\n    \"likes\": [\"stuff\", \"wine\", \"code\"],\n    \"human\": false\n};\n",
+		},
+		{
+			`puts(describe({"name": "Joshua", "age": "22", "likes": ["naps, ", "cooking, ", "reading"]}));`,
+			"puts(describe({\n    \"name\": \"Joshua\",\n    \"age\": \"22\",\n    \"likes\": [\"naps, \", \"cooking, \", \"reading\"]\n}));\n",
+		},
+		{
+			`putl(fullname("joshua")(" tamunoiwarilama")(" pepple"), fullname("joshua")(" pepple"));`,
+			"putl(\n    fullname(\"joshua\")(\" tamunoiwarilama\")(\" pepple\"),\n    fullname(\"joshua\")(\" pepple\")\n);\n",
+		},
+		{
+			`let product = fn(arr) { reduce(arr, 1, fn(total, element) { total * element + offset }) };`,
+			"let product = fn(arr) {\n    reduce(arr, 1, fn(total, element) { total * element + offset })\n};\n",
+		},
+		{
+			`map(numbers, fn(x) { let y = x * 2; puts(y) });`,
+			"map(numbers, fn(x) {\n    let y = x * 2;\n    puts(y)\n});\n",
+		},
+		{
+			`let f = fn(x) { if (x > 10000000) { "a very long string indeed" } else { "another long string" } };`,
+			"let f = fn(x) {\n    if (x > 10000000) {\n        \"a very long string indeed\"\n    } else {\n        \"another long string\"\n    }\n};\n",
+		},
+	}
+	runFormatTests(t, nala, false)
+
+	ellisp := []formatTest{
+		{
+			`(putl (((fullname "joshua") " tamunoiwarilama") " pepple"), (((fullname "joshua") " tamunoiwarilama") " pepple"))`,
+			"(putl (((fullname \"joshua\") \" tamunoiwarilama\") \" pepple\"),\n    (((fullname \"joshua\") \" tamunoiwarilama\") \" pepple\"))\n",
+		},
+		{
+			`(map numbers, (fn (x): (let y (* x 2)) (puts y)))`,
+			"(map numbers, (fn (x):\n    (let y (* x 2))\n    (puts y)))\n",
+		},
+		{
+			`(let f (fn (x): (if (> x 10000000): "a very long string indeed", "and yet another long string")))`,
+			"(let f (fn (x):\n    (if (> x 10000000):\n        \"a very long string indeed\",\n        \"and yet another long string\")))\n",
+		},
+	}
+	runFormatTests(t, ellisp, true)
+}
+
+// the programs in code/ the parser rejects, and why. fmt leaves them alone
+var unparsable = map[string]string{
+	"davidoRap.nl": "assigns to index expressions, which Nala has no syntax for",
+}
+
+// parses the program at path, skipping it when it is listed in unparsable.
+// reports whether it should be checked further
+func parsesOrIsListed(t *testing.T, path string, src string, ellisp bool) bool {
+	t.Helper()
+
+	_, err := Source(src, ellisp)
+	reason, listed := unparsable[filepath.Base(path)]
+	switch {
+	case err != nil && listed:
+		t.Logf("skipping %s: it %s", path, reason)
+		return false
+	case err != nil:
+		t.Errorf("%s: %s", path, err)
+		return false
+	case listed:
+		t.Errorf("%s parses now, so it should be taken out of unparsable", path)
+	}
+	return true
+}
+
+// every program in code/ formats to something that stays the same when
+// formatted again, and that parses to the same program
+func TestIdempotent(t *testing.T) {
+	paths, _ := filepath.Glob("../code/*.[ne]l")
+	if len(paths) == 0 {
+		t.Fatal("no programs found in code/")
+	}
+
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ellisp := filepath.Ext(path) == ".el"
+		if !parsesOrIsListed(t, path, string(src), ellisp) {
+			continue
+		}
+
+		out, _ := Source(string(src), ellisp)
+		again, err := Source(out, ellisp)
+		if err != nil {
+			t.Errorf("%s: formatted program does not parse: %s", path, err)
+			continue
+		}
+		if again != out {
+			t.Errorf("%s: formatting is not idempotent.\n%s", path, Diff(path, out, again))
+		}
+		if s, f := programString(string(src), ellisp), programString(out, ellisp); s != f {
+			t.Errorf("%s: formatting changed the program.\n%s", path, Diff(path, s, f))
+		}
+	}
+}
+
+func programString(src string, ellisp bool) string {
+	l := lexer.New(src)
+	if ellisp {
+		return lispparser.New(l).ParseProgram().String()
+	}
+	return parser.New(l).ParseProgram().String()
+}
+
+// converts src to the other language and back, checking that every step
+// parses to the same program
+func checkRoundTrip(t *testing.T, name string, src string, ellisp bool) {
+	t.Helper()
+
+	want := programString(src, ellisp)
+	converted, err := Convert(src, ellisp, !ellisp)
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+		return
+	}
+	if got := programString(converted, !ellisp); got != want {
+		t.Errorf("%s: converting changed the program.\n%s", name, Diff(name, want, got))
+		return
+	}
+
+	back, err := Convert(converted, !ellisp, ellisp)
+	if err != nil {
+		t.Errorf("%s: converted program does not parse: %s\n%s", name, err, converted)
+		return
+	}
+	if got := programString(back, ellisp); got != want {
+		t.Errorf("%s: converting back changed the program.\n%s", name, Diff(name, want, got))
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	paths, _ := filepath.Glob("../code/*.[ne]l")
+	for _, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ellisp := filepath.Ext(path) == ".el"
+		if !parsesOrIsListed(t, path, string(src), ellisp) {
+			continue
+		}
+		checkRoundTrip(t, path, string(src), ellisp)
+	}
+
+	nala := []string{
+		"let x = -(-a) * (b - c) / !d % 2;",
+		"struct P { x, y }\nlet p = P(1, 2);\np.x = p.y + 1;\np.move(1, 2).x;",
+		"let s = #{1, 2};\nlet h = {\"a\": [1, [2]], 3: #{}};\nh[\"a\"][1][0];",
+		"let g = fn() { yield 1; yield from [2, 3]; };\nnext(g());",
+		"let f = fn(n) { if (n < 2) { return n; } else { let m = n - 1; f(m) + f(m - 1) } };",
+		"fn(x) { x }(1); [1, 2] |> map(fn(x) { x * 2 });",
+		"if (a == b) { puts(1); 2 } else { if (a != b) { 3 } }",
+	}
+	for _, src := range nala {
+		checkRoundTrip(t, src, src, false)
+	}
+
+	ellisp := []string{
+		"(let x (* (- (- a)) (- b c)))",
+		"(defstruct P x y) (. p x 3) (. (. p (move 1, 2)) x)",
+		"(let h {\"a\": |xs 0|}) (let s #{1, 2})",
+		"(-> [1, 2] (map (fn (x): (* x 2))) (filter isInt))",
+		"(let f (fn (n): (if (< n 2): (return n), (let m (- n 1)) (+ (f m) (f (- m 1))))))",
+	}
+	for _, src := range ellisp {
+		checkRoundTrip(t, src, src, true)
+	}
+
+	// Ellisp has no syntax for types, so annotated programs fail to convert
+	// instead of losing them
+	annotated := []string{
+		"let n: int = 5;",
+		"let f = fn(xs: [int], s: string?) { xs };",
+		"let f = fn(x) -> {string: int} { x };",
+	}
+	for _, src := range annotated {
+		if out, err := Convert(src, false, true); err == nil {
+			t.Errorf("%q converted without its types:\n%s", src, out)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		input    string
+		ellisp   bool
+		expected string
+	}{
+		{"let f = fn(x) { x * 2 }; // double\nf(2)", false, "(let f (fn (x): (* x 2))) // double\n(f 2)\n"},
+		{"(let xs [1, 2])\n\n(puts |xs 0|, (. p x))", true, "let xs = [1, 2];\n\nputs(xs[0], p.x);\n"},
+	}
+
+	for _, tt := range tests {
+		out, err := Convert(tt.input, tt.ellisp, !tt.ellisp)
+		if err != nil {
+			t.Errorf("error converting %q: %s", tt.input, err)
+			continue
+		}
+		if out != tt.expected {
+			t.Errorf("wrong output for %q.\n%s", tt.input, Diff("expected", tt.expected, out))
+		}
+	}
+}
+
+func TestNoEllispForm(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let m = macro(a) { a };", "1:9: macros have no Ellisp form"},
+		{"let n: int = 5;", "1:8: type annotations have no Ellisp form"},
+		{"let f = fn(x, y: [int]) { x };", "1:18: type annotations have no Ellisp form"},
+		{"let f = fn(x) -> int { x };", "1:18: type annotations have no Ellisp form"},
+	}
+
+	for _, tt := range tests {
+		prog := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		_, err := Ellisp(prog, nil)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	b := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+
+	expected := `--- f
++++ f
+@@ -1,6 +1,6 @@
+ 1
+ 2
+-3
++three
+ 4
+ 5
+ 6
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`
+	if got := Diff("f", a, b); got != expected {
+		t.Errorf("wrong diff. expected=\n%s\ngot=\n%s", expected, got)
+	}
+	if got := Diff("f", a, a); got != "" {
+		t.Errorf("expected no diff for the same text. got=%q", got)
+	}
+}
@@ -0,0 +1,329 @@
+package format
+
+import (
+	"fmt"
+	"nala/ast"
+	"nala/lexer"
+	"nala/token"
+	"strings"
+)
+
+// operator precedences, as the Nala parser has them
+const (
+	_ int = iota
+	LOWEST
+	PIPELINE
+	EQUALS
+	LESSGREATER
+	SUM
+	PRODUCT
+	PREFIX
+	CALL
+	INDEX
+	ATOM // literals and names, which never need parentheses
+)
+
+var infixPrecedences = map[string]int{
+	"==": EQUALS,
+	"!=": EQUALS,
+	"<":  LESSGREATER,
+	">":  LESSGREATER,
+	"+":  SUM,
+	"-":  SUM,
+	"*":  PRODUCT,
+	"/":  PRODUCT,
+	"%":  PRODUCT,
+}
+
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		if prec, ok := infixPrecedences[exp.Operator]; ok {
+			return prec
+		}
+		return LOWEST
+	case *ast.PrefixExpression:
+		return PREFIX
+	case *ast.CallExpression:
+		if exp.Piped {
+			return PIPELINE
+		}
+		return CALL
+	case *ast.MethodCallExpression:
+		if exp.Piped {
+			return PIPELINE
+		}
+		return CALL
+	case *ast.IndexExpression, *ast.FieldAccessExpression:
+		return INDEX
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean,
+		*ast.ArrayLiteral, *ast.HashLiteral, *ast.SetLiteral:
+		return ATOM
+	}
+	// ifs, functions, yields and field updates take everything after them
+	return LOWEST
+}
+
+type nalaPrinter struct {
+	printer
+}
+
+// Nala prints prog as Nala source, with the comments and blank lines
+// the lexer kept aside while it was read. prog can come from either front end
+func Nala(prog *ast.Program, trivia *lexer.Trivia) (string, error) {
+	p := &nalaPrinter{newPrinter(trivia)}
+
+	out := []line{}
+	p.block(&out, prog.Statements, 0, endOfSource, func(i int) []line {
+		return p.statement(prog.Statements, i, 0, true)
+	})
+	return render(out), nil
+}
+
+// a statement starting with one of these carries on from an if before it
+// unless a semicolon comes between them
+const continuesIf = "([-"
+
+// statement lays out stmts[i]. statements end in a semicolon, apart from
+// the value at the end of a block and ifs, which only need one when the
+// next statement would carry on from them. top is set for the statements
+// of a program, whose last one is not the value of a block
+func (p *nalaPrinter) statement(stmts []ast.Statement, i int, indent int, top bool) []line {
+	ind := indentOf(indent)
+
+	switch stmt := stmts[i].(type) {
+	case *ast.LetStatement:
+		head := ind + "let " + stmt.Name.Value
+		if stmt.Type != nil {
+			head += ": " + stmt.Type.String()
+		}
+		head += " = "
+		return suffix(cat(text(head), p.expr(stmt.Value, indent, len(head))), ";", indent)
+	case *ast.ReturnStatement:
+		head := ind + "return "
+		return suffix(cat(text(head), p.expr(stmt.ReturnValue, indent, len(head))), ";", indent)
+	case *ast.StructStatement:
+		fields := []string{}
+		for _, f := range stmt.Fields {
+			fields = append(fields, f.Value)
+		}
+		return text(ind + "struct " + stmt.Name.Value + " { " + strings.Join(fields, ", ") + " }")
+	case *ast.ExpressionStatement:
+		out := cat(text(ind), p.expr(stmt.Expression, indent, len(ind)))
+		last := i == len(stmts)-1
+		if _, isIf := stmt.Expression.(*ast.IfExpression); isIf {
+			if !last && strings.ContainsAny(p.start(stmts, i+1), continuesIf) {
+				out = suffix(out, ";", indent)
+			}
+			return out
+		}
+		if top || !last {
+			out = suffix(out, ";", indent)
+		}
+		return out
+	case *ast.BlockStatement:
+		out := []line{}
+		for j := range stmt.Statements {
+			out = append(out, p.statement(stmt.Statements, j, indent, top)...)
+		}
+		return out
+	}
+	return nil
+}
+
+// the first character of stmts[i] as it is printed
+func (p *nalaPrinter) start(stmts []ast.Statement, i int) string {
+	out := p.flatten(func() []line { return p.statement(stmts, i, 0, false) })
+	if len(out) == 0 || out[0].text == "" {
+		return ""
+	}
+	return out[0].text[:1]
+}
+
+// expr lays out an expression that starts at column col of a line
+// indented by indent
+func (p *nalaPrinter) expr(exp ast.Expression, indent int, col int) []line {
+	return p.layout(exp, col, func() []line {
+		switch exp := exp.(type) {
+		case *ast.Identifier:
+			return text(exp.Value)
+		case *ast.IntegerLiteral:
+			return text(fmt.Sprint(exp.Value))
+		case *ast.StringLiteral:
+			return text(`"` + exp.Value + `"`)
+		case *ast.Boolean:
+			return text(fmt.Sprint(exp.Value))
+		case *ast.PrefixExpression:
+			// -(-a) keeps its parentheses
+			return cat(text(exp.Operator), p.operand(exp.Right, PREFIX+1, indent, col+1))
+		case *ast.InfixExpression:
+			prec := precedence(exp)
+			left := p.operand(exp.Left, prec, indent, col)
+			op := " " + exp.Operator + " "
+			return cat(left, text(op), p.operand(exp.Right, prec+1, indent, col+len(op)))
+		case *ast.IfExpression:
+			return p.ifExpression(exp, indent, col)
+		case *ast.FunctionLiteral:
+			return p.function("fn", exp.Parameters, exp.ReturnType, exp.Body, indent)
+		case *ast.MacroLiteral:
+			return p.function("macro", exp.Parameters, nil, exp.Body, indent)
+		case *ast.CallExpression:
+			if exp.Piped {
+				return p.pipeline(exp.Arguments[0], indent, col, func(col int) []line {
+					if len(exp.Arguments) == 1 && !isCall(exp.Function) {
+						return p.operand(exp.Function, PIPELINE+1, indent, col)
+					}
+					return p.call(p.operand(exp.Function, CALL, indent, col), exp.Arguments[1:], indent, col)
+				})
+			}
+			return p.call(p.operand(exp.Function, CALL, indent, col), exp.Arguments, indent, col)
+		case *ast.MethodCallExpression:
+			if exp.Piped {
+				return p.pipeline(exp.Arguments[0], indent, col, func(col int) []line {
+					object := cat(p.operand(exp.Object, CALL, indent, col), text("."+exp.Method.Value))
+					return p.call(object, exp.Arguments[1:], indent, col)
+				})
+			}
+			object := cat(p.operand(exp.Object, CALL, indent, col), text("."+exp.Method.Value))
+			return p.call(object, exp.Arguments, indent, col)
+		case *ast.IndexExpression:
+			left := p.operand(exp.Left, CALL, indent, col)
+			return cat(left, text("["), p.expr(exp.Index, indent, col), text("]"))
+		case *ast.FieldAccessExpression:
+			return cat(p.operand(exp.Object, CALL, indent, col), text("."+exp.Field.Value))
+		case *ast.FieldAssignExpression:
+			object := cat(p.operand(exp.Object, CALL, indent, col), text("."+exp.Field.Value+" = "))
+			return cat(object, p.expr(exp.Value, indent, col))
+		case *ast.ArrayLiteral:
+			return p.elements("[", "]", exp.Elements, indent)
+		case *ast.SetLiteral:
+			return p.elements("#{", "}", exp.Elements, indent)
+		case *ast.HashLiteral:
+			return p.list("{", "}", len(exp.Pairs), indent,
+				func(i int) token.Token { return ast.StartToken(exp.Pairs[i].Key) },
+				func(i int, col int) []line {
+					key := cat(p.expr(exp.Pairs[i].Key, indent+1, col), text(": "))
+					return cat(key, p.expr(exp.Pairs[i].Value, indent+1, col))
+				})
+		case *ast.YieldExpression:
+			head := "yield "
+			if exp.Delegate {
+				head = "yield from "
+			}
+			return cat(text(head), p.expr(exp.Value, indent, col+len(head)))
+		}
+		return text(exp.String())
+	})
+}
+
+// operand lays out exp in a place that binds at least as tightly as prec,
+// wrapping it in parentheses when it binds more loosely than that
+func (p *nalaPrinter) operand(exp ast.Expression, prec int, indent int, col int) []line {
+	if precedence(exp) >= prec {
+		return p.expr(exp, indent, col)
+	}
+	return cat(text("("), p.expr(exp, indent, col+1), text(")"))
+}
+
+// value |> step, where step lays out the call without the value
+func (p *nalaPrinter) pipeline(value ast.Expression, indent int, col int, step func(col int) []line) []line {
+	out := cat(p.operand(value, PIPELINE, indent, col), text(" |> "))
+	return cat(out, step(col+len(out[len(out)-1].text)))
+}
+
+// a pipeline step that is a call has to keep its parentheses, even
+// without arguments, or the value would be piped into it instead
+func isCall(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.CallExpression, *ast.MethodCallExpression:
+		return true
+	}
+	return false
+}
+
+func (p *nalaPrinter) elements(open, close string, exps []ast.Expression, indent int) []line {
+	return p.list(open, close, len(exps), indent,
+		func(i int) token.Token { return ast.StartToken(exps[i]) },
+		func(i int, col int) []line { return p.expr(exps[i], indent+1, col) })
+}
+
+// a call goes on one line when it fits. otherwise a function or literal
+// passed last can open on the line of the call and close it after its
+// body, and failing that the arguments go one to a line
+func (p *nalaPrinter) call(callee []line, args []ast.Expression, indent int, col int) []line {
+	hug := func() []line {
+		out := cat(callee, text("("))
+		for i, arg := range args {
+			if i > 0 {
+				out = cat(out, text(", "))
+			}
+			at := col + len(out[len(out)-1].text)
+			el := func() []line { return p.expr(arg, indent, at) }
+			if i < len(args)-1 {
+				out = cat(out, p.flatten(el))
+			} else {
+				out = cat(out, el())
+			}
+		}
+		return cat(out, text(")"))
+	}
+	if p.flat || len(args) == 0 {
+		return hug()
+	}
+
+	if p.hugs(args) {
+		saved := p.printer
+		if out := hug(); col+len(out[0].text) <= width {
+			return out
+		}
+		p.printer = saved
+	}
+	return cat(callee, p.elements("(", ")", args, indent))
+}
+
+func (p *nalaPrinter) ifExpression(ie *ast.IfExpression, indent int, col int) []line {
+	head := cat(text("if ("), p.expr(ie.Condition, indent, col+4), text(") "))
+	out := cat(head, p.body(ie.Consequence, indent))
+	if ie.Alternative != nil {
+		out = cat(out, text(" else "), p.body(ie.Alternative, indent))
+	}
+	return out
+}
+
+func (p *nalaPrinter) function(keyword string, params []*ast.Identifier, returns *ast.TypeAnnotation,
+	body *ast.BlockStatement, indent int) []line {
+	names := []string{}
+	for _, param := range params {
+		if param.Type != nil {
+			names = append(names, param.Value+": "+param.Type.String())
+		} else {
+			names = append(names, param.Value)
+		}
+	}
+
+	head := keyword + "(" + strings.Join(names, ", ") + ") "
+	if returns != nil {
+		head += "-> " + returns.String() + " "
+	}
+	return cat(text(head), p.body(body, indent))
+}
+
+// body lays out a block in braces. in flat mode a block holding a single
+// expression goes on one line, any other block is a line per statement
+func (p *nalaPrinter) body(bs *ast.BlockStatement, indent int) []line {
+	if len(bs.Statements) == 0 && !p.commentsIn(bs) {
+		return text("{}")
+	}
+
+	if p.flat && len(bs.Statements) == 1 {
+		if es, ok := bs.Statements[0].(*ast.ExpressionStatement); ok {
+			return cat(text("{ "), p.expr(es.Expression, indent, 0), text(" }"))
+		}
+	}
+
+	out := text("{")
+	p.block(&out, bs.Statements, indent+1, bs.End, func(i int) []line {
+		return p.statement(bs.Statements, i, indent+1, false)
+	})
+	return append(out, line{text: indentOf(indent) + "}"})
+}
@@ -0,0 +1,234 @@
+package format
+
+import (
+	"bytes"
+	"math"
+	"nala/ast"
+	"nala/lexer"
+	"nala/token"
+	"strings"
+)
+
+// lines longer than this are broken up where the layout allows it
+const width = 80
+
+const indentUnit = "    "
+
+func indentOf(n int) string { return strings.Repeat(indentUnit, n) }
+
+// line is a line of output. a trailing comment is kept apart from the
+// text, so closing brackets and separators can still be added after it
+type line struct {
+	text    string
+	comment string
+}
+
+// a line holding nothing but a comment
+func (l line) commentOnly() bool { return l.comment != "" && strings.TrimSpace(l.text) == "" }
+
+func render(out []line) string {
+	var buf bytes.Buffer
+	for _, l := range out {
+		text := l.text
+		switch {
+		case l.commentOnly():
+			text += l.comment
+		case l.comment != "":
+			text += " " + l.comment
+		}
+		buf.WriteString(strings.TrimRight(text, " ") + "\n")
+	}
+	return buf.String()
+}
+
+// cat joins layouts end to end, each one carrying on from the last line
+// of the one before it
+func cat(parts ...[]line) []line {
+	out := []line{}
+	for _, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		if len(out) == 0 || out[len(out)-1].commentOnly() {
+			out = append(out, part...)
+			continue
+		}
+		last := &out[len(out)-1]
+		last.text += part[0].text
+		if part[0].comment != "" {
+			last.comment = part[0].comment
+		}
+		out = append(out, part[1:]...)
+	}
+	return out
+}
+
+func text(s string) []line { return []line{{text: s}} }
+
+// adds s to the end of the layout, on a line of its own at indent when
+// the layout ends in a comment line
+func suffix(out []line, s string, indent int) []line {
+	if len(out) > 0 && out[len(out)-1].commentOnly() {
+		return append(out, line{text: indentOf(indent) + s})
+	}
+	return cat(out, text(s))
+}
+
+// reports whether a comes before b in the source
+func before(a, b token.Token) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// stands in for the end of a program
+var endOfSource = token.Token{Type: token.EOF, Line: math.MaxInt32}
+
+// printer holds what both languages' printers share: the comments still
+// to be printed and the layout mode they are in. in flat mode everything
+// goes on one line and comments wait for the layout that is really used
+type printer struct {
+	comments []lexer.Comment
+	next     int
+	blank    map[int]bool
+	flat     bool
+}
+
+func newPrinter(trivia *lexer.Trivia) printer {
+	if trivia == nil {
+		return printer{}
+	}
+	return printer{comments: trivia.Comments, blank: trivia.Blank}
+}
+
+// flush prints the comments that come before tok. a trailing comment joins
+// the last line, any other goes on a line of its own at indent. blank lines
+// in front of comments are kept once the layout has more than keepBlank lines
+func (p *printer) flush(out *[]line, tok token.Token, indent int, keepBlank int) {
+	if p.flat {
+		return
+	}
+
+	for p.next < len(p.comments) && before(p.comments[p.next].Token, tok) {
+		c := p.comments[p.next]
+		p.next++
+
+		if n := len(*out); c.Trailing && n > 0 && (*out)[n-1].comment == "" {
+			(*out)[n-1].comment = c.Token.Literal
+		} else {
+			if len(*out) > keepBlank && p.blank[c.Token.Line] {
+				*out = append(*out, line{})
+			}
+			*out = append(*out, line{text: indentOf(indent), comment: c.Token.Literal})
+		}
+	}
+}
+
+// block prints a list of statements into out, along with the comments
+// among them. one blank line is kept wherever the source had any. end is
+// the token that closes the block
+func (p *printer) block(out *[]line, stmts []ast.Statement, indent int, end token.Token,
+	statement func(i int) []line) {
+	start := len(*out)
+	noBlank := math.MaxInt32
+	if p.flat {
+		start = noBlank
+	}
+
+	for i, stmt := range stmts {
+		tok := ast.StartToken(stmt)
+		p.flush(out, tok, indent, start)
+		if len(*out) > start && p.blank[tok.Line] {
+			*out = append(*out, line{})
+		}
+		*out = append(*out, statement(i)...)
+	}
+	p.flush(out, end, indent, start)
+}
+
+// commentsIn reports whether a comment still to be printed sits inside node
+func (p *printer) commentsIn(node ast.Node) bool {
+	start, end := ast.StartToken(node), ast.LastToken(node)
+	for i := p.next; i < len(p.comments) && before(p.comments[i].Token, end); i++ {
+		if before(start, p.comments[i].Token) {
+			return true
+		}
+	}
+	return false
+}
+
+// layout tries fn in flat mode first and keeps the result when it fits on
+// one line from column col. otherwise fn lays itself out over many lines
+func (p *printer) layout(node ast.Node, col int, fn func() []line) []line {
+	if p.flat {
+		return fn()
+	}
+
+	if !p.commentsIn(node) {
+		p.flat = true
+		out := fn()
+		p.flat = false
+		if len(out) == 1 && col+len(out[0].text) <= width {
+			return out
+		}
+	}
+	return fn()
+}
+
+// list lays out elements between open and close, on one line when they
+// fit and one to a line otherwise, keeping the comments among them
+func (p *printer) list(open, close string, n int, indent int, start func(i int) token.Token,
+	element func(i int, col int) []line) []line {
+	if p.flat || n == 0 {
+		out := text(open)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				out = cat(out, text(", "))
+			}
+			out = cat(out, element(i, 0))
+		}
+		return cat(out, text(close))
+	}
+
+	out := text(open)
+	for i := 0; i < n; i++ {
+		p.flush(&out, start(i), indent+1, math.MaxInt32)
+		el := cat(text(indentOf(indent+1)), element(i, len(indentOf(indent+1))))
+		if i < n-1 {
+			el = suffix(el, ",", indent+1)
+		}
+		out = append(out, el...)
+	}
+	return append(out, line{text: indentOf(indent) + close})
+}
+
+// flatten lays out fn in flat mode
+func (p *printer) flatten(fn func() []line) []line {
+	flat := p.flat
+	p.flat = true
+	out := fn()
+	p.flat = flat
+	return out
+}
+
+// hugs reports whether a call can keep its arguments on its own line and
+// let the last one spread over the lines below, which suits a function or
+// a literal in brackets, or a call ending in one
+func (p *printer) hugs(args []ast.Expression) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch last := args[len(args)-1].(type) {
+	case *ast.FunctionLiteral, *ast.MacroLiteral, *ast.ArrayLiteral, *ast.HashLiteral, *ast.SetLiteral:
+	case *ast.CallExpression:
+		if !p.hugs(last.Arguments) {
+			return false
+		}
+	default:
+		return false
+	}
+	for _, arg := range args[:len(args)-1] {
+		if p.commentsIn(arg) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"nala/token"
+	"strings"
 )
 
 type Lexer struct {
@@ -9,11 +10,34 @@ type Lexer struct {
 	position     int
 	readPosition int
 	ch           byte
+	line         int // position of ch, for error messages
+	column       int
+
+	trivia    Trivia
+	tokenLine int // line of the last token handed out
+}
+
+// Trivia is what the lexer reads but keeps aside instead of handing to
+// the parsers, for tools that print source back out
+type Trivia struct {
+	Comments []Comment    // in source order
+	Blank    map[int]bool // lines whose token or comment has an empty line before it
+}
+
+// Comment is a // comment
+type Comment struct {
+	Token    token.Token
+	Trailing bool // code comes before it on its line
+}
+
+// Trivia returns the trivia read so far
+func (l *Lexer) Trivia() *Trivia {
+	return &l.trivia
 }
 
 // returns a new Lexer struct
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1, trivia: Trivia{Blank: make(map[int]bool)}}
 	l.readChar()
 	return l
 }
@@ -22,6 +46,12 @@ func New(input string) *Lexer {
 // to fully support Unicode (and Emojis), need to replace byte with rune and implement some other functions
 // maybe check parser inside Go itself
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+	l.column++
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -44,6 +74,12 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	for l.ch == '/' && l.peekChar() == '/' {
+		l.readComment()
+		l.skipWhitespace()
+	}
+	line, column := l.line, l.column
+	l.tokenLine = line
 
 	switch l.ch {
 	case '=':
@@ -55,7 +91,13 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '|':
-		tok = newToken(token.PIPE, l.ch)
+		if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.PIPELINE, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
 	case '\'':
 		tok = newToken(token.APOSTROPHE, l.ch)
 	case ';':
@@ -72,10 +114,18 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.COMMA, l.ch)
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '.':
+		tok = newToken(token.DOT, l.ch)
 	case '+':
 		tok = newToken(token.PLUS, l.ch)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '>' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -89,14 +139,27 @@ func (l *Lexer) NextToken() token.Token {
 	case '*':
 		tok = newToken(token.ASTERISK, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		str, ok := l.readString()
+		if ok {
+			tok = token.Token{Type: token.STRING, Literal: str}
+		} else {
+			// the parsers report an ILLEGAL string as unterminated
+			tok = token.Token{Type: token.ILLEGAL, Literal: `"` + str}
+		}
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
 		tok = newToken(token.GT, l.ch)
 	case '%':
 		tok = newToken(token.MODULO, l.ch)
+	case '#':
+		if l.peekChar() == '{' {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.LSET, Literal: string(ch) + string(l.ch)}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -108,16 +171,19 @@ func (l *Lexer) NextToken() token.Token {
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Line, tok.Column = line, column
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Line, tok.Column = line, column
 			return tok
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
 	l.readChar()
+	tok.Line, tok.Column = line, column
 	return tok
 }
 
@@ -140,28 +206,46 @@ func (l *Lexer) readNumber() string {
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readString() string {
+// readString reads up to the closing quote, reporting whether there was
+// one before the end of the input
+func (l *Lexer) readString() (string, bool) {
 	position := l.position + 1
 	for {
 		l.readChar()
-		if isSpecialChar(l.ch) || l.ch == '.' {
-			continue
+		if l.position >= len(l.input) {
+			return l.input[position:], false
 		}
-		if l.ch == '"' || l.position > len(l.input) {
-			break
+		if l.ch == '"' {
+			return l.input[position:l.position], true
 		}
 	}
-	str := l.input[position:l.position]
-	return str
+}
+
+// reads a comment up to the end of its line
+func (l *Lexer) readComment() {
+	tok := token.Token{Type: token.COMMENT, Line: l.line, Column: l.column}
+	trailing := l.tokenLine == l.line
+
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	tok.Literal = strings.TrimRight(l.input[position:l.position], " \t\r")
+
+	l.trivia.Comments = append(l.trivia.Comments, Comment{Token: tok, Trailing: trailing})
 }
 
 func (l *Lexer) skipWhitespace() {
 	// this form can be sugared as:
 	// for l.ch in (' ', '\t', '\n', '\r')
 	// the compiler can rewrite it to the form below
+	line := l.line
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
 	}
+	if l.line-line > 1 {
+		l.trivia.Blank[l.line] = true
+	}
 }
 
 func newToken(tokenType token.TokenType, ch byte) token.Token {
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"nala/token"
+	"strings"
 	"testing"
 )
 
@@ -36,6 +37,11 @@ func TestNextToken(t *testing.T) {
 	[1, 2, 10 > 5];
 	{ "foo" : "bar" }
 	macro(a, b) { a + b };
+	#{1, 2}
+	struct P { x } p.x
+	defstruct
+	xs |> f -> |
+	yield from
 	`
 
 	// generates an array of expected tokens from that initializer list
@@ -157,6 +163,29 @@ func TestNextToken(t *testing.T) {
 		{token.RBRACE, "}"},
 		{token.SEMICOLON, ";"},
 
+		{token.LSET, "#{"},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACE, "}"},
+
+		{token.STRUCT, "struct"},
+		{token.IDENT, "P"},
+		{token.LBRACE, "{"},
+		{token.IDENT, "x"},
+		{token.RBRACE, "}"},
+		{token.IDENT, "p"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.DEFSTRUCT, "defstruct"},
+		{token.IDENT, "xs"},
+		{token.PIPELINE, "|>"},
+		{token.IDENT, "f"},
+		{token.ARROW, "->"},
+		{token.PIPE, "|"},
+		{token.YIELD, "yield"},
+		{token.IDENT, "from"},
+
 		{token.EOF, ""},
 	}
 
@@ -393,3 +422,104 @@ func TestEllispNextToken(t *testing.T) {
 	}
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  puts(\"a b\")\n"
+
+	tests := []struct {
+		literal string
+		line    int
+		column  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"puts", 2, 3},
+		{"(", 2, 7},
+		{"a b", 2, 8},
+		{")", 2, 13},
+	}
+
+	l := New(input)
+
+	for indx, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.literal {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", indx, tt.literal, tok.Literal)
+		}
+		if tok.Line != tt.line || tok.Column != tt.column {
+			t.Errorf("tests[%d] - position of %q wrong. expected=%d:%d, got=%d:%d",
+				indx, tt.literal, tt.line, tt.column, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	for _, input := range []string{`let x = "abc`, `"`, `puts("a b`} {
+		l := New(input)
+		tok := l.NextToken()
+		for tok.Type != token.ILLEGAL && tok.Type != token.EOF {
+			tok = l.NextToken()
+		}
+		if tok.Type != token.ILLEGAL || tok.Literal != input[strings.Index(input, `"`):] {
+			t.Errorf("expected ILLEGAL for the string in %q. got=%s %q", input, tok.Type, tok.Literal)
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("expected EOF after the string in %q. got=%s", input, tok.Type)
+		}
+	}
+}
+
+func TestTrivia(t *testing.T) {
+	input := "// header\n\nlet x = 5; // five\n  // about y\nlet y = x / 2;\n\n\nx"
+
+	l := New(input)
+	literals := []string{}
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		literals = append(literals, tok.Literal)
+	}
+
+	expected := []string{"let", "x", "=", "5", ";", "let", "y", "=", "x", "/", "2", ";", "x"}
+	if len(literals) != len(expected) {
+		t.Fatalf("comments were handed out as tokens. got=%q", literals)
+	}
+
+	comments := []struct {
+		literal  string
+		line     int
+		column   int
+		trailing bool
+	}{
+		{"// header", 1, 1, false},
+		{"// five", 3, 12, true},
+		{"// about y", 4, 3, false},
+	}
+
+	trivia := l.Trivia()
+	if len(trivia.Comments) != len(comments) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d", len(comments), len(trivia.Comments))
+	}
+	for indx, tt := range comments {
+		c := trivia.Comments[indx]
+		if c.Token.Literal != tt.literal {
+			t.Errorf("comments[%d] - literal wrong. expected=%q, got=%q", indx, tt.literal, c.Token.Literal)
+		}
+		if c.Token.Line != tt.line || c.Token.Column != tt.column {
+			t.Errorf("comments[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				indx, tt.line, tt.column, c.Token.Line, c.Token.Column)
+		}
+		if c.Trailing != tt.trailing {
+			t.Errorf("comments[%d] - trailing wrong. expected=%t, got=%t", indx, tt.trailing, c.Trailing)
+		}
+	}
+
+	for line := 1; line <= 8; line++ {
+		want := line == 3 || line == 8
+		if trivia.Blank[line] != want {
+			t.Errorf("blank line before line %d wrong. expected=%t, got=%t", line, want, trivia.Blank[line])
+		}
+	}
+}
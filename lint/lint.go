@@ -0,0 +1,320 @@
+package lint
+
+import (
+	"fmt"
+	"nala/ast"
+	"nala/object"
+	"nala/parser"
+	"nala/token"
+	"sort"
+)
+
+// the rules a Diagnostic can come from
+const (
+	UNUSED           = "unused"
+	SHADOWED_BUILTIN = "shadowed-builtin"
+	UNREACHABLE      = "unreachable"
+	ARITY            = "arity"
+	DISCARDED_IF     = "discarded-if"
+)
+
+// Diagnostic is a problem found by the linter at Line and Column
+type Diagnostic struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s (%s)", d.Line, d.Column, d.Message, d.Rule)
+}
+
+// a name bound by a let or a parameter
+type binding struct {
+	tok  token.Token
+	kind string
+	used bool
+}
+
+// functions get a scope each, blocks share the scope of their function
+// the way they do when the program runs
+type scope struct {
+	names    map[string]*binding
+	bindings []*binding
+	outer    *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{names: make(map[string]*binding), outer: outer}
+}
+
+func (s *scope) declare(tok token.Token, kind string) {
+	b := &binding{tok: tok, kind: kind}
+	s.names[tok.Literal] = b
+	s.bindings = append(s.bindings, b)
+}
+
+// the scope name was bound in, if any
+func (s *scope) resolve(name string) *scope {
+	for ; s != nil; s = s.outer {
+		if _, ok := s.names[name]; ok {
+			return s
+		}
+	}
+	return nil
+}
+
+type linter struct {
+	global *scope
+	scope  *scope
+	arity  map[string]int // of the global functions, struct constructors and builtins
+	diags  []Diagnostic
+}
+
+// Lint checks a program from either front end and returns what it found,
+// ordered by position. unused globals are not reported, as they are there
+// for the REPL and whatever runs after the program
+func Lint(prog *ast.Program) []Diagnostic {
+	l := &linter{global: newScope(nil), arity: make(map[string]int)}
+	l.scope = l.global
+	l.collectArities(prog)
+
+	l.statements(prog.Statements)
+
+	sort.SliceStable(l.diags, func(i, j int) bool {
+		a, b := l.diags[i], l.diags[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.diags
+}
+
+func (l *linter) report(tok token.Token, rule string, format string, a ...interface{}) {
+	l.diags = append(l.diags, Diagnostic{Line: tok.Line, Column: tok.Column, Rule: rule, Message: fmt.Sprintf(format, a...)})
+}
+
+// a global bound to functions of different arities is left unchecked
+func (l *linter) collectArities(prog *ast.Program) {
+	set := func(name string, n int) {
+		if prev, ok := l.arity[name]; ok && prev != n {
+			n = -1
+		}
+		l.arity[name] = n
+	}
+
+	for _, stmt := range prog.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+				set(stmt.Name.Value, len(fl.Parameters))
+			} else {
+				set(stmt.Name.Value, -1)
+			}
+		case *ast.StructStatement:
+			set(stmt.Name.Value, len(stmt.Fields))
+		}
+	}
+
+	// a global of the same name hides the builtin
+	for name, n := range builtinArities {
+		if _, ok := l.arity[name]; !ok {
+			l.arity[name] = n
+		}
+	}
+}
+
+// the arities of the builtins, read from their signatures. those taking
+// any number of arguments, or some that can be left out, are left unchecked
+var builtinArities = map[string]int{}
+
+func init() {
+	for _, def := range object.Builtins {
+		ta, errs := parser.ParseType(def.Signature)
+		if len(errs) > 0 || ta.Name != "func" || ta.Variadic {
+			continue
+		}
+		fixed := true
+		for _, p := range ta.Params {
+			fixed = fixed && !p.Optional
+		}
+		if fixed {
+			builtinArities[def.Name] = len(ta.Params)
+		}
+	}
+}
+
+func (l *linter) statements(stmts []ast.Statement) {
+	returned := false
+	for i, stmt := range stmts {
+		if returned {
+			l.report(ast.StartToken(stmt), UNREACHABLE, "unreachable code after return")
+			returned = false
+		}
+		if _, ok := stmt.(*ast.ReturnStatement); ok && i < len(stmts)-1 {
+			returned = true
+		}
+
+		// the last statement is the value of the block, the others are thrown away
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && i < len(stmts)-1 {
+			if ie, ok := es.Expression.(*ast.IfExpression); ok && producesValue(ie) {
+				l.report(ie.Token, DISCARDED_IF, "value of if expression is discarded")
+			}
+		}
+
+		l.statement(stmt)
+	}
+}
+
+func (l *linter) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		l.checkShadowing(stmt.Name.Token, "let")
+		if _, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+			// declared first, so the function can call itself
+			l.scope.declare(stmt.Name.Token, "let")
+			l.expr(stmt.Value)
+			return
+		}
+		l.expr(stmt.Value)
+		l.scope.declare(stmt.Name.Token, "let")
+	case *ast.ReturnStatement:
+		l.expr(stmt.ReturnValue)
+	case *ast.ExpressionStatement:
+		l.expr(stmt.Expression)
+	case *ast.BlockStatement:
+		l.statements(stmt.Statements)
+	case *ast.StructStatement:
+		l.checkShadowing(stmt.Name.Token, "struct")
+		l.scope.declare(stmt.Name.Token, "struct")
+	}
+}
+
+func (l *linter) checkShadowing(tok token.Token, kind string) {
+	if object.GetBuiltinByName(tok.Literal) != nil {
+		l.report(tok, SHADOWED_BUILTIN, "%s %s shadows the builtin %s", kind, tok.Literal, tok.Literal)
+	}
+}
+
+func (l *linter) expr(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if s := l.scope.resolve(exp.Value); s != nil {
+			s.names[exp.Value].used = true
+		}
+	case *ast.PrefixExpression:
+		l.expr(exp.Right)
+	case *ast.InfixExpression:
+		l.expr(exp.Left)
+		l.expr(exp.Right)
+	case *ast.IfExpression:
+		l.expr(exp.Condition)
+		l.statements(exp.Consequence.Statements)
+		if exp.Alternative != nil {
+			l.statements(exp.Alternative.Statements)
+		}
+	case *ast.FunctionLiteral:
+		l.function(exp.Parameters, exp.Body, true)
+	case *ast.MacroLiteral:
+		// macro parameters are spliced in as code, so they are not reported
+		l.function(exp.Parameters, exp.Body, false)
+	case *ast.CallExpression:
+		l.checkArity(exp)
+		l.expr(exp.Function)
+		l.exprs(exp.Arguments)
+	case *ast.ArrayLiteral:
+		l.exprs(exp.Elements)
+	case *ast.SetLiteral:
+		l.exprs(exp.Elements)
+	case *ast.HashLiteral:
+		for _, pair := range exp.Pairs {
+			l.expr(pair.Key)
+			l.expr(pair.Value)
+		}
+	case *ast.IndexExpression:
+		l.expr(exp.Left)
+		l.expr(exp.Index)
+	case *ast.FieldAccessExpression:
+		l.expr(exp.Object)
+	case *ast.FieldAssignExpression:
+		l.expr(exp.Object)
+		l.expr(exp.Value)
+	case *ast.MethodCallExpression:
+		l.expr(exp.Object)
+		l.exprs(exp.Arguments)
+	case *ast.YieldExpression:
+		l.expr(exp.Value)
+	}
+}
+
+func (l *linter) exprs(exps []ast.Expression) {
+	for _, exp := range exps {
+		l.expr(exp)
+	}
+}
+
+func (l *linter) function(params []*ast.Identifier, body *ast.BlockStatement, reportParams bool) {
+	outer := l.scope
+	l.scope = newScope(outer)
+
+	for _, p := range params {
+		l.checkShadowing(p.Token, "parameter")
+		l.scope.declare(p.Token, "parameter")
+		if !reportParams {
+			l.scope.names[p.Value].used = true
+		}
+	}
+	l.statements(body.Statements)
+
+	for _, b := range l.scope.bindings {
+		if !b.used {
+			l.report(b.tok, UNUSED, "%s %s is never used", b.kind, b.tok.Literal)
+		}
+	}
+	l.scope = outer
+}
+
+// calls to a global function have to pass as many arguments as it takes
+func (l *linter) checkArity(ce *ast.CallExpression) {
+	id, ok := ce.Function.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	// a global can be called before its let, but not once a local hides it
+	if s := l.scope.resolve(id.Value); s != nil && s != l.global {
+		return
+	}
+
+	want, ok := l.arity[id.Value]
+	if ok && want >= 0 && want != len(ce.Arguments) {
+		l.report(id.Token, ARITY, "wrong number of arguments to %s: want=%d, got=%d", id.Value, want, len(ce.Arguments))
+	}
+}
+
+// an if produces a value when one of its branches ends in an expression
+// that does nothing but compute one
+func producesValue(ie *ast.IfExpression) bool {
+	return endsInValue(ie.Consequence) || (ie.Alternative != nil && endsInValue(ie.Alternative))
+}
+
+func endsInValue(bs *ast.BlockStatement) bool {
+	if bs == nil || len(bs.Statements) == 0 {
+		return false
+	}
+	es, ok := bs.Statements[len(bs.Statements)-1].(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+
+	switch exp := es.Expression.(type) {
+	case *ast.IfExpression:
+		return producesValue(exp)
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean,
+		*ast.PrefixExpression, *ast.InfixExpression, *ast.ArrayLiteral, *ast.HashLiteral,
+		*ast.SetLiteral, *ast.FunctionLiteral, *ast.IndexExpression, *ast.FieldAccessExpression:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package lint
+
+import (
+	"nala/ast"
+	"nala/lexer"
+	lispparser "nala/lisp_parser"
+	"nala/parser"
+	"testing"
+)
+
+type lintTest struct {
+	input    string
+	expected []string
+}
+
+func parseNala(t *testing.T, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return prog
+}
+
+func parseEllisp(t *testing.T, input string) *ast.Program {
+	p := lispparser.New(lexer.New(input))
+	prog := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return prog
+}
+
+func runLintTests(t *testing.T, tests []lintTest, parse func(*testing.T, string) *ast.Program) {
+	t.Helper()
+
+	for _, tt := range tests {
+		diags := Lint(parse(t, tt.input))
+
+		if len(diags) != len(tt.expected) {
+			t.Errorf("wrong number of diagnostics for %q. want=%d, got=%v", tt.input, len(tt.expected), diags)
+			continue
+		}
+		for i, d := range diags {
+			if d.String() != tt.expected[i] {
+				t.Errorf("wrong diagnostic for %q. want=%q, got=%q", tt.input, tt.expected[i], d.String())
+			}
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	tests := []lintTest{
+		{"let f = fn(x, y) { x };", []string{"1:15: parameter y is never used (unused)"}},
+		{"let f = fn() { let a = 1; 2 };", []string{"1:20: let a is never used (unused)"}},
+		{"let f = fn() { let a = 1; let g = fn() { a }; g() };", []string{}},
+		{"let f = fn(n) { if (n < 2) { n } else { f(n - 1) } };", []string{}},
+		{"let unused = 1;", []string{}},
+		{"let len = fn(x) { x };", []string{"1:5: let len shadows the builtin len (shadowed-builtin)"}},
+		{"let f = fn(first) { first };", []string{"1:12: parameter first shadows the builtin first (shadowed-builtin)"}},
+		{"struct type { x }", []string{"1:8: struct type shadows the builtin type (shadowed-builtin)"}},
+		{
+			"let f = fn(x) {\n  return x;\n  puts(x);\n  x\n};",
+			[]string{"3:3: unreachable code after return (unreachable)"},
+		},
+		{"let f = fn(x) { if (x) { return 1; } 2 };", []string{}},
+		{
+			"let plus = fn(x, y) { x + y };\nplus(1);\nplus(1, 2, 3);\nplus(1, 2);",
+			[]string{
+				"2:1: wrong number of arguments to plus: want=2, got=1 (arity)",
+				"3:1: wrong number of arguments to plus: want=2, got=3 (arity)",
+			},
+		},
+		{"let g = fn() { later(1) };\nlet later = fn() { 1 };", []string{"1:16: wrong number of arguments to later: want=0, got=1 (arity)"}},
+		{"struct P { x, y }\nP(1);", []string{"2:1: wrong number of arguments to P: want=2, got=1 (arity)"}},
+		{"let plus = fn(x, y) { x + y };\nlet f = fn(plus) { plus(1) };", []string{}},
+		{"let plus = fn(x, y) { x + y };\nlet plus = fn(x) { x };\nplus(1);", []string{}},
+		{"let plus = fn(x, y) { x + y };\n1 |> plus(2);", []string{}},
+		{
+			"len(1, 2);\npush([]);\nputs(1, 2, 3);\nrange(1, 10);\nspawn(puts, 1, 2);",
+			[]string{
+				"1:1: wrong number of arguments to len: want=1, got=2 (arity)",
+				"2:1: wrong number of arguments to push: want=2, got=1 (arity)",
+			},
+		},
+		{"let len = fn(x, y) { x + y };\nlen(1, 2);", []string{"1:5: let len shadows the builtin len (shadowed-builtin)"}},
+		{
+			"let f = fn(x) { if (x) { 1 } else { 2 }; x };",
+			[]string{"1:17: value of if expression is discarded (discarded-if)"},
+		},
+		{"let f = fn(x) { if (x) { puts(1) }; x };", []string{}},
+		{"let f = fn(x) { if (x) { 1 } else { 2 } };", []string{}},
+		{"if (true) { if (false) { 1 } }; 2", []string{"1:1: value of if expression is discarded (discarded-if)"}},
+	}
+
+	runLintTests(t, tests, parseNala)
+}
+
+func TestLintEllisp(t *testing.T) {
+	tests := []lintTest{
+		{"(let f (fn (x, y): x))", []string{"1:16: parameter y is never used (unused)"}},
+		{"(let len (fn (x): x))", []string{"1:6: let len shadows the builtin len (shadowed-builtin)"}},
+		{"(let plus (fn (x, y): (+ x y)))\n(plus 1)", []string{"2:2: wrong number of arguments to plus: want=2, got=1 (arity)"}},
+		{"(len 1, 2)", []string{"1:2: wrong number of arguments to len: want=1, got=2 (arity)"}},
+		{"(let f (fn (x): (if x: 1, 2) x))", []string{"1:18: value of if expression is discarded (discarded-if)"}},
+	}
+
+	runLintTests(t, tests, parseEllisp)
+}
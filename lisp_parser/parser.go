@@ -6,6 +6,7 @@ import (
 	"nala/lexer"
 	"nala/token"
 	"strconv"
+	"strings"
 )
 
 type (
@@ -35,11 +36,17 @@ type (
 type Parser struct {
 	l      *lexer.Lexer
 	errors []string
+	errPos []token.Token // where each of errors was found
 
 	curToken  token.Token
 	peekToken token.Token
 
 	prefixParseFns map[token.TokenType]prefixParseFn
+
+	// tracks the function literal being parsed, so a yield in its
+	// body can mark it as a generator
+	fnDepth  int
+	sawYield bool
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -68,6 +75,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.PIPE, p.parseIndexExpression)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.LSET, p.parseSetLiteral)
+	p.registerPrefix(token.DOT, p.parseDotExpression)
 
 	// explicit coerced prefixes
 	p.registerPrefix(token.BANG, p.parseNegateBooleanExpression)
@@ -83,6 +92,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.GT, p.parseBinaryExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.YIELD, p.parseYieldExpression)
 
 	return p
 }
@@ -115,7 +125,10 @@ func (p *Parser) parseStatement() ast.Statement {
 }
 
 func (p *Parser) parseParenthesesExpression() ast.Expression {
-	if p.peekTokenIs(token.IDENT) {
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		return p.parseThreadingExpression()
+	} else if p.peekTokenIs(token.IDENT) {
 		p.nextToken()
 		return p.parseCallExpression(p.parseExpression())
 	} else if p.peekTokenIs(token.LPAREN) {
@@ -151,6 +164,9 @@ func (p *Parser) parseParenthesesStatement() ast.Statement {
 	} else if p.peekTokenIs(token.RETURN) {
 		p.nextToken()
 		return p.parseReturnStatement()
+	} else if p.peekTokenIs(token.DEFSTRUCT) {
+		p.nextToken()
+		return p.parseStructStatement()
 		// } else if p.peekTokenIs(token.FUNCTION) {
 		// 	tok := p.peekToken
 		// 	expr := p.parseFunctionLiteral()
@@ -191,6 +207,31 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+// (defstruct Person name age)
+func (p *Parser) parseStructStatement() *ast.StructStatement {
+	stmt := &ast.StructStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	stmt.Fields = []*ast.Identifier{}
+	for !p.peekTokenIs(token.RPAREN) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Fields = append(stmt.Fields, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+	p.nextToken()
+
+	return stmt
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
@@ -330,7 +371,6 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if !p.expectCur(token.RPAREN) {
 		return nil
 	}
-	p.nextToken()
 	return expr
 }
 
@@ -347,6 +387,7 @@ func (p *Parser) parseBlockStatement(endToken token.TokenType, fallbackEnd token
 		}
 		p.nextToken()
 	}
+	block.End = p.curToken
 
 	return block
 }
@@ -364,14 +405,45 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	outer := p.sawYield
+	p.sawYield = false
+	p.fnDepth++
+
 	fn.Body = p.parseBlockStatement(token.RPAREN, token.RPAREN)
 
+	p.fnDepth--
+	fn.IsGenerator = p.sawYield
+	p.sawYield = outer
+
 	if !p.expectCur(token.RPAREN) {
 		return nil
 	}
 	return fn
 }
 
+// (yield x) or (yield from xs)
+func (p *Parser) parseYieldExpression() ast.Expression {
+	expr := &ast.YieldExpression{Token: p.curToken}
+
+	if p.fnDepth == 0 {
+		p.errorAt(p.curToken, "yield outside of a function")
+	}
+	p.sawYield = true
+
+	p.nextToken()
+	// (yield from xs), while (yield from) yields a variable named from
+	if p.curTokenIs(token.IDENT) && p.curToken.Literal == "from" && !p.peekTokenIs(token.RPAREN) {
+		expr.Delegate = true
+		p.nextToken()
+	}
+	expr.Value = p.parseExpression()
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return expr
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	ids := []*ast.Identifier{}
 
@@ -413,9 +485,59 @@ func (p *Parser) parseIndexExpression() ast.Expression {
 	return exp
 }
 
+// (. p name) reads a field, (. p name value) updates it
+// and (. p (name args)) calls a method
+func (p *Parser) parseDotExpression() ast.Expression {
+	tok := p.curToken
+
+	p.nextToken()
+	obj := p.parseExpression()
+
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		call := &ast.MethodCallExpression{
+			Token:  tok,
+			Object: obj,
+			Method: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+		}
+		call.Arguments = p.parseExpressionList(token.RPAREN)
+
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return call
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	field := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return &ast.FieldAccessExpression{Token: tok, Object: obj, Field: field}
+	}
+
+	p.nextToken()
+	expr := &ast.FieldAssignExpression{
+		Token:  tok,
+		Object: obj,
+		Field:  field,
+		Value:  p.parseExpression(),
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return expr
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hsh := &ast.HashLiteral{Token: p.curToken}
-	hsh.Pairs = make(ast.ExpressionPairs)
+	hsh.Pairs = ast.ExpressionPairs{}
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -426,7 +548,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 		p.nextToken()
 		val := p.parseExpression()
-		hsh.Pairs[key] = val
+		hsh.Pairs = append(hsh.Pairs, ast.ExpressionPair{Key: key, Value: val})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -445,6 +567,53 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	return arr
 }
 
+// parses #{1 2 3}, where commas are optional
+func (p *Parser) parseSetLiteral() ast.Expression {
+	set := &ast.SetLiteral{Token: p.curToken}
+	set.Elements = []ast.Expression{}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if p.peekTokenIs(token.EOF) {
+			p.peekError(token.RBRACE)
+			return nil
+		}
+		p.nextToken()
+		set.Elements = append(set.Elements, p.parseExpression())
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+
+	p.nextToken()
+	return set
+}
+
+// (-> xs (filter isInt) sum) threads xs through each step as its first
+// argument, becoming (sum (filter xs, isInt))
+func (p *Parser) parseThreadingExpression() ast.Expression {
+	tok := p.curToken
+
+	p.nextToken()
+	expr := p.parseExpression()
+
+	for !p.peekTokenIs(token.RPAREN) {
+		if p.peekTokenIs(token.EOF) {
+			p.peekError(token.RPAREN)
+			return nil
+		}
+		p.nextToken()
+		expr = ast.PipeInto(tok, expr, p.parseExpression())
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+	p.nextToken()
+
+	return expr
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) *ast.CallExpression {
 	exp := &ast.CallExpression{
 		Token:    p.curToken,
@@ -511,32 +680,46 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// ErrorPositions returns the token each of Errors was found at
+func (p *Parser) ErrorPositions() []token.Token {
+	return p.errPos
+}
+
+func (p *Parser) errorAt(tok token.Token, err string) {
+	p.errors = append(p.errors, err)
+	p.errPos = append(p.errPos, tok)
+}
+
 func (p *Parser) peekError(t token.TokenType) {
 	err := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, err)
+	p.errorAt(p.peekToken, err)
 }
 
 func (p *Parser) curError(t token.TokenType) {
 	err := fmt.Sprintf("expected current token to be %s, got %s instead",
 		t, p.curToken.Type)
-	p.errors = append(p.errors, err)
+	p.errorAt(p.curToken, err)
 }
 
 func (p *Parser) integerParseError() {
 	err := fmt.Sprintf("could not parse %q as integer",
 		p.curToken.Literal)
-	p.errors = append(p.errors, err)
+	p.errorAt(p.curToken, err)
 }
 
 func (p *Parser) nonLiteralError() {
 	err := fmt.Sprintf("could not parse %q as a literal",
 		p.curToken.Literal)
-	p.errors = append(p.errors, err)
+	p.errorAt(p.curToken, err)
 }
 
 func (p *Parser) noPrefixParseFnError() {
+	if p.curToken.Type == token.ILLEGAL && strings.HasPrefix(p.curToken.Literal, `"`) {
+		p.errorAt(p.curToken, "unterminated string")
+		return
+	}
 	err := fmt.Sprintf("no prefix parse function found for %s",
 		p.curToken.Type)
-	p.errors = append(p.errors, err)
+	p.errorAt(p.curToken, err)
 }
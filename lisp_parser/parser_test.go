@@ -428,6 +428,30 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+// an if ends on its own closing paren, so whatever follows it is parsed
+func TestIfExpressionFollowedByForms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(if x: 1, 2)\n(puts 1)", "if x     1 else     2puts(1)"},
+		{"(if x: 1)\n(puts 1)", "if x     1puts(1)"},
+		{"(let f (fn (x): (if x: 1, 2)))", "let f = fn (x)     if x     1 else     2;"},
+		{"(let f (fn (x): (if x: 1, 2) x))", "let f = fn (x)     if x     1 else     2    x;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if prog.String() != tt.expected {
+			t.Errorf("wrong parse of %q. want=%q, got=%q", tt.input, tt.expected, prog.String())
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `(fn (x, y): (+ x y))`
 
@@ -522,6 +546,174 @@ func TestParsingArrayLiterals(t *testing.T) {
 	testInfixExpression(t, arr.Elements[2], 3, "+", 3)
 }
 
+func TestParsingSetLiterals(t *testing.T) {
+	tests := []string{
+		"#{1 (* 2 2) (+ 3 3)}",
+		"#{1, (* 2 2), (+ 3 3)}",
+	}
+
+	for _, input := range tests {
+		l := lexer.New(input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if len(prog.Statements) != 1 {
+			t.Fatalf("%s: prog.Statements does not contain 1 statement. got=%d", input, len(prog.Statements))
+		}
+		stmt, ok := prog.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("prog.Statements[0] is not *ast.ExpressionStatement. got=%T", prog.Statements[0])
+		}
+		set, ok := stmt.Expression.(*ast.SetLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.SetLiteral. got=%T", stmt.Expression)
+		}
+
+		if len(set.Elements) != 3 {
+			t.Fatalf("len(set.Elements) not 3. got=%d", len(set.Elements))
+		}
+
+		testIntegerLiteral(t, set.Elements[0], 1)
+		testInfixExpression(t, set.Elements[1], 2, "*", 2)
+		testInfixExpression(t, set.Elements[2], 3, "+", 3)
+	}
+}
+
+// set is an ordinary name, which a program can bind and call
+func TestSetIsNotReserved(t *testing.T) {
+	input := `(let set (fn (a, b): (+ a b))) (set 1, 2)`
+
+	l := lexer.New(input)
+	p := New(l)
+	prog := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(prog.Statements) != 2 {
+		t.Fatalf("prog.Statements does not contain 2 statements. got=%d", len(prog.Statements))
+	}
+	stmt, ok := prog.Statements[1].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("prog.Statements[1] is not *ast.ExpressionStatement. got=%T", prog.Statements[1])
+	}
+	call, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("exp is not ast.CallExpression. got=%T", stmt.Expression)
+	}
+	testIdentifier(t, call.Function, "set")
+	if len(call.Arguments) != 2 {
+		t.Fatalf("wrong number of arguments. got=%d", len(call.Arguments))
+	}
+}
+
+func TestStructStatements(t *testing.T) {
+	input := `(defstruct Person name age)`
+
+	l := lexer.New(input)
+	p := New(l)
+	prog := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(prog.Statements) != 1 {
+		t.Fatalf("prog.Statements does not contain 1 statement. got=%d", len(prog.Statements))
+	}
+	stmt, ok := prog.Statements[0].(*ast.StructStatement)
+	if !ok {
+		t.Fatalf("prog.Statements[0] is not *ast.StructStatement. got=%T", prog.Statements[0])
+	}
+	if stmt.String() != "struct Person { name, age }" {
+		t.Errorf("wrong struct statement. got=%q", stmt.String())
+	}
+}
+
+func TestFieldExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(. p name)", "(p.name)"},
+		{"(. (. p pos) x)", "((p.pos).x)"},
+		{"(. p age (+ (. p age) 1))", "(p.age = ((p.age) + 1))"},
+		{"(. arr (push (+ 1 2)))", "arr.push((1 + 2))"},
+		{"(. (. s (upper)) (lower))", "s.upper().lower()"},
+		{"(. p (greet \"hi\", 2))", "p.greet(hi, 2)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if prog.String() != tt.expected {
+			t.Errorf("wrong parse of %q. want=%q, got=%q", tt.input, tt.expected, prog.String())
+		}
+	}
+}
+
+func TestThreadingExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(-> xs sum)", "sum(xs)"},
+		{"(-> xs (filter isInt) (map double) sum)", "sum(map(filter(xs, isInt), double))"},
+		{"(-> (+ 1 2) (f (* 3 4)))", "f((1 + 2), (3 * 4))"},
+		{"(-> [1, 2] (reduce 0, add))", "reduce([1, 2], 0, add)"},
+		{"(let total (-> xs sum))", "let total = sum(xs);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if prog.String() != tt.expected {
+			t.Errorf("wrong parse of %q. want=%q, got=%q", tt.input, tt.expected, prog.String())
+		}
+	}
+}
+
+func TestYieldExpressions(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    string
+		isGenerator bool
+	}{
+		{"(fn (): (yield (+ 1 2)))", "yield (1 + 2)", true},
+		{"(fn (xs): (yield from xs))", "yield from xs", true},
+		{"(fn (from): (yield from))", "yield from", true},
+		{"(fn (): (fn (): (yield 1)))", "fn ()     yield 1", false},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt := prog.Statements[0].(*ast.ExpressionStatement)
+		fn, ok := stmt.Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression is not *ast.FunctionLiteral. got=%T", stmt.Expression)
+		}
+		if fn.IsGenerator != tt.isGenerator {
+			t.Errorf("wrong IsGenerator for %q. want=%t, got=%t", tt.input, tt.isGenerator, fn.IsGenerator)
+		}
+		body := fn.Body.Statements[0].String()
+		if body != tt.expected {
+			t.Errorf("wrong body for %q. want=%q, got=%q", tt.input, tt.expected, body)
+		}
+	}
+
+	p := New(lexer.New("(yield 1)"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != "yield outside of a function" {
+		t.Errorf("expected a yield outside of a function error. got=%v", p.Errors())
+	}
+}
+
 func TestParsingIndexExpressions(t *testing.T) {
 	input := "|arr (+ 1 1)|"
 
@@ -575,7 +767,8 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		"three": 3,
 	}
 
-	for k, v := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		k, v := pair.Key, pair.Value
 		lit, ok := k.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", k)
@@ -586,6 +779,13 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 			testIntegerLiteral(t, v, int64(expV))
 		}
 	}
+
+	// pairs must come out in source order
+	for i, key := range []string{"one", "two", "three"} {
+		if hash.Pairs[i].Key.String() != key {
+			t.Errorf("hash.Pairs[%d] has wrong key. want=%q, got=%q", i, key, hash.Pairs[i].Key.String())
+		}
+	}
 }
 
 func TestParsingEmptyHashLiteral(t *testing.T) {
@@ -643,7 +843,8 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for k, v := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		k, v := pair.Key, pair.Value
 		lit, ok := k.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", k)
@@ -834,3 +1035,29 @@ func testStringLiteral(t *testing.T, stmt ast.Expression, expected interface{})
 	}
 	return true
 }
+
+func TestErrorPositions(t *testing.T) {
+	p := New(lexer.New("(let x 1)\n (let 5 2)"))
+	p.ParseProgram()
+
+	errs, positions := p.Errors(), p.ErrorPositions()
+	if len(errs) == 0 || len(positions) != len(errs) {
+		t.Fatalf("expected an error with a position each. got errors=%v, positions=%v", errs, positions)
+	}
+	if positions[0].Line != 2 || positions[0].Column != 7 {
+		t.Errorf("wrong position for %q. want=2:7, got=%d:%d", errs[0], positions[0].Line, positions[0].Column)
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	p := New(lexer.New("(let x 1)\n(let y \"abc"))
+	p.ParseProgram()
+
+	errs, positions := p.Errors(), p.ErrorPositions()
+	if len(errs) == 0 || errs[0] != "unterminated string" {
+		t.Fatalf("expected an unterminated string error. got=%v", errs)
+	}
+	if positions[0].Line != 2 || positions[0].Column != 8 {
+		t.Errorf("wrong position for %q. want=2:8, got=%d:%d", errs[0], positions[0].Line, positions[0].Column)
+	}
+}
@@ -0,0 +1,164 @@
+package lsp
+
+import (
+	"nala/ast"
+	"nala/compiler"
+	"nala/lexer"
+	"nala/object"
+	"nala/source"
+	"nala/token"
+)
+
+// document is an open file and what the server worked out about it
+type document struct {
+	uri         string
+	text        string
+	prog        *ast.Program
+	tokens      []token.Token
+	diagnostics []Diagnostic
+	globals     []compiler.Symbol // bound once the prelude and the file are compiled
+	index       *index
+}
+
+// newDocument works out what it can about text. a file that does not parse
+// gets its diagnostics, while the rest carries over from the last version
+// that did, as the half-built tree of a broken file can not be walked
+func newDocument(uri string, text string, prelude *ast.Program, last *document) *document {
+	doc := &document{uri: uri, text: text}
+
+	l := lexer.New(text)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		doc.tokens = append(doc.tokens, tok)
+	}
+
+	p := source.NewParser(uri, lexer.New(text))
+	doc.prog = p.ParseProgram()
+	errs, positions := p.Errors(), p.ErrorPositions()
+
+	for i, msg := range errs {
+		doc.diagnostics = append(doc.diagnostics, Diagnostic{
+			Range: rangeOf(positions[i]), Severity: severityError, Source: "parser", Message: msg,
+		})
+	}
+	if len(errs) > 0 {
+		if last != nil {
+			doc.prog, doc.index, doc.globals = last.prog, last.index, last.globals
+		} else {
+			doc.prog = &ast.Program{}
+			doc.index = newIndex(doc.prog)
+			doc.compile(prelude)
+		}
+		return doc
+	}
+
+	doc.index = newIndex(doc.prog)
+	doc.compile(prelude)
+	return doc
+}
+
+// compile runs the compiler over the prelude and then the file, carrying
+// on past errors so that one does not hide the ones after it
+func (doc *document) compile(prelude *ast.Program) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbolTable.DefineBuiltin(i, v.Name)
+	}
+
+	c := compiler.NewWithState(symbolTable, []object.Object{})
+	if prelude != nil {
+		c.CompileAll(prelude)
+	}
+
+	for _, err := range c.CompileAll(doc.prog) {
+		tok := token.Token{Line: 1, Column: 1}
+		if compileErr, ok := err.(*compiler.Error); ok {
+			tok = compileErr.Token
+		}
+		doc.diagnostics = append(doc.diagnostics, Diagnostic{
+			Range: rangeOf(tok), Severity: severityError, Source: "compiler", Message: err.Error(),
+		})
+	}
+
+	for _, sym := range symbolTable.Symbols() {
+		if sym.Scope == compiler.GlobalScope {
+			doc.globals = append(doc.globals, sym)
+		}
+	}
+}
+
+// identAt returns the identifier token at pos, if there is one
+func (doc *document) identAt(pos Position) (token.Token, bool) {
+	for _, tok := range doc.tokens {
+		if tok.Type == token.IDENT && rangeOf(tok).contains(pos) {
+			return tok, true
+		}
+	}
+	return token.Token{}, false
+}
+
+func (doc *document) hover(pos Position) *Hover {
+	tok, ok := doc.identAt(pos)
+	if !ok {
+		return nil
+	}
+	r := rangeOf(tok)
+
+	if b := doc.index.bindingAt(pos); b != nil {
+		return &Hover{Contents: markdown("```nala\n" + b.describe() + "\n```"), Range: &r}
+	}
+	for _, def := range object.Builtins {
+		if def.Name == tok.Literal {
+			text := "```nala\n" + def.Name + ": " + def.Signature + "\n```\n" + def.BuiltIn.Desc
+			return &Hover{Contents: markdown(text), Range: &r}
+		}
+	}
+	return nil
+}
+
+func markdown(s string) MarkupContent {
+	return MarkupContent{Kind: "markdown", Value: s}
+}
+
+func (doc *document) definition(pos Position) *Location {
+	b := doc.index.bindingAt(pos)
+	if b == nil {
+		return nil
+	}
+	return &Location{URI: doc.uri, Range: rangeOf(b.name.Token)}
+}
+
+// completion offers the names in scope at pos, then the globals the
+// compiler bound, the builtins and the keywords
+func (doc *document) completion(pos Position) []CompletionItem {
+	items := []CompletionItem{}
+	seen := make(map[string]bool)
+	add := func(item CompletionItem) {
+		if !seen[item.Label] {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+
+	for _, b := range doc.index.visible(pos) {
+		item := CompletionItem{Label: b.name.Value, Kind: completionVariable, Detail: b.describe()}
+		switch node := b.node.(type) {
+		case *ast.LetStatement:
+			if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+				item.Kind = completionFunction
+			}
+		case *ast.StructStatement:
+			item.Kind = completionStruct
+		}
+		add(item)
+	}
+	for _, sym := range doc.globals {
+		add(CompletionItem{Label: sym.Name, Kind: completionVariable})
+	}
+	for _, def := range object.Builtins {
+		add(CompletionItem{Label: def.Name, Kind: completionFunction, Detail: def.Signature, Documentation: def.BuiltIn.Desc})
+	}
+	for _, word := range token.Keywords() {
+		add(CompletionItem{Label: word, Kind: completionKeyword})
+	}
+	return items
+}
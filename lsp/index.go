@@ -0,0 +1,278 @@
+package lsp
+
+import (
+	"nala/ast"
+	"strings"
+)
+
+// binding is a name bound by a let, a parameter or a struct
+type binding struct {
+	name *ast.Identifier
+	kind string
+	node ast.Node // the let or struct statement, nil for parameters
+}
+
+// describe is the hover text for b, written in Nala
+func (b *binding) describe() string {
+	switch node := b.node.(type) {
+	case *ast.LetStatement:
+		if fl, ok := node.Value.(*ast.FunctionLiteral); ok {
+			return "let " + b.name.Value + " = " + signature(fl)
+		}
+		if node.Type != nil {
+			return "let " + b.name.Value + ": " + node.Type.String()
+		}
+	case *ast.StructStatement:
+		fields := []string{}
+		for _, f := range node.Fields {
+			fields = append(fields, f.Value)
+		}
+		return "struct " + b.name.Value + " { " + strings.Join(fields, ", ") + " }"
+	}
+	if b.name.Type != nil {
+		return b.kind + " " + b.name.Value + ": " + b.name.Type.String()
+	}
+	return b.kind + " " + b.name.Value
+}
+
+func signature(fl *ast.FunctionLiteral) string {
+	params := []string{}
+	for _, p := range fl.Parameters {
+		if p.Type != nil {
+			params = append(params, p.Value+": "+p.Type.String())
+		} else {
+			params = append(params, p.Value)
+		}
+	}
+	sig := "fn(" + strings.Join(params, ", ") + ")"
+	if fl.ReturnType != nil {
+		sig += " -> " + fl.ReturnType.String()
+	}
+	return sig
+}
+
+// functions get a scope each, blocks share the scope of their function
+// the way they do when the program runs
+type scope struct {
+	names    map[string]*binding
+	bindings []*binding
+	outer    *scope
+	span     Range
+}
+
+func newScope(outer *scope, span Range) *scope {
+	return &scope{names: make(map[string]*binding), outer: outer, span: span}
+}
+
+func (s *scope) declare(b *binding) {
+	s.names[b.name.Value] = b
+	s.bindings = append(s.bindings, b)
+}
+
+func (s *scope) resolve(name string) *binding {
+	for ; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			return b
+		}
+	}
+	return nil
+}
+
+// a use of a name and the binding it refers to
+type reference struct {
+	ident   *ast.Identifier
+	binding *binding
+}
+
+// index knows where every name in a program is bound and used
+type index struct {
+	global *scope
+	scopes []*scope
+	refs   []reference
+	scope  *scope
+
+	// names used before anything visible bound them, which can still be
+	// globals bound further down
+	pending []*ast.Identifier
+}
+
+var everywhere = Range{End: Position{Line: 1 << 30}}
+
+func newIndex(prog *ast.Program) *index {
+	ix := &index{global: newScope(nil, everywhere)}
+	ix.scope = ix.global
+	ix.scopes = []*scope{ix.global}
+
+	ix.statements(prog.Statements)
+
+	for _, ident := range ix.pending {
+		if b, ok := ix.global.names[ident.Value]; ok {
+			ix.refs = append(ix.refs, reference{ident: ident, binding: b})
+		}
+	}
+	return ix
+}
+
+func (ix *index) statements(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		ix.statement(stmt)
+	}
+}
+
+func (ix *index) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		b := &binding{name: stmt.Name, kind: "let", node: stmt}
+		if _, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+			// declared first, so the function can call itself
+			ix.scope.declare(b)
+			ix.expr(stmt.Value)
+			return
+		}
+		ix.expr(stmt.Value)
+		ix.scope.declare(b)
+	case *ast.StructStatement:
+		ix.scope.declare(&binding{name: stmt.Name, kind: "struct", node: stmt})
+	case *ast.ReturnStatement:
+		ix.expr(stmt.ReturnValue)
+	case *ast.ExpressionStatement:
+		ix.expr(stmt.Expression)
+	case *ast.BlockStatement:
+		ix.statements(stmt.Statements)
+	}
+}
+
+func (ix *index) expr(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if b := ix.scope.resolve(exp.Value); b != nil {
+			ix.refs = append(ix.refs, reference{ident: exp, binding: b})
+		} else {
+			ix.pending = append(ix.pending, exp)
+		}
+	case *ast.PrefixExpression:
+		ix.expr(exp.Right)
+	case *ast.InfixExpression:
+		ix.expr(exp.Left)
+		ix.expr(exp.Right)
+	case *ast.IfExpression:
+		ix.expr(exp.Condition)
+		ix.statement(exp.Consequence)
+		if exp.Alternative != nil {
+			ix.statement(exp.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		ix.function(exp, exp.Parameters, exp.Body)
+	case *ast.MacroLiteral:
+		ix.function(exp, exp.Parameters, exp.Body)
+	case *ast.CallExpression:
+		ix.expr(exp.Function)
+		ix.exprs(exp.Arguments)
+	case *ast.ArrayLiteral:
+		ix.exprs(exp.Elements)
+	case *ast.SetLiteral:
+		ix.exprs(exp.Elements)
+	case *ast.HashLiteral:
+		for _, pair := range exp.Pairs {
+			ix.expr(pair.Key)
+			ix.expr(pair.Value)
+		}
+	case *ast.IndexExpression:
+		ix.expr(exp.Left)
+		ix.expr(exp.Index)
+	case *ast.FieldAccessExpression:
+		ix.expr(exp.Object)
+	case *ast.FieldAssignExpression:
+		ix.expr(exp.Object)
+		ix.expr(exp.Value)
+	case *ast.MethodCallExpression:
+		ix.expr(exp.Object)
+		ix.exprs(exp.Arguments)
+	case *ast.YieldExpression:
+		ix.expr(exp.Value)
+	}
+}
+
+func (ix *index) exprs(exps []ast.Expression) {
+	for _, exp := range exps {
+		ix.expr(exp)
+	}
+}
+
+func (ix *index) function(fn ast.Node, params []*ast.Identifier, body *ast.BlockStatement) {
+	span := Range{Start: positionOf(ast.StartToken(fn)), End: rangeOf(ast.LastToken(fn)).End}
+	outer := ix.scope
+	ix.scope = newScope(outer, span)
+	ix.scopes = append(ix.scopes, ix.scope)
+
+	for _, p := range params {
+		ix.scope.declare(&binding{name: p, kind: "parameter"})
+	}
+	ix.statements(body.Statements)
+	ix.scope = outer
+}
+
+// bindingAt finds the binding whose name, or a use of it, is at pos
+func (ix *index) bindingAt(pos Position) *binding {
+	for _, ref := range ix.refs {
+		if rangeOf(ref.ident.Token).contains(pos) {
+			return ref.binding
+		}
+	}
+	for _, s := range ix.scopes {
+		for _, b := range s.bindings {
+			if rangeOf(b.name.Token).contains(pos) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// visible returns the bindings in the scopes around pos, innermost first
+func (ix *index) visible(pos Position) []*binding {
+	var innermost *scope
+	for _, s := range ix.scopes {
+		if s.span.contains(pos) && (innermost == nil || innermost.span.Start.before(s.span.Start)) {
+			innermost = s
+		}
+	}
+
+	seen := make(map[string]bool)
+	out := []*binding{}
+	for s := innermost; s != nil; s = s.outer {
+		for _, b := range s.bindings {
+			if !seen[b.name.Value] {
+				seen[b.name.Value] = true
+				out = append(out, b)
+			}
+		}
+	}
+	return out
+}
+
+// symbols lists the lets and structs among stmts, with the ones inside
+// a function's body as its children
+func symbols(stmts []ast.Statement) []DocumentSymbol {
+	out := []DocumentSymbol{}
+	for _, stmt := range stmts {
+		span := Range{Start: positionOf(ast.StartToken(stmt)), End: rangeOf(ast.LastToken(stmt)).End}
+
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			sym := DocumentSymbol{Name: stmt.Name.Value, Kind: symbolVariable, Range: span, SelectionRange: rangeOf(stmt.Name.Token)}
+			if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+				sym.Kind = symbolFunction
+				sym.Children = symbols(fl.Body.Statements)
+			}
+			out = append(out, sym)
+		case *ast.StructStatement:
+			sym := DocumentSymbol{Name: stmt.Name.Value, Kind: symbolStruct, Range: span, SelectionRange: rangeOf(stmt.Name.Token)}
+			for _, f := range stmt.Fields {
+				sym.Children = append(sym.Children, DocumentSymbol{Name: f.Value, Kind: symbolField, Range: rangeOf(f.Token), SelectionRange: rangeOf(f.Token)})
+			}
+			out = append(out, sym)
+		}
+	}
+	return out
+}
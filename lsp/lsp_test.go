@@ -0,0 +1,431 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"nala/lexer"
+	"nala/parser"
+	"strings"
+	"testing"
+)
+
+// client drives a Server the way an editor would, over a pair of pipes
+type client struct {
+	t      *testing.T
+	w      *io.PipeWriter
+	r      *bufio.Reader
+	nextID int
+	done   chan error
+
+	notifications []message // received while waiting for a response
+}
+
+const prelude = "let map = fn(arr, f) { arr };"
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	prog := parser.New(lexer.New(prelude)).ParseProgram()
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(inR, outW, prog).Run()
+		outW.Close()
+		c.done <- err
+	}()
+
+	c.request("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, nil)
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func (c *client) send(msg interface{}) {
+	c.t.Helper()
+	if err := writeMessage(c.w, msg); err != nil {
+		c.t.Fatalf("could not send %v: %s", msg, err)
+	}
+}
+
+func (c *client) read() message {
+	c.t.Helper()
+	body, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("could not read from the server: %s", err)
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("server sent bad JSON %s: %s", body, err)
+	}
+	return msg
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// request sends a request and decodes its result into result. it returns
+// the error the server answered with, if any
+func (c *client) request(method string, params interface{}, result interface{}) *responseError {
+	c.t.Helper()
+	c.nextID++
+	c.send(map[string]interface{}{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+
+	for {
+		body, err := readMessage(c.r)
+		if err != nil {
+			c.t.Fatalf("no response to %s: %s", method, err)
+		}
+		var resp struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Result json.RawMessage `json:"result"`
+			Error  *responseError  `json:"error"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			c.t.Fatalf("server sent bad JSON %s: %s", body, err)
+		}
+		if resp.ID == nil {
+			var msg message
+			json.Unmarshal(body, &msg)
+			c.notifications = append(c.notifications, msg)
+			continue
+		}
+		if *resp.ID != c.nextID {
+			c.t.Fatalf("response to %s has id %d, want %d", method, *resp.ID, c.nextID)
+		}
+		if resp.Error == nil && result != nil {
+			if err := json.Unmarshal(resp.Result, result); err != nil {
+				c.t.Fatalf("bad result for %s: %s (%s)", method, err, resp.Result)
+			}
+		}
+		return resp.Error
+	}
+}
+
+// diagnostics waits for the diagnostics published for uri
+func (c *client) diagnostics(uri string) []Diagnostic {
+	c.t.Helper()
+	for {
+		var msg message
+		if len(c.notifications) > 0 {
+			msg, c.notifications = c.notifications[0], c.notifications[1:]
+		} else {
+			msg = c.read()
+		}
+		if msg.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var p PublishDiagnosticsParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			c.t.Fatalf("bad diagnostics %s: %s", msg.Params, err)
+		}
+		if p.URI == uri {
+			return p.Diagnostics
+		}
+	}
+}
+
+func (c *client) open(uri string, text string) []Diagnostic {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", DidOpenParams{TextDocument: TextDocumentItem{URI: uri, Text: text, Version: 1}})
+	return c.diagnostics(uri)
+}
+
+func (c *client) change(uri string, text string) []Diagnostic {
+	c.t.Helper()
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": text}},
+	})
+	return c.diagnostics(uri)
+}
+
+func (c *client) close() {
+	c.t.Helper()
+	if err := c.request("shutdown", nil, nil); err != nil {
+		c.t.Fatalf("shutdown failed: %s", err.Message)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Fatalf("server stopped with %s", err)
+	}
+}
+
+func at(uri string, line, character int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: Position{Line: line, Character: character}}
+}
+
+func TestInitialize(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	var result struct {
+		Capabilities map[string]interface{} `json:"capabilities"`
+	}
+	c.request("initialize", map[string]interface{}{}, &result)
+	for _, capability := range []string{"hoverProvider", "completionProvider", "definitionProvider", "documentSymbolProvider"} {
+		if _, ok := result.Capabilities[capability]; !ok {
+			t.Errorf("capability %s is missing. got=%v", capability, result.Capabilities)
+		}
+	}
+
+	if err := c.request("workspace/symbol", map[string]interface{}{}, nil); err == nil || err.Code != methodNotFound {
+		t.Errorf("expected method not found for an unknown request. got=%+v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	tests := []struct {
+		uri      string
+		text     string
+		expected []string // line:character source: message
+	}{
+		{"file:///a.nl", "let x = 1;\nputs(map([x], len));", []string{}},
+		{"file:///a.nl", "let x = 1;\nlet y 2;", []string{"1:6 parser: expected next token to be =, got INT instead"}},
+		{
+			"file:///a.nl",
+			"let x = y;\nstruct P { a }\nlet p = P(1);\np.b;\nz",
+			[]string{"0:8 compiler: undefined variable y", "3:2 compiler: unknown field b for struct P", "4:0 compiler: undefined variable z"},
+		},
+		{"file:///b.el", "(let x 1)\n(puts x, w)", []string{"1:9 compiler: undefined variable w"}},
+	}
+
+	for i, tt := range tests {
+		var diags []Diagnostic
+		if i == 0 || tt.uri != tests[i-1].uri {
+			diags = c.open(tt.uri, tt.text)
+		} else {
+			diags = c.change(tt.uri, tt.text)
+		}
+
+		got := []string{}
+		for _, d := range diags {
+			got = append(got, formatDiagnostic(d))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("wrong diagnostics for %q.\nwant=%q\ngot=%q", tt.text, tt.expected, got)
+		}
+	}
+
+	c.notify("textDocument/didClose", DidCloseParams{TextDocument: TextDocumentIdentifier{URI: "file:///a.nl"}})
+	if diags := c.diagnostics("file:///a.nl"); len(diags) != 0 {
+		t.Errorf("expected closing to clear the diagnostics. got=%v", diags)
+	}
+}
+
+func formatDiagnostic(d Diagnostic) string {
+	return strings.Join([]string{itoa(d.Range.Start.Line) + ":" + itoa(d.Range.Start.Character), d.Source + ":", d.Message}, " ")
+}
+
+func itoa(n int) string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+const program = `let total = 5;
+let add2 = fn(n: int) -> int {
+    let step = 2;
+    n + step + total
+};
+struct Point { x, y }
+add2(len("abc"));
+`
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///p.nl", program)
+
+	tests := []struct {
+		line, character int
+		expected        string
+	}{
+		{6, 0, "```nala\nlet add2 = fn(n: int) -> int\n```"},
+		{3, 4, "```nala\nparameter n: int\n```"},
+		{3, 9, "```nala\nlet step\n```"},
+		{5, 8, "```nala\nstruct Point { x, y }\n```"},
+		{6, 5, "```nala\nlen: fn(string | [any] | {any: any} | #{any} | range) -> int\n```\ncalculates the length of a Nala iterable"},
+	}
+
+	for _, tt := range tests {
+		var hover *Hover
+		if err := c.request("textDocument/hover", at("file:///p.nl", tt.line, tt.character), &hover); err != nil {
+			t.Fatalf("hover failed: %s", err.Message)
+		}
+		if hover == nil {
+			t.Errorf("no hover at %d:%d", tt.line, tt.character)
+			continue
+		}
+		if hover.Contents.Value != tt.expected {
+			t.Errorf("wrong hover at %d:%d. want=%q, got=%q", tt.line, tt.character, tt.expected, hover.Contents.Value)
+		}
+	}
+
+	var hover *Hover
+	c.request("textDocument/hover", at("file:///p.nl", 1, 26), &hover)
+	if hover != nil {
+		t.Errorf("expected no hover on a keyword. got=%+v", hover)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///p.nl", program)
+
+	tests := []struct {
+		line, character int
+		defLine, defCol int
+	}{
+		{6, 1, 1, 4},  // add2
+		{3, 15, 0, 4}, // total
+		{3, 10, 2, 8}, // step
+		{3, 4, 1, 14}, // n
+		{1, 5, 1, 4},  // the let itself
+	}
+
+	for _, tt := range tests {
+		var loc *Location
+		if err := c.request("textDocument/definition", at("file:///p.nl", tt.line, tt.character), &loc); err != nil {
+			t.Fatalf("definition failed: %s", err.Message)
+		}
+		if loc == nil {
+			t.Errorf("no definition for %d:%d", tt.line, tt.character)
+			continue
+		}
+		if loc.URI != "file:///p.nl" || loc.Range.Start.Line != tt.defLine || loc.Range.Start.Character != tt.defCol {
+			t.Errorf("wrong definition for %d:%d. want=%d:%d, got=%+v", tt.line, tt.character, tt.defLine, tt.defCol, loc)
+		}
+	}
+
+	var loc *Location
+	c.request("textDocument/definition", at("file:///p.nl", 6, 6), &loc)
+	if loc != nil {
+		t.Errorf("expected no definition for a builtin. got=%+v", loc)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///p.nl", program)
+
+	labels := func(line, character int) map[string]int {
+		var items []CompletionItem
+		if err := c.request("textDocument/completion", at("file:///p.nl", line, character), &items); err != nil {
+			t.Fatalf("completion failed: %s", err.Message)
+		}
+		out := make(map[string]int)
+		for _, item := range items {
+			out[item.Label] = item.Kind
+		}
+		return out
+	}
+
+	inside := labels(3, 4)
+	expected := map[string]int{
+		"n": completionVariable, "step": completionVariable, "total": completionVariable,
+		"add2": completionFunction, "Point": completionStruct,
+		"map": completionVariable, "len": completionFunction, "let": completionKeyword, "yield": completionKeyword,
+	}
+	for label, kind := range expected {
+		if got, ok := inside[label]; !ok || got != kind {
+			t.Errorf("completion %s inside add2 wrong. want kind %d, got=%d (offered=%t)", label, kind, got, ok)
+		}
+	}
+
+	outside := labels(6, 0)
+	for _, local := range []string{"n", "step"} {
+		if _, ok := outside[local]; ok {
+			t.Errorf("local %s offered outside its function", local)
+		}
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///p.nl", program)
+
+	var syms []DocumentSymbol
+	if err := c.request("textDocument/documentSymbol", map[string]interface{}{"textDocument": TextDocumentIdentifier{URI: "file:///p.nl"}}, &syms); err != nil {
+		t.Fatalf("documentSymbol failed: %s", err.Message)
+	}
+
+	var describe func(syms []DocumentSymbol) string
+	describe = func(syms []DocumentSymbol) string {
+		parts := []string{}
+		for _, s := range syms {
+			part := s.Name + ":" + itoa(s.Kind) + "@" + itoa(s.SelectionRange.Start.Line)
+			if len(s.Children) > 0 {
+				part += "(" + describe(s.Children) + ")"
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, " ")
+	}
+
+	expected := "total:13@0 add2:12@1(step:13@2) Point:23@5(x:8@5 y:8@5)"
+	if got := describe(syms); got != expected {
+		t.Errorf("wrong symbols. want=%q, got=%q", expected, got)
+	}
+}
+
+func TestBrokenEditsKeepTheLastAnalysis(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open("file:///p.nl", program)
+
+	if diags := c.change("file:///p.nl", program+"let broken = ;"); len(diags) == 0 {
+		t.Fatalf("expected a parser error for the broken edit")
+	}
+
+	var loc *Location
+	c.request("textDocument/definition", at("file:///p.nl", 6, 1), &loc)
+	if loc == nil || loc.Range.Start.Line != 1 {
+		t.Errorf("expected the definition from the last good version. got=%+v", loc)
+	}
+}
+
+// half-typed strings are diagnosed, and the server keeps serving
+func TestUnterminatedString(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	tests := []struct {
+		uri, text, closed string
+		expected          string
+	}{
+		{"file:///s.nl", "let x = 1;\nlet y = \"abc", "\";", "1:8 parser: unterminated string"},
+		{"file:///s.el", "(let x 1)\n(let y \"abc", "\")", "1:7 parser: unterminated string"},
+	}
+
+	for _, tt := range tests {
+		diags := c.open(tt.uri, tt.text)
+		if len(diags) == 0 || formatDiagnostic(diags[0]) != tt.expected {
+			t.Errorf("wrong diagnostics for %q. want=%q, got=%v", tt.text, tt.expected, diags)
+		}
+		if diags := c.change(tt.uri, tt.text+tt.closed); len(diags) != 0 {
+			t.Errorf("expected no diagnostics once the string is closed. got=%v", diags)
+		}
+	}
+}
+
+func TestRequestsAfterShutdown(t *testing.T) {
+	c := newClient(t)
+	if err := c.request("shutdown", nil, nil); err != nil {
+		t.Fatalf("shutdown failed: %s", err.Message)
+	}
+	if err := c.request("textDocument/hover", at("file:///p.nl", 0, 0), nil); err == nil || err.Code != invalidRequest {
+		t.Errorf("expected requests after shutdown to be refused. got=%+v", err)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatalf("server stopped with %s", err)
+	}
+}
@@ -0,0 +1,205 @@
+// Package lsp is a Language Server Protocol server for Nala and Ellisp
+// files, spoken as JSON-RPC over a pair of streams
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"nala/ast"
+	"nala/token"
+)
+
+// Server answers one client. documents are analysed again in full on
+// every change, which is quick for the size of programs Nala sees
+type Server struct {
+	in      *bufio.Reader
+	out     io.Writer
+	prelude *ast.Program
+	docs    map[string]*document
+
+	shutdown bool
+}
+
+// NewServer returns a server reading requests from in and writing to out.
+// the names prelude binds are known to every file, the way the REPL loads
+// code/functions.nl before a program. it can be nil
+func NewServer(in io.Reader, out io.Writer, prelude *ast.Program) *Server {
+	return &Server{
+		in:      bufio.NewReader(in),
+		out:     out,
+		prelude: prelude,
+		docs:    make(map[string]*document),
+	}
+}
+
+// Run serves requests until the client sends exit or closes its stream
+func (s *Server) Run() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.reply(nil, nil, &responseError{Code: parseError, Message: err.Error()})
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if s.shutdown {
+			if msg.ID != nil {
+				s.reply(msg.ID, nil, &responseError{Code: invalidRequest, Message: "the server is shut down"})
+			}
+			continue
+		}
+
+		result, rerr := s.safeHandle(msg.Method, msg.Params)
+		if msg.ID != nil {
+			s.reply(msg.ID, result, rerr)
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) {
+	resp := response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		resp.Result, _ = json.Marshal(result)
+	}
+	writeMessage(s.out, resp)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// safeHandle is handle, turning a panic into an error for the request
+// rather than letting it stop the server
+func (s *Server) safeHandle(method string, params json.RawMessage) (result interface{}, rerr *responseError) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, rerr = nil, &responseError{Code: internalError, Message: fmt.Sprintf("%s failed: %v", method, r)}
+		}
+	}()
+	return s.handle(method, params)
+}
+
+// handle runs a request or notification and returns its result. the
+// result of a notification is thrown away
+func (s *Server) handle(method string, params json.RawMessage) (interface{}, *responseError) {
+	switch method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // the whole text on every change
+				"hoverProvider":          true,
+				"completionProvider":     map[string]interface{}{},
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "nala"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var p DidOpenParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p DidChangeParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p DidCloseParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		return nil, nil
+
+	case "textDocument/hover", "textDocument/completion", "textDocument/definition", "textDocument/documentSymbol":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		doc, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return nil, &responseError{Code: invalidParams, Message: fmt.Sprintf("%s is not open", p.TextDocument.URI)}
+		}
+		return s.query(method, doc, p.Position), nil
+	}
+
+	return nil, &responseError{Code: methodNotFound, Message: fmt.Sprintf("method %s is not supported", method)}
+}
+
+// query answers the requests about a position in an open document. the
+// results are typed as interface{}, as nil pointers have to come out null
+func (s *Server) query(method string, doc *document, pos Position) interface{} {
+	switch method {
+	case "textDocument/hover":
+		if h := doc.hover(pos); h != nil {
+			return h
+		}
+	case "textDocument/completion":
+		return doc.completion(pos)
+	case "textDocument/definition":
+		if loc := doc.definition(pos); loc != nil {
+			return loc
+		}
+	case "textDocument/documentSymbol":
+		return symbols(doc.prog.Statements)
+	}
+	return nil
+}
+
+// update analyses the new text of a document and publishes its
+// diagnostics. if the analysis fails, that is the diagnostic, and the
+// last version stays in place for the requests about the document
+func (s *Server) update(uri string, text string) {
+	doc, err := s.analyse(uri, text)
+	if err != nil {
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: []Diagnostic{
+			{Range: rangeOf(token.Token{Line: 1, Column: 1}), Severity: severityError, Source: "server", Message: err.Error()},
+		}})
+		return
+	}
+	s.docs[uri] = doc
+
+	diags := doc.diagnostics
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+func (s *Server) analyse(uri string, text string) (doc *document, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("could not analyse the file: %v", r)
+		}
+	}()
+	return newDocument(uri, text, s.prelude, s.docs[uri]), nil
+}
+
+func badParams(err error) *responseError {
+	return &responseError{Code: invalidParams, Message: err.Error()}
+}
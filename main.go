@@ -1,10 +1,36 @@
 package main
 
 import (
+	"fmt"
 	"nala/repl"
 	"os"
 )
 
+// commands run in place of the REPL when named as the first argument.
+// each one gets the arguments after its name and returns the exit status
+var commands = map[string]func(args []string) int{
+	"build":   runBuild,
+	"convert": runConvert,
+	"dap":     runDap,
+	"debug":   runDebug,
+	"fmt":     runFmt,
+	"lint":    runLint,
+	"lsp":     runLsp,
+	"profile": runProfile,
+	"run":     runRun,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+// a command's own error, as opposed to the problems it reports
+func fail(format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "nala: "+format+"\n", a...)
+	return 2
+}
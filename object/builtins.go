@@ -3,7 +3,10 @@ package object
 import (
 	"bufio"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"strings"
 )
 
 func newError(format string, a ...interface{}) *Error {
@@ -34,6 +37,10 @@ func nala_len(args ...Object) Object {
 		return &Integer{Value: int64(len(arg.Value))}
 	case *Array:
 		return &Integer{Value: int64(len(arg.Elements))}
+	case *Range:
+		return &Integer{Value: arg.Len()}
+	case *Set:
+		return &Integer{Value: int64(arg.Len())}
 	default:
 		return newError("argument to `len` is not supported, got %s", args[0].Type())
 	}
@@ -44,25 +51,12 @@ func nala_object_type(args ...Object) Object {
 		return newError("wrong number of arguments. got=%d, want=1", len(args))
 	}
 
-	switch args[0].(type) {
-	case *String:
-		return &String{Value: STRING_OBJ}
-	case *Boolean:
-		return &String{Value: BOOLEAN_OBJ}
-	case *Integer:
-		return &String{Value: INTEGER_OBJ}
-	case *Nil:
-		return &String{Value: NIL_OBJ}
-	case *Function:
-		return &String{Value: FUNCTION_OBJ}
-	case *BuiltIn:
-		return &String{Value: BUILTIN_OBJ}
-	case *Array:
-		return &String{Value: ARRAY_OBJ}
-	case *HashMap:
-		return &String{Value: HASHMAP_OBJ}
+	switch arg := args[0].(type) {
+	case *Struct:
+		// struct values report the name they were declared with
+		return &String{Value: arg.Def.Name}
 	default:
-		return newError("object type unexpected. got %s", args[0].Type())
+		return &String{Value: string(arg.Type())}
 	}
 }
 
@@ -71,6 +65,13 @@ func nala_first(args ...Object) Object {
 		return newError("wrong number of arguments. got=%d, want=1", len(args))
 	}
 
+	if rng, ok := args[0].(*Range); ok {
+		if val, ok := rng.At(0); ok {
+			return &Integer{Value: val}
+		}
+		return NIL
+	}
+
 	if args[0].Type() != ARRAY_OBJ {
 		return newError("argument to `first` must be ARRAY, got %s", args[0].Type())
 	}
@@ -88,6 +89,13 @@ func nala_last(args ...Object) Object {
 		return newError("wrong number of arguments. got=%d, want=1", len(args))
 	}
 
+	if rng, ok := args[0].(*Range); ok {
+		if val, ok := rng.At(rng.Len() - 1); ok {
+			return &Integer{Value: val}
+		}
+		return NIL
+	}
+
 	if args[0].Type() != ARRAY_OBJ {
 		return newError("argument to `last` must be ARRAY, got %s", args[0].Type())
 	}
@@ -106,6 +114,18 @@ func nala_rest(args ...Object) Object {
 		return newError("wrong number of arguments. got=%d, want=1", len(args))
 	}
 
+	// the rest of a Range is another Range, so walking it with first/rest stays lazy
+	if rng, ok := args[0].(*Range); ok {
+		switch n := rng.Len(); {
+		case n == 1:
+			// stepping past the last element could overflow Start
+			return &Range{Start: rng.End, End: rng.End, Step: rng.Step}
+		case n > 1:
+			return &Range{Start: rng.Start + rng.Step, End: rng.End, Step: rng.Step}
+		}
+		return NIL
+	}
+
 	if args[0].Type() != ARRAY_OBJ {
 		return newError("argument to `rest` must be ARRAY, got %s", args[0].Type())
 	}
@@ -140,27 +160,30 @@ func nala_push(args ...Object) Object {
 	return &Array{Elements: nElems}
 }
 
+// Output is where puts and the other builtins that print write to
+var Output io.Writer = os.Stdout
+
 func nala_puts(args ...Object) Object {
 	if argumentCountMatch(len(args), 0) {
-		fmt.Print()
+		fmt.Fprint(Output)
 		return NIL
 	}
 
 	for _, arg := range args {
-		fmt.Print(arg.Inspect())
+		fmt.Fprint(Output, arg.Inspect())
 	}
-	fmt.Println()
+	fmt.Fprintln(Output)
 	return NIL
 }
 
 func nala_putl(args ...Object) Object {
 	if argumentCountMatch(len(args), 0) {
-		fmt.Println()
+		fmt.Fprintln(Output)
 		return NIL
 	}
 
 	for _, arg := range args {
-		fmt.Println(arg.Inspect())
+		fmt.Fprintln(Output, arg.Inspect())
 	}
 	return NIL
 }
@@ -176,7 +199,7 @@ func nala_reads(args ...Object) Object {
 		}
 
 		str := args[0].(*String).Value
-		fmt.Print(str)
+		fmt.Fprint(Output, str)
 	}
 
 	in := os.Stdin
@@ -201,7 +224,7 @@ func nala_hashmap_keys(args ...Object) Object {
 
 	elems := []Object{}
 
-	for _, pair := range hmap.Pairs {
+	for _, pair := range hmap.Pairs() {
 		elems = append(elems, pair.Key)
 	}
 	return &Array{Elements: elems}
@@ -220,7 +243,7 @@ func nala_hashmap_values(args ...Object) Object {
 
 	elems := []Object{}
 
-	for _, pair := range hmap.Pairs {
+	for _, pair := range hmap.Pairs() {
 		elems = append(elems, pair.Value)
 	}
 	return &Array{Elements: elems}
@@ -239,7 +262,7 @@ func nala_hashmap_items(args ...Object) Object {
 
 	elems := []Object{}
 
-	for _, pair := range hmap.Pairs {
+	for _, pair := range hmap.Pairs() {
 		nested_elems := []Object{
 			pair.Key,
 			pair.Value,
@@ -262,9 +285,7 @@ func nala_insert(args ...Object) Object {
 			return newError("unusable as hash key: %s", args[1].Type())
 		}
 
-		val := args[2]
-		hsh := hashKey.HashKey()
-		ob.Pairs[hsh] = HashPair{Key: hashKey.(Object), Value: val}
+		ob.Set(hashKey, args[2])
 		return NIL
 	case *Array:
 		ind, ok := args[1].(*Integer)
@@ -303,10 +324,8 @@ func nala_delete(args ...Object) Object {
 			return newError("unusable as hash key: %s", args[1].Type())
 		}
 
-		if _, ok := ob.Pairs[hashKey.HashKey()]; !ok {
+		if !ob.Delete(hashKey) {
 			return newError("key does not exist in HashMap")
-		} else {
-			delete(ob.Pairs, hashKey.HashKey())
 		}
 		return NIL
 	case *Array:
@@ -344,14 +363,14 @@ func nala_copy(args ...Object) Object {
 		}
 		return &Array{Elements: obj.Elements}
 	case *HashMap:
-		pairs := make(map[HashKey]HashPair)
+		hmap := NewHashMap()
 
-		for k, pair := range obj.Pairs {
-			key := pair.Key
-			val := pair.Value
-			pairs[k] = HashPair{Key: key, Value: val}
+		for _, pair := range obj.Pairs() {
+			hmap.Set(pair.Key.(Hashable), pair.Value)
 		}
-		return &HashMap{Pairs: pairs}
+		return hmap
+	case *Set:
+		return obj.Union(NewSet())
 	default:
 		return newError("argument to `copy` is not supported, got %s", obj.Type())
 	}
@@ -363,79 +382,389 @@ func nala_showbuiltin_desc(args ...Object) Object {
 	}
 
 	if args[0].Type() == BUILTIN_OBJ {
-		fmt.Println(args[0].Inspect())
+		fmt.Fprintln(Output, args[0].Inspect())
+	}
+	return NIL
+}
+
+func nala_range(args ...Object) Object {
+	if len(args) < 1 || len(args) > 3 {
+		return newError("wrong number of arguments. got=%d, want 1 to 3", len(args))
+	}
+
+	bounds := []int64{}
+	for _, arg := range args {
+		num, ok := arg.(*Integer)
+		if !ok {
+			return newError("arguments to `range` must be INTEGER, got %s", arg.Type())
+		}
+		bounds = append(bounds, num.Value)
+	}
+
+	// range(end), range(start, end) and range(start, end, step)
+	rng := &Range{Start: 0, Step: 1}
+	switch len(bounds) {
+	case 1:
+		rng.End = bounds[0]
+	case 2:
+		rng.Start, rng.End = bounds[0], bounds[1]
+	case 3:
+		rng.Start, rng.End, rng.Step = bounds[0], bounds[1], bounds[2]
+	}
+
+	if rng.Step == 0 {
+		return newError("step argument to `range` must not be zero")
+	}
+	if rng.Count() > math.MaxInt64 {
+		return newError("range(%d, %d, %d) has too many elements", rng.Start, rng.End, rng.Step)
+	}
+	return rng
+}
+
+func nala_has(args ...Object) Object {
+	if !argumentCountMatch(len(args), 2) {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	switch coll := args[0].(type) {
+	case *Range:
+		num, ok := args[1].(*Integer)
+		if !ok {
+			return FALSE
+		}
+		return nativeBoolToBoolean(coll.Contains(num.Value))
+	case *HashMap:
+		key, ok := args[1].(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", args[1].Type())
+		}
+		_, ok = coll.Get(key)
+		return nativeBoolToBoolean(ok)
+	case *Set:
+		member, ok := args[1].(Hashable)
+		if !ok {
+			return newError("unusable as set member: %s", args[1].Type())
+		}
+		return nativeBoolToBoolean(coll.Has(member))
+	case *String:
+		sub, ok := args[1].(*String)
+		if !ok {
+			return newError("argument to `has` on STRING must be STRING, got %s", args[1].Type())
+		}
+		return nativeBoolToBoolean(strings.Contains(coll.Value, sub.Value))
+	case Iterable:
+		iter := coll.Iterator()
+		for el, ok := iter.Next(); ok; el, ok = iter.Next() {
+			if err, isErr := el.(*Error); isErr {
+				return err
+			}
+			if Equal(el, args[1]) {
+				return TRUE
+			}
+		}
+		return FALSE
+	default:
+		return newError("argument to `has` is not supported, got %s", args[0].Type())
+	}
+}
+
+func nala_array(args ...Object) Object {
+	if !argumentCountMatch(len(args), 1) {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	iterable, ok := args[0].(Iterable)
+	if !ok {
+		return newError("argument to `array` must be iterable, got %s", args[0].Type())
+	}
+
+	elems := []Object{}
+	iter := iterable.Iterator()
+	for el, ok := iter.Next(); ok; el, ok = iter.Next() {
+		// a Generator that fails hands back its error as its last value
+		if err, isErr := el.(*Error); isErr {
+			return err
+		}
+		elems = append(elems, el)
+	}
+	return &Array{Elements: elems}
+}
+
+func nala_next(args ...Object) Object {
+	if !argumentCountMatch(len(args), 1) {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	gen, ok := args[0].(Generator)
+	if !ok {
+		return newError("argument to `next` must be GENERATOR, got %s", args[0].Type())
+	}
+
+	val, ok := gen.Next()
+	if !ok {
+		return NIL
+	}
+	return val
+}
+
+func nala_set_add(args ...Object) Object {
+	if !argumentCountMatch(len(args), 2) {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	set, ok := args[0].(*Set)
+	if !ok {
+		return newError("argument to `add` must be SET, got %s", args[0].Type())
+	}
+
+	member, ok := args[1].(Hashable)
+	if !ok {
+		return newError("unusable as set member: %s", args[1].Type())
+	}
+
+	set.Add(member)
+	return NIL
+}
+
+func nala_set_remove(args ...Object) Object {
+	if !argumentCountMatch(len(args), 2) {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	set, ok := args[0].(*Set)
+	if !ok {
+		return newError("argument to `remove` must be SET, got %s", args[0].Type())
+	}
+
+	member, ok := args[1].(Hashable)
+	if !ok {
+		return newError("unusable as set member: %s", args[1].Type())
+	}
+
+	if !set.Remove(member) {
+		return newError("member does not exist in Set")
 	}
 	return NIL
 }
 
+// builds the builtins that map a String to a new String
+func stringTransform(name string, op func(string) string) BuiltInFunction {
+	return func(args ...Object) Object {
+		if !argumentCountMatch(len(args), 1) {
+			return newError("wrong number of arguments. got=%d, want=1", len(args))
+		}
+
+		str, ok := args[0].(*String)
+		if !ok {
+			return newError("argument to `%s` must be STRING, got %s", name, args[0].Type())
+		}
+		return &String{Value: op(str.Value)}
+	}
+}
+
+// builds the builtins for binary set algebra, which all take two Sets
+func setAlgebra(name string, op func(a, b *Set) *Set) BuiltInFunction {
+	return func(args ...Object) Object {
+		if !argumentCountMatch(len(args), 2) {
+			return newError("wrong number of arguments. got=%d, want=2", len(args))
+		}
+
+		a, aOk := args[0].(*Set)
+		b, bOk := args[1].(*Set)
+		if !aOk || !bOk {
+			return newError("arguments to `%s` must be SET, got %s and %s",
+				name, args[0].Type(), args[1].Type())
+		}
+		return op(a, b)
+	}
+}
+
+func nativeBoolToBoolean(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
+// Signature is the builtin's type, written the way type annotations are.
+// the checker uses it to check calls before they run
 var Builtins = []struct {
-	Name    string
-	BuiltIn *BuiltIn
+	Name      string
+	Signature string
+	BuiltIn   *BuiltIn
 }{
 	{
-		Name:    "len",
-		BuiltIn: &BuiltIn{Fn: nala_len, Desc: "calculates the length of a Nala iterable"},
+		Name:      "len",
+		Signature: "fn(string | [any] | {any: any} | #{any} | range) -> int",
+		BuiltIn:   &BuiltIn{Fn: nala_len, Desc: "calculates the length of a Nala iterable"},
 	},
 	{
-		Name:    "type",
-		BuiltIn: &BuiltIn{Fn: nala_object_type, Desc: "shows the type of a Nala object"},
+		Name:      "type",
+		Signature: "fn(any) -> string",
+		BuiltIn:   &BuiltIn{Fn: nala_object_type, Desc: "shows the type of a Nala object"},
 	},
 	{
-		Name:    "first",
-		BuiltIn: &BuiltIn{Fn: nala_first, Desc: "returns the first element of an Array"},
+		Name:      "first",
+		Signature: "fn([any] | range) -> any",
+		BuiltIn:   &BuiltIn{Fn: nala_first, Desc: "returns the first element of an Array"},
 	},
 	{
-		Name:    "last",
-		BuiltIn: &BuiltIn{Fn: nala_last, Desc: "returns the last element of an Array"},
+		Name:      "last",
+		Signature: "fn([any] | range) -> any",
+		BuiltIn:   &BuiltIn{Fn: nala_last, Desc: "returns the last element of an Array"},
 	},
 	{
-		Name: "rest",
+		Name:      "rest",
+		Signature: "fn([any] | range) -> any",
 		BuiltIn: &BuiltIn{
 			Fn:   nala_rest,
 			Desc: "returns a new copy of passed Array excluding first element"},
 	},
 	{
-		Name:    "push",
-		BuiltIn: &BuiltIn{Fn: nala_push, Desc: "pushes a new element to the back of an Array"},
+		Name:      "push",
+		Signature: "fn([any], any) -> [any]",
+		BuiltIn:   &BuiltIn{Fn: nala_push, Desc: "pushes a new element to the back of an Array"},
+	},
+	{
+		Name:      "puts",
+		Signature: "fn(any...) -> nil",
+		BuiltIn:   &BuiltIn{Fn: nala_puts, Desc: "prints to standard output on the same line, with a terminating newline.\nTakes 0 or more arguments"},
+	},
+	{
+		Name:      "putl",
+		Signature: "fn(any...) -> nil",
+		BuiltIn:   &BuiltIn{Fn: nala_putl, Desc: "prints to standard output on multiple lines.\nTakes 0 or more arguments"},
+	},
+	{
+		Name:      "reads",
+		Signature: "fn(string?) -> string",
+		BuiltIn:   &BuiltIn{Fn: nala_reads, Desc: "reads string from standard input. Takes conditional prompt string"},
+	},
+	{
+		Name:      "keys",
+		Signature: "fn({any: any}) -> [any]",
+		BuiltIn:   &BuiltIn{Fn: nala_hashmap_keys, Desc: "returns the keys of a HashMap in an Array"},
+	},
+	{
+		Name:      "values",
+		Signature: "fn({any: any}) -> [any]",
+		BuiltIn:   &BuiltIn{Fn: nala_hashmap_values, Desc: "returns the keys of a HashMap in an Array"},
+	},
+	{
+		Name:      "items",
+		Signature: "fn({any: any}) -> [[any]]",
+		BuiltIn:   &BuiltIn{Fn: nala_hashmap_items, Desc: "returns an Array of Arrays containing Key, Value of a HashMap."},
+	},
+	{
+		Name:      "ins",
+		Signature: "fn([any] | {any: any}, any, any) -> any",
+		BuiltIn:   &BuiltIn{Fn: nala_insert, Desc: "inserts a Value at a Key/Index in a HashMap/Array"},
+	},
+	{
+		Name:      "del",
+		Signature: "fn([any] | {any: any}, any) -> any",
+		BuiltIn:   &BuiltIn{Fn: nala_delete, Desc: "delete the Value at a Key/Index from a HashMap/Array"},
+	},
+	{
+		Name:      "copy",
+		Signature: "fn([any] | {any: any} | #{any}) -> any",
+		BuiltIn:   &BuiltIn{Fn: nala_copy, Desc: "returns a copy of an Array, HashMap or Set"},
+	},
+	{
+		Name:      "desc",
+		Signature: "fn(fn) -> nil",
+		BuiltIn:   &BuiltIn{Fn: nala_showbuiltin_desc, Desc: "takes a builtin functions and shows the description"},
+	},
+	{
+		Name:      "range",
+		Signature: "fn(int, int?, int?) -> range",
+		BuiltIn: &BuiltIn{
+			Fn:   nala_range,
+			Desc: "returns a lazy Range of integers. Takes (end), (start, end) or (start, end, step)"},
+	},
+	{
+		Name:      "has",
+		Signature: "fn(any, any) -> bool",
+		BuiltIn:   &BuiltIn{Fn: nala_has, Desc: "checks if a value is a member of an Array, Range, Set, String, Generator or a key of a HashMap"},
+	},
+	{
+		Name:      "array",
+		Signature: "fn(any) -> [any]",
+		BuiltIn:   &BuiltIn{Fn: nala_array, Desc: "collects the elements of an iterable (Array, Range, Set, String, Generator) into a new Array"},
+	},
+	{
+		Name:      "add",
+		Signature: "fn(#{any}, any) -> nil",
+		BuiltIn:   &BuiltIn{Fn: nala_set_add, Desc: "adds a member to a Set"},
+	},
+	{
+		Name:      "remove",
+		Signature: "fn(#{any}, any) -> nil",
+		BuiltIn:   &BuiltIn{Fn: nala_set_remove, Desc: "removes a member from a Set"},
+	},
+	{
+		Name:      "union",
+		Signature: "fn(#{any}, #{any}) -> #{any}",
+		BuiltIn:   &BuiltIn{Fn: setAlgebra("union", (*Set).Union), Desc: "returns a new Set with the members of both Sets"},
+	},
+	{
+		Name:      "intersect",
+		Signature: "fn(#{any}, #{any}) -> #{any}",
+		BuiltIn:   &BuiltIn{Fn: setAlgebra("intersect", (*Set).Intersect), Desc: "returns a new Set with the members found in both Sets"},
+	},
+	{
+		Name:      "difference",
+		Signature: "fn(#{any}, #{any}) -> #{any}",
+		BuiltIn:   &BuiltIn{Fn: setAlgebra("difference", (*Set).Difference), Desc: "returns a new Set with the members of the first Set missing from the second"},
 	},
 	{
-		Name:    "puts",
-		BuiltIn: &BuiltIn{Fn: nala_puts, Desc: "prints to standard output on the same line, with a terminating newline.\nTakes 0 or more arguments"},
+		Name:      "upper",
+		Signature: "fn(string) -> string",
+		BuiltIn:   &BuiltIn{Fn: stringTransform("upper", strings.ToUpper), Desc: "returns a String with all letters in upper case"},
 	},
 	{
-		Name:    "putl",
-		BuiltIn: &BuiltIn{Fn: nala_putl, Desc: "prints to standard output on multiple lines.\nTakes 0 or more arguments"},
+		Name:      "lower",
+		Signature: "fn(string) -> string",
+		BuiltIn:   &BuiltIn{Fn: stringTransform("lower", strings.ToLower), Desc: "returns a String with all letters in lower case"},
 	},
 	{
-		Name:    "reads",
-		BuiltIn: &BuiltIn{Fn: nala_reads, Desc: "reads string from standard input. Takes conditional prompt string"},
+		Name:      "next",
+		Signature: "fn(generator) -> any",
+		BuiltIn:   &BuiltIn{Fn: nala_next, Desc: "resumes a Generator and returns the next value it yields, or nil once it has finished"},
 	},
 	{
-		Name:    "keys",
-		BuiltIn: &BuiltIn{Fn: nala_hashmap_keys, Desc: "returns the keys of a HashMap in an Array"},
+		Name:      "spawn",
+		Signature: "fn(any, any...) -> task",
+		BuiltIn:   &BuiltIn{Fn: nala_spawn, Desc: "runs fn(args...) as a Task on its own goroutine. the Task works on copies of its arguments and of the globals"},
 	},
 	{
-		Name:    "values",
-		BuiltIn: &BuiltIn{Fn: nala_hashmap_values, Desc: "returns the keys of a HashMap in an Array"},
+		Name:      "wait",
+		Signature: "fn(task) -> any",
+		BuiltIn:   &BuiltIn{Fn: nala_wait, Desc: "blocks until a Task has finished and returns its result"},
 	},
 	{
-		Name:    "items",
-		BuiltIn: &BuiltIn{Fn: nala_hashmap_items, Desc: "returns an Array of Arrays containing Key, Value of a HashMap."},
+		Name:      "chan",
+		Signature: "fn(int?) -> chan",
+		BuiltIn:   &BuiltIn{Fn: nala_chan, Desc: "returns a new Channel. Takes an optional buffer size"},
 	},
 	{
-		Name:    "ins",
-		BuiltIn: &BuiltIn{Fn: nala_insert, Desc: "inserts a Value at a Key/Index in a HashMap/Array"},
+		Name:      "send",
+		Signature: "fn(chan, any) -> nil",
+		BuiltIn:   &BuiltIn{Fn: nala_send, Desc: "sends a copy of a value on a Channel, blocking until there is room for it"},
 	},
 	{
-		Name:    "del",
-		BuiltIn: &BuiltIn{Fn: nala_delete, Desc: "delete the Value at a Key/Index from a HashMap/Array"},
+		Name:      "recv",
+		Signature: "fn(chan) -> any",
+		BuiltIn:   &BuiltIn{Fn: nala_recv, Desc: "receives a value from a Channel, or nil once it is closed and empty"},
 	},
 	{
-		Name:    "copy",
-		BuiltIn: &BuiltIn{Fn: nala_copy, Desc: "returns a copy of an Array or HashMap"},
+		Name:      "close",
+		Signature: "fn(chan) -> nil",
+		BuiltIn:   &BuiltIn{Fn: nala_close, Desc: "closes a Channel"},
 	},
 	{
-		Name:    "desc",
-		BuiltIn: &BuiltIn{Fn: nala_showbuiltin_desc, Desc: "takes a builtin functions and shows the description"},
+		Name:      "select",
+		Signature: "fn(any, any...) -> [any]",
+		BuiltIn:   &BuiltIn{Fn: nala_select, Desc: "waits on several Channel cases. a Channel receives, a [Channel, value] pair sends. returns [case index, value]"},
 	},
 }
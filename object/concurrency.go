@@ -0,0 +1,385 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Concurrency model
+//
+// spawn runs a function as a Task on a goroutine of its own. tasks share
+// no mutable state: everything that crosses from one task to another is
+// deep copied by a Copier. that covers the arguments to spawn, the globals
+// (or environment) the task starts with, values sent on a Channel and the
+// result handed out by wait. a task that changes a global only changes its
+// own copy, so Channels are the only way to talk between tasks.
+//
+// values that never change are shared instead of copied: nil, booleans,
+// Ranges, Errors, BuiltIns and CompiledFunctions. Channels and Tasks are
+// shared too, as they are safe to use from many goroutines. struct types
+// are copied along with their methods, since setting a method changes the
+// type, which is why structs compare by declaration rather than by the
+// identity of their type. a Generator belongs to the task that created it
+// and can not cross into another one: spawning a task with one fails, and
+// so does a task reading a global (or binding) that holds one.
+//
+// the constants a compiled program starts with are shared by all of its
+// tasks, so the VM caches their hash keys before running anything. the
+// struct types among them are the exception: each task gets copies of
+// them, made by the same Copier as its globals.
+
+const (
+	TASK_OBJ    = "TASK"
+	CHANNEL_OBJ = "CHANNEL"
+)
+
+// the hash keys of the shared singletons are cached before any task runs,
+// so no task ever writes to them
+func init() {
+	TRUE.HashKey()
+	FALSE.HashKey()
+}
+
+// Task is a function running on its own goroutine
+type Task struct {
+	done   chan struct{}
+	result Object
+}
+
+func NewTask() *Task { return &Task{done: make(chan struct{})} }
+
+func (t *Task) Type() ObjectType { return TASK_OBJ }
+func (t *Task) Inspect() string  { return fmt.Sprintf("Task[%p]", t) }
+
+// Finish records the task's result and wakes everything waiting on it
+func (t *Task) Finish(result Object) {
+	t.result = result
+	close(t.done)
+}
+
+// Wait blocks until the task has finished and returns its result
+func (t *Task) Wait() Object {
+	<-t.done
+	return t.result
+}
+
+// Channel passes copies of values between tasks
+type Channel struct {
+	ch chan Object
+}
+
+func NewChannel(size int) *Channel { return &Channel{ch: make(chan Object, size)} }
+
+func (c *Channel) Type() ObjectType { return CHANNEL_OBJ }
+func (c *Channel) Inspect() string  { return fmt.Sprintf("Channel[%d/%d]", len(c.ch), cap(c.ch)) }
+
+// Send blocks until val has been handed over. sending on a closed
+// Channel is an error instead of a panic
+func (c *Channel) Send(val Object) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("send on closed channel")
+		}
+	}()
+	c.ch <- val
+	return nil
+}
+
+// Recv blocks until a value arrives. the bool is false once the
+// Channel is closed and drained
+func (c *Channel) Recv() (Object, bool) {
+	val, ok := <-c.ch
+	return val, ok
+}
+
+func (c *Channel) Close() (err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("close of closed channel")
+		}
+	}()
+	close(c.ch)
+	return nil
+}
+
+// Copier deep copies values crossing from one task to another. a value
+// reached more than once, or through a cycle of environments, is copied
+// once and the copy is reused
+type Copier struct {
+	seen map[Object]Object
+	envs map[*Environment]*Environment
+}
+
+func NewCopier() *Copier {
+	return &Copier{
+		seen: make(map[Object]Object),
+		envs: make(map[*Environment]*Environment),
+	}
+}
+
+// Copy returns a copy of obj that shares nothing mutable with it
+func (c *Copier) Copy(obj Object) (Object, error) {
+	switch obj := obj.(type) {
+	case nil:
+		return nil, nil
+	case *Integer:
+		// not shared, as hashing one caches its key inside it
+		return &Integer{Value: obj.Value}, nil
+	case *String:
+		return &String{Value: obj.Value}, nil
+	case *Boolean, *Nil, *Range, *Error, *BuiltIn, *CompiledFunction, *Channel, *Task:
+		return obj, nil
+	case Generator:
+		return nil, fmt.Errorf("cannot share %s between tasks", obj.Type())
+	}
+
+	if dup, ok := c.seen[obj]; ok {
+		return dup, nil
+	}
+
+	switch obj := obj.(type) {
+	case *Array:
+		dup := &Array{Elements: make([]Object, len(obj.Elements))}
+		c.seen[obj] = dup
+		return dup, c.copyInto(dup.Elements, obj.Elements)
+	case *HashMap:
+		dup := NewHashMap()
+		c.seen[obj] = dup
+		for _, p := range obj.Pairs() {
+			key, err := c.Copy(p.Key)
+			if err != nil {
+				return nil, err
+			}
+			val, err := c.Copy(p.Value)
+			if err != nil {
+				return nil, err
+			}
+			dup.Set(key.(Hashable), val)
+		}
+		return dup, nil
+	case *Set:
+		dup := NewSet()
+		c.seen[obj] = dup
+		for _, el := range obj.Elements() {
+			member, err := c.Copy(el)
+			if err != nil {
+				return nil, err
+			}
+			dup.Add(member.(Hashable))
+		}
+		return dup, nil
+	case *StructType:
+		dup := &StructType{Name: obj.Name, Fields: obj.Fields}
+		c.seen[obj] = dup
+		for name, method := range obj.Methods {
+			m, err := c.Copy(method)
+			if err != nil {
+				return nil, err
+			}
+			dup.SetMethod(name, m)
+		}
+		return dup, nil
+	case *Struct:
+		dup := &Struct{Values: make([]Object, len(obj.Values))}
+		c.seen[obj] = dup
+		def, err := c.Copy(obj.Def)
+		if err != nil {
+			return nil, err
+		}
+		dup.Def = def.(*StructType)
+		return dup, c.copyInto(dup.Values, obj.Values)
+	case *Closure:
+		dup := &Closure{Fn: obj.Fn, FreeVariables: make([]Object, len(obj.FreeVariables))}
+		c.seen[obj] = dup
+		return dup, c.copyInto(dup.FreeVariables, obj.FreeVariables)
+	case *Function:
+		dup := &Function{Parameters: obj.Parameters, Body: obj.Body, IsGenerator: obj.IsGenerator}
+		c.seen[obj] = dup
+		env, err := c.CopyEnvironment(obj.Env)
+		dup.Env = env
+		return dup, err
+	case *ReturnValue:
+		val, err := c.Copy(obj.Value)
+		return &ReturnValue{Value: val}, err
+	default:
+		return nil, fmt.Errorf("cannot share %s between tasks", obj.Type())
+	}
+}
+
+func (c *Copier) copyInto(dst, src []Object) error {
+	for i, el := range src {
+		dup, err := c.Copy(el)
+		if err != nil {
+			return err
+		}
+		dst[i] = dup
+	}
+	return nil
+}
+
+// CopyEnvironment copies env and every environment it extends. a binding
+// that can not be shared, like a Generator, is bound to the Copier's error
+// instead, which the evaluator raises if the task looks the name up
+func (c *Copier) CopyEnvironment(env *Environment) (*Environment, error) {
+	if env == nil {
+		return nil, nil
+	}
+	if dup, ok := c.envs[env]; ok {
+		return dup, nil
+	}
+
+	dup := NewEnvironment()
+	c.envs[env] = dup
+
+	outer, err := c.CopyEnvironment(env.extends)
+	if err != nil {
+		return nil, err
+	}
+	dup.extends = outer
+
+	for name, val := range env.store {
+		v, err := c.Copy(val)
+		if err != nil {
+			v = &Error{Message: err.Error()}
+		}
+		dup.store[name] = v
+	}
+	return dup, nil
+}
+
+// spawn has to run the function on an engine, so the VM and the evaluator
+// each bind their own version in its place. this one is only reached
+// when neither did
+func nala_spawn(args ...Object) Object {
+	return newError("spawn is not available here")
+}
+
+func nala_wait(args ...Object) Object {
+	if !argumentCountMatch(len(args), 1) {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	task, ok := args[0].(*Task)
+	if !ok {
+		return newError("argument to `wait` must be TASK, got %s", args[0].Type())
+	}
+
+	res, err := NewCopier().Copy(task.Wait())
+	if err != nil {
+		return newError("%s", err)
+	}
+	return res
+}
+
+func nala_chan(args ...Object) Object {
+	if len(args) > 1 {
+		return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+	}
+	if len(args) == 0 {
+		return NewChannel(0)
+	}
+
+	size, ok := args[0].(*Integer)
+	if !ok || size.Value < 0 {
+		return newError("argument to `chan` must be a non-negative INTEGER, got %s", args[0].Inspect())
+	}
+	return NewChannel(int(size.Value))
+}
+
+func nala_send(args ...Object) Object {
+	if !argumentCountMatch(len(args), 2) {
+		return newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	ch, ok := args[0].(*Channel)
+	if !ok {
+		return newError("argument to `send` must be CHANNEL, got %s", args[0].Type())
+	}
+
+	val, err := NewCopier().Copy(args[1])
+	if err != nil {
+		return newError("%s", err)
+	}
+	if err := ch.Send(val); err != nil {
+		return newError("%s", err)
+	}
+	return NIL
+}
+
+func nala_recv(args ...Object) Object {
+	if !argumentCountMatch(len(args), 1) {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	ch, ok := args[0].(*Channel)
+	if !ok {
+		return newError("argument to `recv` must be CHANNEL, got %s", args[0].Type())
+	}
+
+	val, ok := ch.Recv()
+	if !ok {
+		return NIL
+	}
+	return val
+}
+
+func nala_close(args ...Object) Object {
+	if !argumentCountMatch(len(args), 1) {
+		return newError("wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	ch, ok := args[0].(*Channel)
+	if !ok {
+		return newError("argument to `close` must be CHANNEL, got %s", args[0].Type())
+	}
+
+	if err := ch.Close(); err != nil {
+		return newError("%s", err)
+	}
+	return NIL
+}
+
+// select(ch, [out, value], ...) waits until one of its cases can go ahead.
+// a Channel on its own receives from it, a [Channel, value] pair sends
+// value on it. returns [index of the case, value received or nil]
+func nala_select(args ...Object) (res Object) {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want=1 or more")
+	}
+
+	cases := make([]reflect.SelectCase, len(args))
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case *Channel:
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(arg.ch)}
+		case *Array:
+			var ch *Channel
+			if len(arg.Elements) == 2 {
+				ch, _ = arg.Elements[0].(*Channel)
+			}
+			if ch == nil {
+				return newError("send case to `select` must be [CHANNEL, value], got %s", arg.Inspect())
+			}
+			val, err := NewCopier().Copy(arg.Elements[1])
+			if err != nil {
+				return newError("%s", err)
+			}
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(ch.ch), Send: reflect.ValueOf(&val).Elem()}
+		default:
+			return newError("argument to `select` must be CHANNEL or ARRAY, got %s", arg.Type())
+		}
+	}
+
+	defer func() {
+		if recover() != nil {
+			res = newError("send on closed channel")
+		}
+	}()
+
+	chosen, recv, ok := reflect.Select(cases)
+	var val Object = NIL
+	if ok {
+		val = recv.Interface().(Object)
+	}
+	return &Array{Elements: []Object{&Integer{Value: int64(chosen)}, val}}
+}
@@ -0,0 +1,49 @@
+package object
+
+import "sort"
+
+// DebugInfo ties a compiled function back to the source it came from.
+// the compiler fills it in and the VM's debugger reads it
+type DebugInfo struct {
+	Name   string      // the name the function was bound to with let, if any
+	File   string      // the file it was compiled from, if the compiler was told
+	Lines  []LineEntry // where each statement starts, in offset order
+	Locals []string    // names of the local slots, by index
+	Free   []string    // names of the free variables, by index
+}
+
+// LineEntry marks the instruction at Offset as the start of a statement
+// on Line
+type LineEntry struct {
+	Offset int
+	Line   int
+}
+
+// Line returns the line of the statement the instruction at offset
+// belongs to, or 0 when it comes before any statement
+func (d *DebugInfo) Line(offset int) int {
+	i := sort.Search(len(d.Lines), func(i int) bool { return d.Lines[i].Offset > offset })
+	if i == 0 {
+		return 0
+	}
+	return d.Lines[i-1].Line
+}
+
+// StatementAt reports whether a statement starts at offset, and on which line
+func (d *DebugInfo) StatementAt(offset int) (int, bool) {
+	i := sort.Search(len(d.Lines), func(i int) bool { return d.Lines[i].Offset >= offset })
+	if i < len(d.Lines) && d.Lines[i].Offset == offset {
+		return d.Lines[i].Line, true
+	}
+	return 0, false
+}
+
+// HasLine reports whether a statement starts on line
+func (d *DebugInfo) HasLine(line int) bool {
+	for _, l := range d.Lines {
+		if l.Line == line {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"nala/opcode"
+)
+
+// Equal reports whether a and b hold the same value. Values of
+// different types are never equal. Arrays, HashMaps and Structs are
+// compared element by element, while Functions, Closures, BuiltIns
+// and StructTypes are only equal to themselves
+func Equal(a, b Object) bool {
+	if a == b {
+		return true
+	}
+	if a == nil || b == nil || a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value == b.(*Integer).Value
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+	case *String:
+		return a.Value == b.(*String).Value
+	case *Nil:
+		return true
+	case *Array:
+		return arraysEqual(a, b.(*Array))
+	case *HashMap:
+		return hashMapsEqual(a, b.(*HashMap))
+	case *Set:
+		return setsEqual(a, b.(*Set))
+	case *Range:
+		return rangesEqual(a, b.(*Range))
+	case *Struct:
+		bSt := b.(*Struct)
+		return sameDeclaration(a.Def, bSt.Def) && arraysEqual(&Array{Elements: a.Values}, &Array{Elements: bSt.Values})
+	case *CompiledFunction:
+		bFn := b.(*CompiledFunction)
+		return a.NumOfLocals == bFn.NumOfLocals &&
+			a.NumOfParameters == bFn.NumOfParameters &&
+			bytes.Equal(a.Instructions, bFn.Instructions) &&
+			a.NumOfRegisters == bFn.NumOfRegisters &&
+			sameRegisters(a.Registers, bFn.Registers)
+	case *Error:
+		return a.Message == b.(*Error).Message
+	case *ReturnValue:
+		return Equal(a.Value, b.(*ReturnValue).Value)
+	default:
+		// Functions, Closures, BuiltIns and Generators were checked by identity above
+		return false
+	}
+}
+
+func arraysEqual(a, b *Array) bool {
+	if len(a.Elements) != len(b.Elements) {
+		return false
+	}
+	for i, el := range a.Elements {
+		if !Equal(el, b.Elements[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// HashMaps are equal when they hold the same pairs, whatever their order
+func hashMapsEqual(a, b *HashMap) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	for _, p := range a.order {
+		other, ok := b.Get(p.Key.(Hashable))
+		if !ok || !Equal(p.Value, other.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sets are equal when they hold the same members, whatever their order
+func setsEqual(a, b *Set) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	for _, el := range a.Elements() {
+		if !b.Has(el.(Hashable)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Ranges are equal when they produce the same elements
+func rangesEqual(a, b *Range) bool {
+	n := a.Len()
+	if n != b.Len() {
+		return false
+	}
+	if n == 0 {
+		return true
+	}
+	return a.Start == b.Start && (n == 1 || a.Step == b.Step)
+}
+
+// Compare orders a and b, returning -1, 0 or 1 when a is less than,
+// equal to or greater than b. Integers compare numerically, while
+// Strings and Arrays compare lexicographically
+func Compare(a, b Object) (int, error) {
+	if a.Type() != b.Type() {
+		return 0, fmt.Errorf("cannot compare %s with %s", a.Type(), b.Type())
+	}
+
+	switch a := a.(type) {
+	case *Integer:
+		return compareInts(a.Value, b.(*Integer).Value), nil
+	case *String:
+		bVal := b.(*String).Value
+		switch {
+		case a.Value < bVal:
+			return -1, nil
+		case a.Value > bVal:
+			return 1, nil
+		}
+		return 0, nil
+	case *Array:
+		return compareArrays(a, b.(*Array))
+	default:
+		return 0, fmt.Errorf("cannot compare %s values", a.Type())
+	}
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// the first differing element decides, otherwise the shorter Array is smaller
+func compareArrays(a, b *Array) (int, error) {
+	for i := 0; i < len(a.Elements) && i < len(b.Elements); i++ {
+		res, err := Compare(a.Elements[i], b.Elements[i])
+		if err != nil || res != 0 {
+			return res, err
+		}
+	}
+	return compareInts(int64(len(a.Elements)), int64(len(b.Elements))), nil
+}
+
+// struct types are copied when they cross into another task, so two
+// types are the same if they were declared with the same name and fields
+func sameDeclaration(a, b *StructType) bool {
+	if a == b {
+		return true
+	}
+	if a.Name != b.Name || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if a.Fields[i] != b.Fields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameRegisters(a, b opcode.RegInstructions) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, in := range a {
+		if in != b[i] {
+			return false
+		}
+	}
+	return true
+}
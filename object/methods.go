@@ -0,0 +1,45 @@
+package object
+
+// builtin methods available on each receiver type. calling one passes the
+// receiver as the first argument, so arr.push(1) is the same as push(arr, 1)
+var builtinMethods = map[ObjectType][]string{
+	ARRAY_OBJ:     {"len", "first", "last", "rest", "push", "ins", "del", "copy", "has"},
+	STRING_OBJ:    {"len", "upper", "lower", "has", "array"},
+	HASHMAP_OBJ:   {"len", "keys", "values", "items", "ins", "del", "copy", "has"},
+	SET_OBJ:       {"len", "add", "remove", "has", "union", "intersect", "difference", "copy", "array"},
+	RANGE_OBJ:     {"len", "first", "last", "rest", "has", "array"},
+	GENERATOR_OBJ: {"next", "has", "array"},
+	TASK_OBJ:      {"wait"},
+	CHANNEL_OBJ:   {"send", "recv", "close"},
+}
+
+// ResolveMethod finds the function that receiver.name(...) calls. the
+// function expects the receiver as its first argument. methods defined
+// on a struct's type are looked up first, then the builtin methods for
+// the receiver's type. every value also has a type() method
+func ResolveMethod(receiver Object, name string) (Object, bool) {
+	if st, ok := receiver.(*Struct); ok {
+		if method, ok := st.Def.Methods[name]; ok {
+			return method, true
+		}
+	}
+
+	if name == "type" {
+		return GetBuiltinByName(name), true
+	}
+
+	for _, method := range builtinMethods[receiver.Type()] {
+		if method == name {
+			return GetBuiltinByName(name), true
+		}
+	}
+	return nil, false
+}
+
+// MethodError describes a failed method lookup the same way for both engines
+func MethodError(receiver Object, name string) string {
+	if st, ok := receiver.(*Struct); ok {
+		return "undefined method " + name + " for struct " + st.Def.Name
+	}
+	return "undefined method " + name + " for " + string(receiver.Type())
+}
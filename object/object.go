@@ -24,9 +24,19 @@ const (
 	HASHMAP_OBJ           = "HASHMAP"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNC"
 	CLOSURE_OBJ           = "CLOSURE"
+	RANGE_OBJ             = "RANGE"
+	SET_OBJ               = "SET"
+	STRUCT_TYPE_OBJ       = "STRUCT_TYPE"
+	STRUCT_OBJ            = "STRUCT"
+	GENERATOR_OBJ         = "GENERATOR"
 )
 
-var NIL = &Nil{}
+// shared singletons, so both engines and the builtins can compare by pointer
+var (
+	NIL   = &Nil{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
 
 type Object interface {
 	Type() ObjectType
@@ -34,9 +44,35 @@ type Object interface {
 }
 
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
+// Iterable is implemented by objects whose elements can be walked in order
+// without first collecting them into an Array
+type Iterable interface {
+	Iterator() Iterator
+}
+
+// Iterator hands out the elements of an Iterable one at a time.
+// the bool is false once there are no elements left
+type Iterator interface {
+	Next() (Object, bool)
+}
+
+// Generator is a suspended call to a generator function. the VM and the
+// evaluator each provide their own, but both resume it through Next, which
+// runs the function up to its next yield. a Generator is its own Iterator,
+// so walking it consumes the values it yields. once the function has
+// returned, Returned is what it returned, and what a yield from of the
+// Generator evaluates to
+type Generator interface {
+	Object
+	Iterable
+	Iterator
+	Returned() Object
+}
+
 type Integer struct {
 	Value       int64
 	HashableKey *HashKey
@@ -92,9 +128,10 @@ func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "Error: " + e.Message }
 
 type Function struct {
-	Parameters []*ast.Identifier
-	Body       *ast.BlockStatement
-	Env        *Environment
+	Parameters  []*ast.Identifier
+	Body        *ast.BlockStatement
+	Env         *Environment
+	IsGenerator bool
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -119,7 +156,13 @@ type CompiledFunction struct {
 	Instructions    opcode.Instructions
 	NumOfLocals     int
 	NumOfParameters int
+	IsGenerator     bool
 	HashableKey     *HashKey
+	Debug           *DebugInfo // nil for functions not built by the compiler
+
+	// the function compiled for the register VM instead, see compiler.RegisterCompiler
+	Registers      opcode.RegInstructions
+	NumOfRegisters int
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -130,6 +173,9 @@ func (cf *CompiledFunction) HashKey() HashKey {
 	if cf.HashableKey == nil {
 		h := fnv.New64a()
 		h.Write([]byte(cf.Instructions))
+		for _, in := range cf.Registers {
+			fmt.Fprintf(h, "%d %d %d %d;", in.Op, in.A, in.B, in.C)
+		}
 		cf.HashableKey = &HashKey{
 			Type:      cf.Type(),
 			HashValue: h.Sum64(),
@@ -141,22 +187,22 @@ func (cf *CompiledFunction) HashKey() HashKey {
 type Closure struct {
 	Fn            *CompiledFunction
 	FreeVariables []Object
-	HashableKey   *HashKey
 }
 
 func (c *Closure) Type() ObjectType { return CLOSURE_OBJ }
 func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%d]", c.HashKey().HashValue)
 }
+
+// the captured variables are part of a Closure's hash, so closures over
+// different values of the same function land in different buckets.
+// it is not cached as captured Arrays and HashMaps can still change
 func (c *Closure) HashKey() HashKey {
-	if c.HashableKey == nil {
-		fnHash := c.Fn.HashKey()
-		c.HashableKey = &HashKey{
-			Type:      c.Type(),
-			HashValue: fnHash.HashValue,
-		}
+	h := c.Fn.HashKey().HashValue
+	for _, free := range c.FreeVariables {
+		h = combineHashes(h, hashOf(free))
 	}
-	return *c.HashableKey
+	return HashKey{Type: c.Type(), HashValue: h}
 }
 
 type String struct {
@@ -174,6 +220,22 @@ func (s *String) HashKey() HashKey {
 	}
 	return *s.HashableKey
 }
+func (s *String) Iterator() Iterator { return &stringIterator{str: s.Value} }
+
+// walks a String one character (as a String) at a time
+type stringIterator struct {
+	str string
+	pos int
+}
+
+func (si *stringIterator) Next() (Object, bool) {
+	if si.pos >= len(si.str) {
+		return nil, false
+	}
+	ch := si.str[si.pos : si.pos+1]
+	si.pos++
+	return &String{Value: ch}, true
+}
 
 type Array struct {
 	Elements []Object
@@ -195,10 +257,115 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
+// an Array hashes by its elements in order. the hash is recomputed on every
+// call since the Array can be changed with ins() and del()
+func (a *Array) HashKey() HashKey {
+	h := fnvHash(ARRAY_OBJ)
+	for _, el := range a.Elements {
+		h = combineHashes(h, hashOf(el))
+	}
+	return HashKey{Type: a.Type(), HashValue: h}
+}
+func (a *Array) Iterator() Iterator { return &arrayIterator{arr: a} }
+
+type arrayIterator struct {
+	arr *Array
+	pos int
+}
+
+func (ai *arrayIterator) Next() (Object, bool) {
+	if ai.pos >= len(ai.arr.Elements) {
+		return nil, false
+	}
+	el := ai.arr.Elements[ai.pos]
+	ai.pos++
+	return el, true
+}
+
+// Range is a lazy arithmetic progression from Start up to (but excluding) End.
+// elements are computed on demand, so counting with a Range never builds an Array
+type Range struct {
+	Start int64
+	End   int64
+	Step  int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// Len returns the number of elements in the Range. range() refuses to make
+// a Range with more elements than an int64 holds
+func (r *Range) Len() int64 {
+	return int64(r.Count())
+}
+
+// Count returns the number of elements in the Range, worked out in uint64
+// since the distance between Start and End can overflow an int64
+func (r *Range) Count() uint64 {
+	var span, step uint64
+	switch {
+	case r.Step > 0 && r.Start < r.End:
+		span, step = uint64(r.End)-uint64(r.Start), uint64(r.Step)
+	case r.Step < 0 && r.Start > r.End:
+		span, step = uint64(r.Start)-uint64(r.End), -uint64(r.Step)
+	default:
+		return 0
+	}
+	n := span / step
+	if span%step != 0 {
+		n++
+	}
+	return n
+}
+
+// At returns the element at index i, if i is within the Range
+func (r *Range) At(i int64) (int64, bool) {
+	if i < 0 || i >= r.Len() {
+		return 0, false
+	}
+	return r.Start + i*r.Step, true
+}
+
+// Contains reports whether v is one of the elements of the Range
+func (r *Range) Contains(v int64) bool {
+	if r.Len() == 0 {
+		return false
+	}
+	if r.Step > 0 && (v < r.Start || v >= r.End) {
+		return false
+	}
+	if r.Step < 0 && (v > r.Start || v <= r.End) {
+		return false
+	}
+	if r.Step > 0 {
+		return (uint64(v)-uint64(r.Start))%uint64(r.Step) == 0
+	}
+	return (uint64(r.Start)-uint64(v))%-uint64(r.Step) == 0
+}
+
+func (r *Range) Iterator() Iterator { return &rangeIterator{rng: r} }
+
+type rangeIterator struct {
+	rng *Range
+	pos int64
+}
+
+func (ri *rangeIterator) Next() (Object, bool) {
+	val, ok := ri.rng.At(ri.pos)
+	if !ok {
+		return nil, false
+	}
+	ri.pos++
+	return &Integer{Value: val}, true
+}
+
 // the key used in our HashMaps
 // hashed from true Values of Expressions
-// to prevent pointer comparison
-// TODO: cache these values so they are not recomputed everytime
+// to prevent pointer comparison.
+// different keys can share a HashKey, so HashMaps
+// still compare the keys themselves with Equal
 type HashKey struct {
 	Type      ObjectType
 	HashValue uint64
@@ -211,9 +378,16 @@ type HashPair struct {
 	Value Object
 }
 
-// HashMap
+// HashMap keeps its pairs in insertion order, so printing it and
+// walking its keys(), values() and items() is deterministic.
+// pairs whose keys collide share a bucket and are told apart with Equal
 type HashMap struct {
-	Pairs map[HashKey]HashPair
+	buckets map[HashKey][]*HashPair
+	order   []*HashPair // pairs in the order their keys were first inserted
+}
+
+func NewHashMap() *HashMap {
+	return &HashMap{buckets: make(map[HashKey][]*HashPair)}
 }
 
 func (hm *HashMap) Type() ObjectType { return HASHMAP_OBJ }
@@ -222,7 +396,7 @@ func (hm *HashMap) Inspect() string {
 
 	pairs := []string{}
 
-	for _, p := range hm.Pairs {
+	for _, p := range hm.Pairs() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			p.Key.Inspect(), p.Value.Inspect()))
 	}
@@ -234,6 +408,289 @@ func (hm *HashMap) Inspect() string {
 	return out.String()
 }
 
+// a HashMap hashes the same regardless of insertion order, since two
+// HashMaps with the same pairs are Equal. like Arrays, it is never cached
+func (hm *HashMap) HashKey() HashKey {
+	h := fnvHash(HASHMAP_OBJ)
+	for _, p := range hm.order {
+		h += combineHashes(hashOf(p.Key), hashOf(p.Value))
+	}
+	return HashKey{Type: hm.Type(), HashValue: h}
+}
+
+// finds the pair stored under key within its bucket
+func (hm *HashMap) lookup(key Hashable) (*HashPair, HashKey) {
+	hsh := key.HashKey()
+	for _, p := range hm.buckets[hsh] {
+		if Equal(p.Key, key) {
+			return p, hsh
+		}
+	}
+	return nil, hsh
+}
+
+// Get returns the pair stored under key
+func (hm *HashMap) Get(key Hashable) (HashPair, bool) {
+	p, _ := hm.lookup(key)
+	if p == nil {
+		return HashPair{}, false
+	}
+	return *p, true
+}
+
+// Set stores value under key. updating an existing key keeps its position
+func (hm *HashMap) Set(key Hashable, value Object) {
+	p, hsh := hm.lookup(key)
+	if p != nil {
+		p.Value = value
+		return
+	}
+
+	p = &HashPair{Key: key, Value: value}
+	hm.buckets[hsh] = append(hm.buckets[hsh], p)
+	hm.order = append(hm.order, p)
+}
+
+// Delete removes key from the HashMap, reporting whether it was there
+func (hm *HashMap) Delete(key Hashable) bool {
+	p, hsh := hm.lookup(key)
+	if p == nil {
+		return false
+	}
+
+	hm.buckets[hsh] = removePair(hm.buckets[hsh], p)
+	if len(hm.buckets[hsh]) == 0 {
+		delete(hm.buckets, hsh)
+	}
+	hm.order = removePair(hm.order, p)
+	return true
+}
+
+func (hm *HashMap) Len() int { return len(hm.order) }
+
+// Pairs returns the key:value pairs in insertion order
+func (hm *HashMap) Pairs() []HashPair {
+	pairs := make([]HashPair, 0, len(hm.order))
+	for _, p := range hm.order {
+		pairs = append(pairs, *p)
+	}
+	return pairs
+}
+
+func removePair(pairs []*HashPair, target *HashPair) []*HashPair {
+	for i, p := range pairs {
+		if p == target {
+			return append(pairs[:i:i], pairs[i+1:]...)
+		}
+	}
+	return pairs
+}
+
+// Set holds unique members in insertion order. it is backed by a
+// HashMap whose keys are the members, so membership uses the same
+// hashing and collision handling
+type Set struct {
+	members *HashMap
+}
+
+func NewSet() *Set {
+	return &Set{members: NewHashMap()}
+}
+
+func (s *Set) Type() ObjectType { return SET_OBJ }
+func (s *Set) Inspect() string {
+	var out bytes.Buffer
+
+	elems := []string{}
+
+	for _, el := range s.Elements() {
+		elems = append(elems, el.Inspect())
+	}
+
+	out.WriteString("#{")
+	out.WriteString(strings.Join(elems, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// like HashMaps, Sets hash the same regardless of insertion order
+func (s *Set) HashKey() HashKey {
+	h := fnvHash(SET_OBJ)
+	for _, p := range s.members.order {
+		h += hashOf(p.Key)
+	}
+	return HashKey{Type: s.Type(), HashValue: h}
+}
+
+func (s *Set) Add(member Hashable) { s.members.Set(member, NIL) }
+
+// Remove takes member out of the Set, reporting whether it was there
+func (s *Set) Remove(member Hashable) bool { return s.members.Delete(member) }
+
+func (s *Set) Has(member Hashable) bool {
+	_, ok := s.members.Get(member)
+	return ok
+}
+
+func (s *Set) Len() int { return s.members.Len() }
+
+// Elements returns the members in insertion order
+func (s *Set) Elements() []Object {
+	elems := make([]Object, 0, s.Len())
+	for _, p := range s.members.order {
+		elems = append(elems, p.Key)
+	}
+	return elems
+}
+
+func (s *Set) Iterator() Iterator {
+	return &arrayIterator{arr: &Array{Elements: s.Elements()}}
+}
+
+// Union returns a new Set with the members of s followed by those of other
+func (s *Set) Union(other *Set) *Set {
+	res := NewSet()
+	for _, el := range s.Elements() {
+		res.Add(el.(Hashable))
+	}
+	for _, el := range other.Elements() {
+		res.Add(el.(Hashable))
+	}
+	return res
+}
+
+// Intersect returns a new Set with the members of s that are also in other
+func (s *Set) Intersect(other *Set) *Set {
+	res := NewSet()
+	for _, el := range s.Elements() {
+		if other.Has(el.(Hashable)) {
+			res.Add(el.(Hashable))
+		}
+	}
+	return res
+}
+
+// Difference returns a new Set with the members of s that are not in other
+func (s *Set) Difference(other *Set) *Set {
+	res := NewSet()
+	for _, el := range s.Elements() {
+		if !other.Has(el.(Hashable)) {
+			res.Add(el.(Hashable))
+		}
+	}
+	return res
+}
+
+// StructType is a declared struct. calling it constructs a Struct,
+// taking one argument per field in declaration order.
+// Methods are functions taking the struct value as their first argument
+type StructType struct {
+	Name    string
+	Fields  []string
+	Methods map[string]Object
+}
+
+func (st *StructType) Type() ObjectType { return STRUCT_TYPE_OBJ }
+func (st *StructType) Inspect() string {
+	return fmt.Sprintf("struct %s { %s }", st.Name, strings.Join(st.Fields, ", "))
+}
+
+// FieldIndex returns the position of field within the struct's values
+func (st *StructType) FieldIndex(field string) (int, bool) {
+	for i, f := range st.Fields {
+		if f == field {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Construct builds a Struct from args, which must match the fields one to one
+func (st *StructType) Construct(args ...Object) Object {
+	if len(args) != len(st.Fields) {
+		return newError("wrong number of arguments to %s. got=%d, want=%d",
+			st.Name, len(args), len(st.Fields))
+	}
+
+	values := make([]Object, len(args))
+	copy(values, args)
+	return &Struct{Def: st, Values: values}
+}
+
+// SetMethod defines (or replaces) the method name on values of this type
+func (st *StructType) SetMethod(name string, fn Object) {
+	if st.Methods == nil {
+		st.Methods = make(map[string]Object)
+	}
+	st.Methods[name] = fn
+}
+
+// Struct is a value of a StructType, holding one value per field. its
+// fields can be set, which would change a hash of them, so unlike the
+// other values compared structurally a Struct is not Hashable
+type Struct struct {
+	Def    *StructType
+	Values []Object
+}
+
+func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
+func (s *Struct) Inspect() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for i, f := range s.Def.Fields {
+		fields = append(fields, fmt.Sprintf("%s: %s", f, s.Values[i].Inspect()))
+	}
+
+	out.WriteString(s.Def.Name)
+	out.WriteString("{")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+func (s *Struct) Get(field string) (Object, bool) {
+	i, ok := s.Def.FieldIndex(field)
+	if !ok {
+		return nil, false
+	}
+	return s.Values[i], true
+}
+
+// Set updates field, reporting whether the struct has it
+func (s *Struct) Set(field string, value Object) bool {
+	i, ok := s.Def.FieldIndex(field)
+	if !ok {
+		return false
+	}
+	s.Values[i] = value
+	return true
+}
+
+// hashOf returns the hash of any object. objects that can't be used as
+// keys on their own still need a hash when they sit inside an Array,
+// so they all fall back to the hash of their type
+func hashOf(obj Object) uint64 {
+	if h, ok := obj.(Hashable); ok {
+		key := h.HashKey()
+		return combineHashes(fnvHash(string(key.Type)), key.HashValue)
+	}
+	return fnvHash(string(obj.Type()))
+}
+
+func fnvHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mixes h into seed so the order of combination matters
+func combineHashes(seed, h uint64) uint64 {
+	return seed ^ (h + 0x9e3779b97f4a7c15 + (seed << 6) + (seed >> 2))
+}
+
 type BuiltInFunction func(args ...Object) Object
 
 type BuiltIn struct {
@@ -254,6 +711,7 @@ type NameObjectPairs map[string]Object
 type Environment struct {
 	store   NameObjectPairs
 	extends *Environment
+	yield   func(Object) // set on the environment of a running generator
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -277,6 +735,12 @@ func (e *Environment) Get(name string) (Object, bool) {
 
 func (e *Environment) GetStore() NameObjectPairs { return e.store }
 
+// SetYield hooks up the function a yield in this environment hands its value to
+func (e *Environment) SetYield(yield func(Object)) { e.yield = yield }
+
+// Yield returns the hook set by SetYield, or nil outside of a generator
+func (e *Environment) Yield() func(Object) { return e.yield }
+
 func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
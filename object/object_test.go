@@ -1,6 +1,9 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	h1 := &String{Value: "Hello World"}
@@ -20,3 +23,289 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		rng      *Range
+		inspect  string
+		elements []int64
+	}{
+		{&Range{Start: 0, End: 5, Step: 1}, "range(0, 5, 1)", []int64{0, 1, 2, 3, 4}},
+		{&Range{Start: 1, End: 10, Step: 4}, "range(1, 10, 4)", []int64{1, 5, 9}},
+		{&Range{Start: 5, End: 0, Step: -2}, "range(5, 0, -2)", []int64{5, 3, 1}},
+		{&Range{Start: 5, End: 5, Step: 1}, "range(5, 5, 1)", []int64{}},
+		{&Range{Start: 5, End: 0, Step: 1}, "range(5, 0, 1)", []int64{}},
+		{&Range{Start: math.MinInt64, End: math.MaxInt64, Step: math.MaxInt64}, "range(-9223372036854775808, 9223372036854775807, 9223372036854775807)",
+			[]int64{math.MinInt64, -1, math.MaxInt64 - 1}},
+		{&Range{Start: math.MaxInt64, End: math.MinInt64, Step: math.MinInt64}, "range(9223372036854775807, -9223372036854775808, -9223372036854775808)",
+			[]int64{math.MaxInt64, -1}},
+	}
+
+	for _, tt := range tests {
+		if tt.rng.Inspect() != tt.inspect {
+			t.Errorf("wrong Inspect. want=%q, got=%q", tt.inspect, tt.rng.Inspect())
+		}
+
+		if tt.rng.Len() != int64(len(tt.elements)) {
+			t.Fatalf("wrong Len for %s. want=%d, got=%d", tt.inspect, len(tt.elements), tt.rng.Len())
+		}
+
+		iter := tt.rng.Iterator()
+		for i, exp := range tt.elements {
+			el, ok := iter.Next()
+			if !ok {
+				t.Fatalf("iterator of %s ended early at %d", tt.inspect, i)
+			}
+			if el.(*Integer).Value != exp {
+				t.Errorf("wrong element %d of %s. want=%d, got=%s", i, tt.inspect, exp, el.Inspect())
+			}
+			if !tt.rng.Contains(exp) {
+				t.Errorf("%s should contain %d", tt.inspect, exp)
+			}
+		}
+
+		if _, ok := iter.Next(); ok {
+			t.Errorf("iterator of %s did not end", tt.inspect)
+		}
+	}
+}
+
+func TestHashMapCollisions(t *testing.T) {
+	// force two different strings into the same bucket
+	collide := HashKey{Type: STRING_OBJ, HashValue: 1}
+	k1 := &String{Value: "one", HashableKey: &collide}
+	k2 := &String{Value: "two", HashableKey: &collide}
+
+	hm := NewHashMap()
+	hm.Set(k1, &Integer{Value: 1})
+	hm.Set(k2, &Integer{Value: 2})
+
+	if hm.Len() != 2 {
+		t.Fatalf("colliding keys overwrote each other. got Len=%d", hm.Len())
+	}
+
+	for i, k := range []*String{k1, k2} {
+		pair, ok := hm.Get(k)
+		if !ok {
+			t.Fatalf("no pair for colliding key %q", k.Value)
+		}
+		if pair.Value.(*Integer).Value != int64(i+1) {
+			t.Errorf("wrong value for %q. want=%d, got=%s", k.Value, i+1, pair.Value.Inspect())
+		}
+	}
+
+	if !hm.Delete(k1) {
+		t.Fatalf("could not delete colliding key %q", k1.Value)
+	}
+	if _, ok := hm.Get(k2); !ok {
+		t.Errorf("deleting %q removed %q", k1.Value, k2.Value)
+	}
+}
+
+func TestStructuralHashKeys(t *testing.T) {
+	a1 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	a2 := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}}
+	a3 := &Array{Elements: []Object{&String{Value: "a"}, &Integer{Value: 1}}}
+
+	if a1.HashKey() != a2.HashKey() || !Equal(a1, a2) {
+		t.Errorf("arrays with same elements are not the same key")
+	}
+	if a1.HashKey() == a3.HashKey() || Equal(a1, a3) {
+		t.Errorf("arrays with reordered elements are the same key")
+	}
+
+	h1 := NewHashMap()
+	h1.Set(&String{Value: "x"}, &Integer{Value: 1})
+	h1.Set(&String{Value: "y"}, a1)
+	h2 := NewHashMap()
+	h2.Set(&String{Value: "y"}, a2)
+	h2.Set(&String{Value: "x"}, &Integer{Value: 1})
+
+	if h1.HashKey() != h2.HashKey() || !Equal(h1, h2) {
+		t.Errorf("hashmaps with same pairs in different order are not the same key")
+	}
+
+	h2.Set(&String{Value: "x"}, &Integer{Value: 2})
+	if Equal(h1, h2) {
+		t.Errorf("hashmaps with different values are equal")
+	}
+
+	fn := &CompiledFunction{}
+	c1 := &Closure{Fn: fn, FreeVariables: []Object{&Integer{Value: 1}}}
+	c2 := &Closure{Fn: fn, FreeVariables: []Object{&Integer{Value: 2}}}
+	if c1.HashKey() == c2.HashKey() {
+		t.Errorf("closures with different captured variables have same hash keys")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	arr := func(vals ...int64) *Array {
+		els := []Object{}
+		for _, v := range vals {
+			els = append(els, &Integer{Value: v})
+		}
+		return &Array{Elements: els}
+	}
+
+	tests := []struct {
+		a, b     Object
+		expected int
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 2}, -1},
+		{&Integer{Value: 2}, &Integer{Value: 2}, 0},
+		{&String{Value: "b"}, &String{Value: "abc"}, 1},
+		{arr(1, 2), arr(1, 3), -1},
+		{arr(1, 2), arr(1, 2, 0), -1},
+		{arr(2), arr(1, 9, 9), 1},
+		{arr(), arr(), 0},
+	}
+
+	for _, tt := range tests {
+		res, err := Compare(tt.a, tt.b)
+		if err != nil {
+			t.Fatalf("unexpected error comparing %s and %s: %s", tt.a.Inspect(), tt.b.Inspect(), err)
+		}
+		if res != tt.expected {
+			t.Errorf("wrong ordering of %s and %s. want=%d, got=%d", tt.a.Inspect(), tt.b.Inspect(), tt.expected, res)
+		}
+	}
+
+	if _, err := Compare(&Integer{Value: 1}, &String{Value: "1"}); err == nil {
+		t.Errorf("comparing INTEGER with STRING should fail")
+	}
+	if _, err := Compare(TRUE, FALSE); err == nil {
+		t.Errorf("comparing BOOLEAN values should fail")
+	}
+}
+
+func TestEqualAcrossTypes(t *testing.T) {
+	builtin := &BuiltIn{}
+	if !Equal(builtin, builtin) || Equal(builtin, &BuiltIn{}) {
+		t.Errorf("builtins should only equal themselves")
+	}
+
+	fn := &CompiledFunction{}
+	c1 := &Closure{Fn: fn}
+	c2 := &Closure{Fn: fn}
+	if !Equal(c1, c1) || Equal(c1, c2) {
+		t.Errorf("closures should only equal themselves")
+	}
+
+	if Equal(&Integer{Value: 1}, &String{Value: "1"}) {
+		t.Errorf("values of different types should not be equal")
+	}
+	if !Equal(NIL, &Nil{}) {
+		t.Errorf("nil should equal nil")
+	}
+}
+
+func TestResolveMethod(t *testing.T) {
+	def := &StructType{Name: "P", Fields: []string{"n"}}
+	greet := &BuiltIn{}
+	def.SetMethod("greet", greet)
+	p := def.Construct(&Integer{Value: 1})
+
+	tests := []struct {
+		receiver Object
+		name     string
+		expected Object
+	}{
+		{&Array{}, "push", GetBuiltinByName("push")},
+		{&String{Value: "a"}, "upper", GetBuiltinByName("upper")},
+		{NewSet(), "union", GetBuiltinByName("union")},
+		{&Integer{Value: 1}, "type", GetBuiltinByName("type")},
+		{p, "greet", greet},
+		{p, "type", GetBuiltinByName("type")},
+		{&Array{}, "upper", nil},
+		{p, "push", nil},
+	}
+
+	for _, tt := range tests {
+		method, ok := ResolveMethod(tt.receiver, tt.name)
+		if tt.expected == nil {
+			if ok {
+				t.Errorf("%s should not have method %s", tt.receiver.Type(), tt.name)
+			}
+			continue
+		}
+		if !ok || method != tt.expected {
+			t.Errorf("wrong method %s for %s. got=%v", tt.name, tt.receiver.Type(), method)
+		}
+	}
+}
+
+func TestCopier(t *testing.T) {
+	def := &StructType{Name: "P", Fields: []string{"x"}}
+	inner := &Array{Elements: []Object{&Integer{Value: 1}}}
+	hm := NewHashMap()
+	hm.Set(&String{Value: "k"}, inner)
+	st := def.Construct(inner).(*Struct)
+	// the same Array is reachable three times
+	orig := &Array{Elements: []Object{inner, hm, st, TRUE, NIL}}
+
+	c := NewCopier()
+	dup, err := c.Copy(orig)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !Equal(orig, dup) {
+		t.Fatalf("copy is not equal to the original. got=%s", dup.Inspect())
+	}
+
+	dupArr := dup.(*Array)
+	dupInner := dupArr.Elements[0].(*Array)
+	if dupInner == inner {
+		t.Errorf("nested Array was shared instead of copied")
+	}
+	pair, _ := dupArr.Elements[1].(*HashMap).Get(&String{Value: "k"})
+	if pair.Value != dupInner || dupArr.Elements[2].(*Struct).Values[0] != dupInner {
+		t.Errorf("a value reached twice should be copied once")
+	}
+	if dupArr.Elements[2].(*Struct).Def == def {
+		t.Errorf("struct type was shared instead of copied")
+	}
+	if dupArr.Elements[3] != TRUE || dupArr.Elements[4] != NIL {
+		t.Errorf("singletons should be shared")
+	}
+
+	dupInner.Elements[0] = &Integer{Value: 2}
+	if inner.Elements[0].(*Integer).Value != 1 {
+		t.Errorf("changing the copy changed the original")
+	}
+
+	env := NewEnvironment()
+	fn := &Function{Env: NewEnclosedEnvironment(env)}
+	env.Set("self", fn)
+	dupFn, err := c.Copy(fn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	self, _ := dupFn.(*Function).Env.Get("self")
+	if self != dupFn {
+		t.Errorf("a Function reachable from its own environment should point at its copy")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	a, b := NewChannel(0), NewChannel(1)
+	b.Send(&String{Value: "b"})
+
+	res := nala_select(a, b)
+	if res.Inspect() != "[1, b]" {
+		t.Errorf("wrong select result. want=%q, got=%q", "[1, b]", res.Inspect())
+	}
+
+	res = nala_select(a, &Array{Elements: []Object{b, &Integer{Value: 3}}})
+	if res.Inspect() != "[1, nil]" {
+		t.Errorf("wrong select result. want=%q, got=%q", "[1, nil]", res.Inspect())
+	}
+	if val, _ := b.Recv(); val.Inspect() != "3" {
+		t.Errorf("select did not send. got=%s", val.Inspect())
+	}
+
+	b.Close()
+	res = nala_select(&Array{Elements: []Object{b, &Integer{Value: 3}}})
+	if res.Inspect() != "Error: send on closed channel" {
+		t.Errorf("expected send on closed channel error. got=%q", res.Inspect())
+	}
+}
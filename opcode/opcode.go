@@ -16,16 +16,19 @@ func (ins Instructions) String() string {
 
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(ins[i]) // Lookup operator to get operand widths
+		in, err := Decode(ins[i:]) // Lookup operator and read its operands
 		if err != nil {
 			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			break
 		}
-		operands, read := ReadOperands(def, ins[i+1:])
 
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		prefix := ""
+		if in.Wide {
+			prefix = "OpWide "
+		}
+		fmt.Fprintf(&out, "%04d %s%s\n", i, prefix, ins.fmtInstruction(in.Def, in.Operands))
 
-		i += read + 1
+		i += in.Size
 	}
 	return out.String()
 }
@@ -94,6 +97,27 @@ const (
 	OpGetBuiltin
 	OpClosure
 	OpGetFree
+	OpSet
+	OpGetField
+	OpSetField
+	OpCallMethod
+	OpYield
+	OpYieldFrom
+	OpWide
+
+	// superinstructions the optimizer picks for common sequences
+	OpGetLocal0
+	OpGetLocal1
+	OpGetLocal2
+	OpGetLocal3
+	OpAddConstant
+	OpSubtractConstant
+	OpLThanConstant
+	OpGThanConstant
+	OpEqualConstant
+	OpNotEqualConstant
+	OpCallGlobal
+	OpTailCall
 )
 
 var definitions = map[OpCode]*Definition{
@@ -131,6 +155,60 @@ var definitions = map[OpCode]*Definition{
 	// to be bundled together w the CompiledFunction into a Closure object
 	OpGetFree: {"OpGetFree", []int{1}}, // pushes a Free variable from inside the closure
 	// onto the stack
+	OpSet: {"OpSet", []int{2}}, // bundles that many members on the stack into a Set
+	// both take the constant index of the field name. OpSetField pops the value and
+	// the struct, then pushes the value back as the result of the assignment
+	OpGetField: {"OpGetField", []int{2}},
+	OpSetField: {"OpSetField", []int{2}},
+	// takes the constant index of the method name and the number of arguments
+	// sitting on the stack above the receiver
+	OpCallMethod: {"OpCallMethod", []int{2, 1}},
+	// pops the value on top of the stack and suspends the running generator
+	// with it. the yield expression itself evaluates to nil once resumed
+	OpYield: {"OpYield", []int{}},
+	// suspends the generator with each element of the iterable on top of
+	// the stack in turn, then leaves nil in its place, or what a generator
+	// returned
+	OpYieldFrom: {"OpYieldFrom", []int{}},
+	// prefixes an instruction whose operands are too big for their usual
+	// widths. each of them then takes twice as many bytes
+	OpWide: {"OpWide", []int{}},
+	// OpGetLocal with the first four locals built in
+	OpGetLocal0: {"OpGetLocal0", []int{}},
+	OpGetLocal1: {"OpGetLocal1", []int{}},
+	OpGetLocal2: {"OpGetLocal2", []int{}},
+	OpGetLocal3: {"OpGetLocal3", []int{}},
+	// OpConstant followed by the operator, in one. each takes the constant
+	// index of the right operand, the left one being on top of the stack
+	OpAddConstant:      {"OpAddConstant", []int{2}},
+	OpSubtractConstant: {"OpSubtractConstant", []int{2}},
+	OpLThanConstant:    {"OpLessThanConstant", []int{2}},
+	OpGThanConstant:    {"OpGreaterThanConstant", []int{2}},
+	OpEqualConstant:    {"OpEqualConstant", []int{2}},
+	OpNotEqualConstant: {"OpNotEqualConstant", []int{2}},
+	// calls the global at the index with the arguments on the stack, which
+	// aren't pushed above the function as for OpCall
+	OpCallGlobal: {"OpCallGlobal", []int{2, 1}},
+	// OpCall in front of an OpReturnValue. a closure called this way takes
+	// over the frame of the caller
+	OpTailCall: {"OpTailCall", []int{1}},
+}
+
+// the superinstruction for each operator applied to a constant
+var WithConstant = map[OpCode]OpCode{
+	OpAdd:      OpAddConstant,
+	OpSubtract: OpSubtractConstant,
+	OpLThan:    OpLThanConstant,
+	OpGThan:    OpGThanConstant,
+	OpEqual:    OpEqualConstant,
+	OpNotEqual: OpNotEqualConstant,
+}
+
+// the VM only has room for as many globals as a narrow operand can count
+var narrowOnly = map[OpCode]bool{
+	OpGetGlobal:  true,
+	OpSetGlobal:  true,
+	OpCallGlobal: true,
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -142,26 +220,40 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
-// turning OpCode and integer operands into an array of bytes
+// turning OpCode and integer operands into an array of bytes. when an
+// operand is too big for its width, the instruction is made wide. operands
+// too big even for that are truncated, so check them with Fits first
 func Make(op OpCode, operands ...int) []byte {
 	def, ok := definitions[op]
 	if !ok {
 		return []byte{}
 	}
 
-	instructionLen := 1 // 1 byte to encode the OpCode
-	for _, w := range def.OperandWidths {
+	widths := def.OperandWidths
+	prefix := 0
+	if !fits(widths, operands) && !narrowOnly[op] {
+		widths = wideWidths(def)
+		prefix = 1
+	}
+
+	instructionLen := prefix + 1 // 1 byte to encode the OpCode
+	for _, w := range widths {
 		instructionLen += w // then add on the additional widths for the operands
 	}
 
 	instruction := make([]byte, instructionLen)
-	instruction[0] = byte(op)
+	if prefix == 1 {
+		instruction[0] = byte(OpWide)
+	}
+	instruction[prefix] = byte(op)
 
-	offset := 1                  // offset is the number of bytes to start inserting after, which is 1 for right after our OpCode
+	offset := prefix + 1         // offset is the number of bytes to start inserting after, which is 1 for right after our OpCode
 	for i, o := range operands { // for each of the passed operands
-		width := def.OperandWidths[i] // collect the appropriate width
+		width := widths[i] // collect the appropriate width
 
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:offset+width], uint32(o))
 		case 2:
 			binary.BigEndian.PutUint16(instruction[offset:offset+width], uint16(o))
 		case 1:
@@ -172,6 +264,90 @@ func Make(op OpCode, operands ...int) []byte {
 	return instruction
 }
 
+// Fits reports whether Make can encode operands for op, if need be by
+// making the instruction wide
+func Fits(op OpCode, operands ...int) bool {
+	def, ok := definitions[op]
+	if !ok {
+		return false
+	}
+	if fits(def.OperandWidths, operands) {
+		return true
+	}
+	return !narrowOnly[op] && fits(wideWidths(def), operands)
+}
+
+func fits(widths []int, operands []int) bool {
+	for i, o := range operands {
+		if i >= len(widths) || o < 0 || uint64(o) >= 1<<(8*uint(widths[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func wideWidths(def *Definition) []int {
+	widths := make([]int, len(def.OperandWidths))
+	for i, w := range def.OperandWidths {
+		widths[i] = 2 * w
+	}
+	return widths
+}
+
+// Instruction is an instruction as read from Instructions
+type Instruction struct {
+	Op       OpCode
+	Def      *Definition
+	Operands []int
+	Wide     bool // prefixed by OpWide
+	Size     int  // in bytes, the prefix included
+}
+
+// Decode reads the instruction at the start of ins, wide or not
+func Decode(ins Instructions) (Instruction, error) {
+	if len(ins) == 0 {
+		return Instruction{}, fmt.Errorf("no instruction to read")
+	}
+	in := Instruction{Op: OpCode(ins[0]), Size: 1}
+	if in.Op == OpWide {
+		if len(ins) < 2 {
+			return Instruction{}, fmt.Errorf("OpWide is cut off")
+		}
+		in.Op, in.Wide, in.Size = OpCode(ins[1]), true, 2
+	}
+
+	def, err := Lookup(byte(in.Op))
+	if err != nil {
+		return Instruction{}, err
+	}
+	in.Def = def
+	widths := def.OperandWidths
+	if in.Wide {
+		if len(widths) == 0 || in.Op == OpWide || narrowOnly[in.Op] {
+			return Instruction{}, fmt.Errorf("%s can't be made wide", def.Name)
+		}
+		widths = wideWidths(def)
+	}
+
+	operands := ins[in.Size:]
+	for _, w := range widths {
+		if len(operands) < w {
+			return Instruction{}, fmt.Errorf("%s is cut off", def.Name)
+		}
+		switch w {
+		case 4:
+			in.Operands = append(in.Operands, int(binary.BigEndian.Uint32(operands)))
+		case 2:
+			in.Operands = append(in.Operands, int(ReadUInt16(operands)))
+		case 1:
+			in.Operands = append(in.Operands, int(ReadUInt8(operands)))
+		}
+		operands = operands[w:]
+		in.Size += w
+	}
+	return in, nil
+}
+
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	operands := make([]int, len(def.OperandWidths))
 	offset := 0
@@ -20,6 +20,9 @@ func TestMake(t *testing.T) {
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
 		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpConstant, []int{65536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpClosure, []int{1, 256}, []byte{byte(OpWide), byte(OpClosure), 0, 0, 0, 1, 1, 0}},
 	}
 
 	for _, tt := range tests {
@@ -45,6 +48,8 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpAdd),
 		Make(OpGetLocal, 1),
 		Make(OpClosure, 65535, 255),
+		Make(OpJump, 70000),
+		Make(OpGetLocal, 300),
 	}
 
 	exp := `0000 OpConstant 1
@@ -53,6 +58,8 @@ func TestInstructionsString(t *testing.T) {
 0009 OpAdd
 0010 OpGetLocal 1
 0012 OpClosure 65535 255
+0016 OpWide OpJump 70000
+0022 OpWide OpGetLocal 300
 `
 
 	concat := Instructions{}
@@ -94,3 +101,76 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestFits(t *testing.T) {
+	tests := []struct {
+		op       OpCode
+		operands []int
+		fits     bool
+	}{
+		{OpConstant, []int{65535}, true},
+		{OpConstant, []int{1 << 31}, true},
+		{OpConstant, []int{-1}, false},
+		{OpGetLocal, []int{65535}, true},
+		{OpGetLocal, []int{65536}, false},
+		{OpSetGlobal, []int{65535}, true},
+		{OpSetGlobal, []int{65536}, false},
+	}
+
+	for _, tt := range tests {
+		if got := Fits(tt.op, tt.operands...); got != tt.fits {
+			t.Errorf("Fits(%d, %v) wrong. want=%t, got=%t", tt.op, tt.operands, tt.fits, got)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	in, err := Decode(Make(OpClosure, 70000, 3))
+	if err != nil {
+		t.Fatalf("decode error: %s", err)
+	}
+	if in.Op != OpClosure || !in.Wide || in.Size != 8 || len(in.Operands) != 2 ||
+		in.Operands[0] != 70000 || in.Operands[1] != 3 {
+		t.Errorf("wrong instruction. got=%+v", in)
+	}
+
+	errors := []struct {
+		ins      Instructions
+		expected string
+	}{
+		{Instructions{byte(OpWide)}, "OpWide is cut off"},
+		{Instructions{byte(OpWide), byte(OpAdd)}, "OpAdd can't be made wide"},
+		{Instructions{byte(OpWide), byte(OpGetGlobal), 0, 0, 0, 1}, "OpGetGlobal can't be made wide"},
+		{Instructions{byte(OpWide), byte(OpConstant), 0, 1}, "OpConstant is cut off"},
+	}
+	for _, tt := range errors {
+		_, err := Decode(tt.ins)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %v. want=%q, got=%v", tt.ins, tt.expected, err)
+		}
+	}
+}
+
+func TestRegInstructionsString(t *testing.T) {
+	ins := RegInstructions{
+		{Op: RegLoadConstant, A: 2, B: 7},
+		{Op: RegAddConstant, A: 0, B: 2, C: 1},
+		{Op: RegJumpNotTruthy, A: 0, B: 5},
+		{Op: RegCall, A: 3, B: 2},
+		{Op: RegReturn, A: 3},
+	}
+
+	exp := `0000 RegLoadConstant 2 7
+0001 RegAddConstant 0 2 1
+0002 RegJumpNotTruthy 0 5
+0003 RegCall 3 2
+0004 RegReturn 3
+`
+	if ins.String() != exp {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q", exp, ins.String())
+	}
+
+	if op, ok := RegLThanConstant.Operator(); !ok || op != OpLThan {
+		t.Errorf("wrong operator for RegLThanConstant. got=%d", op)
+	}
+}
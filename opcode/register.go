@@ -0,0 +1,199 @@
+package opcode
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RegOp is an opcode of the register VM. instead of pushing and popping a
+// stack, its instructions name the registers of the running function's
+// frame they read and write. a function's parameters are its first
+// registers, its locals come after them and its temporaries after those
+type RegOp byte
+
+// RegInstruction is one instruction of the register VM. each of A, B and C
+// is a register, a constant index, a count or a jump target, as the
+// comment on the opcode says. R[x] is register x and K[x] constant x
+type RegInstruction struct {
+	Op      RegOp
+	A, B, C int
+}
+
+// RegInstructions is the code of a function compiled for the register VM.
+// jump targets are indexes into it
+type RegInstructions []RegInstruction
+
+const (
+	RegMove         RegOp = iota // R[A] = R[B]
+	RegLoadConstant              // R[A] = K[B]
+	RegLoadTrue                  // R[A] = true
+	RegLoadFalse                 // R[A] = false
+	RegLoadNil                   // R[A] = nil
+	RegGetGlobal                 // R[A] = global B
+	RegSetGlobal                 // global A = R[B]
+	RegGetBuiltin                // R[A] = builtin B
+	RegGetFree                   // R[A] = free variable B of the running closure
+
+	// R[A] = R[B] operator R[C]
+	RegAdd
+	RegSubtract
+	RegMultiply
+	RegDivide
+	RegModulo
+	RegGThan
+	RegLThan
+	RegEqual
+	RegNotEqual
+
+	// R[A] = R[B] operator K[C]
+	RegAddConstant
+	RegSubtractConstant
+	RegLThanConstant
+	RegGThanConstant
+	RegEqualConstant
+	RegNotEqualConstant
+
+	RegNegateInt     // R[A] = -R[B]
+	RegNegateBool    // R[A] = !R[B]
+	RegJump          // jump to A
+	RegJumpNotTruthy // jump to B unless R[A] is truthy
+
+	RegArray    // R[A] = the array of the C registers from R[B]
+	RegHashMap  // R[A] = the hash map of the C registers from R[B], keys and values in turn
+	RegSet      // R[A] = the set of the C registers from R[B]
+	RegIndex    // R[A] = R[B][R[C]]
+	RegGetField // R[A] = field K[C] of R[B]
+	RegSetField // field K[B] of R[A] = R[C]
+
+	RegCall       // R[A] = R[A](the B registers after A)
+	RegTailCall   // RegCall in front of a RegReturn of A, taking over the caller's frame
+	RegCallMethod // R[A] = method K[B] of R[A](the C registers after A)
+	RegReturn     // return R[A]
+	RegClosure    // R[A] = the closure of function K[B] over the C registers from R[A]
+
+	RegYield     // suspend the generator with R[A], which is nil once resumed
+	RegYieldFrom // suspend the generator with each element of R[A] in turn, then set it to nil or what a generator returned
+	RegResult    // the main program's value so far is R[A]
+)
+
+type RegDefinition struct {
+	Name     string
+	Operands int // how many of A, B and C it uses
+}
+
+var regDefinitions = map[RegOp]*RegDefinition{
+	RegMove:             {"RegMove", 2},
+	RegLoadConstant:     {"RegLoadConstant", 2},
+	RegLoadTrue:         {"RegLoadTrue", 1},
+	RegLoadFalse:        {"RegLoadFalse", 1},
+	RegLoadNil:          {"RegLoadNil", 1},
+	RegGetGlobal:        {"RegGetGlobal", 2},
+	RegSetGlobal:        {"RegSetGlobal", 2},
+	RegGetBuiltin:       {"RegGetBuiltin", 2},
+	RegGetFree:          {"RegGetFree", 2},
+	RegAdd:              {"RegAdd", 3},
+	RegSubtract:         {"RegSubtract", 3},
+	RegMultiply:         {"RegMultiply", 3},
+	RegDivide:           {"RegDivide", 3},
+	RegModulo:           {"RegModulo", 3},
+	RegGThan:            {"RegGreaterThan", 3},
+	RegLThan:            {"RegLessThan", 3},
+	RegEqual:            {"RegEqual", 3},
+	RegNotEqual:         {"RegNotEqual", 3},
+	RegAddConstant:      {"RegAddConstant", 3},
+	RegSubtractConstant: {"RegSubtractConstant", 3},
+	RegLThanConstant:    {"RegLessThanConstant", 3},
+	RegGThanConstant:    {"RegGreaterThanConstant", 3},
+	RegEqualConstant:    {"RegEqualConstant", 3},
+	RegNotEqualConstant: {"RegNotEqualConstant", 3},
+	RegNegateInt:        {"RegNegateInt", 2},
+	RegNegateBool:       {"RegNegateBool", 2},
+	RegJump:             {"RegJump", 1},
+	RegJumpNotTruthy:    {"RegJumpNotTruthy", 2},
+	RegArray:            {"RegArray", 3},
+	RegHashMap:          {"RegHashMap", 3},
+	RegSet:              {"RegSet", 3},
+	RegIndex:            {"RegIndex", 3},
+	RegGetField:         {"RegGetField", 3},
+	RegSetField:         {"RegSetField", 3},
+	RegCall:             {"RegCall", 2},
+	RegTailCall:         {"RegTailCall", 2},
+	RegCallMethod:       {"RegCallMethod", 3},
+	RegReturn:           {"RegReturn", 1},
+	RegClosure:          {"RegClosure", 3},
+	RegYield:            {"RegYield", 1},
+	RegYieldFrom:        {"RegYieldFrom", 1},
+	RegResult:           {"RegResult", 1},
+}
+
+// the register form of each stack operator, and of each with a constant
+var (
+	RegOperators = map[OpCode]RegOp{
+		OpAdd:      RegAdd,
+		OpSubtract: RegSubtract,
+		OpMultiply: RegMultiply,
+		OpDivide:   RegDivide,
+		OpModulo:   RegModulo,
+		OpGThan:    RegGThan,
+		OpLThan:    RegLThan,
+		OpEqual:    RegEqual,
+		OpNotEqual: RegNotEqual,
+	}
+	RegWithConstant = map[RegOp]RegOp{
+		RegAdd:      RegAddConstant,
+		RegSubtract: RegSubtractConstant,
+		RegLThan:    RegLThanConstant,
+		RegGThan:    RegGThanConstant,
+		RegEqual:    RegEqualConstant,
+		RegNotEqual: RegNotEqualConstant,
+	}
+)
+
+// the stack operator each register operator applies
+var regOperatorOf = map[RegOp]OpCode{}
+
+func init() {
+	for op, regOp := range RegOperators {
+		regOperatorOf[regOp] = op
+		if regConst, ok := RegWithConstant[regOp]; ok {
+			regOperatorOf[regConst] = op
+		}
+	}
+}
+
+// Operator is the stack operator a register operator applies, with or
+// without a constant
+func (op RegOp) Operator() (OpCode, bool) {
+	stackOp, ok := regOperatorOf[op]
+	return stackOp, ok
+}
+
+func LookupReg(op RegOp) (*RegDefinition, error) {
+	def, ok := regDefinitions[op]
+	if !ok {
+		return nil, fmt.Errorf("register opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+func (in RegInstruction) String() string {
+	def, err := LookupReg(in.Op)
+	if err != nil {
+		return "ERROR: " + err.Error()
+	}
+	operands := []int{in.A, in.B, in.C}[:def.Operands]
+
+	out := def.Name
+	for _, o := range operands {
+		out += fmt.Sprintf(" %d", o)
+	}
+	return out
+}
+
+func (ins RegInstructions) String() string {
+	var out bytes.Buffer
+	for i, in := range ins {
+		fmt.Fprintf(&out, "%04d %s\n", i, in)
+	}
+	return out.String()
+}
@@ -6,11 +6,13 @@ import (
 	"nala/lexer"
 	"nala/token"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ int = iota // this gives the constants incrementing numbers as values
 	LOWEST
+	PIPELINE    // xs |> f
 	EQUALS      // ==
 	LESSGREATER // < or >
 	SUM         // +
@@ -21,6 +23,7 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
+	token.PIPELINE: PIPELINE,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
@@ -32,6 +35,7 @@ var precedences = map[token.TokenType]int{
 	token.MODULO:   PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
 }
 
 type (
@@ -42,12 +46,18 @@ type (
 type Parser struct {
 	l      *lexer.Lexer
 	errors []string
+	errPos []token.Token // where each of errors was found
 
 	curToken  token.Token
 	peekToken token.Token
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// tracks the function literal being parsed, so a yield in its
+	// body can mark it as a generator
+	fnDepth  int
+	sawYield bool
 }
 
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
@@ -79,7 +89,9 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.LSET, p.parseSetLiteral)
 	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
+	p.registerPrefix(token.YIELD, p.parseYieldExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -93,6 +105,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseFieldAccessExpression)
+	p.registerInfix(token.PIPELINE, p.parsePipelineExpression)
 	return p
 }
 
@@ -123,6 +137,8 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.STRUCT:
+		return p.parseStructStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -139,6 +155,14 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		if stmt.Type = p.parseTypeAnnotation(); stmt.Type == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -153,6 +177,38 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
+func (p *Parser) parseStructStatement() *ast.StructStatement {
+	stmt := &ast.StructStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Fields = []*ast.Identifier{}
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Fields = append(stmt.Fields, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
@@ -237,9 +293,15 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	return arr
 }
 
+func (p *Parser) parseSetLiteral() ast.Expression {
+	set := &ast.SetLiteral{Token: p.curToken}
+	set.Elements = p.parseExpressionList(token.RBRACE)
+	return set
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hsh := &ast.HashLiteral{Token: p.curToken}
-	hsh.Pairs = make(ast.ExpressionPairs)
+	hsh.Pairs = ast.ExpressionPairs{}
 
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
@@ -250,7 +312,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 
 		p.nextToken()
 		val := p.parseExpression(LOWEST)
-		hsh.Pairs[key] = val
+		hsh.Pairs = append(hsh.Pairs, ast.ExpressionPair{Key: key, Value: val})
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -341,6 +403,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	block.End = p.curToken
 
 	return block
 }
@@ -354,14 +417,54 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 
 	flit.Parameters = p.parseFunctionParameters()
 
+	if p.peekTokenIs(token.ARROW) {
+		p.nextToken()
+		p.nextToken()
+		if flit.ReturnType = p.parseTypeAnnotation(); flit.ReturnType == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
+	outer := p.sawYield
+	p.sawYield = false
+	p.fnDepth++
+
 	flit.Body = p.parseBlockStatement()
+
+	p.fnDepth--
+	flit.IsGenerator = p.sawYield
+	p.sawYield = outer
 	return flit
 }
 
+func (p *Parser) parseYieldExpression() ast.Expression {
+	expr := &ast.YieldExpression{Token: p.curToken}
+
+	if p.fnDepth == 0 {
+		p.errorAt(p.curToken, "yield outside of a function")
+	}
+	p.sawYield = true
+
+	p.nextToken()
+	if p.curTokenIs(token.IDENT) && p.curToken.Literal == "from" && p.startsOperand(p.peekToken) {
+		expr.Delegate = true
+		p.nextToken()
+	}
+	expr.Value = p.parseExpression(LOWEST)
+	return expr
+}
+
+// reports whether tok can start the operand of yield from xs, so that
+// yield from; still yields a variable named from
+func (p *Parser) startsOperand(tok token.Token) bool {
+	_, isPrefix := p.prefixParseFns[tok.Type]
+	return isPrefix
+}
+
 func (p *Parser) parseMacroLiteral() ast.Expression {
 	mac := &ast.MacroLiteral{Token: p.curToken}
 
@@ -378,6 +481,18 @@ func (p *Parser) parseMacroLiteral() ast.Expression {
 	return mac
 }
 
+// a parameter name with an optional annotation, as in xs: [int]
+func (p *Parser) parseParameter() *ast.Identifier {
+	id := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		p.nextToken()
+		id.Type = p.parseTypeAnnotation()
+	}
+	return id
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	ids := []*ast.Identifier{}
 
@@ -389,13 +504,13 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	p.nextToken()
 
 	// could call parseExpression to generate the ID or do it by hand like here
-	id := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	id := p.parseParameter()
 	ids = append(ids, id)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		id := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		id := p.parseParameter()
 		ids = append(ids, id)
 	}
 
@@ -418,6 +533,47 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return exp
 }
 
+// parses p.name, p.name = value when an assignment follows
+// and p.name(args) when a call follows
+func (p *Parser) parseFieldAccessExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	field := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.LPAREN) {
+		p.nextToken()
+		call := &ast.MethodCallExpression{Token: tok, Object: left, Method: field}
+		call.Arguments = p.parseExpressionList(token.RPAREN)
+		return call
+	}
+
+	if p.peekTokenIs(token.ASSIGN) {
+		p.nextToken()
+		p.nextToken()
+		return &ast.FieldAssignExpression{
+			Token:  tok,
+			Object: left,
+			Field:  field,
+			Value:  p.parseExpression(LOWEST),
+		}
+	}
+
+	return &ast.FieldAccessExpression{Token: tok, Object: left, Field: field}
+}
+
+// xs |> f(a) is sugar for f(xs, a), and xs |> f for f(xs)
+func (p *Parser) parsePipelineExpression(left ast.Expression) ast.Expression {
+	tok := p.curToken
+
+	p.nextToken()
+	step := p.parseExpression(PIPELINE)
+
+	return ast.PipeInto(tok, left, step)
+}
+
 func (p *Parser) parseCallExpression(fn ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{
 		Token:    p.curToken,
@@ -486,20 +642,32 @@ func (p *Parser) peekTokenIs(expectedType token.TokenType) bool {
 
 func (p *Parser) Errors() []string { return p.errors }
 
+// ErrorPositions returns the token each of Errors was found at
+func (p *Parser) ErrorPositions() []token.Token { return p.errPos }
+
+func (p *Parser) errorAt(tok token.Token, err string) {
+	p.errors = append(p.errors, err)
+	p.errPos = append(p.errPos, tok)
+}
+
 func (p *Parser) peekError(t token.TokenType) {
 	err := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, err)
+	p.errorAt(p.peekToken, err)
 }
 
 func (p *Parser) integerParseError() {
 	err := fmt.Sprintf("could not parse %q as integer",
 		p.curToken.Literal)
-	p.errors = append(p.errors, err)
+	p.errorAt(p.curToken, err)
 }
 
 func (p *Parser) noPrefixParseFnError() {
+	if p.curToken.Type == token.ILLEGAL && strings.HasPrefix(p.curToken.Literal, `"`) {
+		p.errorAt(p.curToken, "unterminated string")
+		return
+	}
 	err := fmt.Sprintf("no prefix parse function found for %s",
 		p.curToken.Type)
-	p.errors = append(p.errors, err)
+	p.errorAt(p.curToken, err)
 }
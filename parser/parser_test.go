@@ -830,6 +830,197 @@ func TestParsingArrayLiterals(t *testing.T) {
 	testInfixExpression(t, arr.Elements[2], 3, "+", 3)
 }
 
+func TestParsingSetLiterals(t *testing.T) {
+	input := "#{1, 2 * 2, 3 + 3}"
+
+	l := lexer.New(input)
+	p := New(l)
+	prog := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	stmt, ok := prog.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("prog.Statements[0] is not *ast.ExpressionStatement. got=%T", prog.Statements[0])
+	}
+	set, ok := stmt.Expression.(*ast.SetLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.SetLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(set.Elements) != 3 {
+		t.Fatalf("len(set.Elements) not 3. got=%d", len(set.Elements))
+	}
+
+	testIntegerLiteral(t, set.Elements[0], 1)
+	testInfixExpression(t, set.Elements[1], 2, "*", 2)
+	testInfixExpression(t, set.Elements[2], 3, "+", 3)
+
+	if set.String() != "#{1, (2 * 2), (3 + 3)}" {
+		t.Errorf("set.String() wrong. got=%q", set.String())
+	}
+}
+
+func TestStructStatements(t *testing.T) {
+	input := `struct Person { name, age }`
+
+	l := lexer.New(input)
+	p := New(l)
+	prog := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	if len(prog.Statements) != 1 {
+		t.Fatalf("prog.Statements does not contain 1 statement. got=%d", len(prog.Statements))
+	}
+	stmt, ok := prog.Statements[0].(*ast.StructStatement)
+	if !ok {
+		t.Fatalf("prog.Statements[0] is not *ast.StructStatement. got=%T", prog.Statements[0])
+	}
+	if stmt.Name.Value != "Person" {
+		t.Errorf("stmt.Name.Value not Person. got=%q", stmt.Name.Value)
+	}
+	if len(stmt.Fields) != 2 || stmt.Fields[0].Value != "name" || stmt.Fields[1].Value != "age" {
+		t.Errorf("wrong fields. got=%v", stmt.Fields)
+	}
+}
+
+func TestFieldExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"p.name", "(p.name)"},
+		{"p.pos.x + 1", "(((p.pos).x) + 1)"},
+		{"get(p).name", "(get(p).name)"},
+		{"p.age = p.age + 1", "(p.age = ((p.age) + 1))"},
+		{"arr[0].name", "((arr[0]).name)"},
+		{"arr.push(1 + 2)", "arr.push((1 + 2))"},
+		{"s.upper().lower()", "s.upper().lower()"},
+		{"p.pos.len()", "(p.pos).len()"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if prog.String() != tt.expected {
+			t.Errorf("wrong parse of %q. want=%q, got=%q", tt.input, tt.expected, prog.String())
+		}
+	}
+}
+
+func TestPipelineExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xs |> sum", "sum(xs)"},
+		{"xs |> filter(isInt) |> map(double) |> sum", "sum(map(filter(xs, isInt), double))"},
+		{"1 + 2 |> f(3 * 4)", "f((1 + 2), (3 * 4))"},
+		{"a == b |> f", "f((a == b))"},
+		{"xs |> fn(x) { x }", "fn (x)     x(xs)"},
+		{"s |> obj.apply(1)", "obj.apply(s, 1)"},
+		{"let total = xs |> sum;", "let total = sum(xs);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if prog.String() != tt.expected {
+			t.Errorf("wrong parse of %q. want=%q, got=%q", tt.input, tt.expected, prog.String())
+		}
+	}
+}
+
+func TestYieldExpressions(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    string
+		isGenerator bool
+	}{
+		{"fn() { yield 1 + 2 }", "yield (1 + 2)", true},
+		{"fn(xs) { yield from xs }", "yield from xs", true},
+		{"fn(xs) { yield from [1, 2] }", "yield from [1, 2]", true},
+		{"fn(from) { yield from; }", "yield from", true},
+		{"fn() { let x = yield 1; }", "let x = yield 1;", true},
+		{"fn() { fn() { yield 1 } }", "fn ()     yield 1", false},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt := prog.Statements[0].(*ast.ExpressionStatement)
+		fn, ok := stmt.Expression.(*ast.FunctionLiteral)
+		if !ok {
+			t.Fatalf("stmt.Expression is not *ast.FunctionLiteral. got=%T", stmt.Expression)
+		}
+		if fn.IsGenerator != tt.isGenerator {
+			t.Errorf("wrong IsGenerator for %q. want=%t, got=%t", tt.input, tt.isGenerator, fn.IsGenerator)
+		}
+		body := fn.Body.Statements[0].String()
+		if body != tt.expected {
+			t.Errorf("wrong body for %q. want=%q, got=%q", tt.input, tt.expected, body)
+		}
+	}
+
+	p := New(lexer.New("yield 1"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != "yield outside of a function" {
+		t.Errorf("expected a yield outside of a function error. got=%v", p.Errors())
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let n: int = 5;", "let n: int = 5;"},
+		{"let xs: [int] = [];", "let xs: [int] = [];"},
+		{"let h: {string: [int]} = {};", "let h: {string: [int]} = {};"},
+		{"let s: #{string} | nil = #{};", "let s: #{string} | nil = #{};"},
+		{"let f: fn(int, string?, any...) -> bool = g;", "let f: fn(int, string?, any...) -> bool = g;"},
+		{"let f: fn = g;", "let f: fn = g;"},
+		{"fn(x: int, xs: [int]) -> int { x }", "fn (x: int, xs: [int]) -> int     x"},
+		{"fn(x, p: Point) { x }", "fn (x, p: Point)     x"},
+		{"fn() -> fn(int) -> int { x }", "fn () -> fn(int) -> int     x"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		prog := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		if prog.String() != tt.expected {
+			t.Errorf("wrong program for %q. want=%q, got=%q", tt.input, tt.expected, prog.String())
+		}
+	}
+
+	errTests := []struct {
+		input    string
+		expected string
+	}{
+		{"int | ", "expected a type, got  instead"},
+		{"[int", "expected next token to be ], got EOF instead"},
+		{"int string", "unexpected string after type"},
+	}
+
+	for _, tt := range errTests {
+		_, errs := ParseType(tt.input)
+		if len(errs) == 0 || errs[0] != tt.expected {
+			t.Errorf("wrong errors for %q. want=%q, got=%v", tt.input, tt.expected, errs)
+		}
+	}
+}
+
 func TestParsingIndexExpressions(t *testing.T) {
 	input := "arr[1 + 1]"
 
@@ -884,7 +1075,8 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 		"three": 3,
 	}
 
-	for k, v := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		k, v := pair.Key, pair.Value
 		lit, ok := k.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", k)
@@ -895,6 +1087,13 @@ func TestParsingHashLiteralsStringKeys(t *testing.T) {
 			testIntegerLiteral(t, v, int64(expV))
 		}
 	}
+
+	// pairs must come out in source order
+	for i, key := range []string{"one", "two", "three"} {
+		if hash.Pairs[i].Key.String() != key {
+			t.Errorf("hash.Pairs[%d] has wrong key. want=%q, got=%q", i, key, hash.Pairs[i].Key.String())
+		}
+	}
 }
 
 func TestParsingEmptyHashLiteral(t *testing.T) {
@@ -952,7 +1151,8 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 		},
 	}
 
-	for k, v := range hash.Pairs {
+	for _, pair := range hash.Pairs {
+		k, v := pair.Key, pair.Value
 		lit, ok := k.(*ast.StringLiteral)
 		if !ok {
 			t.Errorf("key is not ast.StringLiteral. got=%T", k)
@@ -1005,3 +1205,46 @@ func TestMacroLiteralParsing(t *testing.T) {
 
 	testInfixExpression(t, body.Expression, "x", "+", "y")
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input     string
+		positions [][2]int
+	}{
+		{"let x 5;", [][2]int{{1, 7}}},
+		{"let x = 1;\n  yield 2;", [][2]int{{2, 3}}},
+		{"let x = ;\nlet = 2;", [][2]int{{1, 9}, {2, 5}}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errs, positions := p.Errors(), p.ErrorPositions()
+		if len(positions) != len(errs) {
+			t.Fatalf("%d errors but %d positions for %q", len(errs), len(positions), tt.input)
+		}
+		if len(positions) < len(tt.positions) {
+			t.Fatalf("wrong number of errors for %q. want at least %d, got=%v", tt.input, len(tt.positions), errs)
+		}
+		for i, want := range tt.positions {
+			if positions[i].Line != want[0] || positions[i].Column != want[1] {
+				t.Errorf("wrong position for %q (%s). want=%d:%d, got=%d:%d",
+					tt.input, errs[i], want[0], want[1], positions[i].Line, positions[i].Column)
+			}
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	p := New(lexer.New("let x = 1;\nlet y = \"abc"))
+	p.ParseProgram()
+
+	errs, positions := p.Errors(), p.ErrorPositions()
+	if len(errs) == 0 || errs[0] != "unterminated string" {
+		t.Fatalf("expected an unterminated string error. got=%v", errs)
+	}
+	if positions[0].Line != 2 || positions[0].Column != 9 {
+		t.Errorf("wrong position for %q. want=2:9, got=%d:%d", errs[0], positions[0].Line, positions[0].Column)
+	}
+}
@@ -0,0 +1,127 @@
+package parser
+
+import (
+	"fmt"
+	"nala/ast"
+	"nala/lexer"
+	"nala/token"
+	"strings"
+)
+
+// ParseType parses a type annotation written on its own, the way the
+// builtins write down their signatures
+func ParseType(src string) (*ast.TypeAnnotation, []string) {
+	p := New(lexer.New(src))
+	ta := p.parseTypeAnnotation()
+	if ta != nil && !p.peekTokenIs(token.EOF) {
+		p.errorAt(p.peekToken, fmt.Sprintf("unexpected %s after type", p.peekToken.Literal))
+	}
+	return ta, p.Errors()
+}
+
+// parses the type starting at curToken, leaving curToken on its last token.
+// a union is a list of types separated by |, as in string | nil
+func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
+	first := p.parseSingleType()
+	if first == nil || !p.peekTokenIs(token.PIPE) {
+		return first
+	}
+
+	union := &ast.TypeAnnotation{Token: first.Token, Name: "union", Params: []*ast.TypeAnnotation{first}}
+	for p.peekTokenIs(token.PIPE) {
+		p.nextToken()
+		p.nextToken()
+		option := p.parseSingleType()
+		if option == nil {
+			return nil
+		}
+		union.Params = append(union.Params, option)
+	}
+	return union
+}
+
+func (p *Parser) parseSingleType() *ast.TypeAnnotation {
+	ta := &ast.TypeAnnotation{Token: p.curToken}
+
+	switch p.curToken.Type {
+	case token.IDENT:
+		// ? is part of an identifier, so int? arrives as one token
+		ta.Name = strings.TrimSuffix(p.curToken.Literal, "?")
+		ta.Optional = ta.Name != p.curToken.Literal
+	case token.LBRACKET:
+		ta.Name = "array"
+		if !p.parseTypeParams(ta, token.RBRACKET) {
+			return nil
+		}
+	case token.LSET:
+		ta.Name = "set"
+		if !p.parseTypeParams(ta, token.RBRACE) {
+			return nil
+		}
+	case token.LBRACE:
+		ta.Name = "hash"
+		if !p.parseTypeParams(ta, token.COLON) || !p.parseTypeParams(ta, token.RBRACE) {
+			return nil
+		}
+	case token.FUNCTION:
+		// fn on its own is any function at all
+		ta.Name = "fn"
+		if !p.peekTokenIs(token.LPAREN) {
+			return ta
+		}
+		ta.Name = "func"
+		if !p.expectPeek(token.LPAREN) || !p.parseFunctionTypeParams(ta) {
+			return nil
+		}
+		if p.peekTokenIs(token.ARROW) {
+			p.nextToken()
+			p.nextToken()
+			ta.Return = p.parseTypeAnnotation()
+			if ta.Return == nil {
+				return nil
+			}
+		}
+	default:
+		p.errorAt(p.curToken, fmt.Sprintf("expected a type, got %s instead", p.curToken.Literal))
+		return nil
+	}
+	return ta
+}
+
+// parses the next type into ta.Params and expects end to follow it
+func (p *Parser) parseTypeParams(ta *ast.TypeAnnotation, end token.TokenType) bool {
+	p.nextToken()
+	param := p.parseTypeAnnotation()
+	if param == nil {
+		return false
+	}
+	ta.Params = append(ta.Params, param)
+	return p.expectPeek(end)
+}
+
+// (int, [string], any...)
+func (p *Parser) parseFunctionTypeParams(ta *ast.TypeAnnotation) bool {
+	for !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		param := p.parseTypeAnnotation()
+		if param == nil {
+			return false
+		}
+		ta.Params = append(ta.Params, param)
+
+		if p.peekTokenIs(token.DOT) {
+			// only the last parameter can repeat
+			for i := 0; i < 3; i++ {
+				if !p.expectPeek(token.DOT) {
+					return false
+				}
+			}
+			ta.Variadic = true
+			break
+		}
+		if !p.peekTokenIs(token.RPAREN) && !p.expectPeek(token.COMMA) {
+			return false
+		}
+	}
+	return p.expectPeek(token.RPAREN)
+}
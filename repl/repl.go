@@ -7,6 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"nala/ast"
+	"nala/checker"
 	"nala/compiler"
 	"nala/evaluator"
 	"nala/lexer"
@@ -24,8 +25,10 @@ const PROMPT = "=> "
 const FUNCSPATH = "functions"
 
 var engine = flag.Bool("vm", true, "Use Compiler and Virtual Machine")
+var registers = flag.Bool("reg", false, "Use the register-based Virtual Machine instead of the stack one")
 var file = flag.String("f", "", "Run Nala source file")
 var lang = flag.Bool("nl", true, "Interpret Nala language. Set to false for Ellisp")
+var optimize = flag.Bool("O", false, "Optimize the compiled code")
 
 // const FUNCSPATH = "./nl/test.nl"
 
@@ -34,7 +37,8 @@ func Start(in io.Reader, out io.Writer) {
 	env := object.NewEnvironment()
 	symbolTable := compiler.NewSymbolTable()
 	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalsSize)
+	globals := make([]vm.Value, vm.GlobalsSize)
+	typeChecker := checker.New()
 
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
@@ -42,6 +46,9 @@ func Start(in io.Reader, out io.Writer) {
 
 	// IMPLEMENT COMPILATION DOWN THERE USING 3 state vars above
 	nalaFuncsProg := parseNalaFunctions()
+	if nalaFuncsProg != nil {
+		typeChecker.Check(nalaFuncsProg)
+	}
 
 	flag.Parse()
 
@@ -50,11 +57,11 @@ func Start(in io.Reader, out io.Writer) {
 		// try to parse user file
 		userProg = readAndParseSourceFile(*file, *lang)
 
-		if userProg != nil {
+		if userProg != nil && typeCheck(typeChecker, userProg) {
 			// go on to execute it
 			// load in nalaFuncsProg first
 			if *engine {
-				fmt.Print("using VM...\n")
+				fmt.Print(vmBanner())
 				globals, constants = compileAndRunProg(nalaFuncsProg, symbolTable, constants, globals, false, false)
 				compileAndRunProg(userProg, symbolTable, constants, globals, false, true)
 			} else {
@@ -69,7 +76,7 @@ func Start(in io.Reader, out io.Writer) {
 	// run the predefined functions through first
 	showIntro()
 	if *engine {
-		fmt.Print("using VM...\n")
+		fmt.Print(vmBanner())
 		globals, constants = compileAndRunProg(nalaFuncsProg, symbolTable, constants, globals, false, false)
 	} else {
 		fmt.Print("using TreeWalker...\n")
@@ -126,7 +133,7 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		prog, ok := parseSource(line, *lang)
-		if !ok {
+		if !ok || !typeCheck(typeChecker, prog) {
 			continue
 		}
 
@@ -138,6 +145,13 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
+func vmBanner() string {
+	if *registers {
+		return "using register VM...\n"
+	}
+	return "using VM...\n"
+}
+
 const CAT_FACE = ` A_A
 (-.-)
  |-|
@@ -147,9 +161,23 @@ const CAT_FACE = ` A_A
 \_||_/_/
 `
 
-func compileAndRunProg(prog *ast.Program, st *compiler.SymbolTable, cons,
-	globals []object.Object, show bool, showRes bool) ([]object.Object, []object.Object) {
+// runs the type checker over prog and reports whether it can go on to run
+func typeCheck(tc *checker.Checker, prog *ast.Program) bool {
+	errs := tc.Check(prog)
+	for _, err := range errs {
+		fmt.Printf("type error: %s\n", err)
+	}
+	return len(errs) == 0
+}
+
+func compileAndRunProg(prog *ast.Program, st *compiler.SymbolTable, cons []object.Object,
+	globals []vm.Value, show bool, showRes bool) ([]vm.Value, []object.Object) {
+	if *registers {
+		return compileAndRunRegisters(prog, st, cons, globals, show, showRes)
+	}
+
 	comp := compiler.NewWithState(st, cons)
+	comp.SetOptimize(*optimize)
 	err := comp.Compile(prog)
 	if err != nil {
 		if showRes {
@@ -182,14 +210,57 @@ func compileAndRunProg(prog *ast.Program, st *compiler.SymbolTable, cons,
 		fmt.Println("\n*DISASSEMBLED BYTECODE*")
 		fmt.Println("************************")
 		ins := comp.ByteCode().Instructions
-		comp.Decompile(ins, cons, globs, "", 0)
+		globObjs := make([]object.Object, len(globs))
+		for i, g := range globs {
+			globObjs[i] = g.Object()
+		}
+		comp.Decompile(ins, cons, globObjs, "", 0)
 		println()
 	}
 	println()
 	return globs, cons
 }
 
-func runVM(bc *compiler.ByteCode, globals []object.Object) (object.Object, []object.Object, error) {
+// compileAndRunProg for the register VM
+func compileAndRunRegisters(prog *ast.Program, st *compiler.SymbolTable, cons []object.Object,
+	globals []vm.Value, show bool, showRes bool) ([]vm.Value, []object.Object) {
+	comp := compiler.NewRegisterWithState(st, cons)
+	comp.SetOptimize(*optimize)
+	err := comp.Compile(prog)
+	if err != nil {
+		if showRes {
+			fmt.Println(fmt.Errorf("compiler error: %s", err))
+		}
+		return globals, cons
+	}
+
+	bc := comp.ByteCode()
+	machine := vm.NewRegisterWithGlobalsStore(bc, globals)
+	now := time.Now()
+	err = machine.Run()
+	fmt.Printf("[duration: %s]\n", time.Since(now))
+
+	if err != nil {
+		if showRes {
+			fmt.Println(fmt.Errorf("vm error: %s", err))
+		}
+		fmt.Println(bc.Main.Registers.String())
+		return globals, cons
+	}
+
+	if showRes {
+		fmt.Println(machine.LastPoppedElement().Inspect())
+	}
+	if show {
+		fmt.Println("\n*REGISTER BYTECODE*")
+		fmt.Println("************************")
+		fmt.Println(bc.Main.Registers.String())
+	}
+	println()
+	return machine.Globals(), bc.Constants
+}
+
+func runVM(bc *compiler.ByteCode, globals []vm.Value) (object.Object, []vm.Value, error) {
 	machine := vm.NewWithGlobalsStore(bc, globals)
 	now := time.Now()
 	err := machine.Run()
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"nala/checker"
+	"nala/compiler"
+	"nala/object"
+	"nala/source"
+	"nala/vm"
+	"os"
+)
+
+// vmProgram is a file compiled to run on the VM once the prelude has run
+type vmProgram struct {
+	src      string
+	symbols  *compiler.SymbolTable
+	bytecode *compiler.ByteCode
+	globals  []vm.Value // as the prelude left them
+}
+
+// preludeFlag is the -prelude flag of the commands that run, or know
+// about, a prelude. the default one is left out when it is missing, while
+// a file given on the command line has to be there. -prelude "" is none
+type preludeFlag struct {
+	path string
+	set  bool
+}
+
+func newPreludeFlag(flags *flag.FlagSet, usage string) *preludeFlag {
+	p := &preludeFlag{path: "code/functions.nl"}
+	flags.Var(p, "prelude", usage)
+	return p
+}
+
+func (p *preludeFlag) String() string { return p.path }
+
+func (p *preludeFlag) Set(path string) error {
+	p.path, p.set = path, true
+	return nil
+}
+
+// file is the path of the prelude, or "" when there is none
+func (p *preludeFlag) file() (string, error) {
+	if p.path == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(p.path); err != nil {
+		if p.set {
+			return "", fmt.Errorf("prelude: %s", err)
+		}
+		return "", nil
+	}
+	return p.path, nil
+}
+
+// prelude is what the files compiled after the prelude start from
+type prelude struct {
+	tc      *checker.Checker
+	symbols *compiler.SymbolTable
+	comp    *compiler.Compiler // holds the prelude's code
+}
+
+// compilePrelude type checks and compiles the prelude at path, or only
+// defines the builtins when path is ""
+func compilePrelude(path string, optimize bool) (*prelude, error) {
+	p := &prelude{tc: checker.New(), symbols: compiler.NewSymbolTable()}
+	for i, v := range object.Builtins {
+		p.symbols.DefineBuiltin(i, v.Name)
+	}
+	p.comp = compiler.NewWithState(p.symbols, []object.Object{})
+	p.comp.SetOptimize(optimize)
+
+	if path == "" {
+		return p, nil
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.compile(p.comp, path, string(src)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// compile type checks src, the file at path, against the globals of the
+// prelude and compiles it with comp, recording path in the debug info
+func (p *prelude) compile(comp *compiler.Compiler, path string, src string) error {
+	prog, err := source.Check(p.tc, path, src)
+	if err != nil {
+		return err
+	}
+	comp.SetFile(path)
+	if err := comp.Compile(prog); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// compileForVM runs the prelude at preludePath, unless it is "", and
+// compiles the file at path to run after it
+func compileForVM(preludePath string, path string, optimize bool) (*vmProgram, error) {
+	p, err := compilePrelude(preludePath, optimize)
+	if err != nil {
+		return nil, err
+	}
+	globals := make([]vm.Value, vm.GlobalsSize)
+	if preludePath != "" {
+		if err := vm.NewWithGlobalsStore(p.comp.ByteCode(), globals).Run(); err != nil {
+			return nil, fmt.Errorf("%s: %s", preludePath, err)
+		}
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	comp := compiler.NewWithState(p.symbols, p.comp.ByteCode().Constants)
+	comp.SetOptimize(optimize)
+	if err := p.compile(comp, path, string(src)); err != nil {
+		return nil, err
+	}
+	return &vmProgram{src: string(src), symbols: p.symbols, bytecode: comp.ByteCode(), globals: globals}, nil
+}
+
+// compileProgram compiles the prelude at preludePath, unless it is "", and
+// the file at path into one program that runs them one after the other.
+// the main line table only covers the file at path
+func compileProgram(preludePath string, path string, optimize bool) (*compiler.ByteCode, *compiler.SymbolTable, error) {
+	p, err := compilePrelude(preludePath, optimize)
+	if err != nil {
+		return nil, nil, err
+	}
+	preludeEnd := len(p.comp.ByteCode().Instructions)
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.compile(p.comp, path, string(src)); err != nil {
+		return nil, nil, err
+	}
+
+	bc := p.comp.ByteCode()
+	lines := []object.LineEntry{}
+	for _, l := range bc.Debug.Lines {
+		if l.Offset >= preludeEnd {
+			lines = append(lines, l)
+		}
+	}
+	bc.Debug.Lines = lines
+	return bc, p.symbols, nil
+}
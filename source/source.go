@@ -0,0 +1,92 @@
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"nala/ast"
+	"nala/checker"
+	"nala/lexer"
+	lispparser "nala/lisp_parser"
+	"nala/parser"
+	"nala/token"
+	"path/filepath"
+	"strings"
+)
+
+// IsEllisp picks the front end for a source file by its extension. path
+// can be a document URI as well
+func IsEllisp(path string) bool {
+	return filepath.Ext(path) == ".el"
+}
+
+// Parser is what both front ends have
+type Parser interface {
+	ParseProgram() *ast.Program
+	Errors() []string
+	ErrorPositions() []token.Token
+}
+
+// NewParser returns the front end for path, reading from l
+func NewParser(path string, l *lexer.Lexer) Parser {
+	if IsEllisp(path) {
+		return lispparser.New(l)
+	}
+	return parser.New(l)
+}
+
+// Parse parses src with the front end for path. parse errors come back as
+// a single error, one per line
+func Parse(path string, src string) (*ast.Program, error) {
+	p := NewParser(path, lexer.New(src))
+	prog := p.ParseProgram()
+
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, fmt.Errorf("%s: parser errors:\n\t%s", path, strings.Join(errs, "\n\t"))
+	}
+	return prog, nil
+}
+
+// ParseFile reads the file at path and parses it
+func ParseFile(path string) (*ast.Program, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, string(src))
+}
+
+// TypeCheck runs tc over prog, the file at path. the mismatches found come
+// back as a single error, one per line
+func TypeCheck(tc *checker.Checker, path string, prog *ast.Program) error {
+	errs := tc.Check(prog)
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%s: type errors:\n\t%s", path, strings.Join(msgs, "\n\t"))
+}
+
+// Check parses src and type checks it with tc, which keeps the globals of
+// the files it checked before, such as the prelude
+func Check(tc *checker.Checker, path string, src string) (*ast.Program, error) {
+	prog, err := Parse(path, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := TypeCheck(tc, path, prog); err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// CheckFile reads the file at path, parses it and type checks it with tc
+func CheckFile(tc *checker.Checker, path string) (*ast.Program, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Check(tc, path, string(src))
+}
@@ -0,0 +1,47 @@
+package source
+
+import (
+	"nala/checker"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		path     string
+		input    string
+		expected string
+	}{
+		{"main.nl", "let x = 1 + 2;", "let x = (1 + 2);"},
+		{"main.el", "(let x (+ 1 2))", "let x = (1 + 2);"},
+		{"file:///home/main.el", "(let x (+ 1 2))", "let x = (1 + 2);"},
+	}
+
+	for _, tt := range tests {
+		prog, err := Parse(tt.path, tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.path, err)
+			continue
+		}
+		if prog.String() != tt.expected {
+			t.Errorf("%s: wrong program. want=%q, got=%q", tt.path, tt.expected, prog.String())
+		}
+	}
+
+	if _, err := Parse("main.el", "let x = 1;"); err == nil || !strings.HasPrefix(err.Error(), "main.el: parser errors:") {
+		t.Errorf("expected Nala to be rejected in an Ellisp file. got=%v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tc := checker.New()
+	if _, err := Check(tc, "prelude.nl", "let double = fn(x: int) -> int { x * 2 };"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err := Check(tc, "main.nl", "let s: string = double(1);")
+	expected := "main.nl: type errors:\n\t1:17: cannot use int as string in let s"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
@@ -1,5 +1,7 @@
 package token
 
+import "sort"
+
 // TokenType is an alias for the string type
 type TokenType string
 
@@ -7,11 +9,14 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // 1-based position of the token's first character
+	Column  int
 }
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" // only ever kept aside by the lexer, never handed out
 
 	// identifiers and literals
 	IDENT  = "IDENT"
@@ -45,32 +50,52 @@ const (
 	LBRACKET = "["
 	RBRACKET = "]"
 	COLON    = ":"
+	DOT      = "."
 	PIPE     = "|"
+	LSET     = "#{"
+	PIPELINE = "|>"
+	ARROW    = "->"
 
 	// keywords
-	FUNCTION = "FUNCTION"
-	LET      = "LET"
-	TRUE     = "TRUE"
-	FALSE    = "FALSE"
-	IF       = "IF"
-	ELSE     = "ELSE"
-	RETURN   = "RETURN"
-	MACRO    = "MACRO"
-	CONS     = "CONS"
-	LIST     = "LIST"
+	FUNCTION  = "FUNCTION"
+	LET       = "LET"
+	TRUE      = "TRUE"
+	FALSE     = "FALSE"
+	IF        = "IF"
+	ELSE      = "ELSE"
+	RETURN    = "RETURN"
+	MACRO     = "MACRO"
+	CONS      = "CONS"
+	LIST      = "LIST"
+	STRUCT    = "STRUCT"
+	DEFSTRUCT = "DEFSTRUCT"
+	YIELD     = "YIELD"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"macro":  MACRO,
-	"cons":   CONS,
-	"list":   LIST,
+	"fn":        FUNCTION,
+	"let":       LET,
+	"true":      TRUE,
+	"false":     FALSE,
+	"if":        IF,
+	"else":      ELSE,
+	"return":    RETURN,
+	"macro":     MACRO,
+	"cons":      CONS,
+	"list":      LIST,
+	"struct":    STRUCT,
+	"defstruct": DEFSTRUCT,
+	"yield":     YIELD,
+}
+
+// Keywords returns the reserved words of both languages, sorted
+func Keywords() []string {
+	words := make([]string, 0, len(keywords))
+	for word := range keywords {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,374 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"nala/ast"
+	"nala/compiler"
+	"nala/lexer"
+	"nala/object"
+	"nala/parser"
+	"sync"
+)
+
+// StepMode is what the VM does after the debugger stops it
+type StepMode int
+
+const (
+	Continue StepMode = iota // run to the next breakpoint
+	StepIn                   // stop at the next statement, in whichever function it is
+	StepOver                 // stop at the next statement of this function or its callers
+	StepOut                  // stop at the next statement of a caller
+	Quit                     // end the run with ErrQuit
+)
+
+// ErrQuit is what Run returns when the debugger is told to Quit
+var ErrQuit = errors.New("debugging stopped")
+
+// Breakpoint stops the VM at the statements starting on Line of File,
+// or when the function bound to Func is entered
+type Breakpoint struct {
+	ID       int
+	File     string
+	Line     int
+	Func     string
+	Verified bool // some statement the VM knows of can hit it
+}
+
+// Stop describes where the VM stopped
+type Stop struct {
+	Reason     string // "entry", "breakpoint" or "step"
+	Breakpoint *Breakpoint
+	Frame      FrameInfo
+}
+
+// FrameInfo describes a frame on the call stack
+type FrameInfo struct {
+	Func string // "main" for the program itself
+	File string
+	Line int
+}
+
+// Variable is a named value in a frame or in the globals. Value is nil
+// for a local not yet assigned
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// Debugger stops a VM at breakpoints and after steps, and inspects it
+// while it is stopped. it only ever stops at the start of a statement.
+// generators and spawned tasks run on VMs of their own and are not debugged
+type Debugger struct {
+	// OnStop is called each time the VM stops, from the goroutine running
+	// it, and returns how to carry on. the VM can be inspected until it returns
+	OnStop func(d *Debugger, s Stop) StepMode
+
+	vm      *VM
+	symbols *compiler.SymbolTable // names the globals
+
+	mu          sync.Mutex // breakpoints can be changed while the VM runs
+	breakpoints []*Breakpoint
+	nextID      int
+
+	mode    StepMode
+	depth   int  // how many frames were on the call stack when the step began
+	onEntry bool // stop at the first statement, before anything has run
+}
+
+// NewDebugger attaches a Debugger to vm. symbols is the global symbol
+// table vm's program was compiled with. when stopOnEntry is set the VM
+// stops at the first statement it runs
+func NewDebugger(vm *VM, symbols *compiler.SymbolTable, stopOnEntry bool) *Debugger {
+	d := &Debugger{vm: vm, symbols: symbols, mode: Continue, onEntry: stopOnEntry}
+	vm.debugger = d
+	return d
+}
+
+// BreakLine adds a breakpoint on line of file
+func (d *Debugger) BreakLine(file string, line int) *Breakpoint {
+	bp := &Breakpoint{File: file, Line: line}
+	for _, info := range d.debugInfos() {
+		if info.File == file && info.HasLine(line) {
+			bp.Verified = true
+			break
+		}
+	}
+	return d.add(bp)
+}
+
+// BreakFunc adds a breakpoint on entry to the functions bound to name
+func (d *Debugger) BreakFunc(name string) *Breakpoint {
+	bp := &Breakpoint{Func: name}
+	for _, info := range d.debugInfos() {
+		if info.Name == name {
+			bp.Verified = true
+			break
+		}
+	}
+	return d.add(bp)
+}
+
+func (d *Debugger) add(bp *Breakpoint) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.nextID++
+	bp.ID = d.nextID
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp
+}
+
+// Delete removes the breakpoint with id, and reports whether there was one
+func (d *Debugger) Delete(id int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the breakpoints in the order they were added
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*Breakpoint{}, d.breakpoints...)
+}
+
+// the debug info of the main program and of every compiled function
+// in the constants
+func (d *Debugger) debugInfos() []*object.DebugInfo {
+	infos := []*object.DebugInfo{}
+	if main := d.vm.frames[0].cl.Fn.Debug; main != nil {
+		infos = append(infos, main)
+	}
+	for _, c := range d.vm.constants {
+		if fn, ok := c.(*object.CompiledFunction); ok && fn.Debug != nil {
+			infos = append(infos, fn.Debug)
+		}
+	}
+	return infos
+}
+
+// called by the VM before it runs each instruction
+func (d *Debugger) check() error {
+	frame := d.vm.currentFrame()
+	info := frame.cl.Fn.Debug
+	if info == nil {
+		return nil
+	}
+	line, ok := info.StatementAt(frame.ip)
+	if !ok {
+		return nil
+	}
+	depth := d.vm.framesIndex
+
+	stop := Stop{}
+	switch {
+	case d.onEntry:
+		d.onEntry = false
+		stop.Reason = "entry"
+	case d.mode == StepIn,
+		d.mode == StepOver && depth <= d.depth,
+		d.mode == StepOut && depth < d.depth:
+		stop.Reason = "step"
+	}
+	if bp := d.breakpointAt(info, frame.ip, line); bp != nil && stop.Reason != "entry" {
+		stop.Reason = "breakpoint"
+		stop.Breakpoint = bp
+	}
+	if stop.Reason == "" {
+		return nil
+	}
+
+	stop.Frame = d.frameInfo(frame, depth-1)
+	d.mode = Continue
+	if d.OnStop != nil {
+		d.mode = d.OnStop(d, stop)
+	}
+	d.depth = depth
+	if d.mode == Quit {
+		return ErrQuit
+	}
+	return nil
+}
+
+// the breakpoint hit by the statement starting at offset on line, if any.
+// function breakpoints are hit by the first statement of the function
+func (d *Debugger) breakpointAt(info *object.DebugInfo, offset int, line int) *Breakpoint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, bp := range d.breakpoints {
+		if bp.Func != "" {
+			if bp.Func == info.Name && offset == info.Lines[0].Offset {
+				return bp
+			}
+		} else if bp.File == info.File && bp.Line == line {
+			return bp
+		}
+	}
+	return nil
+}
+
+// Frames describes the call stack, the innermost frame first
+func (d *Debugger) Frames() []FrameInfo {
+	frames := []FrameInfo{}
+	for i := d.vm.framesIndex - 1; i >= 0; i-- {
+		frames = append(frames, d.frameInfo(d.vm.frames[i], i))
+	}
+	return frames
+}
+
+func (d *Debugger) frameInfo(frame *Frame, index int) FrameInfo {
+	fi := FrameInfo{Func: "<anonymous>"}
+	if index == 0 {
+		fi.Func = "main"
+	}
+	if info := frame.cl.Fn.Debug; info != nil {
+		if info.Name != "" {
+			fi.Func = info.Name
+		}
+		fi.File = info.File
+		fi.Line = info.Line(frame.ip)
+	}
+	return fi
+}
+
+// the frame n frames out from the innermost one
+func (d *Debugger) frame(n int) (*Frame, error) {
+	if n < 0 || n >= d.vm.framesIndex {
+		return nil, fmt.Errorf("no frame %d", n)
+	}
+	return d.vm.frames[d.vm.framesIndex-1-n], nil
+}
+
+// Stack returns the operand stack, the bottom first
+func (d *Debugger) Stack() []object.Object {
+	stack := make([]object.Object, d.vm.sp)
+	for i, v := range d.vm.stack[:d.vm.sp] {
+		stack[i] = v.Object()
+	}
+	return stack
+}
+
+// Locals returns the local variables of frame n, counted out from the
+// innermost frame. the main program has none, its variables are globals
+func (d *Debugger) Locals(n int) ([]Variable, error) {
+	frame, err := d.frame(n)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := []Variable{}
+	for i := 0; i < frame.cl.Fn.NumOfLocals; i++ {
+		vars = append(vars, Variable{
+			Name:  variableName(frame.cl.Fn.Debug, i, false),
+			Value: d.vm.stack[frame.basePointer+i].Object(),
+		})
+	}
+	return vars, nil
+}
+
+// Free returns the free variables of the closure running in frame n
+func (d *Debugger) Free(n int) ([]Variable, error) {
+	frame, err := d.frame(n)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := []Variable{}
+	for i, val := range frame.cl.FreeVariables {
+		vars = append(vars, Variable{Name: variableName(frame.cl.Fn.Debug, i, true), Value: val})
+	}
+	return vars, nil
+}
+
+func variableName(info *object.DebugInfo, index int, free bool) string {
+	names, kind := []string{}, "local"
+	if info != nil {
+		names = info.Locals
+	}
+	if free {
+		kind = "free"
+		if info != nil {
+			names = info.Free
+		}
+	}
+	if index < len(names) && names[index] != "" {
+		return names[index]
+	}
+	return fmt.Sprintf("%s%d", kind, index)
+}
+
+// Globals returns the globals that have been set, in the order they
+// were defined
+func (d *Debugger) Globals() []Variable {
+	vars := []Variable{}
+	for _, sym := range d.symbols.Symbols() {
+		if sym.Scope != compiler.GlobalScope || d.vm.globals[sym.Index].kind == kindEmpty {
+			continue
+		}
+		vars = append(vars, Variable{Name: sym.Name, Value: d.vm.globals[sym.Index].Object()})
+	}
+	return vars
+}
+
+// Eval runs the Nala source src as though it were written in frame n,
+// and returns the value of its last expression. it sees the frame's
+// locals and free variables along with the globals, but works on a copy
+// of them, so any let it makes is forgotten afterwards
+func (d *Debugger) Eval(src string, n int) (object.Object, error) {
+	p := parser.New(lexer.New(src))
+	prog := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, fmt.Errorf("%s", p.Errors()[0])
+	}
+
+	locals, err := d.Locals(n)
+	if err != nil {
+		return nil, err
+	}
+	free, _ := d.Free(n)
+
+	symbols := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbols.DefineBuiltin(i, v.Name)
+	}
+	globals := make([]Value, GlobalsSize)
+	copy(globals, d.vm.globals)
+	// the globals are defined in index order, so each keeps its slot
+	for _, sym := range d.symbols.Symbols() {
+		if sym.Scope == compiler.GlobalScope {
+			symbols.Define(sym.Name)
+		}
+	}
+	for _, v := range append(free, locals...) {
+		if v.Value == nil {
+			continue
+		}
+		sym := symbols.Define(v.Name)
+		globals[sym.Index] = ValueOf(v.Value)
+	}
+
+	constants := make([]object.Object, len(d.vm.constants))
+	copy(constants, d.vm.constants)
+	comp := compiler.NewWithState(symbols, constants)
+	if err := comp.Compile(prog); err != nil {
+		return nil, err
+	}
+
+	machine := NewWithGlobalsStore(comp.ByteCode(), globals)
+	if err := machine.Run(); err != nil {
+		return nil, err
+	}
+	if len(prog.Statements) == 0 {
+		return NIL, nil
+	}
+	if _, ok := prog.Statements[len(prog.Statements)-1].(*ast.ExpressionStatement); !ok {
+		return NIL, nil
+	}
+	return machine.LastPoppedElement(), nil
+}
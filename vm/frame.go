@@ -9,6 +9,7 @@ type Frame struct {
 	cl          *object.Closure
 	ip          int
 	basePointer int
+	ret         int // the stack slot the register VM leaves the result in
 }
 
 func NewFrame(cl *object.Closure, bp int) *Frame {
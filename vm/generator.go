@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"fmt"
+	"nala/object"
+)
+
+// Generator is a suspended call to a compiled generator function. it keeps
+// the function's own Frame and runs it on a VM with a stack slice of its
+// own, sharing constants and globals with the VM that made the call.
+// Next picks the frame back up from the instruction after its last OpYield
+type Generator struct {
+	frame   *Frame
+	machine *VM
+
+	running  bool
+	done     bool
+	returned object.Object
+}
+
+func (vm *VM) newGenerator(cl *object.Closure, args []Value) *Generator {
+	machine := vm.newFunctionVM(vm.globals, cl, args)
+	machine.profiler = vm.profiler
+	vm.allocated()
+	return &Generator{frame: machine.frames[0], machine: machine}
+}
+
+func (g *Generator) Type() object.ObjectType   { return object.GENERATOR_OBJ }
+func (g *Generator) Inspect() string           { return fmt.Sprintf("Generator[%s]", g.frame.cl.Inspect()) }
+func (g *Generator) Iterator() object.Iterator { return g }
+
+// Returned is what the function returned, or nil while it hasn't finished
+func (g *Generator) Returned() object.Object {
+	if g.returned == nil {
+		return object.NIL
+	}
+	return g.returned
+}
+
+// Next runs the generator until it yields or its frame returns.
+// a runtime error inside it is handed back as the final value
+func (g *Generator) Next() (object.Object, bool) {
+	if g.done {
+		return nil, false
+	}
+	if g.running {
+		return &object.Error{Message: "generator is already running"}, true
+	}
+
+	g.running = true
+	err := g.machine.Run()
+	g.running = false
+
+	if err != nil {
+		g.finish()
+		return &object.Error{Message: err.Error()}, true
+	}
+	if g.machine.framesIndex == 0 {
+		g.returned = g.machine.StackTop()
+		g.finish()
+		return nil, false
+	}
+	return g.machine.yielded, true
+}
+
+// iteration holds the Iterator that an OpYieldFrom is walking. it takes
+// the place of the iterable in its slot until the Iterator runs out
+type iteration struct {
+	iter object.Iterator
+}
+
+func (it *iteration) Type() object.ObjectType { return "ITERATION" }
+func (it *iteration) Inspect() string         { return "iteration" }
+
+// pulls the next element of the iterable in the stack slot. once it has
+// run out, the iterable is replaced by what the yield from expression
+// evaluates to: nil, or what a generator returned
+func (vm *VM) yieldFrom(slot int) (object.Object, bool, error) {
+	it, ok := vm.stack[slot].obj.(*iteration)
+	if !ok {
+		iterable, ok := vm.stack[slot].obj.(object.Iterable)
+		if !ok {
+			return nil, false, fmt.Errorf("cannot yield from %s", vm.stack[slot].Type())
+		}
+		it = &iteration{iter: iterable.Iterator()}
+		vm.stack[slot] = ValueOf(it)
+	}
+
+	val, ok := it.iter.Next()
+	if !ok {
+		vm.stack[slot] = nilValue
+		if gen, isGen := it.iter.(object.Generator); isGen {
+			vm.stack[slot] = ValueOf(gen.Returned())
+		}
+		return nil, false, nil
+	}
+	if err, isErr := val.(*object.Error); isErr {
+		return nil, false, fmt.Errorf("%s", err.Message)
+	}
+	return val, true, nil
+}
+
+// drops the stack, since a finished generator never runs again
+func (g *Generator) finish() {
+	g.done = true
+	g.machine.stack = nil
+}
@@ -0,0 +1,144 @@
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"nala/object"
+)
+
+// WriteProfile writes the profile in the gzipped protocol buffer format
+// of pprof, so go tool pprof can show it. each sample holds the
+// instructions, time and allocations counted at a line along a call stack
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	strings := []string{""}
+	stringIndex := map[string]int{"": 0}
+	str := func(s string) uint64 {
+		i, ok := stringIndex[s]
+		if !ok {
+			i = len(strings)
+			strings = append(strings, s)
+			stringIndex[s] = i
+		}
+		return uint64(i)
+	}
+
+	var out protobuf
+	valueType := func(tag int, typ, unit string) {
+		var vt protobuf
+		vt.uint64Field(1, str(typ))
+		vt.uint64Field(2, str(unit))
+		out.messageField(tag, &vt)
+	}
+	valueType(1, "instructions", "count")
+	valueType(1, "time", "nanoseconds")
+	valueType(1, "allocations", "count")
+
+	functions := []*object.CompiledFunction{}
+	functionIDs := make(map[*object.CompiledFunction]uint64)
+	locations := []location{}
+	locationIDs := make(map[location]uint64)
+
+	for _, s := range p.samples() {
+		ids := []uint64{}
+		for _, loc := range s.stack {
+			if _, ok := functionIDs[loc.fn]; !ok {
+				functions = append(functions, loc.fn)
+				functionIDs[loc.fn] = uint64(len(functions))
+			}
+			if _, ok := locationIDs[loc]; !ok {
+				locations = append(locations, loc)
+				locationIDs[loc] = uint64(len(locations))
+			}
+			ids = append(ids, locationIDs[loc])
+		}
+
+		var sample protobuf
+		sample.packedField(1, ids)
+		sample.packedField(2, []uint64{uint64(s.instructions), uint64(s.nanos), uint64(s.allocs)})
+		out.messageField(2, &sample)
+	}
+
+	for i, loc := range locations {
+		var line, l protobuf
+		line.uint64Field(1, functionIDs[loc.fn])
+		line.uint64Field(2, uint64(loc.line))
+		l.uint64Field(1, uint64(i+1))
+		l.messageField(4, &line)
+		out.messageField(4, &l)
+	}
+
+	for i, fn := range functions {
+		var f protobuf
+		f.uint64Field(1, uint64(i+1))
+		f.uint64Field(2, str(p.funcName(fn)))
+		f.uint64Field(3, str(p.funcName(fn)))
+		f.uint64Field(4, str(fileOf(fn)))
+		f.uint64Field(5, uint64(startLine(fn)))
+		out.messageField(5, &f)
+	}
+
+	if p.interval > 0 {
+		valueType(11, "time", "nanoseconds")
+	}
+
+	// the strings have to be known before the fields after them are written
+	defaultType := str("time")
+	for _, s := range strings {
+		out.stringField(6, s)
+	}
+	if !p.begun.IsZero() {
+		out.uint64Field(9, uint64(p.begun.UnixNano()))
+	}
+	out.uint64Field(10, uint64(p.duration))
+	if p.interval > 0 {
+		out.uint64Field(12, uint64(p.interval))
+	}
+	out.uint64Field(14, defaultType)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(out.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// protobuf builds a protocol buffer message, a field at a time
+type protobuf struct {
+	bytes.Buffer
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+func (b *protobuf) uint64Field(tag int, x uint64) {
+	b.varint(uint64(tag)<<3 | 0)
+	b.varint(x)
+}
+
+func (b *protobuf) bytesField(tag int, data []byte) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protobuf) stringField(tag int, s string) {
+	b.bytesField(tag, []byte(s))
+}
+
+func (b *protobuf) messageField(tag int, m *protobuf) {
+	b.bytesField(tag, m.Bytes())
+}
+
+func (b *protobuf) packedField(tag int, xs []uint64) {
+	var packed protobuf
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytesField(tag, packed.Bytes())
+}
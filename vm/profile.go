@@ -0,0 +1,380 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"nala/object"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profiler counts what a VM does by Nala call stack and source line: the
+// instructions it runs, the time they take and the objects the VM
+// allocates for them. generators run on VMs of their own, which the
+// Profiler follows too: their frames count as called from the line that
+// resumed them. what builtins allocate is not counted, and spawned tasks
+// run on goroutines of their own and are not profiled.
+//
+// an instrumenting Profiler times every instruction. a sampling one only
+// looks at the clock every sampleCheck instructions and, once an interval
+// has passed, charges the time since the last sample to the instruction
+// running then, which slows the VM down much less
+type Profiler struct {
+	vm      *VM
+	root    *callNode   // the main program
+	running []*profiled // the VMs running now, the innermost last
+
+	interval  time.Duration // between samples, 0 when instrumenting
+	countdown int           // instructions until the clock is looked at
+	sampled   time.Time     // when the last sample was taken
+
+	current  *counts   // of the instruction running now, nil while no VM is running
+	started  time.Time // when the current instruction started
+	resumed  time.Time // when the main VM last started running
+	begun    time.Time // when the main VM first started running
+	duration time.Duration
+}
+
+// how many instructions a sampling Profiler lets run between looks at the clock
+const sampleCheck = 64
+
+// profiled is what the Profiler keeps about a running VM
+type profiled struct {
+	vm       *VM
+	caller   *callNode // what the bottom frame is called from, nil for the main VM
+	callLine int
+	stack    []*callNode // the node of each frame on the VM's call stack
+	line     int         // of the instruction running now
+	current  *counts     // left aside while a generator it resumed runs
+}
+
+type counts struct {
+	instructions int64
+	nanos        int64
+	allocs       int64
+}
+
+func (c *counts) add(other *counts) {
+	c.instructions += other.instructions
+	c.nanos += other.nanos
+	c.allocs += other.allocs
+}
+
+// callNode is a function as it was called along one particular call stack
+type callNode struct {
+	fn       *object.CompiledFunction
+	parent   *callNode
+	callLine int // the line of the parent the call was made from
+	children map[location]*callNode
+	lines    map[int]*counts // by the line the instructions were on
+}
+
+// location is a line of a function
+type location struct {
+	fn   *object.CompiledFunction
+	line int
+}
+
+func newCallNode(fn *object.CompiledFunction, parent *callNode, callLine int) *callNode {
+	return &callNode{
+		fn:       fn,
+		parent:   parent,
+		callLine: callLine,
+		children: make(map[location]*callNode),
+		lines:    make(map[int]*counts),
+	}
+}
+
+func (n *callNode) child(fn *object.CompiledFunction, callLine int) *callNode {
+	key := location{fn: fn, line: callLine}
+	child, ok := n.children[key]
+	if !ok {
+		child = newCallNode(fn, n, callLine)
+		n.children[key] = child
+	}
+	return child
+}
+
+// NewProfiler attaches an instrumenting Profiler to vm, which counts from
+// the next instruction vm runs
+func NewProfiler(vm *VM) *Profiler {
+	p := &Profiler{vm: vm, root: newCallNode(vm.frames[0].cl.Fn, nil, 0)}
+	vm.profiler = p
+	return p
+}
+
+// NewSamplingProfiler attaches a sampling Profiler to vm, which takes a
+// sample every interval while vm runs
+func NewSamplingProfiler(vm *VM, interval time.Duration) *Profiler {
+	p := NewProfiler(vm)
+	p.interval = interval
+	return p
+}
+
+// called by a VM when it starts running. a VM starting while another one
+// runs is a generator being resumed by that one's current instruction,
+// which stops being timed until the generator stops
+func (p *Profiler) resume(vm *VM) {
+	now := time.Now()
+	run := &profiled{vm: vm}
+	if n := len(p.running); n > 0 {
+		outer := p.running[n-1]
+		p.charge(now)
+		outer.current = p.current
+		run.caller, run.callLine = outer.stack[len(outer.stack)-1], outer.line
+	} else {
+		if vm != p.vm {
+			run.caller = p.root
+		}
+		if p.begun.IsZero() {
+			p.begun = now
+		}
+		p.resumed = now
+		p.sampled = now
+	}
+	p.running = append(p.running, run)
+	p.current = nil
+}
+
+// called by a VM when it stops running
+func (p *Profiler) pause() {
+	now := time.Now()
+	p.charge(now)
+	p.running = p.running[:len(p.running)-1]
+
+	if n := len(p.running); n > 0 {
+		// the instruction that resumed a generator carries on
+		p.current, p.started = p.running[n-1].current, now
+		return
+	}
+	if p.interval > 0 && p.current != nil {
+		// what is left since the last sample
+		p.current.nanos += int64(now.Sub(p.sampled))
+	}
+	p.duration += now.Sub(p.resumed)
+	p.current = nil
+}
+
+// charges the time since the current instruction started to it, when
+// instrumenting
+func (p *Profiler) charge(now time.Time) {
+	if p.current != nil && p.interval == 0 {
+		p.current.nanos += int64(now.Sub(p.started))
+	}
+}
+
+// called by the VM before it runs each instruction. when instrumenting,
+// the time since the last call is charged to the instruction that was
+// running. when sampling, a sample that is due is charged to this one
+func (p *Profiler) step() {
+	run := p.running[len(p.running)-1]
+	if p.interval == 0 {
+		now := time.Now()
+		p.charge(now)
+		p.started = now
+	}
+
+	// calls and returns move the depth a frame at a time, so the frames
+	// above the nodes kept are always new calls
+	vm := run.vm
+	depth := vm.framesIndex
+	if len(run.stack) > depth {
+		run.stack = run.stack[:depth]
+	}
+	for i := len(run.stack); i < depth; i++ {
+		switch {
+		case i > 0:
+			run.stack = append(run.stack, run.stack[i-1].child(vm.frames[i].cl.Fn, lineOf(vm.frames[i-1])))
+		case run.caller != nil:
+			run.stack = append(run.stack, run.caller.child(vm.frames[0].cl.Fn, run.callLine))
+		default:
+			run.stack = append(run.stack, p.root)
+		}
+	}
+
+	node := run.stack[depth-1]
+	run.line = lineOf(vm.frames[depth-1])
+	c, ok := node.lines[run.line]
+	if !ok {
+		c = &counts{}
+		node.lines[run.line] = c
+	}
+	c.instructions++
+	if p.interval > 0 {
+		p.countdown--
+		if p.countdown <= 0 {
+			p.countdown = sampleCheck
+			if now := time.Now(); now.Sub(p.sampled) >= p.interval {
+				c.nanos += int64(now.Sub(p.sampled))
+				p.sampled = now
+			}
+		}
+	}
+	p.current = c
+}
+
+// called by the VM when it allocates an object
+func (vm *VM) allocated() {
+	if vm.profiler != nil && vm.profiler.current != nil {
+		vm.profiler.current.allocs++
+	}
+}
+
+func lineOf(frame *Frame) int {
+	if frame.cl.Fn.Debug == nil {
+		return 0
+	}
+	return frame.cl.Fn.Debug.Line(frame.ip)
+}
+
+// sample is what was counted at one line along one call stack, whose
+// innermost location comes first
+type sample struct {
+	stack []location
+	counts
+}
+
+func (p *Profiler) samples() []sample {
+	samples := []sample{}
+	var walk func(n *callNode)
+	walk = func(n *callNode) {
+		lines := make([]int, 0, len(n.lines))
+		for line := range n.lines {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		for _, line := range lines {
+			s := sample{stack: []location{{fn: n.fn, line: line}}, counts: *n.lines[line]}
+			for c := n; c.parent != nil; c = c.parent {
+				s.stack = append(s.stack, location{fn: c.parent.fn, line: c.callLine})
+			}
+			samples = append(samples, s)
+		}
+
+		children := make([]*callNode, 0, len(n.children))
+		for _, child := range n.children {
+			children = append(children, child)
+		}
+		sort.Slice(children, func(i, j int) bool {
+			if children[i].callLine != children[j].callLine {
+				return children[i].callLine < children[j].callLine
+			}
+			return p.funcName(children[i].fn) < p.funcName(children[j].fn)
+		})
+		for _, child := range children {
+			walk(child)
+		}
+	}
+	walk(p.root)
+	return samples
+}
+
+// funcName names fn for reports. functions not bound to a name are
+// named by the line they start on
+func (p *Profiler) funcName(fn *object.CompiledFunction) string {
+	if fn == p.root.fn {
+		return "main"
+	}
+	if fn.Debug == nil {
+		return "<anonymous>"
+	}
+	if fn.Debug.Name != "" {
+		return fn.Debug.Name
+	}
+	return fmt.Sprintf("fn@%d", startLine(fn))
+}
+
+func fileOf(fn *object.CompiledFunction) string {
+	if fn.Debug == nil {
+		return ""
+	}
+	return fn.Debug.File
+}
+
+func startLine(fn *object.CompiledFunction) int {
+	if fn.Debug == nil || len(fn.Debug.Lines) == 0 {
+		return 0
+	}
+	return fn.Debug.Lines[0].Line
+}
+
+// a row of the top report: what ran in an entry itself, and what ran
+// while it was on the call stack
+type topEntry struct {
+	name string
+	flat counts
+	cum  counts
+}
+
+// WriteTop writes a report of the n functions the most time was spent
+// in, or of the n lines when byLine is set
+func (p *Profiler) WriteTop(w io.Writer, n int, byLine bool) error {
+	entries := make(map[string]*topEntry)
+	entry := func(loc location) *topEntry {
+		name := p.funcName(loc.fn)
+		if byLine {
+			name = fmt.Sprintf("%s %s:%d", name, fileOf(loc.fn), loc.line)
+		}
+		e, ok := entries[name]
+		if !ok {
+			e = &topEntry{name: name}
+			entries[name] = e
+		}
+		return e
+	}
+
+	total := counts{}
+	for _, s := range p.samples() {
+		total.add(&s.counts)
+		entry(s.stack[0]).flat.add(&s.counts)
+		// recursion puts an entry on the stack more than once
+		seen := make(map[*topEntry]bool)
+		for _, loc := range s.stack {
+			if e := entry(loc); !seen[e] {
+				seen[e] = true
+				e.cum.add(&s.counts)
+			}
+		}
+	}
+
+	sorted := make([]*topEntry, 0, len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.flat.nanos != b.flat.nanos {
+			return a.flat.nanos > b.flat.nanos
+		}
+		if a.cum.nanos != b.cum.nanos {
+			return a.cum.nanos > b.cum.nanos
+		}
+		return a.name < b.name
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+
+	kind := "functions"
+	if byLine {
+		kind = "lines"
+	}
+	fmt.Fprintf(w, "Showing the top %d of %d %s by time. total: %s, %d instructions, %d allocations\n",
+		len(sorted), len(entries), kind, time.Duration(total.nanos), total.instructions, total.allocs)
+	fmt.Fprintf(w, "%12s %6s %12s %6s %12s %10s  %s\n", "flat", "flat%", "cum", "cum%", "instructions", "allocs", strings.TrimSuffix(kind, "s"))
+	for _, e := range sorted {
+		fmt.Fprintf(w, "%12s %6s %12s %6s %12d %10d  %s\n",
+			time.Duration(e.flat.nanos), percent(e.flat.nanos, total.nanos),
+			time.Duration(e.cum.nanos), percent(e.cum.nanos, total.nanos),
+			e.flat.instructions, e.flat.allocs, e.name)
+	}
+	return nil
+}
+
+func percent(part, whole int64) string {
+	if whole == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(part)/float64(whole))
+}
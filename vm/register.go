@@ -0,0 +1,259 @@
+package vm
+
+import (
+	"fmt"
+	"nala/compiler"
+	"nala/object"
+	"nala/opcode"
+)
+
+// NewRegister makes a VM that runs a program compiled by the register
+// compiler. its frames keep their registers in the stack, from their base
+// pointer up, and a called function's registers start at its first
+// argument, so calls don't copy them. the debugger and the profiler only
+// follow programs compiled for the stack
+func NewRegister(bc *compiler.RegisterByteCode) *VM {
+	vm := New(&compiler.ByteCode{Constants: bc.Constants})
+	vm.registers = true
+	vm.frames[0] = NewFrame(&object.Closure{Fn: bc.Main}, 0)
+	return vm
+}
+
+func NewRegisterWithGlobalsStore(bc *compiler.RegisterByteCode, globs []Value) *VM {
+	vm := NewRegister(bc)
+	vm.globals = globs
+	return vm
+}
+
+// runRegisters is Run for register code. the value of the main program
+// is kept in result rather than left on the stack
+func (vm *VM) runRegisters() error {
+	frame := vm.currentFrame()
+	vm.reserve(frame.basePointer + frame.cl.Fn.NumOfRegisters)
+	code := frame.cl.Fn.Registers
+	regs := vm.stack[frame.basePointer:]
+
+	for {
+		frame.ip++
+		if frame.ip >= len(code) {
+			return nil
+		}
+		in := code[frame.ip]
+
+		switch in.Op {
+		case opcode.RegMove:
+			regs[in.A] = regs[in.B]
+		case opcode.RegLoadConstant:
+			regs[in.A] = vm.values[in.B]
+		case opcode.RegLoadTrue:
+			regs[in.A] = trueValue
+		case opcode.RegLoadFalse:
+			regs[in.A] = falseValue
+		case opcode.RegLoadNil:
+			regs[in.A] = nilValue
+		case opcode.RegGetGlobal:
+			global, err := vm.global(in.B)
+			if err != nil {
+				return err
+			}
+			regs[in.A] = global
+		case opcode.RegSetGlobal:
+			if err := vm.setGlobal(in.A, regs[in.B]); err != nil {
+				return err
+			}
+		case opcode.RegGetBuiltin:
+			def := object.Builtins[in.B]
+			regs[in.A] = ValueOf(vm.bindBuiltin(def.Name, def.BuiltIn))
+		case opcode.RegGetFree:
+			regs[in.A] = ValueOf(frame.cl.FreeVariables[in.B])
+		case opcode.RegAdd, opcode.RegSubtract, opcode.RegMultiply, opcode.RegDivide, opcode.RegModulo,
+			opcode.RegGThan, opcode.RegLThan, opcode.RegEqual, opcode.RegNotEqual:
+			res, err := vm.registerOperation(in.Op, regs[in.B], regs[in.C])
+			if err != nil {
+				return err
+			}
+			regs[in.A] = res
+		case opcode.RegAddConstant, opcode.RegSubtractConstant, opcode.RegLThanConstant,
+			opcode.RegGThanConstant, opcode.RegEqualConstant, opcode.RegNotEqualConstant:
+			res, err := vm.registerOperation(in.Op, regs[in.B], vm.values[in.C])
+			if err != nil {
+				return err
+			}
+			regs[in.A] = res
+		case opcode.RegNegateInt, opcode.RegNegateBool:
+			op := opcode.OpNegateInt
+			if in.Op == opcode.RegNegateBool {
+				op = opcode.OpNegateBool
+			}
+			res, err := vm.unaryOperation(op, regs[in.B])
+			if err != nil {
+				return err
+			}
+			regs[in.A] = res
+		case opcode.RegJump:
+			frame.ip = in.A - 1
+		case opcode.RegJumpNotTruthy:
+			if !isTruthy(regs[in.A]) {
+				frame.ip = in.B - 1
+			}
+		case opcode.RegArray, opcode.RegHashMap, opcode.RegSet:
+			op := opcode.OpArray
+			if in.Op == opcode.RegHashMap {
+				op = opcode.OpHashMap
+			} else if in.Op == opcode.RegSet {
+				op = opcode.OpSet
+			}
+			res, err := vm.buildCollection(op, regs[in.B:in.B+in.C])
+			if err != nil {
+				return err
+			}
+			regs[in.A] = res
+		case opcode.RegIndex:
+			res, err := vm.indexOperation(regs[in.B], regs[in.C])
+			if err != nil {
+				return err
+			}
+			regs[in.A] = res
+		case opcode.RegGetField:
+			field := vm.constants[in.C].(*object.String).Value
+			res, err := vm.getField(vm.toObject(regs[in.B]), field)
+			if err != nil {
+				return err
+			}
+			regs[in.A] = res
+		case opcode.RegSetField:
+			field := vm.constants[in.B].(*object.String).Value
+			err := vm.setField(vm.toObject(regs[in.A]), field, vm.toObject(regs[in.C]))
+			if err != nil {
+				return err
+			}
+		case opcode.RegCall, opcode.RegTailCall:
+			slot := frame.basePointer + in.A
+			err := vm.callRegisters(regs[in.A], slot+1, in.B, slot, in.Op == opcode.RegTailCall)
+			if err != nil {
+				return err
+			}
+			frame = vm.currentFrame()
+			code, regs = frame.cl.Fn.Registers, vm.stack[frame.basePointer:]
+		case opcode.RegCallMethod:
+			slot := frame.basePointer + in.A
+			receiver := vm.toObject(regs[in.A])
+			name := vm.constants[in.B].(*object.String).Value
+
+			method, ok := object.ResolveMethod(receiver, name)
+			if !ok {
+				return fmt.Errorf("%s", object.MethodError(receiver, name))
+			}
+			// the receiver is the first argument
+			err := vm.callRegisters(ValueOf(method), slot, in.C+1, slot, false)
+			if err != nil {
+				return err
+			}
+			frame = vm.currentFrame()
+			code, regs = frame.cl.Fn.Registers, vm.stack[frame.basePointer:]
+		case opcode.RegReturn:
+			val := regs[in.A]
+			returning := vm.popFrame()
+			if vm.framesIndex == 0 {
+				return vm.finishFunction(val)
+			}
+			vm.stack[returning.ret] = val
+
+			frame = vm.currentFrame()
+			code, regs = frame.cl.Fn.Registers, vm.stack[frame.basePointer:]
+		case opcode.RegClosure:
+			fn := vm.constants[in.B].(*object.CompiledFunction)
+			free := make([]object.Object, in.C)
+			for i := range free {
+				free[i] = vm.toObject(regs[in.A+i])
+			}
+			vm.allocated()
+			regs[in.A] = ValueOf(&object.Closure{Fn: fn, FreeVariables: free})
+		case opcode.RegYield:
+			vm.yielded = vm.toObject(regs[in.A])
+			// left for the yield expression to evaluate to once resumed
+			regs[in.A] = nilValue
+			return nil
+		case opcode.RegYieldFrom:
+			val, ok, err := vm.yieldFrom(frame.basePointer + in.A)
+			if err != nil {
+				return err
+			}
+			if ok {
+				vm.yielded = val
+				frame.ip-- // come back here when resumed
+				return nil
+			}
+		case opcode.RegResult:
+			vm.result = regs[in.A]
+		default:
+			return fmt.Errorf("unknown register opcode %d", in.Op)
+		}
+	}
+}
+
+// registerOperation applies the operator of op, working on integers
+// without going through binaryOperation
+func (vm *VM) registerOperation(op opcode.RegOp, left, right Value) (Value, error) {
+	if left.kind == kindInteger && right.kind == kindInteger {
+		switch op {
+		case opcode.RegAdd, opcode.RegAddConstant:
+			return integerValue(left.n + right.n), nil
+		case opcode.RegSubtract, opcode.RegSubtractConstant:
+			return integerValue(left.n - right.n), nil
+		case opcode.RegMultiply:
+			return integerValue(left.n * right.n), nil
+		case opcode.RegLThan, opcode.RegLThanConstant:
+			return booleanValue(left.n < right.n), nil
+		case opcode.RegGThan, opcode.RegGThanConstant:
+			return booleanValue(left.n > right.n), nil
+		case opcode.RegEqual, opcode.RegEqualConstant:
+			return booleanValue(left.n == right.n), nil
+		case opcode.RegNotEqual, opcode.RegNotEqualConstant:
+			return booleanValue(left.n != right.n), nil
+		}
+	}
+	stackOp, _ := op.Operator()
+	return vm.binaryOperation(stackOp, left, right)
+}
+
+// callRegisters calls callee with the numArgs values from the stack slot
+// args, leaving what it returns in the slot ret. a closure's frame starts
+// at args, so they are its first registers. a tail call hands the closure
+// the frame of the running function instead, and its result goes where
+// that function's would have
+func (vm *VM) callRegisters(callee Value, args, numArgs, ret int, tail bool) error {
+	switch fn := callee.obj.(type) {
+	case *object.Closure:
+		if numArgs != fn.Fn.NumOfParameters {
+			return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.Fn.NumOfParameters, numArgs)
+		}
+		if fn.Fn.IsGenerator {
+			gen := vm.newGenerator(fn, vm.stack[args:args+numArgs])
+			vm.stack[ret] = ValueOf(gen)
+			return nil
+		}
+		if tail {
+			caller := vm.popFrame()
+			copy(vm.stack[caller.basePointer:], vm.stack[args:args+numArgs])
+			args, ret = caller.basePointer, caller.ret
+		}
+		if vm.framesIndex >= MaxFrames {
+			return fmt.Errorf("stack overflow")
+		}
+		vm.reserve(args + fn.Fn.NumOfRegisters)
+		frame := NewFrame(fn, args)
+		frame.ret = ret
+		vm.pushFrame(frame)
+	case *object.BuiltIn:
+		res := fn.Fn(vm.objects(vm.stack[args : args+numArgs])...)
+		vm.stack[ret] = ValueOf(res)
+	case *object.StructType:
+		res := fn.Construct(vm.objects(vm.stack[args : args+numArgs])...)
+		vm.allocated()
+		vm.stack[ret] = ValueOf(res)
+	default:
+		return fmt.Errorf("calling non-closure and non-builtin [%T]", callee.Object())
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package vm
+
+import (
+	"fmt"
+	"nala/object"
+)
+
+// spawn has to start closures on a VM of its own, so the VM loading it
+// binds it to itself. every other builtin is used as it is
+func (vm *VM) bindBuiltin(name string, bi *object.BuiltIn) *object.BuiltIn {
+	if name != "spawn" {
+		return bi
+	}
+	return &object.BuiltIn{Fn: vm.spawn, Desc: bi.Desc}
+}
+
+// spawn runs a closure on a new VM, which shares the constants with this
+// one but works on copies of the globals and of the arguments.
+// see the concurrency model in object/concurrency.go
+func (vm *VM) spawn(args ...object.Object) object.Object {
+	if len(args) == 0 {
+		return &object.Error{Message: "wrong number of arguments. got=0, want=1 or more"}
+	}
+
+	copier := object.NewCopier()
+	copied := make([]object.Object, len(args))
+	for i, arg := range args {
+		dup, err := copier.Copy(arg)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		copied[i] = dup
+	}
+	fn, fnArgs := copied[0], copied[1:]
+
+	task := object.NewTask()
+	switch fn := fn.(type) {
+	case *object.Closure:
+		if len(fnArgs) != fn.Fn.NumOfParameters {
+			return &object.Error{Message: fmt.Sprintf("wrong number of arguments: want=%d, got=%d",
+				fn.Fn.NumOfParameters, len(fnArgs))}
+		}
+		values := make([]Value, len(fnArgs))
+		for i, arg := range fnArgs {
+			values[i] = ValueOf(arg)
+		}
+		constants, constValues, err := copyConstants(copier, vm.constants, vm.values)
+		if err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		machine := vm.newFunctionVM(copyGlobals(copier, vm.globals), fn, values)
+		machine.constants, machine.values = constants, constValues
+		go func() {
+			if err := machine.Run(); err != nil {
+				task.Finish(&object.Error{Message: err.Error()})
+				return
+			}
+			task.Finish(machine.StackTop())
+		}()
+	case *object.BuiltIn:
+		go func() { task.Finish(fn.Fn(fnArgs...)) }()
+	case *object.StructType:
+		go func() { task.Finish(fn.Construct(fnArgs...)) }()
+	default:
+		return &object.Error{Message: fmt.Sprintf("cannot spawn %s", fn.Type())}
+	}
+	return task
+}
+
+// copyGlobals copies the globals for a new task, up to the last one set.
+// integers are made again rather than shared, since hashing an integer
+// writes to it. a global that can't be copied, like a Generator, fails
+// with the Copier's error if the task reads it
+func copyGlobals(copier *object.Copier, globals []Value) []Value {
+	n := len(globals)
+	for n > 0 && globals[n-1].kind == kindEmpty {
+		n--
+	}
+
+	dup := make([]Value, n)
+	for i, g := range globals[:n] {
+		switch g.kind {
+		case kindEmpty:
+		case kindInteger:
+			dup[i] = integerValue(g.n)
+		case kindObject:
+			val, err := copier.Copy(g.obj)
+			if err != nil {
+				dup[i] = Value{kind: kindUnshared, obj: &object.Error{Message: err.Error()}}
+				continue
+			}
+			dup[i] = ValueOf(val)
+		default:
+			dup[i] = g
+		}
+	}
+	return dup
+}
+
+// copyConstants gives a new task copies of the struct types among the
+// constants, as setting a method on one changes it. the other constants
+// never change, so without struct types the task shares them all
+func copyConstants(copier *object.Copier, constants []object.Object, values []Value) ([]object.Object, []Value, error) {
+	var dupConstants []object.Object
+	var dupValues []Value
+	for i, c := range constants {
+		def, ok := c.(*object.StructType)
+		if !ok {
+			continue
+		}
+		if dupConstants == nil {
+			dupConstants = append([]object.Object{}, constants...)
+			dupValues = append([]Value{}, values...)
+		}
+		dup, err := copier.Copy(def)
+		if err != nil {
+			return nil, nil, err
+		}
+		dupConstants[i], dupValues[i] = dup, ValueOf(dup)
+	}
+	if dupConstants == nil {
+		return constants, values, nil
+	}
+	return dupConstants, dupValues, nil
+}
@@ -0,0 +1,109 @@
+package vm
+
+import "nala/object"
+
+// Value is what the VM keeps on its stack and in its globals. integers,
+// booleans and nil are held in the Value itself, so working with them
+// doesn't allocate. everything else is held as the object it is. the zero
+// Value is an empty slot, like a global that hasn't been set
+type Value struct {
+	kind valueKind
+	n    int64 // the integer, or 1 for true and 0 for false
+
+	// the object, for kindObject. an integer read from an object keeps
+	// it too, so turning it back into one costs nothing
+	obj object.Object
+}
+
+type valueKind uint8
+
+const (
+	kindEmpty valueKind = iota
+	kindInteger
+	kindBoolean
+	kindNil
+	kindObject
+	kindUnshared // a global a task could not be given a copy of, obj is the error reading it raises
+)
+
+var (
+	trueValue  = Value{kind: kindBoolean, n: 1}
+	falseValue = Value{kind: kindBoolean, n: 0}
+	nilValue   = Value{kind: kindNil}
+)
+
+func integerValue(n int64) Value {
+	return Value{kind: kindInteger, n: n}
+}
+
+func booleanValue(b bool) Value {
+	if b {
+		return trueValue
+	}
+	return falseValue
+}
+
+// ValueOf is o as a Value
+func ValueOf(o object.Object) Value {
+	switch o := o.(type) {
+	case nil:
+		return Value{}
+	case *object.Integer:
+		return Value{kind: kindInteger, n: o.Value, obj: o}
+	case *object.Boolean:
+		return booleanValue(o.Value)
+	case *object.Nil:
+		return nilValue
+	}
+	return Value{kind: kindObject, obj: o}
+}
+
+// Object is v as an object, made for it if v holds an integer made by
+// the VM. an empty Value is nil
+func (v Value) Object() object.Object {
+	switch v.kind {
+	case kindInteger:
+		if v.obj == nil {
+			return &object.Integer{Value: v.n}
+		}
+	case kindBoolean:
+		return nativeBoolToBooleanObject(v.n == 1)
+	case kindNil:
+		return NIL
+	}
+	return v.obj
+}
+
+// Type is the type of the object v stands for
+func (v Value) Type() object.ObjectType {
+	switch v.kind {
+	case kindInteger:
+		return object.INTEGER_OBJ
+	case kindBoolean:
+		return object.BOOLEAN_OBJ
+	case kindNil:
+		return object.NIL_OBJ
+	case kindObject:
+		return v.obj.Type()
+	}
+	return ""
+}
+
+// toObject is Object, counting the allocation for the profiler when one
+// has to be made
+func (vm *VM) toObject(v Value) object.Object {
+	if v.kind == kindInteger && v.obj == nil {
+		vm.allocated()
+	}
+	return v.Object()
+}
+
+// objects is values as objects, in a slice the VM reuses for each call.
+// builtins and constructors, which it is for, never keep hold of it
+func (vm *VM) objects(values []Value) []object.Object {
+	vm.scratch = vm.scratch[:0]
+	for _, v := range values {
+		vm.scratch = append(vm.scratch, vm.toObject(v))
+	}
+	return vm.scratch
+}
@@ -1,52 +1,71 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"nala/compiler"
 	"nala/object"
 	"nala/opcode"
 )
 
+// StackSize is what the main program's stack starts with. it grows from
+// there, so it is MaxFrames that bounds how deep calls go
 const StackSize = 2048
 const GlobalsSize = 65536
 const MaxFrames = 1024
 
+// ErrUnverified is what Run returns for bytecode loaded from outside the
+// compiler that compiler.Verify has not passed
+var ErrUnverified = errors.New("refusing to run unverified bytecode")
+
 var (
-	TRUE = &object.Boolean{
-		Value:       true,
-		HashableKey: &object.HashKey{},
-	}
-	FALSE = &object.Boolean{
-		Value:       false,
-		HashableKey: &object.HashKey{},
-	}
-	NIL = &object.Nil{}
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+	NIL   = object.NIL
 )
 
 type VM struct {
 	constants []object.Object
-	globals   []object.Object
+	values    []Value // the constants as Values
+	globals   []Value
 
-	stack []object.Object
-	sp    int // ALways points to the next value Top of stack is stack[sp-1]
+	stack   []Value
+	sp      int             // ALways points to the next value Top of stack is stack[sp-1]
+	scratch []object.Object // reused for the arguments to builtins, see objects
 
 	frames      []*Frame // Call Stack to contain Frames of called functions
 	framesIndex int      // Index into the call stack
+
+	yielded object.Object // the value a generator's VM suspended with
+
+	debugger *Debugger // consulted before each instruction, when set
+	profiler *Profiler // told of each instruction and allocation, when set
+
+	unverified bool // the bytecode was loaded and not verified
+
+	registers bool  // runs register code, see NewRegister
+	result    Value // the value of the register code's main program
 }
 
-func (vm *VM) Globals() []object.Object {
+func (vm *VM) Globals() []Value {
 	return vm.globals
 }
 
 func (vm *VM) StackTop() object.Object {
+	if vm.registers {
+		return vm.result.Object()
+	}
 	if vm.sp == 0 {
 		return nil
 	}
-	return vm.stack[vm.sp-1]
+	return vm.stack[vm.sp-1].Object()
 }
 
 func (vm *VM) LastPoppedElement() object.Object {
-	return vm.stack[vm.sp]
+	if vm.registers {
+		return vm.result.Object()
+	}
+	return vm.stack[vm.sp].Object()
 }
 
 // rewrite switch into a dispatch map of functions
@@ -55,9 +74,29 @@ func (vm *VM) Run() error {
 	var ins opcode.Instructions
 	var op opcode.OpCode
 
+	if vm.unverified {
+		return ErrUnverified
+	}
+	if vm.registers {
+		return vm.runRegisters()
+	}
+	if vm.profiler != nil {
+		vm.profiler.resume(vm)
+		defer vm.profiler.pause()
+	}
+
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
 
+		if vm.debugger != nil {
+			if err := vm.debugger.check(); err != nil {
+				return err
+			}
+		}
+		if vm.profiler != nil {
+			vm.profiler.step()
+		}
+
 		insPtr = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = opcode.OpCode(ins[insPtr]) // fetch instruction
@@ -70,7 +109,7 @@ func (vm *VM) Run() error {
 			vm.currentFrame().ip += 2
 
 			// execute instruction
-			err := vm.push(vm.constants[constIndex])
+			err := vm.push(vm.values[constIndex])
 			if err != nil {
 				return err
 			}
@@ -84,12 +123,12 @@ func (vm *VM) Run() error {
 		case opcode.OpPop:
 			vm.pop()
 		case opcode.OpTrue:
-			err := vm.push(TRUE)
+			err := vm.push(trueValue)
 			if err != nil {
 				return err
 			}
 		case opcode.OpFalse:
-			err := vm.push(FALSE)
+			err := vm.push(falseValue)
 			if err != nil {
 				return err
 			}
@@ -110,7 +149,7 @@ func (vm *VM) Run() error {
 				vm.currentFrame().ip = newPos - 1 // perform JumpNotTruthy, else continue executing
 			}
 		case opcode.OpNil:
-			err := vm.push(NIL)
+			err := vm.push(nilValue)
 			if err != nil {
 				return err
 			}
@@ -119,38 +158,47 @@ func (vm *VM) Run() error {
 
 			vm.currentFrame().ip += 2
 
-			vm.globals[globalIndex] = vm.pop() // set the global at that position to the top of stack
+			// set the global at that position to the top of stack
+			if err := vm.setGlobal(int(globalIndex), vm.pop()); err != nil {
+				return err
+			}
 		case opcode.OpGetGlobal:
 			globalIndex := opcode.ReadUInt16(ins[insPtr+1:])
 
 			vm.currentFrame().ip += 2
 
-			err := vm.push(vm.globals[globalIndex])
+			global, err := vm.global(int(globalIndex))
 			if err != nil {
 				return err
 			}
-		case opcode.OpArray:
+			err = vm.push(global)
+			if err != nil {
+				return err
+			}
+		case opcode.OpArray, opcode.OpHashMap, opcode.OpSet:
 			numElems := int(opcode.ReadUInt16(ins[insPtr+1:]))
 			vm.currentFrame().ip += 2
 
-			start := vm.sp - numElems
-			array := vm.buildArray(start, vm.sp)
-			vm.sp = start
-			err := vm.push(array)
+			err := vm.executeCollection(op, numElems)
 			if err != nil {
 				return err
 			}
-		case opcode.OpHashMap:
-			numElems := int(opcode.ReadUInt16(ins[insPtr+1:]))
+		case opcode.OpGetField:
+			nameIndex := opcode.ReadUInt16(ins[insPtr+1:])
 			vm.currentFrame().ip += 2
 
-			start := vm.sp - numElems
-			hashMap, err := vm.buildHashMap(start, vm.sp)
+			field := vm.constants[nameIndex].(*object.String).Value
+			err := vm.executeGetField(vm.toObject(vm.pop()), field)
 			if err != nil {
 				return err
 			}
-			vm.sp = start
-			err = vm.push(hashMap)
+		case opcode.OpSetField:
+			nameIndex := opcode.ReadUInt16(ins[insPtr+1:])
+			vm.currentFrame().ip += 2
+
+			field := vm.constants[nameIndex].(*object.String).Value
+			value := vm.toObject(vm.pop())
+			err := vm.executeSetField(vm.toObject(vm.pop()), field, value)
 			if err != nil {
 				return err
 			}
@@ -164,9 +212,12 @@ func (vm *VM) Run() error {
 			}
 		case opcode.OpReturn:
 			frame := vm.popFrame()
+			if vm.framesIndex == 0 {
+				return vm.finishFunction(nilValue)
+			}
 			vm.sp = frame.basePointer - 1
 
-			err := vm.push(NIL)
+			err := vm.push(nilValue)
 			if err != nil {
 				return err
 			}
@@ -174,6 +225,9 @@ func (vm *VM) Run() error {
 			returnVal := vm.pop()
 
 			frame := vm.popFrame()
+			if vm.framesIndex == 0 {
+				return vm.finishFunction(returnVal)
+			}
 			vm.sp = frame.basePointer - 1
 
 			err := vm.push(returnVal)
@@ -189,6 +243,35 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+		case opcode.OpCallMethod:
+			nameIndex := opcode.ReadUInt16(ins[insPtr+1:])
+			numArgs := int(opcode.ReadUInt8(ins[insPtr+3:]))
+			vm.currentFrame().ip += 3
+
+			name := vm.constants[nameIndex].(*object.String).Value
+			err := vm.executeMethodCall(name, numArgs)
+			if err != nil {
+				return err
+			}
+		case opcode.OpYield:
+			vm.yielded = vm.toObject(vm.pop())
+
+			// left for the yield expression to evaluate to once resumed
+			err := vm.push(nilValue)
+			if err != nil {
+				return err
+			}
+			return nil
+		case opcode.OpYieldFrom:
+			val, ok, err := vm.yieldFrom(vm.sp - 1)
+			if err != nil {
+				return err
+			}
+			if ok {
+				vm.yielded = val
+				vm.currentFrame().ip = insPtr - 1 // come back here when resumed
+				return nil
+			}
 		case opcode.OpSetLocal:
 			localIndex := opcode.ReadUInt8(ins[insPtr+1:])
 			vm.currentFrame().ip += 1
@@ -214,7 +297,7 @@ func (vm *VM) Run() error {
 
 			def := object.Builtins[builtinIndex]
 
-			err := vm.push(def.BuiltIn)
+			err := vm.push(ValueOf(vm.bindBuiltin(def.Name, def.BuiltIn)))
 			if err != nil {
 				return err
 			}
@@ -233,11 +316,52 @@ func (vm *VM) Run() error {
 			currentCl := vm.currentFrame().cl
 			free := currentCl.FreeVariables[freeIndex]
 			// fmt.Println(currentCl.FreeVariables)
-			err := vm.push(free)
+			err := vm.push(ValueOf(free))
+			if err != nil {
+				return err
+			}
+		case opcode.OpWide:
+			err := vm.executeWide(ins, insPtr)
+			if err != nil {
+				return err
+			}
+		case opcode.OpGetLocal0, opcode.OpGetLocal1, opcode.OpGetLocal2, opcode.OpGetLocal3:
+			frame := vm.currentFrame()
+
+			err := vm.push(vm.stack[frame.basePointer+int(op-opcode.OpGetLocal0)])
+			if err != nil {
+				return err
+			}
+		case opcode.OpAddConstant, opcode.OpSubtractConstant, opcode.OpLThanConstant,
+			opcode.OpGThanConstant, opcode.OpEqualConstant, opcode.OpNotEqualConstant:
+			constIndex := opcode.ReadUInt16(ins[insPtr+1:])
+			vm.currentFrame().ip += 2
+
+			err := vm.executeBinaryConstant(op, vm.values[constIndex])
 			if err != nil {
 				return err
 			}
+		case opcode.OpCallGlobal:
+			globalIndex := opcode.ReadUInt16(ins[insPtr+1:])
+			numArgs := int(opcode.ReadUInt8(ins[insPtr+3:]))
+			vm.currentFrame().ip += 3
 
+			global, err := vm.global(int(globalIndex))
+			if err != nil {
+				return err
+			}
+			err = vm.executeCallGlobal(global, numArgs)
+			if err != nil {
+				return err
+			}
+		case opcode.OpTailCall:
+			numArgs := int(opcode.ReadUInt8(ins[insPtr+1:]))
+			vm.currentFrame().ip++
+
+			err := vm.executeTailCall(numArgs)
+			if err != nil {
+				return err
+			}
 		}
 		// def, _ := opcode.Lookup(byte(op))
 		// fmt.Println(def.Name)
@@ -251,6 +375,61 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// executeWide runs the instruction the OpWide at insPtr prefixes, with
+// its operands read at twice their usual widths
+func (vm *VM) executeWide(ins opcode.Instructions, insPtr int) error {
+	in, err := opcode.Decode(ins[insPtr:])
+	if err != nil {
+		return err
+	}
+	vm.currentFrame().ip = insPtr + in.Size - 1
+	operands := in.Operands
+
+	switch in.Op {
+	case opcode.OpConstant:
+		return vm.push(vm.values[operands[0]])
+	case opcode.OpJump:
+		vm.currentFrame().ip = operands[0] - 1
+	case opcode.OpJumpNotTruthy:
+		if !isTruthy(vm.pop()) {
+			vm.currentFrame().ip = operands[0] - 1
+		}
+	case opcode.OpArray, opcode.OpHashMap, opcode.OpSet:
+		return vm.executeCollection(in.Op, operands[0])
+	case opcode.OpGetField:
+		field := vm.constants[operands[0]].(*object.String).Value
+		return vm.executeGetField(vm.toObject(vm.pop()), field)
+	case opcode.OpSetField:
+		field := vm.constants[operands[0]].(*object.String).Value
+		value := vm.toObject(vm.pop())
+		return vm.executeSetField(vm.toObject(vm.pop()), field, value)
+	case opcode.OpCall:
+		return vm.executeCall(operands[0])
+	case opcode.OpCallMethod:
+		name := vm.constants[operands[0]].(*object.String).Value
+		return vm.executeMethodCall(name, operands[1])
+	case opcode.OpSetLocal:
+		vm.stack[vm.currentFrame().basePointer+operands[0]] = vm.pop()
+	case opcode.OpGetLocal:
+		return vm.push(vm.stack[vm.currentFrame().basePointer+operands[0]])
+	case opcode.OpGetBuiltin:
+		def := object.Builtins[operands[0]]
+		return vm.push(ValueOf(vm.bindBuiltin(def.Name, def.BuiltIn)))
+	case opcode.OpClosure:
+		return vm.pushClosure(operands[0], operands[1])
+	case opcode.OpGetFree:
+		return vm.push(ValueOf(vm.currentFrame().cl.FreeVariables[operands[0]]))
+	case opcode.OpAddConstant, opcode.OpSubtractConstant, opcode.OpLThanConstant,
+		opcode.OpGThanConstant, opcode.OpEqualConstant, opcode.OpNotEqualConstant:
+		return vm.executeBinaryConstant(in.Op, vm.values[operands[0]])
+	case opcode.OpTailCall:
+		return vm.executeTailCall(operands[0])
+	default:
+		return fmt.Errorf("%s can't be made wide", in.Def.Name)
+	}
+	return nil
+}
+
 func (vm *VM) pushClosure(fnIndex int, freeSyms int) error {
 	fnConst := vm.constants[fnIndex]
 	fn, ok := fnConst.(*object.CompiledFunction)
@@ -263,20 +442,21 @@ func (vm *VM) pushClosure(fnIndex int, freeSyms int) error {
 	// fmt.Println(vm.stack[vm.sp-freeSyms : vm.sp])
 
 	for i := 0; i < freeSyms; i++ {
-		free[i] = vm.stack[vm.sp-freeSyms+i]
+		free[i] = vm.toObject(vm.stack[vm.sp-freeSyms+i])
 	}
 	vm.sp = vm.sp - freeSyms
 
+	vm.allocated()
 	closure := &object.Closure{
 		Fn:            fn,
 		FreeVariables: free,
 	}
-	return vm.push(closure)
+	return vm.push(ValueOf(closure))
 }
 
 func (vm *VM) executeCall(numArgs int) error {
 	// reach down and get the function past the arguments
-	callable := vm.stack[vm.sp-numArgs-1]
+	callable := vm.stack[vm.sp-numArgs-1].obj
 	// fmt.Println(callable.Inspect(), " ", callable.Type())
 
 	// fmt.Println(vm.currentFrame().Instructions().String())
@@ -286,88 +466,261 @@ func (vm *VM) executeCall(numArgs int) error {
 		return vm.callClosure(callable, numArgs)
 	case *object.BuiltIn:
 		return vm.callBuiltin(callable, numArgs)
+	case *object.StructType:
+		return vm.callConstructor(callable, numArgs)
 	default:
-		return fmt.Errorf("calling non-closure and non-builtin [%T]", callable)
+		return fmt.Errorf("calling non-closure and non-builtin [%T]", vm.stack[vm.sp-numArgs-1].Object())
+	}
+}
+
+// slides the global function in under the arguments, where OpCall
+// would have pushed it
+func (vm *VM) executeCallGlobal(callee Value, numArgs int) error {
+	vm.reserve(vm.sp + 1)
+	calleePos := vm.sp - numArgs
+	copy(vm.stack[calleePos+1:vm.sp+1], vm.stack[calleePos:vm.sp])
+	vm.stack[calleePos] = callee
+	vm.sp++
+
+	return vm.executeCall(numArgs)
+}
+
+// executeTailCall makes a call whose value the caller returns right away.
+// a closure takes over the caller's frame, so recursion in tail position
+// needs no more frames. anything else is called as usual, leaving its
+// value for the OpReturnValue after. with a debugger attached every call
+// keeps its frame, so it can be shown
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-numArgs-1].obj.(*object.Closure)
+	if !ok || cl.Fn.IsGenerator || cl.Fn.NumOfParameters != numArgs || vm.debugger != nil {
+		return vm.executeCall(numArgs)
+	}
+	frame := vm.popFrame()
+	copy(vm.stack[frame.basePointer:], vm.stack[vm.sp-numArgs:vm.sp])
+	vm.sp = frame.basePointer + numArgs
+
+	return vm.callClosure(cl, numArgs)
+}
+
+// slides the method in under the receiver, so the call sees the
+// receiver as its first argument
+func (vm *VM) executeMethodCall(name string, numArgs int) error {
+	receiverPos := vm.sp - numArgs - 1
+	receiver := vm.toObject(vm.stack[receiverPos])
+
+	method, ok := object.ResolveMethod(receiver, name)
+	if !ok {
+		return fmt.Errorf("%s", object.MethodError(receiver, name))
 	}
+
+	vm.reserve(vm.sp + 1)
+	copy(vm.stack[receiverPos+1:vm.sp+1], vm.stack[receiverPos:vm.sp])
+	vm.stack[receiverPos] = ValueOf(method)
+	vm.sp++
+
+	return vm.executeCall(numArgs + 1)
 }
 
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumOfParameters {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", cl.Fn.NumOfParameters, numArgs)
 	}
+	if cl.Fn.IsGenerator {
+		// the frame is kept by the generator instead of being pushed
+		gen := vm.newGenerator(cl, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp = vm.sp - numArgs - 1
+		return vm.push(ValueOf(gen))
+	}
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow")
+	}
 	frame := NewFrame(cl, vm.sp-numArgs) // move the basePointer even lower to include Arguments
+	vm.reserve(frame.basePointer + cl.Fn.NumOfLocals)
 	vm.pushFrame(frame)
 	vm.sp = frame.basePointer + cl.Fn.NumOfLocals // this creates the hole
 	// to store and get local variables on the stack
+	if vm.debugger != nil {
+		// so locals not yet assigned don't show whatever was left there
+		for i := frame.basePointer + numArgs; i < vm.sp; i++ {
+			vm.stack[i] = Value{}
+		}
+	}
 	return nil
 }
 
 func (vm *VM) callBuiltin(bi *object.BuiltIn, numArgs int) error {
-	args := vm.stack[vm.sp-numArgs : vm.sp]
+	args := vm.objects(vm.stack[vm.sp-numArgs : vm.sp])
 	res := bi.Fn(args...)
 	vm.sp = vm.sp - numArgs - 1 // move sp back to return position after function
 
-	err := vm.push(res)
+	err := vm.push(ValueOf(res))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (vm *VM) buildArray(start, end int) object.Object {
-	elems := make([]object.Object, end-start)
+func (vm *VM) callConstructor(st *object.StructType, numArgs int) error {
+	args := vm.objects(vm.stack[vm.sp-numArgs : vm.sp])
+	res := st.Construct(args...)
+	vm.allocated()
+	vm.sp = vm.sp - numArgs - 1
+
+	return vm.push(ValueOf(res))
+}
 
-	for i := start; i < end; i++ {
-		elems[i-start] = vm.stack[i]
+func (vm *VM) executeGetField(obj object.Object, field string) error {
+	val, err := vm.getField(obj, field)
+	if err != nil {
+		return err
 	}
+	return vm.push(val)
+}
 
-	return &object.Array{Elements: elems}
+func (vm *VM) getField(obj object.Object, field string) (Value, error) {
+	if def, ok := obj.(*object.StructType); ok {
+		method, ok := def.Methods[field]
+		if !ok {
+			return Value{}, fmt.Errorf("undefined method %s for struct %s", field, def.Name)
+		}
+		return ValueOf(method), nil
+	}
+
+	st, ok := obj.(*object.Struct)
+	if !ok {
+		return Value{}, fmt.Errorf("cannot access field %s on %s", field, obj.Type())
+	}
+
+	val, ok := st.Get(field)
+	if !ok {
+		return Value{}, fmt.Errorf("unknown field %s for struct %s", field, st.Def.Name)
+	}
+	return ValueOf(val), nil
 }
 
-func (vm *VM) buildHashMap(start, end int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+func (vm *VM) executeSetField(obj object.Object, field string, value object.Object) error {
+	err := vm.setField(obj, field, value)
+	if err != nil {
+		return err
+	}
+	return vm.push(ValueOf(value))
+}
 
-	for i := start; i < end; i += 2 {
-		key := vm.stack[i]
-		val := vm.stack[i+1]
+func (vm *VM) setField(obj object.Object, field string, value object.Object) error {
+	if def, ok := obj.(*object.StructType); ok {
+		def.SetMethod(field, value)
+		return nil
+	}
 
-		pair := object.HashPair{
-			Key:   key,
-			Value: val,
-		}
+	st, ok := obj.(*object.Struct)
+	if !ok {
+		return fmt.Errorf("cannot access field %s on %s", field, obj.Type())
+	}
+
+	if !st.Set(field, value) {
+		return fmt.Errorf("unknown field %s for struct %s", field, st.Def.Name)
+	}
+	return nil
+}
+
+// executeCollection replaces the top numElems values on the stack with
+// the array, hash map or set op builds from them
+func (vm *VM) executeCollection(op opcode.OpCode, numElems int) error {
+	start := vm.sp - numElems
+	collection, err := vm.buildCollection(op, vm.stack[start:vm.sp])
+	if err != nil {
+		return err
+	}
+	vm.sp = start
+	return vm.push(collection)
+}
+
+// buildCollection is the array, hash map or set op builds from values
+func (vm *VM) buildCollection(op opcode.OpCode, values []Value) (Value, error) {
+	var collection object.Object
+	var err error
+	switch op {
+	case opcode.OpArray:
+		collection = vm.buildArray(values)
+	case opcode.OpHashMap:
+		collection, err = vm.buildHashMap(values)
+	case opcode.OpSet:
+		collection, err = vm.buildSet(values)
+	}
+	if err != nil {
+		return Value{}, err
+	}
+	return ValueOf(collection), nil
+}
+
+func (vm *VM) buildArray(values []Value) object.Object {
+	elems := make([]object.Object, len(values))
+
+	for i, v := range values {
+		elems[i] = vm.toObject(v)
+	}
+
+	vm.allocated()
+	return &object.Array{Elements: elems}
+}
+
+func (vm *VM) buildHashMap(values []Value) (object.Object, error) {
+	vm.allocated()
+	hashMap := object.NewHashMap()
+
+	for i := 0; i < len(values); i += 2 {
+		key := vm.toObject(values[i])
+		val := vm.toObject(values[i+1])
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
 			return nil, fmt.Errorf("unusable as a hash key: %s", key.Type())
 		}
-		hashedPairs[hashKey.HashKey()] = pair
+		hashMap.Set(hashKey, val)
+	}
+
+	return hashMap, nil
+}
+
+func (vm *VM) buildSet(values []Value) (object.Object, error) {
+	vm.allocated()
+	set := object.NewSet()
+
+	for _, v := range values {
+		member, ok := vm.toObject(v).(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as a set member: %s", v.Type())
+		}
+		set.Add(member)
 	}
 
-	return &object.HashMap{
-		Pairs: hashedPairs,
-	}, nil
+	return set, nil
 }
 
 func (vm *VM) executeUnaryOperation(op opcode.OpCode) error {
-	right := vm.pop()
+	res, err := vm.unaryOperation(op, vm.pop())
+	if err != nil {
+		return err
+	}
+	return vm.push(res)
+}
 
-	switch r := right.(type) {
-	case *object.Integer:
+func (vm *VM) unaryOperation(op opcode.OpCode, right Value) (Value, error) {
+	switch right.kind {
+	case kindInteger:
 		if op != opcode.OpNegateInt {
-			return fmt.Errorf("unknown integer operator: %d", op)
+			return Value{}, fmt.Errorf("unknown integer operator: %d", op)
 		} else {
-			res := -r.Value
-			return vm.push(&object.Integer{Value: res})
+			return integerValue(-right.n), nil
 		}
-	case *object.Boolean:
+	case kindBoolean:
 		if op != opcode.OpNegateBool {
-			return fmt.Errorf("unknown boolean operator: %d", op)
+			return Value{}, fmt.Errorf("unknown boolean operator: %d", op)
 		} else {
-			res := !r.Value
-			return vm.push(&object.Boolean{Value: res})
+			return booleanValue(right.n == 0), nil
 		}
 	default:
-		return fmt.Errorf("unsupported type %s for unary operation", r.Type())
+		return Value{}, fmt.Errorf("unsupported type %s for unary operation", right.Type())
 	}
 }
 
@@ -375,175 +728,254 @@ func (vm *VM) executeBinaryOperation(op opcode.OpCode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	switch lVal := left.(type) {
-	case *object.Integer:
-		rVal, ok := right.(*object.Integer)
-		if !ok {
-			return fmt.Errorf("disjointed types for operators: %s, %s", left.Type(), right.Type())
-		}
-		return vm.executeIntegerBinaryOperation(op, lVal.Value, rVal.Value)
-	case *object.String:
-		rVal, ok := right.(*object.String)
-		if !ok {
-			return fmt.Errorf("disjointed types for operators: %s, %s", left.Type(), right.Type())
-		}
+	res, err := vm.binaryOperation(op, left, right)
+	if err != nil {
+		return err
+	}
+	return vm.push(res)
+}
 
-		return vm.executeStringBinaryOperation(op, lVal.Value, rVal.Value)
-	case *object.Boolean:
-		rVal, ok := right.(*object.Boolean)
-		if !ok {
-			return fmt.Errorf("disjointed types for operators: %s, %s", left.Type(), right.Type())
+// executeBinaryConstant runs a superinstruction like OpAddConstant, with
+// right as the right operand of its operator
+func (vm *VM) executeBinaryConstant(op opcode.OpCode, right Value) error {
+	switch op {
+	case opcode.OpAddConstant:
+		op = opcode.OpAdd
+	case opcode.OpSubtractConstant:
+		op = opcode.OpSubtract
+	case opcode.OpLThanConstant:
+		op = opcode.OpLThan
+	case opcode.OpGThanConstant:
+		op = opcode.OpGThan
+	case opcode.OpEqualConstant:
+		op = opcode.OpEqual
+	case opcode.OpNotEqualConstant:
+		op = opcode.OpNotEqual
+	}
+	res, err := vm.binaryOperation(op, vm.pop(), right)
+	if err != nil {
+		return err
+	}
+	return vm.push(res)
+}
+
+func (vm *VM) binaryOperation(op opcode.OpCode, leftVal, rightVal Value) (Value, error) {
+	if leftVal.kind == kindInteger && rightVal.kind == kindInteger {
+		return integerBinaryOperation(op, leftVal.n, rightVal.n)
+	}
+
+	switch op {
+	case opcode.OpEqual, opcode.OpNotEqual:
+		var equal bool
+		if leftVal.kind != kindObject && leftVal.kind == rightVal.kind {
+			equal = leftVal.n == rightVal.n
+		} else {
+			equal = object.Equal(vm.toObject(leftVal), vm.toObject(rightVal))
 		}
-		return vm.executeBooleanBinaryOperation(op, lVal.Value, rVal.Value)
+		return booleanValue(equal == (op == opcode.OpEqual)), nil
+	}
+
+	left, right := vm.toObject(leftVal), vm.toObject(rightVal)
+	switch op {
+	case opcode.OpLThan, opcode.OpGThan:
+		return comparisonOperation(op, left, right)
+	}
+
+	if left.Type() != right.Type() {
+		return Value{}, fmt.Errorf("disjointed types for operators: %s, %s", left.Type(), right.Type())
+	}
+
+	switch lVal := left.(type) {
+	case *object.String:
+		return vm.stringBinaryOperation(op, lVal.Value, right.(*object.String).Value)
 	default:
-		return fmt.Errorf("unsupported types %s and %s for binary operation", left.Type(), right.Type())
+		return Value{}, fmt.Errorf("unsupported types %s and %s for binary operation", left.Type(), right.Type())
 	}
 }
 
-func (vm *VM) executeIntegerBinaryOperation(op opcode.OpCode, left, right int64) error {
-	var res interface{}
+func integerBinaryOperation(op opcode.OpCode, left, right int64) (Value, error) {
 	switch op {
 	case opcode.OpAdd:
-		res = left + right
+		return integerValue(left + right), nil
 	case opcode.OpSubtract:
-		res = left - right
+		return integerValue(left - right), nil
 	case opcode.OpModulo:
-		if right != 0 {
-			res = left % right
-		} else {
-			return fmt.Errorf("division by 0 error")
+		if right == 0 {
+			return Value{}, fmt.Errorf("division by 0 error")
 		}
+		return integerValue(left % right), nil
 	case opcode.OpDivide:
-		if right != 0 {
-			res = left / right
-		} else {
-			return fmt.Errorf("division by 0 error")
+		if right == 0 {
+			return Value{}, fmt.Errorf("division by 0 error")
 		}
+		return integerValue(left / right), nil
 	case opcode.OpMultiply:
-		res = left * right
+		return integerValue(left * right), nil
 	case opcode.OpLThan:
-		res = left < right
+		return booleanValue(left < right), nil
 	case opcode.OpGThan:
-		res = left > right
+		return booleanValue(left > right), nil
 	case opcode.OpEqual:
-		res = left == right
+		return booleanValue(left == right), nil
 	case opcode.OpNotEqual:
-		res = left != right
+		return booleanValue(left != right), nil
 	default:
-		return fmt.Errorf("unknown integer operator: %d", op)
+		return Value{}, fmt.Errorf("unknown integer operator: %d", op)
 	}
+}
 
-	switch res := res.(type) {
-	case int64:
-		return vm.push(&object.Integer{Value: res})
-	case bool:
-		if res {
-			return vm.push(TRUE)
-		} else {
-			return vm.push(FALSE)
-		}
+// orders Strings and Arrays the same way object.Compare does
+func comparisonOperation(op opcode.OpCode, left, right object.Object) (Value, error) {
+	res, err := object.Compare(left, right)
+	if err != nil {
+		return Value{}, err
 	}
-	return nil
-}
 
-func (vm *VM) executeBooleanBinaryOperation(op opcode.OpCode, left, right bool) error {
-	var res bool
-	switch op {
-	case opcode.OpEqual:
-		res = left == right
-	case opcode.OpNotEqual:
-		res = left != right
-	default:
-		return fmt.Errorf("unknown boolean operator: %d", op)
+	if op == opcode.OpLThan {
+		return booleanValue(res < 0), nil
 	}
-	return vm.push(&object.Boolean{Value: res})
+	return booleanValue(res > 0), nil
 }
 
-func (vm *VM) executeStringBinaryOperation(op opcode.OpCode, left, right string) error {
-	var res interface{}
+func (vm *VM) stringBinaryOperation(op opcode.OpCode, left, right string) (Value, error) {
 	switch op {
 	case opcode.OpAdd:
-		res = left + right
-	case opcode.OpEqual:
-		res = left == right
-	case opcode.OpNotEqual:
-		res = left != right
+		vm.allocated()
+		return ValueOf(&object.String{Value: left + right}), nil
 	default:
-		return fmt.Errorf("unknown boolean operator: %d", op)
-	}
-	switch res := res.(type) {
-	case string:
-		return vm.push(&object.String{Value: res})
-	case bool:
-		if res {
-			return vm.push(TRUE)
-		} else {
-			return vm.push(FALSE)
-		}
+		return Value{}, fmt.Errorf("unknown string operator: %d", op)
 	}
-	return nil
 }
 
-func (vm *VM) executeIndexExpression(left, index object.Object) error {
+func (vm *VM) executeIndexExpression(left, index Value) error {
+	res, err := vm.indexOperation(left, index)
+	if err != nil {
+		return err
+	}
+	return vm.push(res)
+}
+
+func (vm *VM) indexOperation(left, index Value) (Value, error) {
 	switch {
-	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.ARRAY_OBJ && index.kind == kindInteger:
+		return arrayIndex(left.obj, index.n), nil
+	case left.Type() == object.RANGE_OBJ && index.kind == kindInteger:
+		return rangeIndex(left.obj, index.n), nil
 	case left.Type() == object.HASHMAP_OBJ:
-		return vm.executeHashMapIndex(left, index)
+		return hashMapIndex(left.obj, vm.toObject(index))
 	default:
-		return fmt.Errorf("index operator not supportedL %s", left.Type())
+		return Value{}, fmt.Errorf("index operator not supportedL %s", left.Type())
 	}
 }
 
-func (vm *VM) executeArrayIndex(left, index object.Object) error {
+func arrayIndex(left object.Object, i int64) Value {
 	arrObj := left.(*object.Array)
-	i := index.(*object.Integer).Value
 	max := int64(len(arrObj.Elements) - 1)
 
 	if i < 0 || i > max {
-		return vm.push(NIL)
+		return nilValue
+	}
+
+	return ValueOf(arrObj.Elements[i])
+}
+
+func rangeIndex(left object.Object, i int64) Value {
+	rngObj := left.(*object.Range)
+
+	val, ok := rngObj.At(i)
+	if !ok {
+		return nilValue
 	}
 
-	return vm.push(arrObj.Elements[i])
+	return integerValue(val)
 }
 
-func (vm *VM) executeHashMapIndex(left, index object.Object) error {
+func hashMapIndex(left, index object.Object) (Value, error) {
 	hashObj := left.(*object.HashMap)
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return fmt.Errorf("unusable as as hash key: %s", index.Type())
+		return Value{}, fmt.Errorf("unusable as as hash key: %s", index.Type())
 	}
 
-	pair, ok := hashObj.Pairs[key.HashKey()]
+	pair, ok := hashObj.Get(key)
 	if !ok {
-		return vm.push(NIL)
+		return nilValue, nil
 	}
 
-	return vm.push(pair.Value)
+	return ValueOf(pair.Value), nil
 }
 
-func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+func (vm *VM) push(v Value) error {
+	if vm.sp >= len(vm.stack) {
+		vm.reserve(vm.sp + 1)
 	}
 
-	vm.stack[vm.sp] = o
+	vm.stack[vm.sp] = v
 	vm.sp++
 	return nil
 }
 
-func (vm *VM) pop() object.Object {
-	o := vm.stack[vm.sp-1]
+func (vm *VM) pop() Value {
+	v := vm.stack[vm.sp-1]
 	vm.sp--
-	return o
+	return v
+}
+
+// global is the global at index i. one that was never set, which only
+// bytecode the compiler did not make can read, is nil. a task's globals
+// stop after the last one set when it was spawned, and the ones it could
+// not be given a copy of fail to be read
+func (vm *VM) global(i int) (Value, error) {
+	if i >= len(vm.globals) {
+		return nilValue, nil
+	}
+	switch g := vm.globals[i]; g.kind {
+	case kindEmpty:
+		return nilValue, nil
+	case kindUnshared:
+		return Value{}, fmt.Errorf("%s", g.obj.(*object.Error).Message)
+	default:
+		return g, nil
+	}
+}
+
+// setGlobal sets the global at index i. only the main program sets
+// globals, so a task, whose globals may stop short, never needs to
+func (vm *VM) setGlobal(i int, v Value) error {
+	if i >= len(vm.globals) {
+		return fmt.Errorf("a task cannot set global %d", i)
+	}
+	vm.globals[i] = v
+	return nil
 }
 
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
+// reserve grows the stack to hold at least n values, for a call with many
+// locals or a large collection literal. the VMs of generators and tasks
+// start with a small stack, which grows the same way
+func (vm *VM) reserve(n int) {
+	if n <= len(vm.stack) {
+		return
+	}
+
+	size := 2 * len(vm.stack)
+	if size < n {
+		size = n
+	}
+	stack := make([]Value, size)
+	copy(stack, vm.stack)
+	vm.stack = stack
+}
+
 func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+	if vm.framesIndex == len(vm.frames) {
+		vm.frames = append(vm.frames, f)
+	} else {
+		vm.frames[vm.framesIndex] = f
+	}
 	vm.framesIndex++
 }
 
@@ -552,8 +984,58 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+// the stack a VM made by newFunctionVM starts with, on top of the
+// function's locals
+const functionStackSize = 32
+
+// newFunctionVM sets up a VM that runs a single call to cl, the way
+// generators and spawned tasks do. it shares vm's constants, its frame is
+// the only one at the bottom of its own stack, and its result is left on
+// top of the stack once it returns. its stack and frames start small, so
+// making many generators is cheap, and grow as it runs
+func (vm *VM) newFunctionVM(globals []Value, cl *object.Closure, args []Value) *VM {
+	size := cl.Fn.NumOfLocals
+	if cl.Fn.NumOfRegisters > size {
+		size = cl.Fn.NumOfRegisters
+	}
+	stack := make([]Value, size+functionStackSize)
+	copy(stack, args)
+
+	frames := []*Frame{NewFrame(cl, 0)}
+
+	return &VM{
+		constants:   vm.constants,
+		values:      vm.values,
+		globals:     globals,
+		stack:       stack,
+		sp:          cl.Fn.NumOfLocals, // leave room for the locals, as callClosure does
+		frames:      frames,
+		framesIndex: 1,
+		registers:   vm.registers,
+	}
+}
+
+func (vm *VM) finishFunction(result Value) error {
+	if vm.registers {
+		vm.result = result
+		return nil
+	}
+	vm.sp = 0
+	return vm.push(result)
+}
+
 func New(bc *compiler.ByteCode) *VM {
-	mainFn := &object.CompiledFunction{Instructions: bc.Instructions}
+	// the constants are shared with any spawned tasks, so hashing one
+	// must never write to it
+	values := make([]Value, len(bc.Constants))
+	for i, c := range bc.Constants {
+		if h, ok := c.(object.Hashable); ok {
+			h.HashKey()
+		}
+		values[i] = ValueOf(c)
+	}
+
+	mainFn := &object.CompiledFunction{Instructions: bc.Instructions, Debug: bc.Debug}
 	mainClosure := &object.Closure{
 		Fn: mainFn,
 	}
@@ -564,40 +1046,36 @@ func New(bc *compiler.ByteCode) *VM {
 
 	return &VM{
 		constants:   bc.Constants,
-		globals:     make([]object.Object, GlobalsSize),
-		stack:       make([]object.Object, StackSize),
+		values:      values,
+		globals:     make([]Value, GlobalsSize),
+		stack:       make([]Value, StackSize),
 		sp:          0,
 		frames:      frames,
 		framesIndex: 1,
+		unverified:  bc.Unverified,
 	}
 }
 
-func NewWithGlobalsStore(bc *compiler.ByteCode, globs []object.Object) *VM {
+func NewWithGlobalsStore(bc *compiler.ByteCode, globs []Value) *VM {
 	vm := New(bc)
 	vm.globals = globs
 	return vm
 }
 
-func isTruthy(value object.Object) bool {
-	switch value.Type() {
-	case object.INTEGER_OBJ:
-		switch value.Inspect() {
-		case "0":
-			return false
-		default:
-			return true
-		}
-	case object.BOOLEAN_OBJ:
-		switch value {
-		case TRUE:
-			return true
-		case FALSE:
-			return false
-		}
-	case object.NIL_OBJ:
+func nativeBoolToBooleanObject(b bool) *object.Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
+func isTruthy(value Value) bool {
+	switch value.kind {
+	case kindInteger, kindBoolean:
+		return value.n != 0
+	case kindNil:
 		return false
 	default:
 		return true
 	}
-	return false
 }
@@ -1,13 +1,19 @@
 package vm
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io/ioutil"
 	"nala/ast"
 	"nala/compiler"
 	"nala/lexer"
 	"nala/object"
+	"nala/opcode"
 	"nala/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 type vmTest struct {
@@ -61,23 +67,60 @@ func runVmTests(t *testing.T, tests []vmTest) {
 	t.Helper()
 
 	for _, tt := range tests {
-		prog := parse(tt.input)
+		// optimizing must not change what a program does
+		for _, optimize := range []bool{false, true} {
+			prog := parse(tt.input)
 
-		comp := compiler.New()
-		err := comp.Compile(prog)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
+			comp := compiler.New()
+			comp.SetOptimize(optimize)
+			err := comp.Compile(prog)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+			if err := compiler.Verify(comp.ByteCode()); err != nil {
+				t.Errorf("%s: bytecode does not verify (optimized=%t): %s", tt.input, optimize, err)
+			}
 
-		vm := New(comp.ByteCode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
+			vm := New(comp.ByteCode())
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("vm error (optimized=%t): %s", optimize, err)
+			}
+
+			stackElem := vm.LastPoppedElement()
+			testExpectedObject(t, tt.expected, stackElem)
+
+			// the register VM has to agree
+			regComp := compiler.NewRegister()
+			regComp.SetOptimize(optimize)
+			err = regComp.Compile(parse(tt.input))
+			if err != nil {
+				t.Fatalf("register compiler error: %s", err)
+			}
+
+			vm = NewRegister(regComp.ByteCode())
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("%s: register vm error (optimized=%t): %s", tt.input, optimize, err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedElement())
 		}
+	}
+}
 
-		stackElem := vm.LastPoppedElement()
-		testExpectedObject(t, tt.expected, stackElem)
+// machines compiles input for the stack VM and for the register VM
+func machines(t *testing.T, input string) []*VM {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	regComp := compiler.NewRegister()
+	if err := regComp.Compile(parse(input)); err != nil {
+		t.Fatalf("register compiler error: %s", err)
 	}
+	return []*VM{New(comp.ByteCode()), NewRegister(regComp.ByteCode())}
 }
 
 func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
@@ -116,30 +159,36 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
-	case map[object.HashKey]int64:
+	case [][2]int64:
 		hash, ok := actual.(*object.HashMap)
 		if !ok {
 			t.Errorf("object is not Hash. got=%T (%+v)", actual, actual)
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
 
-		for expKey, expVal := range expected {
-			pair, ok := hash.Pairs[expKey]
-			if !ok {
-				t.Errorf("no pair for given key in Pairs")
+		// expected pairs are listed in insertion order
+		pairs := hash.Pairs()
+		for i, exp := range expected {
+			err := testIntegerObject(exp[0], pairs[i].Key)
+			if err != nil {
+				t.Errorf("pair %d has wrong key: %s", i, err)
 			}
 
-			err := testIntegerObject(expVal, pair.Value)
+			err = testIntegerObject(exp[1], pairs[i].Value)
 			if err != nil {
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
 		}
+	case string:
+		if actual.Inspect() != expected {
+			t.Errorf("object has wrong Inspect output. want=%q, got=%q", expected, actual.Inspect())
+		}
 	case *object.Error:
 		err, ok := actual.(*object.Error)
 		if !ok {
@@ -172,6 +221,12 @@ func TestConditionals(t *testing.T) {
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 > 2) { 10 }", NIL},
 		{"if (false) { 10 }", NIL},
+		{"if ((1 < 2) == true) { 10 } else { 20 }", 10},
+		{"if (true != true) { 10 } else { 20 }", 20},
+		{"if (!!true) { 10 } else { 20 }", 10},
+		{"if (true) { let a = 1 }", NIL},
+		{"if (false) { 10 } else { }", NIL},
+		{"let f = fn(x) { if (x) { struct P { a } } else { 20 } }; f(false) + 1", 21},
 	}
 
 	runVmTests(t, tests)
@@ -199,21 +254,15 @@ func TestArrayLiterals(t *testing.T) {
 
 func TestHashMapLiterals(t *testing.T) {
 	tests := []vmTest{
-		{"{}", map[object.HashKey]int64{}},
-		{
-			"{1: 2, 3: 4}",
-			map[object.HashKey]int64{
-				(&object.Integer{Value: 1}).HashKey(): 2,
-				(&object.Integer{Value: 3}).HashKey(): 4,
-			},
-		},
-		{
-			"{1 + 1: 2 * 2, 3 + 3: 4 * 4}",
-			map[object.HashKey]int64{
-				(&object.Integer{Value: 2}).HashKey(): 4,
-				(&object.Integer{Value: 6}).HashKey(): 16,
-			},
-		},
+		{"{}", [][2]int64{}},
+		{"{1: 2, 3: 4}", [][2]int64{{1, 2}, {3, 4}}},
+		{"{1 + 1: 2 * 2, 3 + 3: 4 * 4}", [][2]int64{{2, 4}, {6, 16}}},
+		{"{9: 1, 3: 2, 6: 3}", [][2]int64{{9, 1}, {3, 2}, {6, 3}}},
+		{`{"b": 1, "a": 2, "c": 3}`, "{b: 1, a: 2, c: 3}"},
+		{`keys({"b": 1, "a": 2, "c": 3})`, "[b, a, c]"},
+		{`items({"z": 1, "y": 2})`, "[[z, 1], [y, 2]]"},
+		{`let h = {"a": 1, "b": 2}; ins(h, "a", 5); ins(h, "c", 6); h`, "{a: 5, b: 2, c: 6}"},
+		{`let h = {"a": 1, "b": 2, "c": 3}; del(h, "a"); ins(h, "a", 1); h`, "{b: 2, c: 3, a: 1}"},
 	}
 
 	runVmTests(t, tests)
@@ -236,6 +285,425 @@ func TestIndexExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestStructuralEquality(t *testing.T) {
+	tests := []vmTest{
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] != [1, 2]", false},
+		{"[1, 2] == [2, 1]", false},
+		{"[[1], [2, 3]] == [[1], [2, 3]]", true},
+		{`{"a": 1, "b": 2} == {"b": 2, "a": 1}`, true},
+		{`{"a": 1} != {"a": 2}`, true},
+		{"{[1, 2]: 5}[[1, 2]]", 5},
+		{"let k = [1, 2]; {k: 5}[[1, 2]]", 5},
+		{"{[1, 2]: 5}[[2, 1]]", NIL},
+		{`{{"a": 1}: 7}[{"a": 1}]`, 7},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestEqualityAcrossTypes(t *testing.T) {
+	tests := []vmTest{
+		{`1 == "1"`, false},
+		{`1 != "1"`, true},
+		{"true == 1", false},
+		{"[1] == {1: 1}", false},
+		{`"a" == "a"`, true},
+		{"len == len", true},
+		{"len == first", false},
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+		{"let mk = fn(x) { fn() { x } }; mk(1) == mk(1)", false},
+		{"[1, len] == [1, len]", true},
+		{"[] == [[]]", false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestOrdering(t *testing.T) {
+	tests := []vmTest{
+		{`"abc" < "abd"`, true},
+		{`"abc" > "ab"`, true},
+		{`"b" < "abc"`, false},
+		{"[1, 2] < [1, 3]", true},
+		{"[1, 2] < [1, 2, 0]", true},
+		{"[2] > [1, 9, 9]", true},
+		{"[1, 2] > [1, 2]", false},
+		{`[[1, "b"]] > [[1, "a"]]`, true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestOrderingErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"true < false", "cannot compare BOOLEAN values"},
+		{`1 > "2"`, "cannot compare INTEGER with STRING"},
+		{`[1] < "a"`, "cannot compare ARRAY with STRING"},
+		{`[[1]] < [["a"]]`, "cannot compare INTEGER with STRING"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected VM error but resulted in none.")
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestSets(t *testing.T) {
+	tests := []vmTest{
+		{"#{}", "#{}"},
+		{"#{1, 2, 3}", "#{1, 2, 3}"},
+		{"#{3, 1, 3, 2, 1}", "#{3, 1, 2}"},
+		{`#{[1, 2], "a", [1, 2]}`, "#{[1, 2], a}"},
+		{"len(#{1, 2, 2})", 2},
+		{"type(#{})", "SET"},
+		{"has(#{1, 2}, 2)", true},
+		{"has(#{[1, 2]}, [1, 2])", true},
+		{"has(#{1, 2}, 3)", false},
+		{"let s = #{1}; add(s, 2); add(s, 1); s", "#{1, 2}"},
+		{"let s = #{1, 2, 3}; remove(s, 2); s", "#{1, 3}"},
+		{"union(#{1, 2}, #{2, 3})", "#{1, 2, 3}"},
+		{"intersect(#{1, 2, 3}, #{3, 2, 4})", "#{2, 3}"},
+		{"difference(#{1, 2, 3}, #{2})", "#{1, 3}"},
+		{"#{1, 2} == #{2, 1}", true},
+		{"#{1, 2} == #{1}", false},
+		{"{#{1, 2}: 5}[#{2, 1}]", 5},
+		{"array(#{3, 1})", "[3, 1]"},
+		{"let s = #{1}; let c = copy(s); add(c, 2); s", "#{1}"},
+		{"remove(#{1}, 2)", &object.Error{Message: "member does not exist in Set"}},
+		{"union(#{1}, [1])", &object.Error{Message: "arguments to `union` must be SET, got SET and ARRAY"}},
+		{"let add = fn(a, b) { a + b }; add(1, 2)", 3},
+		{"let union = 5; union", 5},
+		{"let add = 0; let s = #{1}; s.add(2); s", "#{1, 2}"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStructs(t *testing.T) {
+	tests := []vmTest{
+		{`struct Person { name, age } let p = Person("Nala", 3); p.name`, "Nala"},
+		{`struct Person { name, age } Person("Nala", 3).age`, 3},
+		{`struct Person { name, age } let p = Person("Nala", 3); p.age = p.age + 1; p.age`, 4},
+		{`struct Person { name, age } let p = Person("Nala", 3); p.age = 10`, 10},
+		{`struct Person { name, age } Person("Nala", 3)`, "Person{name: Nala, age: 3}"},
+		{`struct Person { name, age } type(Person("Nala", 3))`, "Person"},
+		{`struct Person { name, age } type(Person)`, "STRUCT_TYPE"},
+		{`struct P { x } let get = fn(p) { p.x }; get(P(7))`, 7},
+		{`struct P { x } P(1) == P(1)`, true},
+		{`struct P { x } struct Q { x } P(1) == Q(1)`, false},
+		// inside an Array a Struct only adds its type to the hash, so setting
+		// its field after the Array went in as a key doesn't lose the entry
+		{`struct P { x } let p = P(1); let k = [p]; let h = {k: "one"}; p.x = 2; [h[k], h[[P(2)]], h[[P(1)]]]`, "[one, one, nil]"},
+		{`struct P { x } has(#{1}, P(1))`, &object.Error{Message: "unusable as set member: STRUCT"}},
+		{`struct Pair { l, r } let p = Pair(Pair(1, 2), 3); p.l.r`, 2},
+		{`struct P { x } P()`, &object.Error{Message: "wrong number of arguments to P. got=0, want=1"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStructFieldErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct P { x } let get = fn(p) { p.y }; get(P(1))`, "unknown field y for struct P"},
+		{`struct P { x } let set = fn(p) { p.y = 2 }; set(P(1))`, "unknown field y for struct P"},
+		{`let get = fn(p) { p.y }; get(5)`, "cannot access field y on INTEGER"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected VM error but resulted in none.")
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+			}
+		}
+	}
+}
+
+// a Struct's fields can change, so it can't be a key
+func TestStructKeyErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct P { x } {P(1): 5}`, "unusable as a hash key: STRUCT"},
+		{`struct P { x } #{P(1)}`, "unusable as a set member: STRUCT"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected VM error but resulted in none.")
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestMethodCalls(t *testing.T) {
+	tests := []vmTest{
+		{"[1, 2].push(3)", "[1, 2, 3]"},
+		{"[].push(1).push(2).len()", 2},
+		{"let arr = [3, 4]; arr.first() + arr.last()", 7},
+		{`"Nala".upper()`, "NALA"},
+		{`"Nala".lower().has("n")`, true},
+		{`{"a": 1}.keys()`, "[a]"},
+		{"#{1, 2}.union(#{3})", "#{1, 2, 3}"},
+		{"range(5).len()", 5},
+		{"5.type()", "INTEGER"},
+		{`struct P { name } P.greet = fn(self, greeting) { greeting + ", " + self.name }; P("Nala").greet("hi")`, "hi, Nala"},
+		{`struct P { n } P.double = fn(self) { self.n * 2 }; let p = P(4); p.double() + p.n`, 12},
+		{`struct P { n } P.inc = fn(self) { self.n = self.n + 1; self }; P(1).inc().inc().n`, 3},
+		{`struct P { n } P.len = fn(self) { 99 }; P(1).len()`, 99},
+		{`struct P { n } P.get = fn(self) { self.n }; let f = P.get; f(P(5))`, 5},
+		{`"a".upper(1)`, &object.Error{Message: "wrong number of arguments. got=2, want=1"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestUndefinedMethods(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[1].upper()", "undefined method upper for ARRAY"},
+		{`struct P { n } P(1).greet()`, "undefined method greet for struct P"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected VM error but resulted in none.")
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("wrong VM error: want=%q, got=%q", tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	inputs := []string{
+		"let d = fn() { d() }; d()",
+		"let d = fn(n) { if (n == 0) { 0 } else { d(n - 1) + 1 } }; d(5000)",
+	}
+
+	for _, input := range inputs {
+		for _, vm := range machines(t, input) {
+			err := vm.Run()
+			if err == nil || err.Error() != "stack overflow" {
+				t.Errorf("%s: expected a stack overflow, got=%v", input, err)
+			}
+			if vm.framesIndex > MaxFrames {
+				t.Errorf("%s: ran %d frames deep, more than %d", input, vm.framesIndex, MaxFrames)
+			}
+		}
+	}
+}
+
+// the stack grows past StackSize for calls and literals that need it
+func TestLargeStack(t *testing.T) {
+	lets := []string{}
+	for i := 0; i < 9000; i++ {
+		lets = append(lets, fmt.Sprintf("let a%d = %d;", i, i))
+	}
+	zeros := strings.Repeat("0, ", 69999) + "0"
+
+	tests := []vmTest{
+		{"let f = fn() { " + strings.Join(lets, " ") + " a0 + a8999 }; f()", 8999},
+		{"len([" + zeros + "])", 70000},
+		{"let g = fn() { yield len([" + zeros + "]) }; next(g())", 70000},
+		{"let f = fn(x) { len([" + zeros + "]) + x }; wait(spawn(f, 1))", 70001},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestPipelines(t *testing.T) {
+	tests := []vmTest{
+		{"[1, 2, 3] |> len", 3},
+		{"[1, 2] |> push(3) |> push(4)", "[1, 2, 3, 4]"},
+		{"let double = fn(x) { x * 2 }; 5 |> double |> double", 20},
+		{`let isInt = fn(x) { type(x) == "INTEGER" };
+		  let filter = fn(arr, f) {
+		      let iter = fn(arr, acc, iter) {
+		          if (len(arr) == 0) { acc }
+		          else { if (f(first(arr))) { iter(rest(arr), push(acc, first(arr)), iter) } else { iter(rest(arr), acc, iter) } }
+		      };
+		      iter(arr, [], iter)
+		  };
+		  let sum = fn(arr) { if (len(arr) == 0) { 0 } else { first(arr) + sum(rest(arr)) } };
+		  [1, "a", 2, true, 3] |> filter(isInt) |> sum`, 6},
+		{`"nala" |> upper`, "NALA"},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []vmTest{
+		{"let gen = fn() { yield 1; yield 2 }; let g = gen(); next(g) + next(g)", 3},
+		{"let gen = fn() { yield 1 }; let g = gen(); next(g); next(g)", NIL},
+		{"let gen = fn(x) { yield x; yield x * 2 }; array(gen(4))", "[4, 8]"},
+		{"let gen = fn() { 1 }; type(gen())", "INTEGER"},
+		{"let gen = fn() { yield 1 }; type(gen())", "GENERATOR"},
+		{"let gen = fn() { let x = yield 1; yield x }; array(gen())", "[1, nil]"},
+		{"let gen = fn() { yield 1; return 5; yield 2 }; array(gen())", "[1]"},
+		{"let gen = fn(a, b) { let c = a + b; yield c; yield c + 1 }; let g = gen(1, 2); g.next(); g.next()", 4},
+		{"let gen = fn() { yield 5; yield 6 }; has(gen(), 6)", true},
+		{"let gen = fn() { yield 5 }; gen().has(6)", false},
+		{"let count = fn(i, n, count) { if (i < n) { yield i; yield from count(i + 1, n, count) } }; array(count(0, 4, count))", "[0, 1, 2, 3]"},
+		{"let nat = fn(i, nat) { yield i; yield from nat(i + 1, nat) }; let n = nat(0, nat); next(n); next(n); next(n)", 2},
+		{`let gen = fn() { yield from [1, 2]; yield from range(3, 5); yield from "ab" }; array(gen())`, "[1, 2, 3, 4, a, b]"},
+		{"let gen = fn() { yield from #{7} }; next(gen())", 7},
+		{"let outer = 10; let gen = fn() { yield outer; yield outer + 1 }; let g = gen(); let a = next(g); a + next(g)", 21},
+		{"let gen = fn() { yield 1 }; let a = gen(); let b = gen(); next(a); next(b)", 1},
+		{"next(5)", &object.Error{Message: "argument to `next` must be GENERATOR, got INTEGER"}},
+		{"let inner = fn() { yield 1; return 5 }; let outer = fn() { let r = yield from inner(); yield r }; array(outer())", "[1, 5]"},
+		{"let inner = fn() { yield 1; 6 }; let outer = fn() { let r = yield from inner(); yield r }; array(outer())", "[1, 6]"},
+		{"let outer = fn() { let r = yield from [1]; yield r }; array(outer())", "[1, nil]"},
+		{"let sum = fn(n, sum) { if (n == 0) { 0 } else { n + sum(n - 1, sum) } }; let gen = fn() { yield sum(300, sum) }; next(gen())", 45150},
+	}
+
+	runVmTests(t, tests)
+}
+
+// a generator's stack and frames start small and grow as it needs them
+func TestGeneratorStackSize(t *testing.T) {
+	for _, vm := range machines(t, "let gen = fn() { yield 1 }; gen()") {
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		gen, ok := vm.LastPoppedElement().(*Generator)
+		if !ok {
+			t.Fatalf("object is not Generator. got=%T", vm.LastPoppedElement())
+		}
+		if len(gen.machine.stack) > functionStackSize+1 || len(gen.machine.frames) != 1 {
+			t.Errorf("generator got a stack of %d and %d frames", len(gen.machine.stack), len(gen.machine.frames))
+		}
+	}
+}
+
+func TestGeneratorErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let gen = fn() { yield from 5 }; next(gen())", "cannot yield from INTEGER"},
+		{"let gen = fn() { yield 1 / 0 }; array(gen())", "division by 0 error"},
+	}
+
+	for _, tt := range tests {
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+
+			// a failing generator hands its error back as a value
+			errObj, ok := vm.LastPoppedElement().(*object.Error)
+			if !ok {
+				t.Fatalf("object is not Error. got=%T (%+v)", vm.LastPoppedElement(), vm.LastPoppedElement())
+			}
+			if errObj.Message != tt.expected {
+				t.Errorf("wrong error message: want=%q, got=%q", tt.expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tests := []vmTest{
+		{"let sq = fn(n) { n * n }; wait(spawn(sq, 7))", 49},
+		{"let t = spawn(fn(a, b) { a + b }, 2, 3); t.wait()", 5},
+		{"wait(spawn(len, [1, 2, 3]))", 3},
+		{"struct P { x } wait(spawn(P, 1)).x", 1},
+		{"struct P { x } let p = P(1); let t = spawn(fn(q) { q.x = 2; q.x }, p); [wait(t), p.x]", "[2, 1]"},
+		{"struct P { x } let p = P(1); wait(spawn(fn(q) { q }, p)) == p", true},
+		{"let ch = chan(2); send(ch, 1); send(ch, 2); recv(ch) + recv(ch)", 3},
+		{"let ch = chan(); spawn(fn(c) { send(c, 5) }, ch); recv(ch)", 5},
+		{"let ch = chan(1); ch.send([1, 2]); ch.recv()", "[1, 2]"},
+		{"let ch = chan(1); close(ch); recv(ch)", NIL},
+		{`let produce = fn(out, n, produce) { if (n == 0) { close(out) } else { send(out, n); produce(out, n - 1, produce) } };
+		  let sum = fn(in, acc, sum) { let v = recv(in); if (type(v) == "NIL") { acc } else { sum(in, acc + v, sum) } };
+		  let ch = chan();
+		  spawn(produce, ch, 10, produce);
+		  sum(ch, 0, sum)`, 55},
+		{`let a = chan(); let b = chan(1); send(b, "b"); select(a, b)`, "[1, b]"},
+		{`let a = chan(1); select([a, 4]); recv(a)`, 4},
+		{"let ch = chan(1); close(ch); send(ch, 1)", &object.Error{Message: "send on closed channel"}},
+		{"let ch = chan(); close(ch); close(ch)", &object.Error{Message: "close of closed channel"}},
+		{"let gen = fn() { yield 1 }; spawn(fn(g) { g }, gen())", &object.Error{Message: "cannot share GENERATOR between tasks"}},
+		{"spawn(fn(a) { a })", &object.Error{Message: "wrong number of arguments: want=1, got=0"}},
+		{"spawn(1)", &object.Error{Message: "cannot spawn INTEGER"}},
+		{"wait(spawn(fn() { 1 / 0 }))", &object.Error{Message: "division by 0 error"}},
+		{"chan(-1)", &object.Error{Message: "argument to `chan` must be a non-negative INTEGER, got -1"}},
+		{`let task = fn(n) { struct Q { x } Q.get = fn(q) { q.x + n }; Q(1).get() };
+		  let ts = [spawn(task, 1), spawn(task, 2), spawn(task, 3)];
+		  let q = task(10);
+		  [wait(ts[0]), wait(ts[1]), wait(ts[2]), q]`, "[2, 3, 4, 11]"},
+		{`struct Q { x } Q.get = fn(q) { q.x };
+		  let t = spawn(fn() { Q.get = fn(q) { 0 }; Q(5).get() });
+		  [wait(t), Q(5).get()]`, "[0, 5]"},
+		{`let gen = fn() { yield 1 }; let task = fn() { struct Q { x } Q.g = gen(); 1 }; task(); spawn(task)`,
+			&object.Error{Message: "cannot share GENERATOR between tasks"}},
+		// a global that can't be copied only fails the task that reads it
+		{"let g = fn() { yield 1 }; let xs = [1, g()]; wait(spawn(fn() { xs }))",
+			&object.Error{Message: "cannot share GENERATOR between tasks"}},
+		{"let g = fn() { yield 1 }; let xs = [1, g()]; let n = 4; wait(spawn(fn() { n + 1 }))", 5},
+		// a global set after the task was spawned is nil to it
+		{"let t = spawn(fn() { t }); wait(t)", NIL},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCopyGlobals(t *testing.T) {
+	globals := make([]Value, GlobalsSize)
+	globals[0] = integerValue(1)
+	globals[2] = ValueOf(&Generator{})
+
+	dup := copyGlobals(object.NewCopier(), globals)
+	if len(dup) != 3 {
+		t.Fatalf("expected the globals up to the last one set, got=%d", len(dup))
+	}
+	vm := &VM{globals: dup}
+	if g, err := vm.global(0); err != nil || g.n != 1 {
+		t.Errorf("wrong global 0. got=%v, %v", g, err)
+	}
+	if g, err := vm.global(1); err != nil || g != nilValue {
+		t.Errorf("an empty global should be nil. got=%v, %v", g, err)
+	}
+	if _, err := vm.global(2); err == nil || err.Error() != "cannot share GENERATOR between tasks" {
+		t.Errorf("expected the generator to fail to be read, got=%v", err)
+	}
+	if g, err := vm.global(GlobalsSize - 1); err != nil || g != nilValue {
+		t.Errorf("a global past the copies should be nil. got=%v, %v", g, err)
+	}
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTest{
 		{
@@ -390,22 +858,15 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		prog := parse(tt.input)
-		comp := compiler.New()
-
-		err := comp.Compile(prog)
-		if err != nil {
-			t.Errorf("compiler error: %s", err)
-		}
-
-		vm := New(comp.ByteCode())
-		err = vm.Run()
-		if err == nil {
-			t.Fatalf("expected VM error but resulted in none.")
-		}
+		for _, vm := range machines(t, tt.input) {
+			err := vm.Run()
+			if err == nil {
+				t.Fatalf("expected VM error but resulted in none.")
+			}
 
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q\ngot=%q", tt.expected, err)
+			if err.Error() != tt.expected {
+				t.Fatalf("wrong VM error: want=%q\ngot=%q", tt.expected, err)
+			}
 		}
 	}
 }
@@ -498,3 +959,589 @@ func TestClosures(t *testing.T) {
 
 	runVmTests(t, tests)
 }
+
+func TestRanges(t *testing.T) {
+	tests := []vmTest{
+		{"len(range(10))", 10},
+		{"len(range(2, 10, 3))", 3},
+		{"len(range(10, 0, -2))", 5},
+		{"len(range(5, 5))", 0},
+		{"range(2, 10, 3)[1]", 5},
+		{"range(10, 0, -2)[4]", 2},
+		{"range(3)[3]", NIL},
+		{"range(3)[-1]", NIL},
+		{"first(range(4, 8))", 4},
+		{"last(range(4, 8))", 7},
+		{"first(rest(rest(range(4, 8))))", 6},
+		{"len(rest(range(1, 2)))", 0},
+		{"rest(range(1, 1))", NIL},
+		{"first(range(0))", NIL},
+		{"has(range(0, 100, 5), 35)", true},
+		{"has(range(0, 100, 5), 36)", false},
+		{"has(range(10, 0, -1), 0)", false},
+		{"array(range(4))", []int{0, 1, 2, 3}},
+		{"array(range(6, 0, -2))", []int{6, 4, 2}},
+		{"has([1, 2, 3], 2)", true},
+		{`has({"a": 1}, "b")`, false},
+		{
+			"range(1, 2, 0)",
+			&object.Error{Message: "step argument to `range` must not be zero"},
+		},
+		{
+			`range("a")`,
+			&object.Error{Message: "arguments to `range` must be INTEGER, got STRING"},
+		},
+		{"len(range(-4611686018427387904, 4611686018427387903))", 9223372036854775807},
+		{"range(-9223372036854775807, 0)[9223372036854775806]", -1},
+		{"has(range(-9223372036854775807, 9223372036854775807, 2), 9223372036854775805)", true},
+		{"len(rest(range(9223372036854775806, 9223372036854775807, 5)))", 0},
+		{
+			"range(-9223372036854775807, 9223372036854775807, 1)",
+			&object.Error{Message: "range(-9223372036854775807, 9223372036854775807, 1) has too many elements"},
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+// programs too big for narrow operands, which the compiler makes wide
+func TestWideOperands(t *testing.T) {
+	var statements, locals strings.Builder
+	for i := 0; i < 70000; i++ {
+		fmt.Fprintf(&statements, "%d;\n", i)
+	}
+	names := []string{}
+	expected := []int{}
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&locals, "let l%d = %d;\n", i, i)
+		names = append(names, fmt.Sprintf("l%d", i))
+		expected = append(expected, i)
+	}
+
+	tests := []vmTest{
+		// more than 65536 constants, in a function too long for narrow jumps
+		{"let f = fn(c) { if (c) { " + statements.String() + " } else { -1 } }; f(true)", 69999},
+		{"let f = fn(c) { if (c) { " + statements.String() + " } else { -1 } }; f(false)", -1},
+		// more than 256 locals, closed over as free variables
+		{"let f = fn() { " + locals.String() + "fn() { [" + strings.Join(names, ", ") + "] } }; f()()", expected},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTest{
+		{"let f = fn(g, n) { if (n == 0) { 0 } else { g(g, n - 1) + 1 } }; f(f, 10)", 10},
+		{"let f = fn(g, n) { if (n == 0) { 7 } else { g(g, n - 1) } }; f(f, 10)", 7},
+		{"let f = fn(x) { len(x) }; f([1, 2])", 2},
+		{"struct P { x }; let f = fn(c, x) { c(x) }; f(P, 3).x", 3},
+		{"let f = fn(g) { g() }; len(array(f(fn() { yield 1; yield 2 })))", 2},
+	}
+	runVmTests(t, tests)
+
+	// with the optimizations on, calls in tail position don't use up frames
+	input := "let f = fn(g, n) { if (n == 0) { 0 } else { g(g, n - 1) } }; f(f, 100000)"
+	comp := compiler.New()
+	comp.SetOptimize(true)
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.ByteCode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 0, vm.LastPoppedElement())
+
+	// and they don't use up the register VM's stack either
+	regComp := compiler.NewRegister()
+	regComp.SetOptimize(true)
+	if err := regComp.Compile(parse(input)); err != nil {
+		t.Fatalf("register compiler error: %s", err)
+	}
+	vm = NewRegister(regComp.ByteCode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("register vm error: %s", err)
+	}
+	testExpectedObject(t, 0, vm.LastPoppedElement())
+}
+
+const debugInput = `let plus = fn(a, b) {
+  let sum = a + b;
+  sum
+};
+let twice = fn(x) {
+  let y = plus(x, x);
+  let z = y * 2;
+  z
+};
+let r = twice(4);
+r + 1`
+
+// compiles input as test.nl and attaches a debugger that answers each
+// stop with the next of modes, recording where it stopped
+func debugRun(t *testing.T, input string, setup func(d *Debugger), modes ...StepMode) ([]string, error) {
+	t.Helper()
+
+	symbols := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbols.DefineBuiltin(i, v.Name)
+	}
+	comp := compiler.NewWithState(symbols, []object.Object{})
+	comp.SetFile("test.nl")
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.ByteCode())
+	d := NewDebugger(vm, symbols, true)
+	stops := []string{}
+	d.OnStop = func(d *Debugger, s Stop) StepMode {
+		stops = append(stops, fmt.Sprintf("%s %s:%d", s.Reason, s.Frame.Func, s.Frame.Line))
+		if len(stops) == 1 && setup != nil {
+			setup(d)
+		}
+		if len(modes) == 0 {
+			return Continue
+		}
+		mode := modes[0]
+		modes = modes[1:]
+		return mode
+	}
+	return stops, vm.Run()
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	tests := []struct {
+		modes    []StepMode
+		expected []string
+	}{
+		{
+			[]StepMode{StepOver, StepOver, StepOver, StepOver},
+			[]string{"entry main:1", "step main:5", "step main:10", "step main:11"},
+		},
+		{
+			[]StepMode{StepOver, StepOver, StepIn, StepIn, StepIn, StepOut, StepOut},
+			[]string{"entry main:1", "step main:5", "step main:10", "step twice:6",
+				"step plus:2", "step plus:3", "step twice:7", "step main:11"},
+		},
+		{
+			[]StepMode{StepOver, StepOver, StepIn, StepOver, StepOver, StepOver, StepOver},
+			[]string{"entry main:1", "step main:5", "step main:10", "step twice:6",
+				"step twice:7", "step twice:8", "step main:11"},
+		},
+	}
+
+	for _, tt := range tests {
+		stops, err := debugRun(t, debugInput, nil, tt.modes...)
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		if fmt.Sprint(stops) != fmt.Sprint(tt.expected) {
+			t.Errorf("wrong stops.\nwant=%v\ngot= %v", tt.expected, stops)
+		}
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	var bps []*Breakpoint
+	stops, err := debugRun(t, debugInput, func(d *Debugger) {
+		bps = []*Breakpoint{d.BreakLine("test.nl", 7), d.BreakFunc("plus"),
+			d.BreakLine("test.nl", 4), d.BreakLine("other.nl", 7), d.BreakFunc("nothing")}
+		d.Delete(bps[2].ID)
+	})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	expected := []string{"entry main:1", "breakpoint plus:2", "breakpoint twice:7"}
+	if fmt.Sprint(stops) != fmt.Sprint(expected) {
+		t.Errorf("wrong stops.\nwant=%v\ngot= %v", expected, stops)
+	}
+	verified := []bool{true, true, false, false, false}
+	for i, bp := range bps {
+		if bp.Verified != verified[i] {
+			t.Errorf("breakpoint %d: wrong Verified. want=%v, got=%v", bp.ID, verified[i], bp.Verified)
+		}
+	}
+}
+
+func TestDebuggerInspection(t *testing.T) {
+	input := `let base = 10;
+let adder = fn(n) {
+  fn(m) {
+    let total = base + n + m;
+    total
+  }
+};
+adder(1)(2)`
+
+	var frames []FrameInfo
+	var locals, free, globals []Variable
+	var stack []object.Object
+	results := []string{}
+	_, err := debugRun(t, input, func(d *Debugger) {
+		d.BreakLine("test.nl", 5)
+		d.OnStop = func(d *Debugger, s Stop) StepMode {
+			frames = d.Frames()
+			locals, _ = d.Locals(0)
+			free, _ = d.Free(0)
+			globals = d.Globals()
+			stack = d.Stack()
+			for _, src := range []string{"total * 2", "n + m", "let base = 0; base", "base"} {
+				val, err := d.Eval(src, 0)
+				if err != nil {
+					results = append(results, err.Error())
+					continue
+				}
+				results = append(results, val.Inspect())
+			}
+			if _, err := d.Locals(2); err == nil {
+				results = append(results, "frame 2 exists")
+			}
+			return Continue
+		}
+	})
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	if fmt.Sprint(frames) != "[{<anonymous> test.nl 5} {main test.nl 8}]" {
+		t.Errorf("wrong frames. got=%v", frames)
+	}
+	checkVariables(t, "locals", locals, "[m=2 total=13]")
+	checkVariables(t, "free", free, "[n=1]")
+	checkVariables(t, "globals", globals, "[base=10 adder=Closure]")
+	// the closure, its argument and its locals
+	if len(stack) != 3 {
+		t.Errorf("wrong stack size. want=3, got=%d (%v)", len(stack), stack)
+	}
+	if fmt.Sprint(results) != "[26 3 0 10]" {
+		t.Errorf("wrong eval results. got=%v", results)
+	}
+}
+
+func checkVariables(t *testing.T, kind string, vars []Variable, expected string) {
+	t.Helper()
+
+	shown := []string{}
+	for _, v := range vars {
+		val := "unset"
+		if _, ok := v.Value.(*object.Closure); ok {
+			val = "Closure"
+		} else if v.Value != nil {
+			val = v.Value.Inspect()
+		}
+		shown = append(shown, v.Name+"="+val)
+	}
+	if fmt.Sprint(shown) != expected {
+		t.Errorf("wrong %s. want=%s, got=%v", kind, expected, shown)
+	}
+}
+
+func TestDebuggerQuit(t *testing.T) {
+	stops, err := debugRun(t, debugInput, nil, StepOver, Quit)
+	if err != ErrQuit {
+		t.Fatalf("expected ErrQuit, got=%v", err)
+	}
+	if len(stops) != 2 {
+		t.Errorf("expected 2 stops, got=%v", stops)
+	}
+}
+
+const profileInput = `let sq = fn(x) {
+  x * x
+};
+let total = [sq(2), sq(3)];
+total`
+
+func profileRun(t *testing.T, input string) *Profiler {
+	t.Helper()
+	return profileRunWith(t, input, NewProfiler)
+}
+
+func profileRunWith(t *testing.T, input string, newProfiler func(*VM) *Profiler) *Profiler {
+	t.Helper()
+
+	comp := compiler.New()
+	comp.SetFile("test.nl")
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.ByteCode())
+	p := newProfiler(vm)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return p
+}
+
+// profileCounts maps each call stack of p's samples to what was counted there
+func profileCounts(p *Profiler) map[string]string {
+	got := map[string]string{}
+	for _, s := range p.samples() {
+		names := []string{}
+		for _, loc := range s.stack {
+			names = append(names, fmt.Sprintf("%s:%d", p.funcName(loc.fn), loc.line))
+		}
+		got[strings.Join(names, " ")] = fmt.Sprintf("%d instructions, %d allocs", s.instructions, s.allocs)
+	}
+	return got
+}
+
+func TestProfilerCounts(t *testing.T) {
+	p := profileRun(t, profileInput)
+
+	got := profileCounts(p)
+	// integers only become objects once they go into the array
+	expected := map[string]string{
+		"main:1":      "2 instructions, 1 allocs",
+		"main:4":      "8 instructions, 3 allocs",
+		"main:5":      "2 instructions, 0 allocs",
+		"sq:2 main:4": "8 instructions, 0 allocs",
+	}
+	for stack, want := range expected {
+		if got[stack] != want {
+			t.Errorf("wrong counts for %s. want=%q, got=%q", stack, want, got[stack])
+		}
+	}
+	if len(got) != len(expected) {
+		t.Errorf("wrong samples. want=%v, got=%v", expected, got)
+	}
+}
+
+func TestProfilerGenerators(t *testing.T) {
+	input := `let gen = fn() {
+  yield 1;
+  yield 2
+};
+let g = gen();
+let a = next(g);
+let b = next(g);
+a + b`
+	p := profileRun(t, input)
+
+	got := profileCounts(p)
+	// the generator's lines count as called from the lines resuming it
+	for _, stack := range []string{"gen:2 main:6", "gen:3 main:7"} {
+		if got[stack] == "" {
+			t.Errorf("no counts for %s: %v", stack, got)
+		}
+	}
+
+	var total int64
+	for _, s := range p.samples() {
+		if s.nanos < 0 {
+			t.Errorf("negative time at %v", s.stack)
+		}
+		total += s.nanos
+	}
+	if total > int64(p.duration) {
+		t.Errorf("more time counted than the run took: %d > %d", total, p.duration)
+	}
+	if len(p.running) != 0 {
+		t.Errorf("VMs still running after the run: %d", len(p.running))
+	}
+}
+
+func TestSamplingProfiler(t *testing.T) {
+	input := `let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(22)`
+	interval := 10 * time.Microsecond
+	p := profileRunWith(t, input, func(vm *VM) *Profiler {
+		return NewSamplingProfiler(vm, interval)
+	})
+
+	var instructions, nanos int64
+	for _, s := range p.samples() {
+		instructions += s.instructions
+		nanos += s.nanos
+	}
+	if instructions == 0 {
+		t.Errorf("no instructions counted")
+	}
+	if nanos == 0 || nanos > int64(p.duration) {
+		t.Errorf("wrong time sampled. duration=%d, got=%d", p.duration, nanos)
+	}
+}
+
+func TestProfilerReports(t *testing.T) {
+	p := profileRun(t, profileInput)
+
+	var top bytes.Buffer
+	if err := p.WriteTop(&top, 1, false); err != nil {
+		t.Fatalf("WriteTop: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(top.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "Showing the top 1 of 2 functions by time.") ||
+		!strings.Contains(lines[0], "20 instructions, 4 allocations") {
+		t.Errorf("wrong top report:\n%s", top.String())
+	}
+
+	top.Reset()
+	if err := p.WriteTop(&top, 0, true); err != nil {
+		t.Fatalf("WriteTop: %s", err)
+	}
+	if !strings.Contains(top.String(), "sq test.nl:2\n") || !strings.Contains(top.String(), "of 4 lines") {
+		t.Errorf("wrong line report:\n%s", top.String())
+	}
+
+	var profile bytes.Buffer
+	if err := p.WriteProfile(&profile); err != nil {
+		t.Fatalf("WriteProfile: %s", err)
+	}
+	gz, err := gzip.NewReader(&profile)
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %s", err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %s", err)
+	}
+
+	// count the top level fields of the message and collect its strings
+	fields := map[uint64]int{}
+	strs := []string{}
+	varint := func() uint64 {
+		var x uint64
+		for shift := uint(0); ; shift += 7 {
+			b := data[0]
+			data = data[1:]
+			x |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return x
+			}
+		}
+	}
+	for len(data) > 0 {
+		key := varint()
+		fields[key>>3]++
+		switch key & 7 {
+		case 0:
+			varint()
+		case 2:
+			n := varint()
+			if key>>3 == 6 {
+				strs = append(strs, string(data[:n]))
+			}
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	// 3 sample types, 4 samples, 4 locations, 2 functions
+	if fields[1] != 3 || fields[2] != 4 || fields[4] != 4 || fields[5] != 2 {
+		t.Errorf("wrong fields in profile: %v", fields)
+	}
+	joined := strings.Join(strs, ",")
+	for _, s := range []string{"instructions", "time", "nanoseconds", "allocations", "main", "sq", "test.nl"} {
+		if !strings.Contains(joined, s) {
+			t.Errorf("profile strings are missing %q: %v", s, strs)
+		}
+	}
+}
+
+func TestRunningLoadedByteCode(t *testing.T) {
+	input := `struct Point { x, y }
+let gen = fn(n) { yield n; yield n * 2 };
+let plus = fn(a) { fn(b) { a + b } };
+let p = Point(plus(1)(2), len(array(gen(5))));
+p.x * p.y`
+
+	symbols := compiler.NewSymbolTable()
+	for i, v := range object.Builtins {
+		symbols.DefineBuiltin(i, v.Name)
+	}
+	comp := compiler.NewWithState(symbols, []object.Object{})
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var out bytes.Buffer
+	if err := compiler.WriteByteCode(&out, comp.ByteCode(), symbols); err != nil {
+		t.Fatalf("WriteByteCode: %s", err)
+	}
+	bc, _, err := compiler.ReadByteCode(&out)
+	if err != nil {
+		t.Fatalf("ReadByteCode: %s", err)
+	}
+
+	if err := New(bc).Run(); err != ErrUnverified {
+		t.Fatalf("expected loaded bytecode to be refused until verified, got=%v", err)
+	}
+	if err := compiler.Verify(bc); err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+	vm := New(bc)
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 6, vm.LastPoppedElement())
+}
+
+func TestUnsetGlobal(t *testing.T) {
+	builtin := 0
+	for i, b := range object.Builtins {
+		if b.Name == "array" {
+			builtin = i
+		}
+	}
+	// array(x), where x was never set, as only bytecode not from the compiler can
+	ins := opcode.Instructions{}
+	for _, in := range []opcode.Instructions{
+		opcode.Make(opcode.OpGetBuiltin, builtin),
+		opcode.Make(opcode.OpGetGlobal, 0),
+		opcode.Make(opcode.OpCall, 1),
+		opcode.Make(opcode.OpPop),
+	} {
+		ins = append(ins, in...)
+	}
+	vm := New(&compiler.ByteCode{Instructions: ins})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	// rather than a nil Object
+	err, ok := vm.LastPoppedElement().(*object.Error)
+	if !ok || err.Message != "argument to `array` must be iterable, got NIL" {
+		t.Errorf("expected x to be read as nil, got=%v", vm.LastPoppedElement())
+	}
+}
+
+func TestOptimizedProgramsBehaveTheSame(t *testing.T) {
+	programs := []string{
+		`1 / 0`,
+		`-true`,
+		`puts(1 + 2); if (false) { puts("no") } else { puts("ye" + "s") }`,
+		`let fib = fn(x) { if (x < 1 + 1) { return x }; fib(x - 1) + fib(x - 2) }; fib(15)`,
+		`let f = fn(x) { if (x > 10 - 5) { return "big" } else { if (x == 0) { return "none" } }; "small" }; [f(3), f(30), f(0)]`,
+		`let gen = fn(n) { if (n > 0) { yield n * 2 } else { yield -1 }; yield from [1, 2] }; array(gen(4))`,
+		`struct P { a, b }; let p = P(2 * 3, "x" == "x"); if (p.b) { p.a = p.a + 1 }; p`,
+		`if (!(1 == 1)) { 1 } else { let a = 2 }`,
+	}
+
+	run := func(input string, optimize bool) string {
+		var out bytes.Buffer
+		saved := object.Output
+		object.Output = &out
+		defer func() { object.Output = saved }()
+
+		comp := compiler.New()
+		comp.SetOptimize(optimize)
+		if err := comp.Compile(parse(input)); err != nil {
+			return fmt.Sprintf("compiler error: %s", err)
+		}
+		if err := compiler.Verify(comp.ByteCode()); err != nil {
+			t.Errorf("%s: bytecode does not verify (optimized=%t): %s", input, optimize, err)
+		}
+		vm := New(comp.ByteCode())
+		if err := vm.Run(); err != nil {
+			return fmt.Sprintf("%svm error: %s", out.String(), err)
+		}
+		return fmt.Sprintf("%s=> %s", out.String(), vm.LastPoppedElement().Inspect())
+	}
+
+	for _, input := range programs {
+		plain, optimized := run(input, false), run(input, true)
+		if plain != optimized {
+			t.Errorf("%s: optimizing changed the result.\nplain=%q\noptimized=%q", input, plain, optimized)
+		}
+	}
+}